@@ -7,12 +7,16 @@ echo proxy-client
 go build -o bin/proxy-client cmd/proxy-client/proxy-client.go
 echo proxy-server
 go build -o bin/proxy-server cmd/proxy-server/proxy-server.go
-# We need development files for krb5 1.12 or newer for gss.
-if pkg-config krb5-gssapi 2> /dev/null ; then
-	echo gss-client
-	go build -o bin/gss-client cmd/gss-client/gss-client.go
-	echo gss-server
-	go build -o bin/gss-server cmd/gss-server/gss-server.go
-	echo www-authenticate
-	go build -o bin/www-authenticate cmd/www-authenticate/www-authenticate.go
-fi
+echo gss-dump
+go build -o bin/gss-dump cmd/gss-dump/gss-dump.go
+# gss declares the parts of GSSAPI that it uses itself, and loads libgssapi
+# (MIT krb5 1.12 or newer, or Heimdal) when a program first uses it, so no
+# Kerberos development files are needed to build it.
+echo gss-client
+go build -o bin/gss-client cmd/gss-client/gss-client.go
+echo gss-server
+go build -o bin/gss-server cmd/gss-server/gss-server.go
+echo www-authenticate
+go build -o bin/www-authenticate cmd/www-authenticate/www-authenticate.go
+echo gss-tunnel
+go build -o bin/gss-tunnel cmd/gss-tunnel/gss-tunnel.go
@@ -0,0 +1,438 @@
+package main
+
+import "bufio"
+import "bytes"
+import "encoding/asn1"
+import "encoding/base64"
+import "encoding/binary"
+import "encoding/hex"
+import "flag"
+import "fmt"
+import "io/ioutil"
+import "os"
+import "strings"
+import "time"
+import "github.com/twistlock/gss/pkg/gss/token"
+
+/* Names for mechanism OIDs which commonly turn up in negotiation tokens. */
+var mechNames = map[string]string{
+	"1.2.840.113554.1.2.2":     "Kerberos 5",
+	"1.3.5.1.5.2":              "Kerberos 5 (pre-RFC OID)",
+	"1.2.840.48018.1.2.2":      "Kerberos 5 (Microsoft's mistaken OID)",
+	"1.3.6.1.5.2.5":            "IAKERB",
+	"1.3.6.1.5.5.2":            "SPNEGO",
+	"1.3.6.1.4.1.311.2.2.10":   "NTLMSSP",
+	"1.3.6.1.4.1.311.2.2.30":   "NEGOEX",
+	"1.3.6.1.4.1.32473.1.1":    "fake (test mechanism)",
+	"1.2.840.113554.1.2.2.3":   "Kerberos 5 user-to-user",
+	"1.3.6.1.4.1.5322.26.1.10": "SPKM-3",
+}
+
+var negStates = map[int]string{
+	token.NegStateAcceptCompleted:  "accept-completed",
+	token.NegStateAcceptIncomplete: "accept-incomplete",
+	token.NegStateReject:           "reject",
+	token.NegStateRequestMic:       "request-mic",
+}
+
+var enctypes = map[int]string{
+	1:  "des-cbc-crc",
+	3:  "des-cbc-md5",
+	16: "des3-cbc-sha1",
+	17: "aes128-cts-hmac-sha1-96",
+	18: "aes256-cts-hmac-sha1-96",
+	19: "aes128-cts-hmac-sha256-128",
+	20: "aes256-cts-hmac-sha384-192",
+	23: "arcfour-hmac",
+	24: "arcfour-hmac-exp",
+	25: "camellia128-cts-cmac",
+	26: "camellia256-cts-cmac",
+}
+
+var krbErrors = map[int]string{
+	6:  "KDC_ERR_C_PRINCIPAL_UNKNOWN",
+	7:  "KDC_ERR_S_PRINCIPAL_UNKNOWN",
+	14: "KDC_ERR_ETYPE_NOSUPP",
+	18: "KDC_ERR_CLIENT_REVOKED",
+	23: "KDC_ERR_KEY_EXPIRED",
+	24: "KDC_ERR_PREAUTH_FAILED",
+	25: "KDC_ERR_PREAUTH_REQUIRED",
+	31: "KRB_AP_ERR_BAD_INTEGRITY",
+	32: "KRB_AP_ERR_TKT_EXPIRED",
+	33: "KRB_AP_ERR_TKT_NYV",
+	34: "KRB_AP_ERR_REPEAT",
+	35: "KRB_AP_ERR_NOT_US",
+	36: "KRB_AP_ERR_BADMATCH",
+	37: "KRB_AP_ERR_SKEW",
+	38: "KRB_AP_ERR_BADADDR",
+	39: "KRB_AP_ERR_BADVERSION",
+	40: "KRB_AP_ERR_MSG_TYPE",
+	41: "KRB_AP_ERR_MODIFIED",
+	44: "KRB_AP_ERR_BADKEYVER",
+	45: "KRB_AP_ERR_NOKEY",
+	47: "KRB_AP_ERR_BADDIRECTION",
+	52: "KRB_ERR_RESPONSE_TOO_BIG",
+	60: "KRB_ERR_GENERIC",
+	68: "KDC_ERR_WRONG_REALM",
+}
+
+/* The outer parts of the Kerberos messages found in krb5 mechanism tokens (RFC 4120 section 5). */
+type principalName struct {
+	NameType   int      `asn1:"explicit,tag:0"`
+	NameString []string `asn1:"explicit,tag:1"`
+}
+
+type encryptedData struct {
+	Etype  int    `asn1:"explicit,tag:0"`
+	Kvno   int    `asn1:"optional,explicit,tag:1"`
+	Cipher []byte `asn1:"explicit,tag:2"`
+}
+
+type ticket struct {
+	TktVno  int           `asn1:"explicit,tag:0"`
+	Realm   string        `asn1:"explicit,tag:1"`
+	Sname   principalName `asn1:"explicit,tag:2"`
+	EncPart encryptedData `asn1:"explicit,tag:3"`
+}
+
+type apReq struct {
+	Pvno          int            `asn1:"explicit,tag:0"`
+	MsgType       int            `asn1:"explicit,tag:1"`
+	APOptions     asn1.BitString `asn1:"explicit,tag:2"`
+	Ticket        asn1.RawValue  `asn1:"explicit,tag:3"`
+	Authenticator encryptedData  `asn1:"explicit,tag:4"`
+}
+
+type apRep struct {
+	Pvno    int           `asn1:"explicit,tag:0"`
+	MsgType int           `asn1:"explicit,tag:1"`
+	EncPart encryptedData `asn1:"explicit,tag:2"`
+}
+
+type krbError struct {
+	Pvno      int           `asn1:"explicit,tag:0"`
+	MsgType   int           `asn1:"explicit,tag:1"`
+	Ctime     time.Time     `asn1:"generalized,optional,explicit,tag:2"`
+	Cusec     int           `asn1:"optional,explicit,tag:3"`
+	Stime     time.Time     `asn1:"generalized,explicit,tag:4"`
+	Susec     int           `asn1:"explicit,tag:5"`
+	ErrorCode int           `asn1:"explicit,tag:6"`
+	Crealm    string        `asn1:"optional,explicit,tag:7"`
+	Cname     principalName `asn1:"optional,explicit,tag:8"`
+	Realm     string        `asn1:"explicit,tag:9"`
+	Sname     principalName `asn1:"explicit,tag:10"`
+	EText     string        `asn1:"optional,explicit,tag:11"`
+	EData     []byte        `asn1:"optional,explicit,tag:12"`
+}
+
+func mechName(oid asn1.ObjectIdentifier) string {
+	if name, ok := mechNames[oid.String()]; ok {
+		return fmt.Sprintf("%s (%s)", oid, name)
+	}
+	return oid.String()
+}
+
+func lookup(names map[int]string, value int) string {
+	if name, ok := names[value]; ok {
+		return fmt.Sprintf("%d (%s)", value, name)
+	}
+	return fmt.Sprintf("%d", value)
+}
+
+func (p principalName) String() string {
+	return fmt.Sprintf("%s (type %d)", strings.Join(p.NameString, "/"), p.NameType)
+}
+
+func printf(depth int, format string, args ...interface{}) {
+	fmt.Print(strings.Repeat("  ", depth))
+	fmt.Printf(format, args...)
+}
+
+func printBytes(depth int, label string, b []byte, full bool) {
+	if full || len(b) <= 32 {
+		printf(depth, "%s: %d bytes: %s\n", label, len(b), hex.EncodeToString(b))
+	} else {
+		printf(depth, "%s: %d bytes: %s...\n", label, len(b), hex.EncodeToString(b[:32]))
+	}
+}
+
+func printEncrypted(depth int, label string, e encryptedData, full bool) {
+	printf(depth, "%s: enctype %s, kvno %d\n", label, lookup(enctypes, e.Etype), e.Kvno)
+	printBytes(depth+1, "cipher", e.Cipher, full)
+}
+
+/* unmarshalApplication decodes an [APPLICATION tag] element holding a SEQUENCE. */
+func unmarshalApplication(b []byte, tag int, v interface{}) error {
+	e, err := token.SplitOne(b)
+	if err != nil {
+		return err
+	}
+	if e.Class != token.ClassApplication || e.Tag != tag {
+		return fmt.Errorf("expected [APPLICATION %d], found class %d tag %d", tag, e.Class, e.Tag)
+	}
+	rest, err := asn1.Unmarshal(e.Value, v)
+	if err == nil && len(rest) > 0 {
+		err = token.ErrTrailingData
+	}
+	return err
+}
+
+func dumpKrb5(depth int, b []byte, full bool) {
+	if len(b) < 2 {
+		printBytes(depth, "truncated krb5 token", b, true)
+		return
+	}
+	tokID := binary.BigEndian.Uint16(b)
+	body := b[2:]
+	switch tokID {
+	case 0x0100:
+		var req apReq
+		var t ticket
+		printf(depth, "krb5 AP-REQ\n")
+		/* The ticket is an [APPLICATION 1] inside an explicit tag, which encoding/asn1 can't express, so it is decoded separately. */
+		err := unmarshalApplication(body, 14, &req)
+		if err == nil {
+			err = unmarshalApplication(req.Ticket.Bytes, 1, &t)
+		}
+		if err != nil {
+			printf(depth+1, "can't parse: %v\n", err)
+			printBytes(depth+1, "data", body, full)
+			return
+		}
+		printf(depth+1, "ap-options: %s\n", hex.EncodeToString(req.APOptions.Bytes))
+		printf(depth+1, "ticket realm: %s\n", t.Realm)
+		printf(depth+1, "ticket sname: %s\n", t.Sname)
+		printEncrypted(depth+1, "ticket enc-part", t.EncPart, full)
+		printEncrypted(depth+1, "authenticator", req.Authenticator, full)
+	case 0x0200:
+		var rep apRep
+		printf(depth, "krb5 AP-REP\n")
+		if err := unmarshalApplication(body, 15, &rep); err != nil {
+			printf(depth+1, "can't parse: %v\n", err)
+			printBytes(depth+1, "data", body, full)
+			return
+		}
+		printEncrypted(depth+1, "enc-part", rep.EncPart, full)
+	case 0x0300:
+		var kerr krbError
+		printf(depth, "krb5 KRB-ERROR\n")
+		if err := unmarshalApplication(body, 30, &kerr); err != nil {
+			printf(depth+1, "can't parse: %v\n", err)
+			printBytes(depth+1, "data", body, full)
+			return
+		}
+		printf(depth+1, "error-code: %s\n", lookup(krbErrors, kerr.ErrorCode))
+		printf(depth+1, "server time: %s\n", kerr.Stime.UTC().Format(time.RFC3339))
+		printf(depth+1, "realm: %s\n", kerr.Realm)
+		printf(depth+1, "sname: %s\n", kerr.Sname)
+		if kerr.Crealm != "" || len(kerr.Cname.NameString) > 0 {
+			printf(depth+1, "client: %s@%s\n", kerr.Cname, kerr.Crealm)
+		}
+		if kerr.EText != "" {
+			printf(depth+1, "e-text: %s\n", kerr.EText)
+		}
+		if len(kerr.EData) > 0 {
+			printBytes(depth+1, "e-data", kerr.EData, full)
+		}
+	default:
+		printf(depth, "krb5 token with unknown TOK_ID %04x\n", tokID)
+		printBytes(depth+1, "data", body, full)
+	}
+}
+
+/* dumpPerMessage handles RFC 4121 MIC and wrap tokens, which aren't framed. */
+func dumpPerMessage(depth int, b []byte, full bool) bool {
+	if len(b) < 16 {
+		return false
+	}
+	tokID := binary.BigEndian.Uint16(b)
+	if tokID != 0x0404 && tokID != 0x0504 {
+		return false
+	}
+	flags := b[2]
+	var names []string
+	if flags&1 != 0 {
+		names = append(names, "SentByAcceptor")
+	}
+	if flags&2 != 0 {
+		names = append(names, "Sealed")
+	}
+	if flags&4 != 0 {
+		names = append(names, "AcceptorSubkey")
+	}
+	if tokID == 0x0404 {
+		printf(depth, "RFC 4121 MIC token\n")
+	} else {
+		printf(depth, "RFC 4121 wrap token\n")
+	}
+	printf(depth+1, "flags: %#02x [%s]\n", flags, strings.Join(names, ", "))
+	if tokID == 0x0504 {
+		printf(depth+1, "EC: %d\n", binary.BigEndian.Uint16(b[4:]))
+		printf(depth+1, "RRC: %d\n", binary.BigEndian.Uint16(b[6:]))
+	}
+	printf(depth+1, "sequence number: %d\n", binary.BigEndian.Uint64(b[8:]))
+	printBytes(depth+1, "data", b[16:], full)
+	return true
+}
+
+func dumpNTLM(depth int, b []byte) bool {
+	if len(b) < 12 || !bytes.HasPrefix(b, []byte("NTLMSSP\x00")) {
+		return false
+	}
+	kinds := map[uint32]string{1: "NEGOTIATE", 2: "CHALLENGE", 3: "AUTHENTICATE"}
+	kind := binary.LittleEndian.Uint32(b[8:])
+	printf(depth, "NTLMSSP %s message (type %d), %d bytes\n", kinds[kind], kind, len(b))
+	return true
+}
+
+func dumpSPNEGOInit(depth int, init token.NegTokenInit, full bool) {
+	printf(depth, "SPNEGO NegTokenInit\n")
+	printf(depth+1, "mechTypes:\n")
+	for _, mech := range init.MechTypes {
+		printf(depth+2, "%s\n", mechName(mech))
+	}
+	if init.ReqFlags.BitLength > 0 {
+		printf(depth+1, "reqFlags: %s\n", hex.EncodeToString(init.ReqFlags.Bytes))
+	}
+	if len(init.MechToken) > 0 {
+		printf(depth+1, "mechToken:\n")
+		dump(depth+2, init.MechToken, full)
+	}
+	if len(init.MechListMic) > 0 {
+		printf(depth+1, "mechListMIC:\n")
+		dump(depth+2, init.MechListMic, full)
+	}
+}
+
+func dumpSPNEGOResp(depth int, resp token.NegTokenResp, full bool) {
+	printf(depth, "SPNEGO NegTokenResp\n")
+	printf(depth+1, "negState: %s\n", lookup(negStates, int(resp.NegState)))
+	if len(resp.SupportedMech) > 0 {
+		printf(depth+1, "supportedMech: %s\n", mechName(resp.SupportedMech))
+	}
+	if len(resp.ResponseToken) > 0 {
+		printf(depth+1, "responseToken:\n")
+		dump(depth+2, resp.ResponseToken, full)
+	}
+	if len(resp.MechListMic) > 0 {
+		printf(depth+1, "mechListMIC:\n")
+		dump(depth+2, resp.MechListMic, full)
+	}
+}
+
+/* dump identifies a token and prints its structure. */
+func dump(depth int, b []byte, full bool) {
+	if init, err := token.ParseNegTokenInit(b); err == nil {
+		dumpSPNEGOInit(depth, init, full)
+		return
+	}
+	if resp, err := token.ParseNegTokenResp(b); err == nil {
+		dumpSPNEGOResp(depth, resp, full)
+		return
+	}
+	if mech, inner, err := token.Unframe(b); err == nil {
+		printf(depth, "InitialContextToken for %s\n", mechName(mech))
+		switch mech.String() {
+		case "1.2.840.113554.1.2.2", "1.3.5.1.5.2", "1.2.840.48018.1.2.2":
+			dumpKrb5(depth+1, inner, full)
+		default:
+			printBytes(depth+1, "data", inner, full)
+		}
+		return
+	}
+	if dumpPerMessage(depth, b, full) || dumpNTLM(depth, b) {
+		return
+	}
+	printf(depth, "unrecognized token\n")
+	printBytes(depth+1, "data", b, full)
+}
+
+/* isHex reports whether text is an even number of hexadecimal digits, which is how a hex dump of a token looks, and is unlikely for base64. */
+func isHex(text string) bool {
+	if text == "" || len(text)%2 != 0 {
+		return false
+	}
+	for _, c := range text {
+		if !strings.ContainsRune("0123456789abcdefABCDEF", c) {
+			return false
+		}
+	}
+	return true
+}
+
+/* decode accepts a raw token, its base64 or hex encoding, or an HTTP header carrying it.  Text which could be either is taken as hex, and if forceHex is set, anything else is an error. */
+func decode(input []byte, raw, forceHex bool) ([]byte, error) {
+	if raw {
+		return input, nil
+	}
+	text := strings.TrimSpace(string(input))
+	if i := strings.Index(text, ":"); i >= 0 && !strings.ContainsAny(text[:i], " \t") {
+		/* A header name, such as Authorization or WWW-Authenticate. */
+		text = strings.TrimSpace(text[i+1:])
+	}
+	fields := strings.Fields(text)
+	if len(fields) == 2 && (strings.EqualFold(fields[0], "Negotiate") || strings.EqualFold(fields[0], "Kerberos")) {
+		text = fields[1]
+	}
+	if compact := strings.Join(strings.Fields(text), ""); forceHex || isHex(compact) {
+		return hex.DecodeString(compact)
+	}
+	for _, enc := range []*base64.Encoding{base64.StdEncoding, base64.RawStdEncoding, base64.URLEncoding, base64.RawURLEncoding} {
+		if b, err := enc.DecodeString(text); err == nil {
+			return b, nil
+		}
+	}
+	return input, nil
+}
+
+func main() {
+	raw := flag.Bool("raw", false, "treat input as a binary token rather than base64, hex, or an HTTP header")
+	forceHex := flag.Bool("hex", false, "require input to be hex, possibly in an HTTP header")
+	full := flag.Bool("full", false, "print binary fields in full")
+	lines := flag.Bool("lines", false, "treat each line of input as a separate token")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [options] [token|file ...]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Decodes GSSAPI tokens given as arguments, in files, or on standard input.\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	var inputs [][]byte
+	if flag.NArg() == 0 {
+		if *lines {
+			scanner := bufio.NewScanner(os.Stdin)
+			scanner.Buffer(nil, 1<<20)
+			for scanner.Scan() {
+				if len(bytes.TrimSpace(scanner.Bytes())) > 0 {
+					inputs = append(inputs, append([]byte{}, scanner.Bytes()...))
+				}
+			}
+		} else {
+			b, err := ioutil.ReadAll(os.Stdin)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error reading input: %s\n", err)
+				os.Exit(1)
+			}
+			inputs = append(inputs, b)
+		}
+	}
+	for _, arg := range flag.Args() {
+		if b, err := ioutil.ReadFile(arg); err == nil {
+			inputs = append(inputs, b)
+		} else {
+			inputs = append(inputs, []byte(arg))
+		}
+	}
+
+	for i, input := range inputs {
+		if i > 0 {
+			fmt.Println()
+		}
+		b, err := decode(input, *raw, *forceHex)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error decoding input: %s\n", err)
+			os.Exit(1)
+		}
+		dump(0, b, *full)
+	}
+}
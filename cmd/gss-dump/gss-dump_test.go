@@ -0,0 +1,39 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestDecode(t *testing.T) {
+	want := []byte{0x60, 0x06, 0x06, 0x01, 0x00, 0xa0, 0x01, 0x00}
+	for _, tc := range []struct {
+		input    string
+		forceHex bool
+	}{
+		{"6006060100a00100", false},
+		{"60 06 06 01 00 a0 01 00\n", false},
+		{"YAYGAQCgAQA=", false},
+		{"YAYGAQCgAQA", false},
+		{"Authorization: Negotiate YAYGAQCgAQA=", false},
+		{"WWW-Authenticate: Negotiate 6006060100A00100", false},
+		{"6006060100a00100", true},
+	} {
+		got, err := decode([]byte(tc.input), false, tc.forceHex)
+		if err != nil {
+			t.Errorf("decode(%q): %v", tc.input, err)
+			continue
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("decode(%q) = %x, want %x", tc.input, got, want)
+		}
+	}
+
+	// Base64 which happens to contain only hex digits is taken as hex.
+	if got, err := decode([]byte("deadbeef"), false, false); err != nil || !bytes.Equal(got, []byte{0xde, 0xad, 0xbe, 0xef}) {
+		t.Errorf("decode(%q) = %x, %v", "deadbeef", got, err)
+	}
+	if _, err := decode([]byte("YAYGAQCgAQA="), false, true); err == nil {
+		t.Error("decode accepted base64 when hex was required")
+	}
+}
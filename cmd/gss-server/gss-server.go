@@ -5,6 +5,7 @@ import "encoding/asn1"
 import "flag"
 import "fmt"
 import "github.com/twistlock/gss/pkg/gss"
+import "github.com/twistlock/gss/pkg/gss/handoff"
 import "github.com/twistlock/gss/pkg/gss/misc"
 import "net"
 import "io"
@@ -43,7 +44,7 @@ func serve(conn net.Conn, cred gss.CredHandle, export, verbose bool, logfile io.
 
 	tag, token := misc.RecvToken(conn)
 	if tag == 0 && len(token) == 0 {
-		fmt.Printf("EOF from client\n", tag)
+		fmt.Printf("EOF from client\n")
 		return
 	}
 	if (tag & misc.TOKEN_NOOP) == 0 {
@@ -98,8 +99,12 @@ func serve(conn net.Conn, cred gss.CredHandle, export, verbose bool, logfile io.
 				fmt.Fprintf(logfile, "continue needed...\n")
 			}
 		}
-		/* Make sure the context is cleaned up eventually. */
-		defer gss.DeleteSecContext(ctx)
+		/* Make sure the context is cleaned up eventually, even if it gets reimported. */
+		defer func() {
+			if ctx != nil {
+				gss.DeleteSecContext(ctx)
+			}
+		}()
 		/* Make sure the client name gets cleaned up eventually. */
 		defer gss.ReleaseName(cname)
 		/* Dig up information about the connection. */
@@ -186,16 +191,25 @@ func serve(conn net.Conn, cred gss.CredHandle, export, verbose bool, logfile io.
 			fmt.Fprintf(logfile, "Accepted unauthenticated connection.\n")
 		}
 	}
-	/* Optionally export/reimport the context a few times. */
-	if export {
+	/* Optionally export/reimport the context a few times, the way we would when handing it off to another process. */
+	if export && ctx != nil {
 		for i := 0; i < 3; i++ {
-			major, minor, contextToken := gss.ExportSecContext(ctx)
-			if major != gss.S_COMPLETE {
-				gss.DisplayGSSError("exporting a context", major, minor, &mech)
+			state, err := gss.ExportHandoff(ctx)
+			if err != nil {
+				fmt.Printf("Error exporting a context: %s\n", err)
+				return
 			}
-			major, minor, ctx = gss.ImportSecContext(contextToken)
-			if major != gss.S_COMPLETE {
-				gss.DisplayGSSError("importing a context", major, minor, &mech)
+			blob, err := handoff.Marshal(state, nil)
+			if err == nil {
+				state, err = handoff.Unmarshal(blob, nil)
+			}
+			if err == nil {
+				ctx, err = gss.ImportHandoff(state)
+			}
+			if err != nil {
+				fmt.Printf("Error importing a context: %s\n", err)
+				ctx = nil
+				return
 			}
 		}
 	}
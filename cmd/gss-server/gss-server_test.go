@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/twistlock/gss/pkg/gss/gsstest"
+)
+
+// build compiles the command in dir, and returns the path of the binary.
+func build(t *testing.T, dir string) string {
+	t.Helper()
+	goTool, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("the go tool is not available")
+	}
+	out := filepath.Join(t.TempDir(), filepath.Base(dir))
+	cmd := exec.Command(goTool, "build", "-o", out, ".")
+	cmd.Dir = dir
+	if output, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("building %s: %v\n%s", dir, err, output)
+	}
+	return out
+}
+
+func freePort(t *testing.T) int {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+func TestClientServer(t *testing.T) {
+	kdc := gsstest.Start(t, gsstest.Options{})
+	if err := kdc.AddUser("alice"); err != nil {
+		t.Fatal(err)
+	}
+	service, err := kdc.AddService("host")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := build(t, ".")
+	client := build(t, "../gss-client")
+	port := strconv.Itoa(freePort(t))
+	env := append(os.Environ(), kdc.Env()...)
+
+	srv := exec.Command(server, "-once", "-port", port, service)
+	srv.Env = env
+	stdout, err := srv.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Process.Kill()
+
+	// The server says that it's starting once it's listening and has
+	// acquired its credentials.
+	lines := make(chan string)
+	go func() {
+		scanner := bufio.NewScanner(stdout)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+		close(lines)
+	}()
+	var serverOutput []string
+	for line := range lines {
+		serverOutput = append(serverOutput, line)
+		if line == "starting..." {
+			break
+		}
+	}
+	if len(serverOutput) == 0 || serverOutput[len(serverOutput)-1] != "starting..." {
+		t.Fatalf("gss-server didn't start:\n%s", strings.Join(serverOutput, "\n"))
+	}
+
+	cli := exec.Command(client, "-port", port, "localhost", service, "hello, server")
+	cli.Env = env
+	output, err := cli.CombinedOutput()
+	if err != nil {
+		t.Fatalf("gss-client: %v\n%s", err, output)
+	}
+	if !strings.Contains(string(output), "Signature verified.") {
+		t.Errorf("gss-client didn't verify the server's signature:\n%s", output)
+	}
+	timeout := time.After(10 * time.Second)
+	for done := false; !done; {
+		select {
+		case line, ok := <-lines:
+			serverOutput = append(serverOutput, line)
+			done = !ok
+		case <-timeout:
+			t.Fatal("gss-server didn't exit after its one connection")
+		}
+	}
+	srv.Wait()
+	if want := `Accepted connection: "` + kdc.Principal("alice") + `"`; !strings.Contains(strings.Join(serverOutput, "\n"), want) {
+		t.Errorf("gss-server output lacks %s:\n%s", want, strings.Join(serverOutput, "\n"))
+	}
+}
@@ -0,0 +1,372 @@
+package main
+
+import "bufio"
+import "context"
+import "errors"
+import "flag"
+import "fmt"
+import "github.com/twistlock/gss/pkg/gss"
+import "github.com/twistlock/gss/pkg/gss/credstore"
+import "github.com/twistlock/gss/pkg/gss/mech"
+import "github.com/twistlock/gss/pkg/gss/proxy"
+import "github.com/twistlock/gss/pkg/gss/stream"
+import "io"
+import "log"
+import "net"
+import "os"
+import "os/signal"
+import "strings"
+import "sync"
+import "syscall"
+import "time"
+
+/* acl is a set of principals which may connect to a server, read from -allow and -acl.  An entry of the form "*@REALM" allows any principal in REALM. */
+type acl map[string]bool
+
+func (a acl) add(entry string) {
+	entry = strings.TrimSpace(entry)
+	if entry != "" && !strings.HasPrefix(entry, "#") {
+		a[entry] = true
+	}
+}
+
+func (a acl) load(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		a.add(scanner.Text())
+	}
+	return scanner.Err()
+}
+
+func (a acl) allowed(principal string) bool {
+	if a[principal] {
+		return true
+	}
+	if at := strings.LastIndex(principal, "@"); at >= 0 {
+		return a["*"+principal[at:]]
+	}
+	return false
+}
+
+/* aclContext checks the client's name against the ACL whenever a context is established, including the replacements which stream negotiates before a context expires.  The contexts of one connection share name, which holds the name that the first of them authenticated, and later ones must authenticate the same client. */
+type aclContext struct {
+	mech.Context
+	acl  acl
+	peer string
+	name *string
+}
+
+/* aclFactory returns a factory for the contexts of one connection, from peer, which checks each of them against the ACL. */
+func aclFactory(accept mech.Factory, allowed acl, peer string) mech.Factory {
+	name := new(string)
+	return func() (mech.Context, error) {
+		ctx, err := accept()
+		if err != nil {
+			return nil, err
+		}
+		return &aclContext{Context: ctx, acl: allowed, peer: peer, name: name}, nil
+	}
+}
+
+func (c *aclContext) Step(token []byte) ([]byte, bool, error) {
+	output, complete, err := c.Context.Step(token)
+	if err != nil || !complete {
+		return output, complete, err
+	}
+	name := c.PeerName()
+	if !c.acl.allowed(name) {
+		return nil, false, fmt.Errorf("client %q is not allowed", name)
+	}
+	if *c.name == "" {
+		*c.name = name
+		log.Printf("%s: authenticated as %s", c.peer, name)
+	} else if name != *c.name {
+		return nil, false, fmt.Errorf("client %q replaced its context as %q", *c.name, name)
+	}
+	return output, complete, nil
+}
+
+/* PeerName() returns the client's name, if the underlying context reports it, so that stream can check it too. */
+func (c *aclContext) PeerName() string {
+	if namer, ok := c.Context.(mech.PeerNamer); ok {
+		return namer.PeerName()
+	}
+	return ""
+}
+
+/* tunnel accepts connections and forwards each of them, keeping track of them so that they can be closed when shutting down. */
+type tunnel struct {
+	listener net.Listener
+	dialer   net.Dialer
+	forward  func(conn net.Conn)
+	wg       sync.WaitGroup
+
+	mu    sync.Mutex
+	conns map[net.Conn]bool
+}
+
+func (t *tunnel) track(conn net.Conn) {
+	t.mu.Lock()
+	t.conns[conn] = true
+	t.mu.Unlock()
+}
+
+func (t *tunnel) untrack(conn net.Conn) {
+	t.mu.Lock()
+	delete(t.conns, conn)
+	t.mu.Unlock()
+}
+
+func (t *tunnel) serve() {
+	var delay time.Duration
+	for {
+		conn, err := t.listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			/* Anything else, such as running out of descriptors, may pass, so back off and try again. */
+			if delay == 0 {
+				delay = 5 * time.Millisecond
+			} else if delay *= 2; delay > time.Second {
+				delay = time.Second
+			}
+			log.Printf("error accepting connection: %s", err)
+			time.Sleep(delay)
+			continue
+		}
+		delay = 0
+		t.wg.Add(1)
+		go func() {
+			defer t.wg.Done()
+			t.track(conn)
+			defer t.untrack(conn)
+			defer conn.Close()
+			t.forward(conn)
+		}()
+	}
+}
+
+/* shutdown stops accepting connections, gives the open ones up to grace to finish, and then closes them. */
+func (t *tunnel) shutdown(grace time.Duration) {
+	t.listener.Close()
+	done := make(chan struct{})
+	go func() {
+		t.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return
+	case <-time.After(grace):
+	}
+	t.mu.Lock()
+	log.Printf("closing %d remaining connections", len(t.conns))
+	for conn := range t.conns {
+		conn.Close()
+	}
+	t.mu.Unlock()
+	<-done
+}
+
+/* closeWriter is implemented by connections which can end one direction of a stream while still reading, as both *net.TCPConn and *stream.Conn can. */
+type closeWriter interface {
+	CloseWrite() error
+}
+
+/* errNoHalfClose is reported when the end of one direction can't be passed on. */
+var errNoHalfClose = errors.New("connection does not support CloseWrite")
+
+/* pipe copies data in both directions between a and b, passing the end of each direction on to the other side, and closes both once both directions are done.  If either direction fails, both are closed at once. */
+func pipe(a, b net.Conn) {
+	done := make(chan error, 2)
+	copyData := func(dst, src net.Conn) {
+		_, err := io.Copy(dst, src)
+		if err == nil {
+			err = errNoHalfClose
+			if cw, ok := dst.(closeWriter); ok {
+				err = cw.CloseWrite()
+			}
+		}
+		done <- err
+	}
+	go copyData(a, b)
+	go copyData(b, a)
+	if err := <-done; err != nil {
+		/* Unblock the other direction. */
+		a.Close()
+		b.Close()
+		<-done
+		return
+	}
+	<-done
+	a.Close()
+	b.Close()
+}
+
+func main() {
+	socket := flag.String("proxy", "", "use the gss-proxy listening on this socket instead of the local GSSAPI library")
+	keytab := flag.String("keytab", "", "keytab location (server mode, local library only)")
+	allow := flag.String("allow", "", "comma-separated list of principals which may connect (server mode)")
+	aclfile := flag.String("acl", "", "file listing principals which may connect, one per line (server mode)")
+	noenc := flag.Bool("nx", false, "integrity-protect data without encrypting it")
+	deleg := flag.Bool("d", false, "delegate credentials (client mode)")
+	keepalive := flag.Duration("keepalive", 30*time.Second, "TCP keepalive interval, or negative to disable keepalives")
+	timeout := flag.Duration("timeout", 30*time.Second, "time limit for connecting and establishing a context")
+	renew := flag.Duration("renew", stream.DefaultRenewBefore, "how long before a context expires to replace it")
+	grace := flag.Duration("grace", 30*time.Second, "time allowed for connections to finish when shutting down")
+
+	flag.Parse()
+	mode := flag.Arg(0)
+	if !(mode == "client" && flag.NArg() == 4) && !(mode == "server" && flag.NArg() == 3) {
+		fmt.Printf("Usage: gss-tunnel [options] client listen-address server-address gss-service-name\n")
+		fmt.Printf("       gss-tunnel [options] server listen-address backend-address\n")
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+	listen, remote := flag.Arg(1), flag.Arg(2)
+	config := stream.Config{Conf: !*noenc, RenewBefore: *renew}
+
+	var factory mech.Factory
+	var establish func(conn net.Conn) (*stream.Conn, error)
+	if mode == "client" {
+		/* Set up the server's name. */
+		service := flag.Arg(3)
+		if *socket != "" {
+			name := &proxy.Name{DisplayName: service, NameType: proxy.NT_HOSTBASED_SERVICE}
+			flags := proxy.Flags{Mutual: true, Replay: true, Sequence: true, Conf: !*noenc, Integ: true, Deleg: *deleg}
+			factory = func() (mech.Context, error) {
+				return proxy.NewInitiatorContext(*socket, nil, name, proxy.MechKerberos5, flags), nil
+			}
+		} else {
+			major, minor, name := gss.ImportName(service, gss.C_NT_HOSTBASED_SERVICE)
+			if major != gss.S_COMPLETE {
+				gss.DisplayGSSError("importing name", major, minor, nil)
+				os.Exit(1)
+			}
+			defer gss.ReleaseName(name)
+			flags := gss.Flags{Mutual: true, Replay: true, Sequence: true, Conf: !*noenc, Integ: true, Deleg: *deleg}
+			factory = func() (mech.Context, error) {
+				return gss.NewInitiatorContext(nil, name, gss.Mech_krb5, flags), nil
+			}
+		}
+		establish = func(conn net.Conn) (*stream.Conn, error) {
+			return stream.Client(conn, factory, config)
+		}
+	} else {
+		/* Read the list of principals which may connect. */
+		allowed := make(acl)
+		for _, entry := range strings.Split(*allow, ",") {
+			allowed.add(entry)
+		}
+		if *aclfile != "" {
+			if err := allowed.load(*aclfile); err != nil {
+				fmt.Printf("Error reading ACL file \"%s\": %s\n", *aclfile, err)
+				os.Exit(1)
+			}
+		}
+		if len(allowed) == 0 {
+			fmt.Printf("No principals are allowed to connect; use -allow or -acl.\n")
+			os.Exit(1)
+		}
+
+		/* Make sure we have acceptor creds. */
+		var accept mech.Factory
+		if *socket != "" {
+			accept = func() (mech.Context, error) {
+				return proxy.NewAcceptorContext(*socket, nil), nil
+			}
+		} else {
+			var cred gss.CredHandle
+			if *keytab != "" {
+				major, minor, kcred, _, _ := gss.AcquireCredFrom(nil, gss.C_INDEFINITE, nil, gss.C_ACCEPT, credstore.New().SetKeytab(*keytab))
+				if major != gss.S_COMPLETE {
+					gss.DisplayGSSError("acquiring credentials", major, minor, nil)
+					os.Exit(1)
+				}
+				defer gss.ReleaseCred(kcred)
+				cred = kcred
+			}
+			accept = func() (mech.Context, error) {
+				return gss.NewAcceptorContext(cred), nil
+			}
+		}
+		establish = func(conn net.Conn) (*stream.Conn, error) {
+			return stream.Server(conn, aclFactory(accept, allowed, conn.RemoteAddr().String()), config)
+		}
+	}
+
+	/* Set up the listener socket. */
+	lc := net.ListenConfig{KeepAlive: *keepalive}
+	listener, err := lc.Listen(context.Background(), "tcp", listen)
+	if err != nil {
+		fmt.Printf("Error listening for connections: %s\n", err)
+		os.Exit(1)
+	}
+	t := &tunnel{
+		listener: listener,
+		dialer:   net.Dialer{Timeout: *timeout, KeepAlive: *keepalive},
+		conns:    make(map[net.Conn]bool),
+	}
+
+	t.forward = func(conn net.Conn) {
+		var plain, secure net.Conn
+		if mode == "client" {
+			/* Connect to the tunnel server and establish a context with it. */
+			raw, err := t.dialer.Dial("tcp", remote)
+			if err != nil {
+				log.Printf("%s: error connecting to %s: %s", conn.RemoteAddr(), remote, err)
+				return
+			}
+			t.track(raw)
+			defer t.untrack(raw)
+			raw.SetDeadline(time.Now().Add(*timeout))
+			sconn, err := establish(raw)
+			if err != nil {
+				log.Printf("%s: error establishing context with %s: %s", conn.RemoteAddr(), remote, err)
+				raw.Close()
+				return
+			}
+			raw.SetDeadline(time.Time{})
+			plain, secure = conn, sconn
+		} else {
+			/* Authenticate the client, then connect to the backend. */
+			conn.SetDeadline(time.Now().Add(*timeout))
+			sconn, err := establish(conn)
+			if err != nil {
+				log.Printf("%s: error accepting context: %s", conn.RemoteAddr(), err)
+				return
+			}
+			conn.SetDeadline(time.Time{})
+			backend, err := t.dialer.Dial("tcp", remote)
+			if err != nil {
+				log.Printf("%s: error connecting to %s: %s", conn.RemoteAddr(), remote, err)
+				sconn.Close()
+				return
+			}
+			t.track(backend)
+			defer t.untrack(backend)
+			plain, secure = backend, sconn
+		}
+		pipe(plain, secure)
+	}
+
+	/* Shut down gracefully when we're asked to. */
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		log.Printf("received %s, shutting down", sig)
+		listener.Close()
+	}()
+
+	log.Printf("forwarding %s to %s (%s mode)", listener.Addr(), remote, mode)
+	t.serve()
+	t.shutdown(*grace)
+	log.Printf("stopped")
+}
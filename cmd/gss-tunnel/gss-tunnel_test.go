@@ -0,0 +1,160 @@
+package main
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/twistlock/gss/pkg/gss/mech"
+	"github.com/twistlock/gss/pkg/gss/mech/fake"
+	"github.com/twistlock/gss/pkg/gss/stream"
+)
+
+// tcpPair returns the two ends of a loopback TCP connection.
+func tcpPair(t *testing.T) (*net.TCPConn, *net.TCPConn) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, _ := l.Accept()
+		accepted <- conn
+	}()
+	dialed, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn := <-accepted
+	if conn == nil {
+		t.Fatal("accepting a loopback connection failed")
+	}
+	t.Cleanup(func() {
+		dialed.Close()
+		conn.Close()
+	})
+	return dialed.(*net.TCPConn), conn.(*net.TCPConn)
+}
+
+// TestPipeHalfClose runs a request through both ends of a tunnel, with an
+// application which shuts down its side of the connection once it has sent
+// the request, and a backend which only answers once it has read all of it.
+func TestPipeHalfClose(t *testing.T) {
+	cfg := fake.Config{Initiator: "alice@EXAMPLE.COM", Key: []byte("tunnel test key"), Flags: fake.FlagMutual | fake.FlagInteg | fake.FlagConf}
+	config := stream.Config{Conf: true}
+	clientRaw, serverRaw := net.Pipe()
+	accepted := make(chan *stream.Conn, 1)
+	go func() {
+		sconn, err := stream.Server(serverRaw, fake.AcceptorFactory(cfg), config)
+		if err != nil {
+			t.Error(err)
+		}
+		accepted <- sconn
+	}()
+	clientSecure, err := stream.Client(clientRaw, fake.InitiatorFactory(cfg), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverSecure := <-accepted
+	if serverSecure == nil {
+		t.FailNow()
+	}
+
+	app, clientPlain := tcpPair(t)
+	serverPlain, backend := tcpPair(t)
+	done := make(chan struct{}, 2)
+	go func() {
+		pipe(clientPlain, clientSecure)
+		done <- struct{}{}
+	}()
+	go func() {
+		pipe(serverPlain, serverSecure)
+		done <- struct{}{}
+	}()
+
+	go func() {
+		request, err := io.ReadAll(backend)
+		if err != nil {
+			t.Error(err)
+		}
+		backend.Write(append([]byte("response to "), request...))
+		backend.CloseWrite()
+	}()
+	if _, err = app.Write([]byte("request")); err != nil {
+		t.Fatal(err)
+	}
+	if err = app.CloseWrite(); err != nil {
+		t.Fatal(err)
+	}
+	app.SetReadDeadline(time.Now().Add(10 * time.Second))
+	response, err := io.ReadAll(app)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(response) != "response to request" {
+		t.Errorf("got response %q", response)
+	}
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-done:
+		case <-time.After(10 * time.Second):
+			t.Fatal("pipe didn't finish once both directions were done")
+		}
+	}
+}
+
+// TestACLContext establishes contexts as the replacements on one
+// connection would be, checking that each of them must authenticate the
+// client which the first one did.
+func TestACLContext(t *testing.T) {
+	key := []byte("tunnel test key")
+	allowed := acl{"*@EXAMPLE.COM": true}
+	establish := func(factory mech.Factory, initiator string) (mech.Context, error) {
+		ctx, err := factory()
+		if err != nil {
+			return nil, err
+		}
+		i := fake.NewInitiator(fake.Config{Initiator: initiator, Key: key})
+		defer i.Release()
+		token, _, err := i.Step(nil)
+		if err != nil {
+			return nil, err
+		}
+		if _, _, err = ctx.Step(token); err != nil {
+			ctx.Release()
+			return nil, err
+		}
+		return ctx, nil
+	}
+
+	factory := aclFactory(fake.AcceptorFactory(fake.Config{Key: key}), allowed, "test")
+	ctx, err := establish(factory, "alice@EXAMPLE.COM")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name := ctx.(mech.PeerNamer).PeerName(); name != "alice@EXAMPLE.COM" {
+		t.Errorf("PeerName() = %q", name)
+	}
+	ctx.Release()
+	if ctx, err = establish(factory, "alice@EXAMPLE.COM"); err != nil {
+		t.Fatalf("replacement for the same client: %v", err)
+	}
+	ctx.Release()
+	if _, err = establish(factory, "bob@EXAMPLE.COM"); err == nil {
+		t.Error("replacement for another client was accepted")
+	}
+	if _, err = establish(factory, "eve@OTHER.COM"); err == nil {
+		t.Error("client outside the ACL was accepted")
+	}
+
+	// Another connection may authenticate someone else.
+	factory = aclFactory(fake.AcceptorFactory(fake.Config{Key: key}), allowed, "test")
+	if ctx, err = establish(factory, "bob@EXAMPLE.COM"); err != nil {
+		t.Fatalf("new connection: %v", err)
+	}
+	ctx.Release()
+}
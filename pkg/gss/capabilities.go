@@ -0,0 +1,118 @@
+package gss
+
+/*
+#include <stdlib.h>
+#include "loader.h"
+*/
+import "C"
+import "unsafe"
+
+/* Implementation identifies the GSSAPI library which was loaded. */
+type Implementation int
+
+const (
+	ImplementationUnknown Implementation = iota
+	ImplementationMIT
+	ImplementationHeimdal
+)
+
+func (i Implementation) String() string {
+	switch i {
+	case ImplementationMIT:
+		return "MIT"
+	case ImplementationHeimdal:
+		return "Heimdal"
+	}
+	return "unknown"
+}
+
+/* Capabilities reports which optional parts of the API the loaded library provides.  Functions which it doesn't provide return S_UNAVAILABLE when they're called. */
+type Capabilities struct {
+	Implementation Implementation
+	// AcquireCredFrom(), AddCredFrom() and StoreCredInto().
+	CredStore bool
+	// AcquireCredWithPassword() and AddCredWithPassword().
+	CredPassword bool
+	// AcquireCredImpersonateName() and AddCredImpersonateName().
+	CredImpersonate bool
+	// ExportCred() and ImportCred().
+	CredExport bool
+	// InquireName(), GetNameAttribute(), SetNameAttribute(), DeleteNameAttribute(), DisplayNameExt() and ExportNameComposite().
+	NamingExtensions bool
+	// IndicateMechsByAttrs().
+	MechAttributes bool
+	// InquireSaslnameForMech() and InquireMechForSaslname().
+	SaslNames bool
+	// WrapIOV(), UnwrapIOV(), GetMICIOV() and VerifyMICIOV().
+	IOV bool
+	// WrapAEAD() and UnwrapAEAD().
+	AEAD bool
+	// PseudoRandom().
+	PseudoRandom bool
+	// Localname(), AuthorizeLocalname(), Userok() and PNameToUid().
+	Localname bool
+	// SetNegMechs().
+	SetNegMechs bool
+	// InquireSecContextByOid(), SetSecContextOption(), InquireCredByOid() and SetCredOption().
+	Options bool
+	// MechInvoke().
+	MechInvoke bool
+	// Krb5ExportLucidSecContext().
+	Krb5Lucid bool
+	// Krb5ImportCred().
+	Krb5ImportCred bool
+	// Krb5SetCredRcache().
+	Krb5Rcache bool
+}
+
+func hasSymbols(names ...string) bool {
+	for _, name := range names {
+		cname := C.CString(name)
+		found := C.gssdl_has(cname) != 0
+		C.free(unsafe.Pointer(cname))
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+/* InquireImplementation() loads the GSSAPI library if it hasn't been loaded yet, and reports whose implementation it is. */
+func InquireImplementation() Implementation {
+	switch {
+	case !Available():
+		return ImplementationUnknown
+	case C.gssdl_heimdal() != 0:
+		return ImplementationHeimdal
+	case hasSymbols("krb5_gss_register_acceptor_identity"):
+		return ImplementationMIT
+	}
+	return ImplementationUnknown
+}
+
+/* InquireCapabilities() loads the GSSAPI library if it hasn't been loaded yet, and reports which of the optional functions it provides.  If it can't be loaded, none are reported. */
+func InquireCapabilities() Capabilities {
+	if !Available() {
+		return Capabilities{}
+	}
+	return Capabilities{
+		Implementation:   InquireImplementation(),
+		CredStore:        hasSymbols("gss_acquire_cred_from", "gss_add_cred_from", "gss_store_cred_into"),
+		CredPassword:     hasSymbols("gss_acquire_cred_with_password", "gss_add_cred_with_password"),
+		CredImpersonate:  hasSymbols("gss_acquire_cred_impersonate_name", "gss_add_cred_impersonate_name"),
+		CredExport:       hasSymbols("gss_export_cred", "gss_import_cred"),
+		NamingExtensions: hasSymbols("gss_inquire_name", "gss_get_name_attribute", "gss_set_name_attribute", "gss_delete_name_attribute", "gss_display_name_ext", "gss_export_name_composite"),
+		MechAttributes:   hasSymbols("gss_indicate_mechs_by_attrs"),
+		SaslNames:        hasSymbols("gss_inquire_saslname_for_mech", "gss_inquire_mech_for_saslname"),
+		IOV:              hasSymbols("gss_wrap_iov", "gss_unwrap_iov", "gss_wrap_iov_length", "gss_get_mic_iov", "gss_verify_mic_iov"),
+		AEAD:             hasSymbols("gss_wrap_aead", "gss_unwrap_aead"),
+		PseudoRandom:     hasSymbols("gss_pseudo_random"),
+		Localname:        hasSymbols("gss_localname", "gss_authorize_localname", "gss_userok", "gss_pname_to_uid"),
+		SetNegMechs:      hasSymbols("gss_set_neg_mechs"),
+		Options:          hasSymbols("gss_inquire_sec_context_by_oid", "gss_set_sec_context_option", "gss_inquire_cred_by_oid", "gss_set_cred_option"),
+		MechInvoke:       hasSymbols("gssspi_mech_invoke"),
+		Krb5Lucid:        hasSymbols("gss_krb5_export_lucid_sec_context", "gss_krb5_free_lucid_sec_context"),
+		Krb5ImportCred:   hasSymbols("gss_krb5_import_cred"),
+		Krb5Rcache:       hasSymbols("gss_krb5_set_cred_rcache", "krb5_get_server_rcache"),
+	}
+}
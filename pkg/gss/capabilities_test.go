@@ -0,0 +1,71 @@
+package gss_test
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/twistlock/gss/pkg/gss"
+)
+
+func TestImplementationString(t *testing.T) {
+	for impl, want := range map[gss.Implementation]string{
+		gss.ImplementationUnknown: "unknown",
+		gss.ImplementationMIT:     "MIT",
+		gss.ImplementationHeimdal: "Heimdal",
+		gss.Implementation(42):    "unknown",
+	} {
+		if got := impl.String(); got != want {
+			t.Errorf("Implementation(%d).String() = %q, want %q", int(impl), got, want)
+		}
+	}
+}
+
+func TestInquireImplementation(t *testing.T) {
+	impl := gss.InquireImplementation()
+	if !gss.Available() {
+		if impl != gss.ImplementationUnknown {
+			t.Errorf("InquireImplementation() = %v without a library", impl)
+		}
+		return
+	}
+	// The default library names say whose they are.
+	var want gss.Implementation
+	switch base := filepath.Base(gss.LibraryPath()); {
+	case strings.HasPrefix(base, "libgssapi_krb5."):
+		want = gss.ImplementationMIT
+	case strings.HasPrefix(base, "libgssapi."):
+		want = gss.ImplementationHeimdal
+	default:
+		t.Skipf("can't tell whose library %s is", base)
+	}
+	if impl != want {
+		t.Errorf("InquireImplementation() = %v for %s, want %v", impl, gss.LibraryPath(), want)
+	}
+}
+
+func TestInquireCapabilities(t *testing.T) {
+	caps := gss.InquireCapabilities()
+	if !gss.Available() {
+		if caps != (gss.Capabilities{}) {
+			t.Errorf("InquireCapabilities() = %+v without a library", caps)
+		}
+		return
+	}
+	if caps.Implementation != gss.InquireImplementation() {
+		t.Errorf("Capabilities.Implementation = %v, want %v", caps.Implementation, gss.InquireImplementation())
+	}
+	// Every implementation worth loading has had these for years.
+	if !caps.CredStore || !caps.IOV || !caps.PseudoRandom {
+		t.Errorf("InquireCapabilities() = %+v, missing basic extensions", caps)
+	}
+	if caps.Implementation == gss.ImplementationMIT && (!caps.Krb5Lucid || !caps.Krb5ImportCred || !caps.Localname) {
+		t.Errorf("InquireCapabilities() = %+v, missing MIT's extensions", caps)
+	}
+
+	// What's reported agrees with what calling the function does.
+	major, _, _ := gss.PseudoRandom(nil, gss.C_PRF_KEY_FULL, []byte("prf"), 16)
+	if unavailable := major == gss.S_UNAVAILABLE; unavailable == caps.PseudoRandom {
+		t.Errorf("PseudoRandom() returned %#x, but the capability is %v", major, caps.PseudoRandom)
+	}
+}
@@ -0,0 +1,288 @@
+/*
+Package credmgr keeps a set of gss credentials fresh for long-running services.
+
+A Manager acquires credentials from a Source, watches their remaining lifetime,
+and acquires replacements before they expire.  Callers borrow the current
+handle with Get() and give it back when they're done with it, so that a handle
+which has been replaced is only released once nobody is using it any more.
+*/
+package credmgr
+
+import (
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/twistlock/gss/pkg/gss"
+	"github.com/twistlock/gss/pkg/gss/credstore"
+	"github.com/twistlock/gss/pkg/gss/internal/renewal"
+)
+
+const (
+	// DefaultRenewBefore is used if Options.RenewBefore is not set.
+	DefaultRenewBefore = renewal.DefaultRenewBefore
+	// DefaultMinInterval is used if Options.MinInterval is not set.
+	DefaultMinInterval = renewal.DefaultMinInterval
+	// DefaultRetryInterval is used if Options.RetryInterval is not set.
+	DefaultRetryInterval = renewal.DefaultRetryInterval
+	// DefaultMaxRetryInterval is used if Options.MaxRetryInterval is not set.
+	DefaultMaxRetryInterval = renewal.DefaultMaxRetryInterval
+)
+
+/* Source obtains fresh credentials for a Manager. */
+type Source interface {
+	Acquire() (gss.CredHandle, error)
+}
+
+/* SourceFunc adapts an ordinary function to the Source interface. */
+type SourceFunc func() (gss.CredHandle, error)
+
+func (f SourceFunc) Acquire() (gss.CredHandle, error) {
+	return f()
+}
+
+/* Releaser is implemented by Sources which need to clean up after the credentials they acquired.  A Manager calls Release in place of gss.ReleaseCred() once credentials from such a Source have been replaced and are no longer in use. */
+type Releaser interface {
+	Release(cred gss.CredHandle) error
+}
+
+/* KeytabSource acquires credentials for Principal using keys in Keytab, or in the default keytabs if Keytab is empty.  Initiator credentials are always obtained using a new MEMORY ccache, so that the library fetches new tickets rather than reusing ones which are about to expire, and each ccache is destroyed when the credentials which use it are released.  It must be used by pointer. */
+type KeytabSource struct {
+	Principal string
+	Keytab    string
+	Usage     uint32
+	Mechs     []asn1.ObjectIdentifier
+
+	mu      sync.Mutex
+	serial  uint64
+	ccaches map[gss.CredHandle]string
+}
+
+func (s *KeytabSource) Acquire() (gss.CredHandle, error) {
+	store := credstore.New()
+
+	name, err := importPrincipal(s.Principal)
+	if err != nil {
+		return nil, err
+	}
+	if name != nil {
+		defer gss.ReleaseName(name)
+	}
+
+	var ccache string
+	if s.Usage != gss.C_ACCEPT {
+		s.mu.Lock()
+		s.serial++
+		ccache = fmt.Sprintf("MEMORY:credmgr-%p-%d", s, s.serial)
+		s.mu.Unlock()
+		if s.Keytab != "" {
+			store.SetClientKeytab(s.Keytab)
+		}
+		store.SetCCache(ccache)
+	}
+	if s.Usage != gss.C_INITIATE && s.Keytab != "" {
+		store.SetKeytab(s.Keytab)
+	}
+	major, minor, cred, _, _ := gss.AcquireCredFrom(name, gss.C_INDEFINITE, s.Mechs, s.Usage, store)
+	if major != gss.S_COMPLETE {
+		if ccache != "" {
+			gss.Krb5DestroyCcache(ccache)
+		}
+		return nil, gss.NewGSSError("acquiring credentials from keytab", major, minor, nil)
+	}
+	if ccache != "" {
+		s.mu.Lock()
+		if s.ccaches == nil {
+			s.ccaches = make(map[gss.CredHandle]string)
+		}
+		s.ccaches[cred] = ccache
+		s.mu.Unlock()
+	}
+	return cred, nil
+}
+
+/* Release releases cred, and destroys the ccache which was created for it. */
+func (s *KeytabSource) Release(cred gss.CredHandle) error {
+	s.mu.Lock()
+	ccache := s.ccaches[cred]
+	delete(s.ccaches, cred)
+	s.mu.Unlock()
+
+	major, minor := gss.ReleaseCred(cred)
+	if major != gss.S_COMPLETE {
+		return gss.NewGSSError("releasing credentials", major, minor, nil)
+	}
+	if ccache != "" {
+		if major, minor = gss.Krb5DestroyCcache(ccache); major != gss.S_COMPLETE {
+			return gss.NewGSSError("destroying credential cache", major, minor, nil)
+		}
+	}
+	return nil
+}
+
+/* PasswordSource acquires credentials for Principal using Password. */
+type PasswordSource struct {
+	Principal string
+	Password  []byte
+	Usage     uint32
+	Mechs     []asn1.ObjectIdentifier
+}
+
+func (s PasswordSource) Acquire() (gss.CredHandle, error) {
+	name, err := importPrincipal(s.Principal)
+	if err != nil {
+		return nil, err
+	}
+	if name == nil {
+		return nil, errors.New("a principal name is required to acquire credentials with a password")
+	}
+	defer gss.ReleaseName(name)
+
+	major, minor, cred, _, _ := gss.AcquireCredWithPassword(name, s.Password, gss.C_INDEFINITE, s.Mechs, s.Usage)
+	if major != gss.S_COMPLETE {
+		return nil, gss.NewGSSError("acquiring credentials with password", major, minor, nil)
+	}
+	return cred, nil
+}
+
+func importPrincipal(principal string) (gss.InternalName, error) {
+	if principal == "" {
+		return nil, nil
+	}
+	major, minor, name := gss.ImportName(principal, gss.KRB5_NT_PRINCIPAL_NAME)
+	if major != gss.S_COMPLETE {
+		return nil, gss.NewGSSError("importing principal name", major, minor, nil)
+	}
+	return name, nil
+}
+
+/* Event describes the outcome of an attempt to renew credentials. */
+type Event = renewal.Event
+
+/* Stats counts a Manager's renewal attempts. */
+type Stats = renewal.Stats
+
+/* Options control how a Manager treats its credentials.  The zero value is usable. */
+type Options struct {
+	// Mech, if set, is used with gss.InquireCredByMech() to check the lifetime of the credentials.  Otherwise gss.InquireCred() is used.
+	Mech asn1.ObjectIdentifier
+	// Usage selects which lifetime gss.InquireCredByMech() reports is used.  If it is gss.C_BOTH, the shorter of the two is used.
+	Usage uint32
+	// RenewBefore is how long before expiration the credentials are renewed.  Credentials whose lifetime is too short for that are renewed halfway through their remaining lifetime instead.
+	RenewBefore time.Duration
+	// MinInterval is the shortest time to wait between scheduled renewals.
+	MinInterval time.Duration
+	// RetryInterval is how long to wait before trying again after a failed renewal.  It doubles after each consecutive failure, up to MaxRetryInterval.
+	RetryInterval    time.Duration
+	MaxRetryInterval time.Duration
+	// OnEvent, if set, is called after every renewal attempt.
+	OnEvent func(Event)
+}
+
+/* backend adapts a Source to the renewal package. */
+type backend struct {
+	source Source
+	opts   Options
+}
+
+func (b backend) Acquire() (gss.CredHandle, time.Duration, error) {
+	cred, err := b.source.Acquire()
+	if err != nil {
+		return nil, 0, err
+	}
+	lifetime, err := b.lifetime(cred)
+	if err != nil {
+		b.Release(cred)
+		return nil, 0, err
+	}
+	return cred, lifetime, nil
+}
+
+func (b backend) Release(cred gss.CredHandle) {
+	if releaser, ok := b.source.(Releaser); ok {
+		releaser.Release(cred)
+		return
+	}
+	gss.ReleaseCred(cred)
+}
+
+/* lifetime checks how much longer cred will be valid. */
+func (b backend) lifetime(cred gss.CredHandle) (time.Duration, error) {
+	var major, minor, lifetime uint32
+	var name gss.InternalName
+
+	if len(b.opts.Mech) > 0 {
+		var ilife, alife uint32
+		major, minor, name, ilife, alife, _ = gss.InquireCredByMech(cred, b.opts.Mech)
+		switch b.opts.Usage {
+		case gss.C_INITIATE:
+			lifetime = ilife
+		case gss.C_ACCEPT:
+			lifetime = alife
+		default:
+			lifetime = ilife
+			if alife < lifetime {
+				lifetime = alife
+			}
+		}
+	} else {
+		major, minor, name, lifetime, _, _ = gss.InquireCred(cred)
+	}
+	if name != nil {
+		gss.ReleaseName(name)
+	}
+	if major != gss.S_COMPLETE {
+		return 0, gss.NewGSSError("inquiring about credentials", major, minor, nil)
+	}
+	if lifetime == gss.C_INDEFINITE {
+		return -1, nil
+	}
+	return time.Duration(lifetime) * time.Second, nil
+}
+
+/* Manager hands out credentials which it renews in the background. */
+type Manager struct {
+	m *renewal.Manager[gss.CredHandle]
+}
+
+/* New acquires an initial set of credentials from source and starts renewing them in the background.  The Manager should be shut down using Close() when it's no longer needed. */
+func New(source Source, opts Options) (*Manager, error) {
+	m, err := renewal.New[gss.CredHandle](backend{source: source, opts: opts}, renewal.Options{
+		RenewBefore:      opts.RenewBefore,
+		MinInterval:      opts.MinInterval,
+		RetryInterval:    opts.RetryInterval,
+		MaxRetryInterval: opts.MaxRetryInterval,
+		OnEvent:          opts.OnEvent,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Manager{m: m}, nil
+}
+
+/* Get returns the current credentials, along with a function which must be called once the caller is finished with them. */
+func (m *Manager) Get() (cred gss.CredHandle, done func()) {
+	return m.m.Get()
+}
+
+/* Expired tells the Manager that cred was rejected as expired, so that it can be replaced without waiting for the next scheduled renewal. */
+func (m *Manager) Expired(cred gss.CredHandle) {
+	m.m.Expired(cred)
+}
+
+/* Renew acquires new credentials immediately, without waiting for the current ones to near expiration. */
+func (m *Manager) Renew() error {
+	return m.m.Renew()
+}
+
+/* Stats returns counts of the renewals which have been attempted so far. */
+func (m *Manager) Stats() Stats {
+	return m.m.Stats()
+}
+
+/* Close stops renewing the credentials, and releases them once they're no longer in use.  Calling it more than once has no further effect. */
+func (m *Manager) Close() {
+	m.m.Close()
+}
@@ -0,0 +1,158 @@
+package credmgr
+
+import (
+	"testing"
+	"time"
+
+	"github.com/twistlock/gss/pkg/gss"
+	"github.com/twistlock/gss/pkg/gss/credstore"
+	"github.com/twistlock/gss/pkg/gss/gsstest"
+)
+
+// realm starts a KDC with a user, alice, whose keys are in the returned
+// keytab, and a host service whose keys are in the default keytab.
+func realm(t *testing.T) (*gsstest.KDC, string) {
+	t.Helper()
+	kdc := gsstest.Start(t, gsstest.Options{})
+	if err := kdc.AddPrincipal("alice", ""); err != nil {
+		t.Fatal(err)
+	}
+	keytab, err := kdc.NewKeytab("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = kdc.AddService("host"); err != nil {
+		t.Fatal(err)
+	}
+	return kdc, keytab
+}
+
+func TestKeytabSourceCCache(t *testing.T) {
+	kdc, keytab := realm(t)
+	s := &KeytabSource{Principal: kdc.Principal("alice"), Keytab: keytab, Usage: gss.C_INITIATE}
+
+	cred, err := s.Acquire()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.mu.Lock()
+	ccache := s.ccaches[cred]
+	s.mu.Unlock()
+	if ccache == "" {
+		t.Fatal("initiator credentials weren't given a ccache of their own")
+	}
+	cred2, err := s.Acquire()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Release(cred2)
+	if s.ccaches[cred2] == ccache {
+		t.Error("two sets of credentials share a ccache")
+	}
+
+	if err = s.Release(cred); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.ccaches[cred]; ok {
+		t.Error("released credentials are still tracked")
+	}
+	// The ccache is gone, so nothing can be acquired from it.
+	major, _, stale, _, _ := gss.AcquireCredFrom(nil, gss.C_INDEFINITE, nil, gss.C_INITIATE, credstore.New().SetCCache(ccache))
+	if major == gss.S_COMPLETE {
+		gss.ReleaseCred(stale)
+		t.Errorf("ccache %s wasn't destroyed", ccache)
+	}
+
+	// Acceptor credentials don't use a ccache.
+	acceptor := &KeytabSource{Usage: gss.C_ACCEPT}
+	cred, err = acceptor.Acquire()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(acceptor.ccaches) != 0 {
+		t.Error("acceptor credentials were given a ccache")
+	}
+	if err = acceptor.Release(cred); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestKeytabSourceUnknownPrincipal(t *testing.T) {
+	kdc, keytab := realm(t)
+	s := &KeytabSource{Principal: kdc.Principal("bob"), Keytab: keytab, Usage: gss.C_INITIATE}
+	if _, err := s.Acquire(); err == nil {
+		t.Fatal("acquiring credentials for a principal without keys succeeded")
+	}
+	if len(s.ccaches) != 0 {
+		t.Error("a failed acquisition left a ccache behind")
+	}
+}
+
+func TestBackendLifetime(t *testing.T) {
+	kdc, _ := realm(t)
+	s := &KeytabSource{Principal: kdc.Principal("host/" + kdc.Host), Keytab: kdc.Keytab, Usage: gss.C_BOTH}
+	cred, err := s.Acquire()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Release(cred)
+
+	lifetime := func(usage uint32) time.Duration {
+		t.Helper()
+		d, err := backend{source: s, opts: Options{Mech: gss.Mech_krb5, Usage: usage}}.lifetime(cred)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return d
+	}
+	// Keys in a keytab don't expire, but tickets do.
+	initiator := lifetime(gss.C_INITIATE)
+	if initiator <= 0 || initiator > 24*time.Hour {
+		t.Errorf("initiator lifetime %v", initiator)
+	}
+	if acceptor := lifetime(gss.C_ACCEPT); acceptor >= 0 {
+		t.Errorf("acceptor lifetime %v, want a negative value", acceptor)
+	}
+	if both := lifetime(gss.C_BOTH); both != initiator {
+		t.Errorf("lifetime for both usages %v, want the initiator's %v", both, initiator)
+	}
+
+	// Without a mechanism, gss.InquireCred's lifetime is used.
+	d, err := backend{source: s}.lifetime(cred)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d <= 0 || d > 24*time.Hour {
+		t.Errorf("lifetime without a mechanism %v", d)
+	}
+}
+
+// releaseCounter is a Releaser which counts the credentials it releases.
+type releaseCounter struct {
+	*KeytabSource
+	released int
+}
+
+func (r *releaseCounter) Release(cred gss.CredHandle) error {
+	r.released++
+	return r.KeytabSource.Release(cred)
+}
+
+func TestManagerUsesReleaser(t *testing.T) {
+	kdc, keytab := realm(t)
+	source := &releaseCounter{KeytabSource: &KeytabSource{Principal: kdc.Principal("alice"), Keytab: keytab, Usage: gss.C_INITIATE}}
+	m, err := New(source, Options{Mech: gss.Mech_krb5, Usage: gss.C_INITIATE})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = m.Renew(); err != nil {
+		t.Fatal(err)
+	}
+	m.Close()
+	if source.released != 2 {
+		t.Errorf("source released %d credentials, want 2", source.released)
+	}
+	if len(source.ccaches) != 0 {
+		t.Errorf("%d ccaches were left behind", len(source.ccaches))
+	}
+}
@@ -0,0 +1,179 @@
+/*
+Package credstore describes credential stores, which tell a mechanism where to find or store credentials.
+
+A CredStore can be passed to gss.AcquireCredFrom(), gss.AddCredFrom() and
+gss.StoreCredInto().  There is no equivalent for gss-proxy: the daemon doesn't
+read credential stores from its clients' requests, and instead uses the
+cred_store entries of the service in gssproxy.conf that a client is matched to.
+*/
+package credstore
+
+import (
+	"fmt"
+)
+
+/* Key names a type of element in a credential store. */
+type Key string
+
+const (
+	// CCache names the credential cache to use, for example "FILE:/tmp/krb5cc_1000".
+	CCache Key = "ccache"
+	// ClientKeytab names a keytab from which initiator credentials can be obtained.
+	ClientKeytab Key = "client_keytab"
+	// Keytab names the keytab to use for acceptor credentials.
+	Keytab Key = "keytab"
+	// Rcache names the replay cache to use for acceptor credentials.
+	Rcache Key = "rcache"
+	// Password is a password from which initiator credentials can be obtained.
+	Password Key = "password"
+	// Verify requests that credentials obtained using a password be verified using the named keytab, or the default keytab if the value is empty.
+	Verify Key = "verify"
+)
+
+var knownKeys = map[Key]bool{
+	CCache:       true,
+	ClientKeytab: true,
+	Keytab:       true,
+	Rcache:       true,
+	Password:     true,
+	Verify:       true,
+}
+
+/* Element is a single entry in a credential store. */
+type Element struct {
+	Key   Key
+	Value string
+}
+
+/* CredStore is a list of credential store elements with known keys, each of which appears at most once.  The zero value is an empty store, which selects the mechanism's defaults. */
+type CredStore struct {
+	elements []Element
+	err      error
+}
+
+/* New returns an empty credential store. */
+func New() *CredStore {
+	return &CredStore{}
+}
+
+/* Parse builds a credential store from a list of key and value pairs, rejecting unknown and duplicate keys. */
+func Parse(pairs [][2]string) (*CredStore, error) {
+	s := New()
+	for _, pair := range pairs {
+		err := s.Add(Key(pair[0]), pair[1])
+		if err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+/* check rejects unknown keys, and empty values for keys which need one. */
+func check(key Key, value string) error {
+	if !knownKeys[key] {
+		return fmt.Errorf("unknown credential store key %q", string(key))
+	}
+	if value == "" && key != Verify {
+		return fmt.Errorf("credential store key %q requires a value", string(key))
+	}
+	return nil
+}
+
+/* Add adds an element to the store, rejecting unknown and duplicate keys, and empty values for keys which need one. */
+func (s *CredStore) Add(key Key, value string) error {
+	if err := check(key, value); err != nil {
+		return err
+	}
+	for _, e := range s.elements {
+		if e.Key == key {
+			return fmt.Errorf("credential store key %q given more than once", string(key))
+		}
+	}
+	s.elements = append(s.elements, Element{Key: key, Value: value})
+	return nil
+}
+
+/* set replaces or adds an element.  It's used by the setters for specific keys, which can be chained, so a value which Add would reject is left out of the store and the first such error is kept for Err() to report. */
+func (s *CredStore) set(key Key, value string) *CredStore {
+	if err := check(key, value); err != nil {
+		if s.err == nil {
+			s.err = err
+		}
+		return s
+	}
+	for i, e := range s.elements {
+		if e.Key == key {
+			s.elements[i].Value = value
+			return s
+		}
+	}
+	s.elements = append(s.elements, Element{Key: key, Value: value})
+	return s
+}
+
+/* Err returns the first error from the setters, such as an empty value for a key which needs one.  Functions which use a store refuse it if Err() is not nil. */
+func (s *CredStore) Err() error {
+	if s == nil {
+		return nil
+	}
+	return s.err
+}
+
+/* SetCCache sets the credential cache, and returns the store so that calls can be chained. */
+func (s *CredStore) SetCCache(name string) *CredStore {
+	return s.set(CCache, name)
+}
+
+/* SetClientKeytab sets the client keytab, and returns the store so that calls can be chained. */
+func (s *CredStore) SetClientKeytab(name string) *CredStore {
+	return s.set(ClientKeytab, name)
+}
+
+/* SetKeytab sets the acceptor keytab, and returns the store so that calls can be chained. */
+func (s *CredStore) SetKeytab(name string) *CredStore {
+	return s.set(Keytab, name)
+}
+
+/* SetRcache sets the replay cache, and returns the store so that calls can be chained. */
+func (s *CredStore) SetRcache(name string) *CredStore {
+	return s.set(Rcache, name)
+}
+
+/* SetPassword sets the password, and returns the store so that calls can be chained. */
+func (s *CredStore) SetPassword(password string) *CredStore {
+	return s.set(Password, password)
+}
+
+/* SetVerify requests verification of password-derived credentials using keytab, or the default keytab if keytab is empty, and returns the store so that calls can be chained. */
+func (s *CredStore) SetVerify(keytab string) *CredStore {
+	return s.set(Verify, keytab)
+}
+
+/* Get returns the value for key, and whether or not it was set. */
+func (s *CredStore) Get(key Key) (string, bool) {
+	if s == nil {
+		return "", false
+	}
+	for _, e := range s.elements {
+		if e.Key == key {
+			return e.Value, true
+		}
+	}
+	return "", false
+}
+
+/* Elements returns a copy of the store's elements, in the order in which they were added. */
+func (s *CredStore) Elements() []Element {
+	if s == nil {
+		return nil
+	}
+	return append([]Element(nil), s.elements...)
+}
+
+/* Len returns the number of elements in the store. */
+func (s *CredStore) Len() int {
+	if s == nil {
+		return 0
+	}
+	return len(s.elements)
+}
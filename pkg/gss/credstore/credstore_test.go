@@ -0,0 +1,43 @@
+package credstore
+
+import "testing"
+
+func TestSettersValidate(t *testing.T) {
+	s := New().SetKeytab("FILE:/etc/krb5.keytab").SetVerify("")
+	if err := s.Err(); err != nil {
+		t.Fatalf("valid setters failed: %v", err)
+	}
+	if s.Len() != 2 {
+		t.Fatalf("got %d elements, want 2", s.Len())
+	}
+
+	s.SetCCache("").SetPassword("secret")
+	if s.Err() == nil {
+		t.Error("an empty ccache name was accepted")
+	}
+	if _, ok := s.Get(CCache); ok {
+		t.Error("an empty ccache name was added to the store")
+	}
+	if v, _ := s.Get(Password); v != "secret" {
+		t.Errorf("password is %q after a failed setter, want it set", v)
+	}
+}
+
+func TestAdd(t *testing.T) {
+	s := New()
+	if err := s.Add(Keytab, ""); err == nil {
+		t.Error("Add accepted an empty keytab name")
+	}
+	if err := s.Add("client_cert", "x"); err == nil {
+		t.Error("Add accepted an unknown key")
+	}
+	if err := s.Add(Keytab, "FILE:a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Add(Keytab, "FILE:b"); err == nil {
+		t.Error("Add accepted a duplicate key")
+	}
+	if s.Err() != nil {
+		t.Errorf("Add set the setters' error: %v", s.Err())
+	}
+}
@@ -1,14 +1,11 @@
 package gss
 
 /*
-#cgo LDFLAGS: -lgssapi_krb5 -lkrb5 -lcom_err -lkrb5support -lk5crypto -lgssrpc -ldl -lz -lresolv -lkeyutils -lpthread
+#cgo LDFLAGS: -ldl -lpthread
 #include <sys/types.h>
 #include <stdlib.h>
 #include <string.h>
-#include <gssapi/gssapi.h>
-#include <gssapi/gssapi_generic.h>
-#include <gssapi/gssapi_krb5.h>
-#include <gssapi/gssapi_ext.h>
+#include "loader.h"
 
 static gss_OID_desc nth_oid_in_set(gss_OID_set_desc *oset, unsigned int n)
 {
@@ -20,7 +17,7 @@ static gss_buffer_desc nth_buffer_in_set(gss_buffer_set_desc *bset, unsigned int
 }
 static gss_key_value_element_desc *alloc_n_kvset_elems(unsigned int n)
 {
-	return malloc(sizeof(gss_key_value_element_desc));
+	return calloc(n, sizeof(gss_key_value_element_desc));
 }
 static void kv_set(gss_key_value_set_desc *kvset, int i, char *key, char *value)
 {
@@ -69,6 +66,8 @@ copyOid(unsigned char *bytes, int len)
 */
 import "C"
 import "unsafe"
+import "github.com/twistlock/gss/pkg/gss/credstore"
+import "github.com/twistlock/gss/pkg/gss/token"
 import "encoding/asn1"
 import "fmt"
 import "bytes"
@@ -149,68 +148,67 @@ const (
 	C_PRF_KEY_PARTIAL = C.GSS_C_PRF_KEY_PARTIAL
 )
 
+/* The library is only loaded when it's first used, so these are spelled out here instead of being copied from the library's own variables. */
 var (
-	C_INQ_SSPI_SESSION_KEY  = coidToOid(*C.GSS_C_INQ_SSPI_SESSION_KEY)
-	C_ATTR_LOCAL_LOGIN_USER = bufferToString(*C.GSS_C_ATTR_LOCAL_LOGIN_USER)
-	C_NT_COMPOSITE_EXPORT   = coidToOid(*C.GSS_C_NT_COMPOSITE_EXPORT)
+	C_INQ_SSPI_SESSION_KEY  = asn1.ObjectIdentifier{1, 2, 840, 113554, 1, 2, 2, 5, 5}
+	C_ATTR_LOCAL_LOGIN_USER = "local-login-user"
+	C_NT_COMPOSITE_EXPORT   = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 6, 6}
 
 	// Recognized name types.
-	C_NT_USER_NAME                 = coidToOid(*C.GSS_C_NT_USER_NAME)
-	C_NT_MACHINE_UID_NAME          = coidToOid(*C.GSS_C_NT_MACHINE_UID_NAME)
-	C_NT_STRING_UID_NAME           = coidToOid(*C.GSS_C_NT_STRING_UID_NAME)
-	C_NT_HOSTBASED_SERVICE_X       = coidToOid(*C.GSS_C_NT_HOSTBASED_SERVICE_X)
-	C_NT_HOSTBASED_SERVICE         = coidToOid(*C.GSS_C_NT_HOSTBASED_SERVICE)
-	C_NT_ANONYMOUS                 = coidToOid(*C.GSS_C_NT_ANONYMOUS)
-	C_NT_EXPORT_NAME               = coidToOid(*C.GSS_C_NT_EXPORT_NAME)
-	KRB5_NT_PRINCIPAL_NAME         = coidToOid(*C.GSS_KRB5_NT_PRINCIPAL_NAME)
-	KRB5_NT_HOSTBASED_SERVICE_NAME = coidToOid(*C.GSS_KRB5_NT_HOSTBASED_SERVICE_NAME)
-	KRB5_NT_USER_NAME              = coidToOid(*C.GSS_KRB5_NT_USER_NAME)
-	KRB5_NT_MACHINE_UID_NAME       = coidToOid(*C.GSS_KRB5_NT_MACHINE_UID_NAME)
-	KRB5_NT_STRING_UID_NAME        = coidToOid(*C.GSS_KRB5_NT_STRING_UID_NAME)
+	C_NT_USER_NAME                 = asn1.ObjectIdentifier{1, 2, 840, 113554, 1, 2, 1, 1}
+	C_NT_MACHINE_UID_NAME          = asn1.ObjectIdentifier{1, 2, 840, 113554, 1, 2, 1, 2}
+	C_NT_STRING_UID_NAME           = asn1.ObjectIdentifier{1, 2, 840, 113554, 1, 2, 1, 3}
+	C_NT_HOSTBASED_SERVICE_X       = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 6, 2}
+	C_NT_HOSTBASED_SERVICE         = asn1.ObjectIdentifier{1, 2, 840, 113554, 1, 2, 1, 4}
+	C_NT_ANONYMOUS                 = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 6, 3}
+	C_NT_EXPORT_NAME               = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 6, 4}
+	KRB5_NT_PRINCIPAL_NAME         = asn1.ObjectIdentifier{1, 2, 840, 113554, 1, 2, 2, 1}
+	KRB5_NT_HOSTBASED_SERVICE_NAME = C_NT_HOSTBASED_SERVICE
+	KRB5_NT_USER_NAME              = C_NT_USER_NAME
+	KRB5_NT_MACHINE_UID_NAME       = C_NT_MACHINE_UID_NAME
+	KRB5_NT_STRING_UID_NAME        = C_NT_STRING_UID_NAME
 
 	// Recognized mechanism attributes.
-	C_MA_MECH_CONCRETE  = coidToOid(*C.GSS_C_MA_MECH_CONCRETE)
-	C_MA_MECH_PSEUDO    = coidToOid(*C.GSS_C_MA_MECH_PSEUDO)
-	C_MA_MECH_COMPOSITE = coidToOid(*C.GSS_C_MA_MECH_COMPOSITE)
-	C_MA_MECH_NEGO      = coidToOid(*C.GSS_C_MA_MECH_NEGO)
-	C_MA_MECH_GLUE      = coidToOid(*C.GSS_C_MA_MECH_GLUE)
-	C_MA_NOT_MECH       = coidToOid(*C.GSS_C_MA_NOT_MECH)
-	C_MA_DEPRECATED     = coidToOid(*C.GSS_C_MA_DEPRECATED)
-	C_MA_NOT_DFLT_MECH  = coidToOid(*C.GSS_C_MA_NOT_DFLT_MECH)
-	C_MA_ITOK_FRAMED    = coidToOid(*C.GSS_C_MA_ITOK_FRAMED)
-	C_MA_AUTH_INIT      = coidToOid(*C.GSS_C_MA_AUTH_INIT)
-	C_MA_AUTH_TARG      = coidToOid(*C.GSS_C_MA_AUTH_TARG)
-	C_MA_AUTH_INIT_INIT = coidToOid(*C.GSS_C_MA_AUTH_INIT_INIT)
-	C_MA_AUTH_TARG_INIT = coidToOid(*C.GSS_C_MA_AUTH_TARG_INIT)
-	C_MA_AUTH_INIT_ANON = coidToOid(*C.GSS_C_MA_AUTH_INIT_ANON)
-	C_MA_AUTH_TARG_ANON = coidToOid(*C.GSS_C_MA_AUTH_TARG_ANON)
-	C_MA_DELEG_CRED     = coidToOid(*C.GSS_C_MA_DELEG_CRED)
-	C_MA_INTEG_PROT     = coidToOid(*C.GSS_C_MA_INTEG_PROT)
-	C_MA_CONF_PROT      = coidToOid(*C.GSS_C_MA_CONF_PROT)
-	C_MA_MIC            = coidToOid(*C.GSS_C_MA_MIC)
-	C_MA_WRAP           = coidToOid(*C.GSS_C_MA_WRAP)
-	C_MA_PROT_READY     = coidToOid(*C.GSS_C_MA_PROT_READY)
-	C_MA_REPLAY_DET     = coidToOid(*C.GSS_C_MA_REPLAY_DET)
-	C_MA_OOS_DET        = coidToOid(*C.GSS_C_MA_OOS_DET)
-	C_MA_CBINDINGS      = coidToOid(*C.GSS_C_MA_CBINDINGS)
-	C_MA_PFS            = coidToOid(*C.GSS_C_MA_PFS)
-	C_MA_COMPRESS       = coidToOid(*C.GSS_C_MA_COMPRESS)
-	C_MA_CTX_TRANS      = coidToOid(*C.GSS_C_MA_CTX_TRANS)
+	C_MA_MECH_CONCRETE  = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 13, 1}
+	C_MA_MECH_PSEUDO    = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 13, 2}
+	C_MA_MECH_COMPOSITE = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 13, 3}
+	C_MA_MECH_NEGO      = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 13, 4}
+	C_MA_MECH_GLUE      = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 13, 5}
+	C_MA_NOT_MECH       = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 13, 6}
+	C_MA_DEPRECATED     = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 13, 7}
+	C_MA_NOT_DFLT_MECH  = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 13, 8}
+	C_MA_ITOK_FRAMED    = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 13, 9}
+	C_MA_AUTH_INIT      = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 13, 10}
+	C_MA_AUTH_TARG      = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 13, 11}
+	C_MA_AUTH_INIT_INIT = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 13, 12}
+	C_MA_AUTH_TARG_INIT = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 13, 13}
+	C_MA_AUTH_INIT_ANON = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 13, 14}
+	C_MA_AUTH_TARG_ANON = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 13, 15}
+	C_MA_DELEG_CRED     = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 13, 16}
+	C_MA_INTEG_PROT     = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 13, 17}
+	C_MA_CONF_PROT      = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 13, 18}
+	C_MA_MIC            = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 13, 19}
+	C_MA_WRAP           = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 13, 20}
+	C_MA_PROT_READY     = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 13, 21}
+	C_MA_REPLAY_DET     = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 13, 22}
+	C_MA_OOS_DET        = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 13, 23}
+	C_MA_CBINDINGS      = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 13, 24}
+	C_MA_PFS            = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 13, 25}
+	C_MA_COMPRESS       = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 13, 26}
+	C_MA_CTX_TRANS      = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 13, 27}
 
 	// Some mechanisms.
-	Mech_krb5          = coidToOid(*C.gss_mech_krb5)
-	Mech_krb5_old      = coidToOid(*C.gss_mech_krb5_old)
-	Mech_krb5_wrong    = coidToOid(*C.gss_mech_krb5_wrong)
-	Mech_iakerb        = coidToOid(*C.gss_mech_iakerb)
+	Mech_krb5          = asn1.ObjectIdentifier{1, 2, 840, 113554, 1, 2, 2}
+	Mech_krb5_old      = asn1.ObjectIdentifier{1, 3, 5, 1, 5, 2}
+	Mech_krb5_wrong    = asn1.ObjectIdentifier{1, 2, 840, 48018, 1, 2, 2}
+	Mech_iakerb        = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 2, 5}
 	Mech_spnego        = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 2}
-	Mech_set_krb5      = coidSetToOids(C.gss_mech_set_krb5)
-	Mech_set_krb5_old  = coidSetToOids(C.gss_mech_set_krb5_old)
-	Mech_set_krb5_both = coidSetToOids(C.gss_mech_set_krb5_both)
+	Mech_set_krb5      = []asn1.ObjectIdentifier{Mech_krb5}
+	Mech_set_krb5_old  = []asn1.ObjectIdentifier{Mech_krb5_old}
+	Mech_set_krb5_both = []asn1.ObjectIdentifier{Mech_krb5, Mech_krb5_old}
 
-	NT_krb5_name      = coidToOid(*C.gss_nt_krb5_name)
-	NT_krb5_principal = coidToOid(*C.gss_nt_krb5_principal)
-
-//Krb5_gss_oid_array = coidToOid(C.krb5_gss_oid_array)
+	NT_krb5_name      = asn1.ObjectIdentifier{1, 2, 840, 113554, 1, 2, 2, 1}
+	NT_krb5_principal = asn1.ObjectIdentifier{1, 2, 840, 113554, 1, 2, 2, 2}
 )
 
 /* CredHandle holds a reference to client or server credentials, or delegated credentials.  It should be released using gss.ReleaseCred() when it's no longer needed. */
@@ -300,81 +298,12 @@ func buffersToStrings(cdesc C.gss_buffer_set_desc) (s []string) {
 }
 
 /* Encode a tag and a length as a DER definite length */
-func makeTagAndLength(tag, length int) (l []byte) {
-	var count, bits int
-
-	if length <= 127 {
-		l = make([]byte, 2)
-		l[0] = byte(tag)
-		l[1] = byte(length)
-		return
-	}
-	count = 0
-	bits = length
-	for bits != 0 {
-		count++
-		bits = bits >> 8
-	}
-	if count > 126 {
-		return nil
-	}
-	l = make([]byte, 2+count)
-	count = 0
-	bits = length
-	l[0] = byte(tag)
-	for bits != 0 {
-		l[len(l)-1-count] = byte(bits & 0xff)
-		count++
-		bits = bits >> 8
-	}
-	l[1] = byte(count | 0x80)
-	return
-}
-
-/* Split up a DER item */
-func splitTagAndLength(tlv []byte) (class int, constructed bool, tag, length int, value []byte) {
-	tbytes := 1
-	lbytes := 1
-
-	class = int((tlv[0] & 0xc0) >> 6)
-	constructed = (tlv[0] & 0x20) != 0
-	tag = int(tlv[0] & 0x1f)
-	if tag == 0x1f {
-		tag = 0
-		for tlv[tbytes]&0x80 != 0 {
-			tag = (tag << 7) + int(tlv[tbytes]&0x7f)
-			tbytes++
-		}
-		tag = (tag << 7) + int(tlv[tbytes]&0x7f)
-		tbytes++
-	}
-	if tlv[tbytes]&0x80 == 0 {
-		length = int(tlv[tbytes] & 0x7f)
-	} else {
-		lbytes = int(tlv[tbytes] & 0x7f)
-		if lbytes == 0 {
-			value = nil
-			return
-		}
-		for count := 0; count < lbytes; count++ {
-			length = (length << 8) + int(tlv[tbytes+1+count]&0xff)
-		}
-		lbytes++
-	}
-	if len(tlv) != tbytes+lbytes+length {
-		value = nil
-		return
-	}
-	value = tlv[(tbytes + lbytes):]
-	return
-}
-
 /* coidToOid produces an asn1.ObjectIdentifier from the library's preferred bytes-and-length representation, which is just the DER encoding without a tag and length. */
 func coidToOid(coid C.gss_OID_desc) (oid asn1.ObjectIdentifier) {
 	length := C.int(coid.length)
 	b := C.GoBytes(coid.elements, length)
 
-	b = append(makeTagAndLength(6, len(b)), b...)
+	b = append(token.TagAndLength(asn1.TagOID, len(b)), b...)
 	asn1.Unmarshal(b, &oid)
 	return
 }
@@ -389,10 +318,11 @@ func oidToCOid(oid asn1.ObjectIdentifier) (coid C.gss_OID) {
 	if b == nil {
 		return
 	}
-	_, _, _, _, v := splitTagAndLength(b)
-	if v == nil {
+	e, err := token.SplitOne(b)
+	if err != nil {
 		return
 	}
+	v := e.Value
 	length := len(v)
 	if length == 0 {
 		return
@@ -453,32 +383,45 @@ func coidSetToOids(coids *C.gss_OID_set_desc) (oidSet []asn1.ObjectIdentifier) {
 	return
 }
 
+/* bindingsToCBindings() allocates a gss_channel_bindings_t, which should be freed using freeCBindings(). */
 func bindingsToCBindings(bindings *ChannelBindings) (cbindings C.gss_channel_bindings_t) {
 	if bindings == nil {
 		return nil
 	}
+	cbindings = C.gss_channel_bindings_t(C.calloc(1, C.sizeof_struct_gss_channel_bindings_struct))
 	cbindings.application_data = bytesToBuffer(bindings.ApplicationData)
-	defer C.free(cbindings.application_data.value)
 	return
 }
 
+func freeCBindings(cbindings C.gss_channel_bindings_t) {
+	if cbindings == nil {
+		return
+	}
+	C.free(cbindings.application_data.value)
+	C.free(unsafe.Pointer(cbindings))
+}
+
 func cbindingsToBindings(cbindings C.gss_channel_bindings_t) (bindings *ChannelBindings) {
 	if cbindings == nil {
 		return nil
 	}
-	bindings.ApplicationData = bufferToBytes(cbindings.application_data)
+	bindings = &ChannelBindings{ApplicationData: bufferToBytes(cbindings.application_data)}
 	return
 }
 
-func credStoreToKVSet(credStore [][2]string) (kvset C.gss_key_value_set_desc) {
-	kvset.elements = C.alloc_n_kvset_elems(C.uint(len(credStore)))
+func credStoreToKVSet(credStore *credstore.CredStore) (kvset C.gss_key_value_set_desc) {
+	elements := credStore.Elements()
+	if len(elements) == 0 {
+		return
+	}
+	kvset.elements = C.alloc_n_kvset_elems(C.uint(len(elements)))
 	if kvset.elements == nil {
 		return
 	}
-	for i, kv := range credStore {
-		C.kv_set(&kvset, C.int(i), C.CString(kv[0]), C.CString(kv[1]))
+	for i, e := range elements {
+		C.kv_set(&kvset, C.int(i), C.CString(string(e.Key)), C.CString(e.Value))
 	}
-	kvset.count = C.OM_uint32(len(credStore))
+	kvset.count = C.OM_uint32(len(elements))
 	return
 }
 
@@ -674,6 +617,7 @@ func InitSecContext(claimantCredHandle CredHandle, contextHandle *ContextHandle,
 
 	major = C.gss_init_sec_context(&minor, handle, &ctx, name, desired, flags, lifetime, bindings, &itoken, &actual, &otoken, &flags, &lifetime)
 	C.free_oid(desired)
+	freeCBindings(bindings)
 
 	*contextHandle = ContextHandle(ctx)
 	majorStatus = uint32(major)
@@ -713,6 +657,7 @@ func AcceptSecContext(acceptorCredHandle CredHandle, contextHandle *ContextHandl
 		defer C.free(itoken.value)
 	}
 	major = C.gss_accept_sec_context(&minor, &ctx, handle, &itoken, bindings, &name, &actual, &otoken, &flags, &lifetime, &dhandle)
+	freeCBindings(bindings)
 	*contextHandle = ContextHandle(ctx)
 	majorStatus = uint32(major)
 	minorStatus = uint32(minor)
@@ -1238,6 +1183,49 @@ func IndicateMechsByAttrs(desiredMechAttrs, exceptMechAttrs, criticalMechAttrs [
 	return
 }
 
+/* InquireSaslnameForMech() returns the SASL name of a mechanism (RFC 5801), along with its name and a description of it. */
+func InquireSaslnameForMech(desiredMech asn1.ObjectIdentifier) (majorStatus, minorStatus uint32, saslMechName, mechName, mechDescription string) {
+	mech := oidToCOid(desiredMech)
+	var major, minor C.OM_uint32
+	var sname, mname, mdesc C.gss_buffer_desc
+
+	major = C.gss_inquire_saslname_for_mech(&minor, mech, &sname, &mname, &mdesc)
+	C.free_oid(mech)
+
+	majorStatus = uint32(major)
+	minorStatus = uint32(minor)
+	if sname.length > 0 {
+		saslMechName = bufferToString(sname)
+		major = C.gss_release_buffer(&minor, &sname)
+	}
+	if mname.length > 0 {
+		mechName = bufferToString(mname)
+		major = C.gss_release_buffer(&minor, &mname)
+	}
+	if mdesc.length > 0 {
+		mechDescription = bufferToString(mdesc)
+		major = C.gss_release_buffer(&minor, &mdesc)
+	}
+	return
+}
+
+/* InquireMechForSaslname() returns the mechanism which has the given SASL name. */
+func InquireMechForSaslname(saslMechName string) (majorStatus, minorStatus uint32, mechType asn1.ObjectIdentifier) {
+	name := bytesToBuffer([]byte(saslMechName))
+	defer C.free(name.value)
+	var major, minor C.OM_uint32
+	var mech C.gss_OID
+
+	major = C.gss_inquire_mech_for_saslname(&minor, &name, &mech)
+
+	majorStatus = uint32(major)
+	minorStatus = uint32(minor)
+	if mech != nil {
+		mechType = coidToOid(*mech)
+	}
+	return
+}
+
 /* Krb5ExtractAuthzDataFromSecContext() returns the raw bytes of a specific Kerberos auth-data type associated with the established security context's client. */
 func Krb5ExtractAuthzDataFromSecContext(contextHandle ContextHandle, adType int) (majorStatus, minorStatus uint32, adData []byte) {
 	handle := C.gss_ctx_id_t(contextHandle)
@@ -1645,8 +1633,12 @@ func ExportNameComposite(name InternalName) (majorStatus, minorStatus uint32, co
 	return
 }
 
-/* AcquireCredFrom() obtains credentials to be used to either initiate or accept (or both) a security context as desiredName using information pointed to by the credStore.  The returned outputCredHandle should be released using gss.ReleaseCred() when it's no longer needed. */
-func AcquireCredFrom(desiredName InternalName, timeReq uint32, desiredMechs []asn1.ObjectIdentifier, desiredCredUsage uint32, credStore [][2]string) (majorStatus, minorStatus uint32, outputCredHandle CredHandle, actualMechs []asn1.ObjectIdentifier, timeRec uint32) {
+/* AcquireCredFrom() obtains credentials to be used to either initiate or accept (or both) a security context as desiredName using information pointed to by the credStore.  The returned outputCredHandle should be released using gss.ReleaseCred() when it's no longer needed.  A credStore whose Err() is not nil is refused with S_FAILURE. */
+func AcquireCredFrom(desiredName InternalName, timeReq uint32, desiredMechs []asn1.ObjectIdentifier, desiredCredUsage uint32, credStore *credstore.CredStore) (majorStatus, minorStatus uint32, outputCredHandle CredHandle, actualMechs []asn1.ObjectIdentifier, timeRec uint32) {
+	if credStore.Err() != nil {
+		majorStatus = S_FAILURE
+		return
+	}
 	name := C.gss_name_t(desiredName)
 	time := C.OM_uint32(timeReq)
 	dmechs := oidsToCOidSet(desiredMechs)
@@ -1669,8 +1661,12 @@ func AcquireCredFrom(desiredName InternalName, timeReq uint32, desiredMechs []as
 	return
 }
 
-/* AddCredFrom() obtains credentials specific to a particular mechanism using information pointed to by credStore, optionally merging them with already-obtained credentials (if outputCredHandle is not nil) or storing them in a new credential handle which should eventually be freed using gss.ReleaseCred(). */
-func AddCredFrom(inputCredHandle CredHandle, desiredName InternalName, desiredMech asn1.ObjectIdentifier, desiredCredUsage, initiatorTimeReq, acceptorTimeReq uint32, outputCredHandle CredHandle, credStore [][2]string) (majorStatus, minorStatus uint32, outputCredHandleRec CredHandle, actualMechs []asn1.ObjectIdentifier, initiatorTimeRec, acceptorTimeRec uint32) {
+/* AddCredFrom() obtains credentials specific to a particular mechanism using information pointed to by credStore, optionally merging them with already-obtained credentials (if outputCredHandle is not nil) or storing them in a new credential handle which should eventually be freed using gss.ReleaseCred().  A credStore whose Err() is not nil is refused with S_FAILURE. */
+func AddCredFrom(inputCredHandle CredHandle, desiredName InternalName, desiredMech asn1.ObjectIdentifier, desiredCredUsage, initiatorTimeReq, acceptorTimeReq uint32, outputCredHandle CredHandle, credStore *credstore.CredStore) (majorStatus, minorStatus uint32, outputCredHandleRec CredHandle, actualMechs []asn1.ObjectIdentifier, initiatorTimeRec, acceptorTimeRec uint32) {
+	if credStore.Err() != nil {
+		majorStatus = S_FAILURE
+		return
+	}
 	icred := C.gss_cred_id_t(inputCredHandle)
 	ocred := C.gss_cred_id_t(outputCredHandle)
 	name := C.gss_name_t(desiredName)
@@ -1696,8 +1692,12 @@ func AddCredFrom(inputCredHandle CredHandle, desiredName InternalName, desiredMe
 	return
 }
 
-/* StoreCredInto() stores non-nil credentials (for initiator, acceptor, or both) in locations pointed to by the credential store, or the default location if defaultCred is set. */
-func StoreCredInto(inputCredHandle CredHandle, desiredCredUsage uint32, desiredMech asn1.ObjectIdentifier, overwriteCred, defaultCred bool, credStore [][2]string) (majorStatus, minorStatus uint32, elementsStored []asn1.ObjectIdentifier, credUsage uint32) {
+/* StoreCredInto() stores non-nil credentials (for initiator, acceptor, or both) in locations pointed to by the credential store, or the default location if defaultCred is set.  A credStore whose Err() is not nil is refused with S_FAILURE. */
+func StoreCredInto(inputCredHandle CredHandle, desiredCredUsage uint32, desiredMech asn1.ObjectIdentifier, overwriteCred, defaultCred bool, credStore *credstore.CredStore) (majorStatus, minorStatus uint32, elementsStored []asn1.ObjectIdentifier, credUsage uint32) {
+	if credStore.Err() != nil {
+		majorStatus = S_FAILURE
+		return
+	}
 	cred := C.gss_cred_id_t(inputCredHandle)
 	usage := C.gss_cred_usage_t(desiredCredUsage)
 	mech := oidToCOid(desiredMech)
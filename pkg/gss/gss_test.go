@@ -0,0 +1,311 @@
+package gss_test
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+
+	"github.com/twistlock/gss/pkg/gss"
+	"github.com/twistlock/gss/pkg/gss/gsstest"
+)
+
+// krb5Realm starts a throwaway KDC, skipping the test if none can be
+// started, with alice's credentials in the default ccache and a host service
+// in the default keytab.  It returns the service's name.
+func krb5Realm(tb testing.TB) (*gsstest.KDC, gss.InternalName) {
+	tb.Helper()
+	kdc := gsstest.Start(tb, gsstest.Options{})
+	if err := kdc.AddUser("alice"); err != nil {
+		tb.Fatal(err)
+	}
+	service, err := kdc.AddService("host")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	major, minor, target := gss.ImportName(service, gss.C_NT_HOSTBASED_SERVICE)
+	if major != gss.S_COMPLETE {
+		tb.Fatal(gss.NewGSSError("importing name", major, minor, nil))
+	}
+	tb.Cleanup(func() { gss.ReleaseName(target) })
+	return kdc, target
+}
+
+// establish sets up a pair of Kerberos contexts with target, using the given
+// credentials.  The caller is responsible for deleting them.
+func establish(tb testing.TB, icred, acred gss.CredHandle, target gss.InternalName) (initiator, acceptor gss.ContextHandle) {
+	tb.Helper()
+	var itoken, atoken []byte
+	var imajor, amajor, minor uint32 = gss.S_CONTINUE_NEEDED, gss.S_CONTINUE_NEEDED, 0
+	for imajor == gss.S_CONTINUE_NEEDED {
+		imajor, minor, _, itoken, _, _, _, _ = gss.InitSecContext(icred, &initiator, target, gss.Mech_krb5, gss.Flags{Mutual: true, Conf: true, Integ: true}, gss.C_INDEFINITE, nil, atoken)
+		if imajor != gss.S_COMPLETE && imajor != gss.S_CONTINUE_NEEDED {
+			tb.Fatal(gss.NewGSSError("initializing context", imajor, minor, nil))
+		}
+		if len(itoken) == 0 {
+			break
+		}
+		amajor, minor, _, _, _, _, _, _, _, atoken = gss.AcceptSecContext(acred, &acceptor, nil, itoken)
+		if amajor != gss.S_COMPLETE && amajor != gss.S_CONTINUE_NEEDED {
+			tb.Fatal(gss.NewGSSError("accepting context", amajor, minor, nil))
+		}
+	}
+	if imajor != gss.S_COMPLETE || amajor != gss.S_COMPLETE {
+		tb.Fatalf("context establishment stopped early (%#x, %#x)", imajor, amajor)
+	}
+	return
+}
+
+// krb5Contexts establishes a pair of Kerberos contexts using a throwaway KDC,
+// skipping the test if none can be started.
+func krb5Contexts(tb testing.TB) (initiator, acceptor gss.ContextHandle) {
+	tb.Helper()
+	_, target := krb5Realm(tb)
+	initiator, acceptor = establish(tb, nil, nil, target)
+	tb.Cleanup(func() {
+		gss.DeleteSecContext(initiator)
+		gss.DeleteSecContext(acceptor)
+	})
+	return
+}
+
+func TestSecContext(t *testing.T) {
+	initiator, acceptor := krb5Contexts(t)
+
+	major, minor, src, _, _, mech, flags, _, _, local, open := gss.InquireContext(acceptor)
+	if major != gss.S_COMPLETE {
+		t.Fatal(gss.NewGSSError("inquiring context", major, minor, nil))
+	}
+	defer gss.ReleaseName(src)
+	if !mech.Equal(gss.Mech_krb5) || local || !open {
+		t.Errorf("acceptor context: mech %v, locally initiated %v, open %v", mech, local, open)
+	}
+	if !flags.Mutual || !flags.Conf || !flags.Integ {
+		t.Errorf("acceptor context flags %+v lack mutual, conf or integ", flags)
+	}
+	major, minor, client, _ := gss.DisplayName(src)
+	if major != gss.S_COMPLETE {
+		t.Fatal(gss.NewGSSError("displaying name", major, minor, nil))
+	}
+	if want := "alice@" + gsstest.DefaultRealm; client != want {
+		t.Errorf("client is %q, want %q", client, want)
+	}
+
+	message := []byte("sealed by the initiator")
+	major, minor, conf, wrapped := gss.Wrap(initiator, true, 0, message)
+	if major != gss.S_COMPLETE || !conf {
+		t.Fatal(gss.NewGSSError("wrapping", major, minor, nil))
+	}
+	major, minor, conf, _, unwrapped := gss.Unwrap(acceptor, wrapped)
+	if major != gss.S_COMPLETE {
+		t.Fatal(gss.NewGSSError("unwrapping", major, minor, nil))
+	}
+	if !conf || !bytes.Equal(unwrapped, message) {
+		t.Errorf("unwrapped %q (conf %v), want %q", unwrapped, conf, message)
+	}
+
+	major, minor, mic := gss.GetMIC(acceptor, 0, message)
+	if major != gss.S_COMPLETE {
+		t.Fatal(gss.NewGSSError("computing MIC", major, minor, nil))
+	}
+	if major, minor, _ := gss.VerifyMIC(initiator, message, mic); major != gss.S_COMPLETE {
+		t.Fatal(gss.NewGSSError("verifying MIC", major, minor, nil))
+	}
+	tampered := append([]byte(nil), message...)
+	tampered[0] ^= 1
+	if major, _, _ := gss.VerifyMIC(initiator, tampered, mic); major == gss.S_COMPLETE {
+		t.Error("MIC verified over a modified message")
+	}
+}
+
+// credName returns the display form of the name of cred's principal.
+func credName(t *testing.T, cred gss.CredHandle) string {
+	t.Helper()
+	major, minor, name, _, _, _ := gss.InquireCred(cred)
+	if major != gss.S_COMPLETE {
+		t.Fatal(gss.NewGSSError("inquiring about credentials", major, minor, nil))
+	}
+	defer gss.ReleaseName(name)
+	major, minor, display, _ := gss.DisplayName(name)
+	if major != gss.S_COMPLETE {
+		t.Fatal(gss.NewGSSError("displaying name", major, minor, nil))
+	}
+	return display
+}
+
+// exportLucid exports a context's lucid form, which consumes the context.
+func exportLucid(t *testing.T, ctx *gss.ContextHandle) *gss.Krb5LucidContext {
+	t.Helper()
+	major, minor, lucid := gss.Krb5ExportLucidSecContext(ctx, 1)
+	if major == gss.S_UNAVAILABLE {
+		t.Skip("the library can't export lucid contexts")
+	}
+	if major != gss.S_COMPLETE || lucid == nil {
+		t.Fatal(gss.NewGSSError("exporting lucid context", major, minor, nil))
+	}
+	return lucid
+}
+
+func TestKrb5ExportLucidSecContext(t *testing.T) {
+	_, target := krb5Realm(t)
+	initiator, acceptor := establish(t, nil, nil, target)
+	defer func() {
+		gss.DeleteSecContext(initiator)
+		gss.DeleteSecContext(acceptor)
+	}()
+
+	// Move the initiator's sequence number along.
+	major, minor, _, wrapped := gss.Wrap(initiator, true, 0, []byte("one"))
+	if major != gss.S_COMPLETE {
+		t.Fatal(gss.NewGSSError("wrapping", major, minor, nil))
+	}
+	if major, minor, _, _, _ = gss.Unwrap(acceptor, wrapped); major != gss.S_COMPLETE {
+		t.Fatal(gss.NewGSSError("unwrapping", major, minor, nil))
+	}
+
+	ilucid := exportLucid(t, &initiator)
+	alucid := exportLucid(t, &acceptor)
+	if initiator != nil || acceptor != nil {
+		t.Error("exported contexts are still valid")
+	}
+	if ilucid.Version != 1 || !ilucid.Initiate || alucid.Initiate {
+		t.Errorf("lucid contexts: version %d, initiate %v and %v", ilucid.Version, ilucid.Initiate, alucid.Initiate)
+	}
+	if ilucid.Protocol != gss.KRB5_LUCID_PROTOCOL_CFX || alucid.Protocol != gss.KRB5_LUCID_PROTOCOL_CFX {
+		t.Fatalf("AES contexts use protocols %d and %d, want CFX", ilucid.Protocol, alucid.Protocol)
+	}
+	if ilucid.SendSeq != alucid.RecvSeq || ilucid.RecvSeq != alucid.SendSeq {
+		t.Errorf("sequence numbers don't match: initiator %d/%d, acceptor %d/%d", ilucid.SendSeq, ilucid.RecvSeq, alucid.SendSeq, alucid.RecvSeq)
+	}
+	if len(ilucid.CFX.CtxKey.Data) == 0 || !bytes.Equal(ilucid.CFX.CtxKey.Data, alucid.CFX.CtxKey.Data) {
+		t.Error("context keys are missing or don't match")
+	}
+	if ilucid.CFX.HaveAcceptorSubkey != alucid.CFX.HaveAcceptorSubkey || !bytes.Equal(ilucid.CFX.AcceptorSubkey.Data, alucid.CFX.AcceptorSubkey.Data) {
+		t.Error("acceptor subkeys don't match")
+	}
+	if ilucid.Endtime == 0 || ilucid.Endtime != alucid.Endtime {
+		t.Errorf("end times %d and %d", ilucid.Endtime, alucid.Endtime)
+	}
+}
+
+func TestKrb5SetAllowableEnctypes(t *testing.T) {
+	_, target := krb5Realm(t)
+	major, minor, cred, _, _ := gss.AcquireCred(nil, gss.C_INDEFINITE, gss.Mech_set_krb5, gss.C_INITIATE)
+	if major != gss.S_COMPLETE {
+		t.Fatal(gss.NewGSSError("acquiring credentials", major, minor, nil))
+	}
+	defer gss.ReleaseCred(cred)
+	if major, minor = gss.Krb5SetAllowableEnctypes(cred, []int32{gss.ENCTYPE_AES128_CTS_HMAC_SHA1_96}); major != gss.S_COMPLETE {
+		t.Fatal(gss.NewGSSError("setting enctypes", major, minor, nil))
+	}
+
+	initiator, acceptor := establish(t, cred, nil, target)
+	defer gss.DeleteSecContext(acceptor)
+	lucid := exportLucid(t, &initiator)
+	key := lucid.CFX.CtxKey
+	if lucid.CFX.HaveAcceptorSubkey {
+		key = lucid.CFX.AcceptorSubkey
+	}
+	if key.Type != gss.ENCTYPE_AES128_CTS_HMAC_SHA1_96 {
+		t.Errorf("context key has enctype %d, want %d", key.Type, gss.ENCTYPE_AES128_CTS_HMAC_SHA1_96)
+	}
+}
+
+func TestKrb5ImportCred(t *testing.T) {
+	kdc, target := krb5Realm(t)
+	if err := kdc.AddPrincipal("bob", ""); err != nil {
+		t.Fatal(err)
+	}
+	keytab, err := kdc.NewKeytab("bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ccache := "FILE:" + filepath.Join(kdc.Dir, "ccache.bob")
+	if err = kdc.Kinit("bob", keytab, ccache); err != nil {
+		t.Fatal(err)
+	}
+
+	major, minor, icred := gss.Krb5ImportCred(ccache, "", "")
+	if major != gss.S_COMPLETE {
+		t.Fatal(gss.NewGSSError("importing initiator credentials", major, minor, nil))
+	}
+	defer gss.ReleaseCred(icred)
+	if name, want := credName(t, icred), kdc.Principal("bob"); name != want {
+		t.Errorf("imported credentials are for %q, want %q", name, want)
+	}
+	major, minor, acred := gss.Krb5ImportCred("", "host/"+kdc.Host, kdc.Keytab)
+	if major != gss.S_COMPLETE {
+		t.Fatal(gss.NewGSSError("importing acceptor credentials", major, minor, nil))
+	}
+	defer gss.ReleaseCred(acred)
+
+	initiator, acceptor := establish(t, icred, acred, target)
+	defer func() {
+		gss.DeleteSecContext(initiator)
+		gss.DeleteSecContext(acceptor)
+	}()
+	major, minor, src, _, _, _, _, _, _, _, _ := gss.InquireContext(acceptor)
+	if major != gss.S_COMPLETE {
+		t.Fatal(gss.NewGSSError("inquiring context", major, minor, nil))
+	}
+	defer gss.ReleaseName(src)
+	if _, _, client, _ := gss.DisplayName(src); client != kdc.Principal("bob") {
+		t.Errorf("acceptor saw client %q", client)
+	}
+
+	if major, _, cred := gss.Krb5ImportCred("NOSUCHTYPE:nowhere", "", ""); major == gss.S_COMPLETE {
+		gss.ReleaseCred(cred)
+		t.Error("importing a ccache of an unknown type succeeded")
+	}
+}
+
+func TestKrb5GetTktFlags(t *testing.T) {
+	_, acceptor := krb5Contexts(t)
+
+	major, minor, flags := gss.Krb5GetTktFlags(acceptor)
+	if major != gss.S_COMPLETE {
+		t.Fatal(gss.NewGSSError("getting ticket flags", major, minor, nil))
+	}
+	// The ticket came from the TGS, not from an AS exchange.
+	if flags&gss.TKT_FLG_INITIAL != 0 || flags&gss.TKT_FLG_INVALID != 0 {
+		t.Errorf("service ticket flags %#x include INITIAL or INVALID", flags)
+	}
+}
+
+// TestKrb5SetCredRcache exercises the workaround for Heimdal's krb5_data,
+// whose layout differs from MIT's, by opening a replay cache for a service
+// and accepting a context with it.
+func TestKrb5SetCredRcache(t *testing.T) {
+	kdc, target := krb5Realm(t)
+	t.Setenv("KRB5RCACHEDIR", kdc.Dir)
+	major, minor, cred, _, _ := gss.AcquireCred(nil, gss.C_INDEFINITE, gss.Mech_set_krb5, gss.C_ACCEPT)
+	if major != gss.S_COMPLETE {
+		t.Fatal(gss.NewGSSError("acquiring credentials", major, minor, nil))
+	}
+	defer gss.ReleaseCred(cred)
+	major, minor = gss.Krb5SetCredRcache(cred, "host")
+	if major == gss.S_UNAVAILABLE {
+		t.Skip("the library can't set a credential's replay cache")
+	}
+	if major != gss.S_COMPLETE {
+		t.Fatal(gss.NewGSSError("setting replay cache", major, minor, nil))
+	}
+
+	// Without mutual authentication, the initiator's one token can be
+	// replayed.
+	var initiator, acceptor, replayed gss.ContextHandle
+	defer func() {
+		gss.DeleteSecContext(initiator)
+		gss.DeleteSecContext(acceptor)
+		gss.DeleteSecContext(replayed)
+	}()
+	major, minor, _, token, _, _, _, _ := gss.InitSecContext(nil, &initiator, target, gss.Mech_krb5, gss.Flags{Integ: true}, gss.C_INDEFINITE, nil, nil)
+	if major != gss.S_COMPLETE {
+		t.Fatal(gss.NewGSSError("initializing context", major, minor, nil))
+	}
+	if major, minor, _, _, _, _, _, _, _, _ = gss.AcceptSecContext(cred, &acceptor, nil, token); major != gss.S_COMPLETE {
+		t.Fatal(gss.NewGSSError("accepting context", major, minor, nil))
+	}
+	if major, _, _, _, _, _, _, _, _, _ = gss.AcceptSecContext(cred, &replayed, nil, token); major == gss.S_COMPLETE {
+		t.Error("the replay cache didn't catch a replayed token")
+	}
+}
@@ -0,0 +1,267 @@
+#ifndef GSS_GSSAPI_DEFS_H
+#define GSS_GSSAPI_DEFS_H
+
+// The parts of the GSSAPI and krb5 interfaces which the bindings use.  Since
+// the library is loaded with dlopen() and every function is found by name,
+// nothing here is linked against, so neither MIT krb5's nor Heimdal's headers
+// are needed to build the package.  The types and constants are the ones
+// defined by RFC 2744 and the common extensions, which both implementations
+// lay out identically; where they differ, as with krb5_data, the MIT layout
+// is declared here and the code which uses it adjusts for Heimdal at run time.
+
+#include <stddef.h>
+#include <stdint.h>
+#include <sys/types.h>
+
+// RFC 2744 types and status codes.
+
+typedef uint32_t OM_uint32;
+typedef uint64_t OM_uint64;
+typedef struct gss_name_struct *gss_name_t;
+typedef struct gss_cred_id_struct *gss_cred_id_t;
+typedef struct gss_ctx_id_struct *gss_ctx_id_t;
+typedef struct gss_OID_desc_struct { OM_uint32 length; void *elements; } gss_OID_desc, *gss_OID;
+typedef const gss_OID_desc *gss_const_OID;
+typedef struct gss_OID_set_desc_struct { size_t count; gss_OID elements; } gss_OID_set_desc, *gss_OID_set;
+typedef struct gss_buffer_desc_struct { size_t length; void *value; } gss_buffer_desc, *gss_buffer_t;
+typedef const gss_buffer_desc *gss_const_buffer_t;
+typedef struct gss_channel_bindings_struct {
+	OM_uint32 initiator_addrtype; gss_buffer_desc initiator_address;
+	OM_uint32 acceptor_addrtype; gss_buffer_desc acceptor_address;
+	gss_buffer_desc application_data;
+} *gss_channel_bindings_t;
+typedef OM_uint32 gss_qop_t;
+typedef int gss_cred_usage_t;
+#define GSS_C_DELEG_FLAG 1
+#define GSS_C_MUTUAL_FLAG 2
+#define GSS_C_REPLAY_FLAG 4
+#define GSS_C_SEQUENCE_FLAG 8
+#define GSS_C_CONF_FLAG 16
+#define GSS_C_INTEG_FLAG 32
+#define GSS_C_ANON_FLAG 64
+#define GSS_C_PROT_READY_FLAG 128
+#define GSS_C_TRANS_FLAG 256
+#define GSS_C_DELEG_POLICY_FLAG 32768
+#define GSS_C_BOTH 0
+#define GSS_C_INITIATE 1
+#define GSS_C_ACCEPT 2
+#define GSS_C_GSS_CODE 1
+#define GSS_C_MECH_CODE 2
+#define GSS_C_AF_UNSPEC 0
+#define GSS_C_NO_NAME ((gss_name_t) 0)
+#define GSS_C_NO_BUFFER ((gss_buffer_t) 0)
+#define GSS_C_NO_OID ((gss_OID) 0)
+#define GSS_C_NO_OID_SET ((gss_OID_set) 0)
+#define GSS_C_NO_CONTEXT ((gss_ctx_id_t) 0)
+#define GSS_C_NO_CREDENTIAL ((gss_cred_id_t) 0)
+#define GSS_C_NO_CHANNEL_BINDINGS ((gss_channel_bindings_t) 0)
+#define GSS_C_EMPTY_BUFFER {0, NULL}
+#define GSS_C_QOP_DEFAULT 0
+#define GSS_C_INDEFINITE ((OM_uint32) 0xfffffffful)
+#define GSS_C_CALLING_ERROR_OFFSET 24
+#define GSS_C_ROUTINE_ERROR_OFFSET 16
+#define GSS_C_SUPPLEMENTARY_OFFSET 0
+#define GSS_C_CALLING_ERROR_MASK ((OM_uint32) 0377ul)
+#define GSS_C_ROUTINE_ERROR_MASK ((OM_uint32) 0377ul)
+#define GSS_C_SUPPLEMENTARY_MASK ((OM_uint32) 0177777ul)
+#define GSS_S_COMPLETE 0
+#define GSS_S_CALL_INACCESSIBLE_READ (((OM_uint32) 1ul) << GSS_C_CALLING_ERROR_OFFSET)
+#define GSS_S_CALL_INACCESSIBLE_WRITE (((OM_uint32) 2ul) << GSS_C_CALLING_ERROR_OFFSET)
+#define GSS_S_CALL_BAD_STRUCTURE (((OM_uint32) 3ul) << GSS_C_CALLING_ERROR_OFFSET)
+#define GSS_S_BAD_MECH (((OM_uint32) 1ul) << GSS_C_ROUTINE_ERROR_OFFSET)
+#define GSS_S_BAD_NAME (((OM_uint32) 2ul) << GSS_C_ROUTINE_ERROR_OFFSET)
+#define GSS_S_BAD_NAMETYPE (((OM_uint32) 3ul) << GSS_C_ROUTINE_ERROR_OFFSET)
+#define GSS_S_BAD_BINDINGS (((OM_uint32) 4ul) << GSS_C_ROUTINE_ERROR_OFFSET)
+#define GSS_S_BAD_STATUS (((OM_uint32) 5ul) << GSS_C_ROUTINE_ERROR_OFFSET)
+#define GSS_S_BAD_SIG (((OM_uint32) 6ul) << GSS_C_ROUTINE_ERROR_OFFSET)
+#define GSS_S_NO_CRED (((OM_uint32) 7ul) << GSS_C_ROUTINE_ERROR_OFFSET)
+#define GSS_S_NO_CONTEXT (((OM_uint32) 8ul) << GSS_C_ROUTINE_ERROR_OFFSET)
+#define GSS_S_DEFECTIVE_TOKEN (((OM_uint32) 9ul) << GSS_C_ROUTINE_ERROR_OFFSET)
+#define GSS_S_DEFECTIVE_CREDENTIAL (((OM_uint32) 10ul) << GSS_C_ROUTINE_ERROR_OFFSET)
+#define GSS_S_CREDENTIALS_EXPIRED (((OM_uint32) 11ul) << GSS_C_ROUTINE_ERROR_OFFSET)
+#define GSS_S_CONTEXT_EXPIRED (((OM_uint32) 12ul) << GSS_C_ROUTINE_ERROR_OFFSET)
+#define GSS_S_FAILURE (((OM_uint32) 13ul) << GSS_C_ROUTINE_ERROR_OFFSET)
+#define GSS_S_BAD_QOP (((OM_uint32) 14ul) << GSS_C_ROUTINE_ERROR_OFFSET)
+#define GSS_S_UNAUTHORIZED (((OM_uint32) 15ul) << GSS_C_ROUTINE_ERROR_OFFSET)
+#define GSS_S_UNAVAILABLE (((OM_uint32) 16ul) << GSS_C_ROUTINE_ERROR_OFFSET)
+#define GSS_S_DUPLICATE_ELEMENT (((OM_uint32) 17ul) << GSS_C_ROUTINE_ERROR_OFFSET)
+#define GSS_S_NAME_NOT_MN (((OM_uint32) 18ul) << GSS_C_ROUTINE_ERROR_OFFSET)
+#define GSS_S_BAD_MECH_ATTR (((OM_uint32) 19ul) << GSS_C_ROUTINE_ERROR_OFFSET)
+#define GSS_S_CONTINUE_NEEDED (1 << (GSS_C_SUPPLEMENTARY_OFFSET + 0))
+#define GSS_S_DUPLICATE_TOKEN (1 << (GSS_C_SUPPLEMENTARY_OFFSET + 1))
+#define GSS_S_OLD_TOKEN (1 << (GSS_C_SUPPLEMENTARY_OFFSET + 2))
+#define GSS_S_UNSEQ_TOKEN (1 << (GSS_C_SUPPLEMENTARY_OFFSET + 3))
+#define GSS_S_GAP_TOKEN (1 << (GSS_C_SUPPLEMENTARY_OFFSET + 4))
+#define GSS_S_CRED_UNAVAIL GSS_S_FAILURE
+#define GSS_C_PRF_KEY_FULL 0
+#define GSS_C_PRF_KEY_PARTIAL 1
+
+// Extensions: buffer sets, credential stores and IOV buffers.
+
+typedef struct gss_buffer_set_desc_struct { size_t count; gss_buffer_desc *elements; } gss_buffer_set_desc, *gss_buffer_set_t;
+typedef struct gss_key_value_element_struct { const char *key; const char *value; } gss_key_value_element_desc;
+typedef struct gss_key_value_set_struct { OM_uint32 count; gss_key_value_element_desc *elements; } gss_key_value_set_desc;
+typedef const gss_key_value_set_desc *gss_const_key_value_set_t;
+#define GSS_C_DCE_STYLE 0x1000
+#define GSS_C_IDENTIFY_FLAG 0x2000
+#define GSS_C_EXTENDED_ERROR_FLAG 0x4000
+typedef OM_uint32 gss_iov_buffer_type_t;
+#define GSS_IOV_BUFFER_TYPE_EMPTY 0
+#define GSS_IOV_BUFFER_TYPE_DATA 1
+#define GSS_IOV_BUFFER_TYPE_HEADER 2
+#define GSS_IOV_BUFFER_TYPE_MECH_PARAMS 3
+#define GSS_IOV_BUFFER_TYPE_TRAILER 7
+#define GSS_IOV_BUFFER_TYPE_PADDING 9
+#define GSS_IOV_BUFFER_TYPE_STREAM 10
+#define GSS_IOV_BUFFER_TYPE_SIGN_ONLY 11
+#define GSS_IOV_BUFFER_TYPE_MIC_TOKEN 12
+#define GSS_IOV_BUFFER_FLAG_MASK 0xFFFF0000
+#define GSS_IOV_BUFFER_FLAG_ALLOCATE 0x00010000
+#define GSS_IOV_BUFFER_FLAG_ALLOCATED 0x00020000
+#define GSS_IOV_BUFFER_TYPE(type) ((type) & ~(GSS_IOV_BUFFER_FLAG_MASK))
+typedef struct gss_iov_buffer_desc_struct { OM_uint32 type; gss_buffer_desc buffer; } gss_iov_buffer_desc, *gss_iov_buffer_t;
+
+// Kerberos mechanism extensions.
+
+typedef struct gss_krb5_lucid_key { OM_uint32 type; OM_uint32 length; void *data; } gss_krb5_lucid_key_t;
+typedef struct gss_krb5_rfc1964_keydata { OM_uint32 sign_alg; OM_uint32 seal_alg; gss_krb5_lucid_key_t ctx_key; } gss_krb5_rfc1964_keydata_t;
+typedef struct gss_krb5_cfx_keydata { OM_uint32 have_acceptor_subkey; gss_krb5_lucid_key_t ctx_key; gss_krb5_lucid_key_t acceptor_subkey; } gss_krb5_cfx_keydata_t;
+typedef struct gss_krb5_lucid_context_v1 { OM_uint32 version; OM_uint32 initiate; OM_uint32 endtime; uint64_t send_seq; uint64_t recv_seq; OM_uint32 protocol; gss_krb5_rfc1964_keydata_t rfc1964_kd; gss_krb5_cfx_keydata_t cfx_kd; } gss_krb5_lucid_context_v1_t;
+typedef struct gss_krb5_lucid_context_version { OM_uint32 version; } gss_krb5_lucid_context_version_t;
+
+// The krb5 types which the Kerberos extensions take.  Handles are opaque.
+
+typedef int32_t krb5_int32;
+typedef int32_t krb5_enctype;
+typedef int32_t krb5_flags;
+typedef int32_t krb5_error_code;
+typedef struct _krb5_context *krb5_context;
+typedef struct _krb5_ccache *krb5_ccache;
+typedef struct krb5_principal_data *krb5_principal;
+typedef struct _krb5_kt *krb5_keytab;
+typedef struct krb5_rc_st *krb5_rcache;
+typedef int32_t krb5_magic;
+typedef struct _krb5_data { krb5_magic magic; unsigned int length; char *data; } krb5_data;
+#define ENCTYPE_DES3_CBC_SHA1 0x0010
+#define ENCTYPE_AES128_CTS_HMAC_SHA1_96 0x0011
+#define ENCTYPE_AES256_CTS_HMAC_SHA1_96 0x0012
+#define ENCTYPE_AES128_CTS_HMAC_SHA256_128 0x0013
+#define ENCTYPE_AES256_CTS_HMAC_SHA384_192 0x0014
+#define ENCTYPE_ARCFOUR_HMAC 0x0017
+#define ENCTYPE_CAMELLIA128_CTS_CMAC 0x0019
+#define ENCTYPE_CAMELLIA256_CTS_CMAC 0x001a
+#define TKT_FLG_FORWARDABLE 0x40000000
+#define TKT_FLG_FORWARDED 0x20000000
+#define TKT_FLG_PROXIABLE 0x10000000
+#define TKT_FLG_PROXY 0x08000000
+#define TKT_FLG_MAY_POSTDATE 0x04000000
+#define TKT_FLG_POSTDATED 0x02000000
+#define TKT_FLG_INVALID 0x01000000
+#define TKT_FLG_RENEWABLE 0x00800000
+#define TKT_FLG_INITIAL 0x00400000
+#define TKT_FLG_PRE_AUTH 0x00200000
+#define TKT_FLG_HW_AUTH 0x00100000
+#define TKT_FLG_TRANSIT_POLICY_CHECKED 0x00080000
+#define TKT_FLG_OK_AS_DELEGATE 0x00040000
+#define TKT_FLG_ENC_PA_REP 0x00010000
+#define TKT_FLG_ANONYMOUS 0x00008000
+
+// Functions, which loader.c resolves by name.
+
+OM_uint32 gss_accept_sec_context(OM_uint32*, gss_ctx_id_t*, gss_cred_id_t, gss_buffer_t, gss_channel_bindings_t, gss_name_t*, gss_OID*, gss_buffer_t, OM_uint32*, OM_uint32*, gss_cred_id_t*);
+OM_uint32 gss_acquire_cred(OM_uint32*, gss_name_t, OM_uint32, gss_OID_set, gss_cred_usage_t, gss_cred_id_t*, gss_OID_set*, OM_uint32*);
+OM_uint32 gss_acquire_cred_from(OM_uint32*, gss_name_t, OM_uint32, gss_OID_set, gss_cred_usage_t, gss_const_key_value_set_t, gss_cred_id_t*, gss_OID_set*, OM_uint32*);
+OM_uint32 gss_acquire_cred_impersonate_name(OM_uint32*, const gss_cred_id_t, const gss_name_t, OM_uint32, const gss_OID_set, gss_cred_usage_t, gss_cred_id_t*, gss_OID_set*, OM_uint32*);
+OM_uint32 gss_acquire_cred_with_password(OM_uint32*, const gss_name_t, const gss_buffer_t, OM_uint32, const gss_OID_set, gss_cred_usage_t, gss_cred_id_t*, gss_OID_set*, OM_uint32*);
+OM_uint32 gss_add_cred(OM_uint32*, gss_cred_id_t, gss_name_t, gss_OID, gss_cred_usage_t, OM_uint32, OM_uint32, gss_cred_id_t*, gss_OID_set*, OM_uint32*, OM_uint32*);
+OM_uint32 gss_add_cred_from(OM_uint32*, gss_cred_id_t, gss_name_t, gss_OID, gss_cred_usage_t, OM_uint32, OM_uint32, gss_const_key_value_set_t, gss_cred_id_t*, gss_OID_set*, OM_uint32*, OM_uint32*);
+OM_uint32 gss_add_cred_impersonate_name(OM_uint32*, gss_cred_id_t, const gss_cred_id_t, const gss_name_t, gss_OID, gss_cred_usage_t, OM_uint32, OM_uint32, gss_cred_id_t*, gss_OID_set*, OM_uint32*, OM_uint32*);
+OM_uint32 gss_add_cred_with_password(OM_uint32*, const gss_cred_id_t, const gss_name_t, const gss_OID, const gss_buffer_t, gss_cred_usage_t, OM_uint32, OM_uint32, gss_cred_id_t*, gss_OID_set*, OM_uint32*, OM_uint32*);
+OM_uint32 gss_add_oid_set_member(OM_uint32*, gss_OID, gss_OID_set*);
+OM_uint32 gss_authorize_localname(OM_uint32*, const gss_name_t, const gss_name_t);
+OM_uint32 gss_canonicalize_name(OM_uint32*, const gss_name_t, const gss_OID, gss_name_t*);
+OM_uint32 gss_compare_name(OM_uint32*, gss_name_t, gss_name_t, int*);
+OM_uint32 gss_complete_auth_token(OM_uint32*, const gss_ctx_id_t, gss_buffer_t);
+OM_uint32 gss_context_time(OM_uint32*, gss_ctx_id_t, OM_uint32*);
+OM_uint32 gss_create_empty_oid_set(OM_uint32*, gss_OID_set*);
+OM_uint32 gss_delete_name_attribute(OM_uint32*, gss_name_t, gss_buffer_t);
+OM_uint32 gss_delete_sec_context(OM_uint32*, gss_ctx_id_t*, gss_buffer_t);
+OM_uint32 gss_display_name(OM_uint32*, gss_name_t, gss_buffer_t, gss_OID*);
+OM_uint32 gss_display_name_ext(OM_uint32*, gss_name_t, gss_OID, gss_buffer_t);
+OM_uint32 gss_display_status(OM_uint32*, OM_uint32, int, gss_OID, OM_uint32*, gss_buffer_t);
+OM_uint32 gss_duplicate_name(OM_uint32*, const gss_name_t, gss_name_t*);
+OM_uint32 gss_export_cred(OM_uint32*, gss_cred_id_t, gss_buffer_t);
+OM_uint32 gss_export_name(OM_uint32*, const gss_name_t, gss_buffer_t);
+OM_uint32 gss_export_name_composite(OM_uint32*, gss_name_t, gss_buffer_t);
+OM_uint32 gss_export_sec_context(OM_uint32*, gss_ctx_id_t*, gss_buffer_t);
+OM_uint32 gss_get_mic(OM_uint32*, gss_ctx_id_t, gss_qop_t, gss_buffer_t, gss_buffer_t);
+OM_uint32 gss_get_mic_iov(OM_uint32*, gss_ctx_id_t, gss_qop_t, gss_iov_buffer_desc*, int);
+OM_uint32 gss_get_mic_iov_length(OM_uint32*, gss_ctx_id_t, gss_qop_t, gss_iov_buffer_desc*, int);
+OM_uint32 gss_get_name_attribute(OM_uint32*, gss_name_t, gss_buffer_t, int*, int*, gss_buffer_t, gss_buffer_t, int*);
+OM_uint32 gss_import_cred(OM_uint32*, gss_buffer_t, gss_cred_id_t*);
+OM_uint32 gss_import_name(OM_uint32*, gss_buffer_t, gss_OID, gss_name_t*);
+OM_uint32 gss_import_sec_context(OM_uint32*, gss_buffer_t, gss_ctx_id_t*);
+OM_uint32 gss_indicate_mechs(OM_uint32*, gss_OID_set*);
+OM_uint32 gss_indicate_mechs_by_attrs(OM_uint32*, const gss_OID_set_desc *, const gss_OID_set_desc *, const gss_OID_set_desc *, gss_OID_set*);
+OM_uint32 gss_init_sec_context(OM_uint32*, gss_cred_id_t, gss_ctx_id_t*, gss_name_t, gss_OID, OM_uint32, OM_uint32, gss_channel_bindings_t, gss_buffer_t, gss_OID*, gss_buffer_t, OM_uint32*, OM_uint32*);
+OM_uint32 gss_inquire_context(OM_uint32*, gss_ctx_id_t, gss_name_t*, gss_name_t*, OM_uint32*, gss_OID*, OM_uint32*, int*, int*);
+OM_uint32 gss_inquire_cred(OM_uint32*, gss_cred_id_t, gss_name_t*, OM_uint32*, gss_cred_usage_t*, gss_OID_set*);
+OM_uint32 gss_inquire_cred_by_mech(OM_uint32*, gss_cred_id_t, gss_OID, gss_name_t*, OM_uint32*, OM_uint32*, gss_cred_usage_t*);
+OM_uint32 gss_inquire_cred_by_oid(OM_uint32*, const gss_cred_id_t, const gss_OID, gss_buffer_set_t*);
+OM_uint32 gss_inquire_mech_for_saslname(OM_uint32*, const gss_buffer_t, gss_OID*);
+OM_uint32 gss_inquire_mechs_for_name(OM_uint32*, const gss_name_t, gss_OID_set*);
+OM_uint32 gss_inquire_name(OM_uint32*, gss_name_t, int*, gss_OID*, gss_buffer_set_t*);
+OM_uint32 gss_inquire_names_for_mech(OM_uint32*, gss_OID, gss_OID_set*);
+OM_uint32 gss_inquire_saslname_for_mech(OM_uint32*, const gss_OID, gss_buffer_t, gss_buffer_t, gss_buffer_t);
+OM_uint32 gss_inquire_sec_context_by_oid(OM_uint32*, const gss_ctx_id_t, const gss_OID, gss_buffer_set_t*);
+OM_uint32 gss_krb5_ccache_name(OM_uint32*, const char*, const char**);
+OM_uint32 gss_krb5_export_lucid_sec_context(OM_uint32*, gss_ctx_id_t*, OM_uint32, void**);
+OM_uint32 gss_krb5_free_lucid_sec_context(OM_uint32*, void*);
+OM_uint32 gss_krb5_get_tkt_flags(OM_uint32*, gss_ctx_id_t, krb5_flags*);
+OM_uint32 gss_krb5_import_cred(OM_uint32*, krb5_ccache, krb5_principal, krb5_keytab, gss_cred_id_t*);
+OM_uint32 gss_krb5_set_allowable_enctypes(OM_uint32*, gss_cred_id_t, OM_uint32, krb5_enctype*);
+OM_uint32 gss_krb5_set_cred_rcache(OM_uint32*, gss_cred_id_t, krb5_rcache);
+OM_uint32 gss_localname(OM_uint32*, const gss_name_t, gss_const_OID, gss_buffer_t);
+OM_uint32 gss_oid_to_str(OM_uint32*, gss_OID, gss_buffer_t);
+OM_uint32 gss_pname_to_uid(OM_uint32*, const gss_name_t, const gss_OID, uid_t*);
+OM_uint32 gss_process_context_token(OM_uint32*, gss_ctx_id_t, gss_buffer_t);
+OM_uint32 gss_pseudo_random(OM_uint32*, gss_ctx_id_t, int, const gss_buffer_t, ssize_t, gss_buffer_t);
+OM_uint32 gss_release_buffer(OM_uint32*, gss_buffer_t);
+OM_uint32 gss_release_buffer_set(OM_uint32*, gss_buffer_set_t*);
+OM_uint32 gss_release_cred(OM_uint32*, gss_cred_id_t*);
+OM_uint32 gss_release_iov_buffer(OM_uint32*, gss_iov_buffer_desc*, int);
+OM_uint32 gss_release_name(OM_uint32*, gss_name_t*);
+OM_uint32 gss_release_oid(OM_uint32*, gss_OID*);
+OM_uint32 gss_release_oid_set(OM_uint32*, gss_OID_set*);
+OM_uint32 gss_set_cred_option(OM_uint32*, gss_cred_id_t*, const gss_OID, const gss_buffer_t);
+OM_uint32 gss_set_name_attribute(OM_uint32*, gss_name_t, int, gss_buffer_t, gss_buffer_t);
+OM_uint32 gss_set_neg_mechs(OM_uint32*, gss_cred_id_t, const gss_OID_set);
+OM_uint32 gss_set_sec_context_option(OM_uint32*, gss_ctx_id_t*, const gss_OID, const gss_buffer_t);
+OM_uint32 gss_store_cred(OM_uint32*, gss_cred_id_t, gss_cred_usage_t, const gss_OID, OM_uint32, OM_uint32, gss_OID_set*, gss_cred_usage_t*);
+OM_uint32 gss_store_cred_into(OM_uint32*, gss_cred_id_t, gss_cred_usage_t, gss_OID, OM_uint32, OM_uint32, gss_const_key_value_set_t, gss_OID_set*, gss_cred_usage_t*);
+OM_uint32 gss_unwrap(OM_uint32*, gss_ctx_id_t, gss_buffer_t, gss_buffer_t, int*, gss_qop_t*);
+OM_uint32 gss_unwrap_aead(OM_uint32*, gss_ctx_id_t, gss_buffer_t, gss_buffer_t, gss_buffer_t, int*, gss_qop_t*);
+OM_uint32 gss_unwrap_iov(OM_uint32*, gss_ctx_id_t, int*, gss_qop_t*, gss_iov_buffer_desc*, int);
+int gss_userok(const gss_name_t, const char*);
+OM_uint32 gss_verify_mic(OM_uint32*, gss_ctx_id_t, gss_buffer_t, gss_buffer_t, gss_qop_t*);
+OM_uint32 gss_verify_mic_iov(OM_uint32*, gss_ctx_id_t, gss_qop_t*, gss_iov_buffer_desc*, int);
+OM_uint32 gss_wrap(OM_uint32*, gss_ctx_id_t, int, gss_qop_t, gss_buffer_t, int*, gss_buffer_t);
+OM_uint32 gss_wrap_aead(OM_uint32*, gss_ctx_id_t, int, gss_qop_t, gss_buffer_t, gss_buffer_t, int*, gss_buffer_t);
+OM_uint32 gss_wrap_iov(OM_uint32*, gss_ctx_id_t, int, gss_qop_t, int*, gss_iov_buffer_desc*, int);
+OM_uint32 gss_wrap_iov_length(OM_uint32*, gss_ctx_id_t, int, gss_qop_t, int*, gss_iov_buffer_desc*, int);
+OM_uint32 gss_wrap_size_limit(OM_uint32*, gss_ctx_id_t, int, gss_qop_t, OM_uint32, OM_uint32*);
+OM_uint32 gsskrb5_extract_authz_data_from_sec_context(OM_uint32*, const gss_ctx_id_t, int, gss_buffer_t);
+OM_uint32 gssspi_mech_invoke(OM_uint32*, const gss_OID, const gss_OID, gss_buffer_t);
+krb5_error_code krb5_cc_close(krb5_context, krb5_ccache);
+krb5_error_code krb5_cc_destroy(krb5_context, krb5_ccache);
+krb5_error_code krb5_cc_resolve(krb5_context, const char*, krb5_ccache*);
+void krb5_free_context(krb5_context);
+void krb5_free_principal(krb5_context, krb5_principal);
+krb5_error_code krb5_get_server_rcache(krb5_context, const krb5_data*, krb5_rcache*);
+OM_uint32 krb5_gss_register_acceptor_identity(const char*);
+krb5_error_code krb5_init_context(krb5_context*);
+krb5_error_code krb5_kt_close(krb5_context, krb5_keytab);
+krb5_error_code krb5_kt_resolve(krb5_context, const char*, krb5_keytab*);
+krb5_error_code krb5_parse_name(krb5_context, const char*, krb5_principal*);
+
+#endif
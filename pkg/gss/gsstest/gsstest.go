@@ -0,0 +1,544 @@
+/*
+Package gsstest runs a throwaway MIT krb5 or Heimdal KDC, so that code which
+uses GSSAPI can be exercised end-to-end without an existing realm.
+
+A KDC keeps its configuration, database, keytabs and credential caches in a
+temporary directory, listens only on the loopback interface, and is removed
+again by Close.  Its Env and Setenv methods point the Kerberos library (and
+any commands which are started) at that configuration.  MIT's krb5kdc,
+kdb5_util, kadmin.local and kinit programs, or Heimdal's kdc, kadmin and kinit
+programs, must be installed; Start skips the test if neither set is.
+
+The Kerberos library reads its configuration when a library context is
+created, so Setenv should be called before the code under test first uses
+Kerberos.
+*/
+package gsstest
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// DefaultRealm is used if Options.Realm is not set.
+const DefaultRealm = "GSSTEST.EXAMPLE"
+
+// ErrNoKDC is returned by New when the KDC programs can't be found.
+var ErrNoKDC = errors.New("no MIT krb5 or Heimdal KDC programs are installed")
+
+// Flavor selects which implementation's KDC is run.
+type Flavor int
+
+const (
+	// AnyFlavor runs MIT krb5's KDC if it's installed, and Heimdal's
+	// otherwise.
+	AnyFlavor Flavor = iota
+	MIT
+	Heimdal
+)
+
+func (f Flavor) String() string {
+	switch f {
+	case MIT:
+		return "MIT"
+	case Heimdal:
+		return "Heimdal"
+	}
+	return "any"
+}
+
+// searchPath lists the places besides $PATH where the KDC programs are
+// commonly installed.
+var searchPath = []string{"/usr/sbin", "/usr/local/sbin", "/usr/lib/mit/sbin", "/usr/lib/heimdal-servers", "/usr/libexec", "/usr/heimdal/libexec"}
+
+// toolNames lists the programs each flavor needs, by the role they play, and
+// the names they may be installed under.  Distributions which ship both
+// implementations rename Heimdal's clients.
+var toolNames = map[Flavor]map[string][]string{
+	MIT: {
+		"kdc":    {"krb5kdc"},
+		"kdb":    {"kdb5_util"},
+		"kadmin": {"kadmin.local"},
+		"kinit":  {"kinit"},
+	},
+	Heimdal: {
+		"kdc":    {"kdc"},
+		"kadmin": {"kadmin.heimdal", "kadmin"},
+		"kinit":  {"kinit.heimdal", "kinit"},
+	},
+}
+
+// Options control how a KDC is set up.
+type Options struct {
+	// Realm is the name of the realm which the KDC serves.
+	Realm string
+	// Host is the name used for host-based service principals, which is
+	// mapped to the realm.  It defaults to "localhost".
+	Host string
+	// StartTimeout bounds how long New waits for the KDC to start
+	// accepting connections.  It defaults to ten seconds.
+	StartTimeout time.Duration
+	// Flavor selects MIT krb5's or Heimdal's KDC.  By default, whichever is
+	// installed is used.
+	Flavor Flavor
+}
+
+// KDC is a running KDC and the files which describe its realm.
+type KDC struct {
+	Realm string
+	Host  string
+	// Flavor is the implementation whose KDC is running.
+	Flavor Flavor
+	// Dir holds all of the KDC's files.
+	Dir string
+	// Port is the TCP and UDP port the KDC listens on, on 127.0.0.1.
+	Port int
+	// Config is the krb5.conf which clients should use.
+	Config string
+	// CCache is the default credential cache for clients.
+	CCache string
+	// Keytab is the default keytab for services.
+	Keytab string
+
+	kdcConfig string
+	tools     map[string]string
+	cmd       *exec.Cmd
+	exited    chan error
+}
+
+func findTool(name string) (string, error) {
+	if path, err := exec.LookPath(name); err == nil {
+		return path, nil
+	}
+	for _, dir := range searchPath {
+		path := filepath.Join(dir, name)
+		if info, err := os.Stat(path); err == nil && !info.IsDir() {
+			return path, nil
+		}
+	}
+	return "", ErrNoKDC
+}
+
+// isHeimdal reports whether the program at path is Heimdal's, for the
+// clients which both implementations install under the same name.
+func isHeimdal(path string) bool {
+	out, _ := exec.Command(path, "--version").CombinedOutput()
+	return bytes.Contains(out, []byte("Heimdal"))
+}
+
+// findTools looks for the programs which flavor needs.
+func findTools(flavor Flavor) (map[string]string, error) {
+	tools := make(map[string]string)
+	for role, names := range toolNames[flavor] {
+		for _, name := range names {
+			path, err := findTool(name)
+			if err != nil {
+				continue
+			}
+			if flavor == Heimdal && role != "kdc" && !strings.HasSuffix(name, ".heimdal") && !isHeimdal(path) {
+				continue
+			}
+			if flavor == MIT && role == "kinit" && isHeimdal(path) {
+				continue
+			}
+			tools[role] = path
+			break
+		}
+		if tools[role] == "" {
+			return nil, ErrNoKDC
+		}
+	}
+	return tools, nil
+}
+
+func freePort() (int, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port, nil
+}
+
+// New creates a realm in a new temporary directory and starts a KDC for it.
+// The KDC should be shut down using Close() when it's no longer needed.
+func New(opts Options) (*KDC, error) {
+	k := &KDC{
+		Realm: opts.Realm,
+		Host:  opts.Host,
+	}
+	if k.Realm == "" {
+		k.Realm = DefaultRealm
+	}
+	if k.Host == "" {
+		k.Host = "localhost"
+	}
+	timeout := opts.StartTimeout
+	if timeout == 0 {
+		timeout = 10 * time.Second
+	}
+	flavors := []Flavor{opts.Flavor}
+	if opts.Flavor == AnyFlavor {
+		flavors = []Flavor{MIT, Heimdal}
+	}
+	for _, flavor := range flavors {
+		if tools, err := findTools(flavor); err == nil {
+			k.Flavor, k.tools = flavor, tools
+			break
+		}
+	}
+	if k.tools == nil {
+		return nil, ErrNoKDC
+	}
+
+	dir, err := ioutil.TempDir("", "gsstest")
+	if err != nil {
+		return nil, err
+	}
+	k.Dir = dir
+	k.Config = filepath.Join(dir, "krb5.conf")
+	k.CCache = "FILE:" + filepath.Join(dir, "ccache")
+	k.Keytab = "FILE:" + filepath.Join(dir, "krb5.keytab")
+	k.kdcConfig = filepath.Join(dir, "kdc.conf")
+
+	if err := k.start(timeout); err != nil {
+		k.Close()
+		return nil, err
+	}
+	return k, nil
+}
+
+func (k *KDC) writeConfig() error {
+	krb5conf := fmt.Sprintf(`[libdefaults]
+	default_realm = %[1]s
+	dns_lookup_kdc = false
+	dns_lookup_realm = false
+	rdns = false
+	udp_preference_limit = 1
+	default_ccache_name = %[3]s
+	default_keytab_name = %[4]s
+
+[realms]
+	%[1]s = {
+		kdc = 127.0.0.1:%[2]d
+	}
+
+[domain_realm]
+	%[5]s = %[1]s
+
+[logging]
+	kdc = FILE:%[6]s
+`, k.Realm, k.Port, k.CCache, k.Keytab, k.Host, filepath.Join(k.Dir, "kdc.log"))
+	if err := ioutil.WriteFile(k.Config, []byte(krb5conf), 0600); err != nil {
+		return err
+	}
+
+	if k.Flavor == Heimdal {
+		// Heimdal's KDC and kadmin read a single file in krb5.conf's
+		// format, with the KDC's settings in a [kdc] section.
+		kdcconf := krb5conf + fmt.Sprintf(`
+[kdc]
+	database = {
+		dbname = %[2]s
+		realm = %[1]s
+	}
+	ports = %[3]d
+	addresses = 127.0.0.1
+	max-request = 65536
+`, k.Realm, filepath.Join(k.Dir, "heimdal"), k.Port)
+		return ioutil.WriteFile(k.kdcConfig, []byte(kdcconf), 0600)
+	}
+
+	kdcconf := fmt.Sprintf(`[kdcdefaults]
+	kdc_ports = %[2]d
+	kdc_tcp_ports = %[2]d
+
+[realms]
+	%[1]s = {
+		database_name = %[3]s
+		key_stash_file = %[4]s
+		acl_file = %[5]s
+		max_life = 1d
+		max_renewable_life = 7d
+		supported_enctypes = aes256-cts-hmac-sha1-96:normal aes128-cts-hmac-sha1-96:normal
+	}
+`, k.Realm, k.Port, filepath.Join(k.Dir, "principal"), filepath.Join(k.Dir, "stash"), filepath.Join(k.Dir, "kadm5.acl"))
+	if err := ioutil.WriteFile(k.kdcConfig, []byte(kdcconf), 0600); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(k.Dir, "kadm5.acl"), nil, 0600)
+}
+
+func (k *KDC) start(timeout time.Duration) error {
+	port, err := freePort()
+	if err != nil {
+		return err
+	}
+	k.Port = port
+	if err := k.writeConfig(); err != nil {
+		return err
+	}
+	if k.Flavor == Heimdal {
+		if err := k.kadmin("init", "--realm-max-ticket-life=1d", "--realm-max-renewable-life=7d", k.Realm); err != nil {
+			return err
+		}
+		k.cmd = exec.Command(k.tools["kdc"], "--config-file="+k.kdcConfig, fmt.Sprintf("--ports=%d", k.Port), "--addresses=127.0.0.1")
+	} else {
+		if _, err := k.run("kdb", "create", "-s", "-r", k.Realm, "-P", "gsstest-master-key"); err != nil {
+			return err
+		}
+		k.cmd = exec.Command(k.tools["kdc"], "-n", "-r", k.Realm)
+	}
+	k.cmd.Env = k.toolEnv()
+	k.cmd.Dir = k.Dir
+	if err := k.cmd.Start(); err != nil {
+		return err
+	}
+	k.exited = make(chan error, 1)
+	go func() {
+		k.exited <- k.cmd.Wait()
+	}()
+
+	addr := fmt.Sprintf("127.0.0.1:%d", k.Port)
+	deadline := time.Now().Add(timeout)
+	for {
+		select {
+		case err := <-k.exited:
+			k.exited <- err
+			return fmt.Errorf("%s KDC exited during startup: %v", k.Flavor, err)
+		default:
+		}
+		conn, err := net.DialTimeout("tcp", addr, time.Second)
+		if err == nil {
+			conn.Close()
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("%s KDC didn't start listening on %s: %v", k.Flavor, addr, err)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// Env returns environment variables which direct the Kerberos library to
+// this realm, for use when starting other programs.
+func (k *KDC) Env() []string {
+	return []string{
+		"KRB5_CONFIG=" + k.Config,
+		"KRB5CCNAME=" + k.CCache,
+		"KRB5_KTNAME=" + k.Keytab,
+	}
+}
+
+func (k *KDC) toolEnv() []string {
+	return append(append(os.Environ(), k.Env()...), "KRB5_KDC_PROFILE="+k.kdcConfig)
+}
+
+// Setenv sets the variables returned by Env in the current process, and
+// returns a function which restores their previous values.
+func (k *KDC) Setenv() (restore func()) {
+	type saved struct {
+		name, value string
+		ok          bool
+	}
+	var old []saved
+	for _, kv := range k.Env() {
+		i := strings.IndexByte(kv, '=')
+		name := kv[:i]
+		value, ok := os.LookupEnv(name)
+		old = append(old, saved{name, value, ok})
+		os.Setenv(name, kv[i+1:])
+	}
+	return func() {
+		for _, s := range old {
+			if s.ok {
+				os.Setenv(s.name, s.value)
+			} else {
+				os.Unsetenv(s.name)
+			}
+		}
+	}
+}
+
+// run runs one of the KDC's administrative programs against this realm.
+func (k *KDC) run(tool string, args ...string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(k.tools[tool], args...)
+	cmd.Env = k.toolEnv()
+	cmd.Dir = k.Dir
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	if err != nil {
+		return stdout.String(), fmt.Errorf("%s %s: %v: %s", tool, strings.Join(args, " "), err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.String(), nil
+}
+
+// kadmin runs a local kadmin command.  MIT's kadmin.local takes the command
+// as a single query, and Heimdal's kadmin -l takes it as arguments.  Older
+// versions of both exit successfully even when the command fails, so their
+// diagnostics are checked too.
+func (k *KDC) kadmin(command ...string) error {
+	var args []string
+	if k.Flavor == Heimdal {
+		args = append([]string{"-l", "--config-file=" + k.kdcConfig, "-r", k.Realm}, command...)
+	} else {
+		args = []string{"-r", k.Realm, "-q", strings.Join(command, " ")}
+	}
+	var stderr bytes.Buffer
+	cmd := exec.Command(k.tools["kadmin"], args...)
+	cmd.Env = k.toolEnv()
+	cmd.Dir = k.Dir
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	msg := strings.TrimSpace(stderr.String())
+	failed := strings.Contains(msg, " while ")
+	if k.Flavor == Heimdal {
+		failed = msg != ""
+	}
+	if err != nil || failed {
+		return fmt.Errorf("kadmin %q: %v: %s", strings.Join(command, " "), err, msg)
+	}
+	return nil
+}
+
+// Principal qualifies name with the realm, if it isn't already.
+func (k *KDC) Principal(name string) string {
+	if strings.Contains(name, "@") {
+		return name
+	}
+	return name + "@" + k.Realm
+}
+
+// AddPrincipal creates a principal with the given password, or with a
+// random key if password is "".
+func (k *KDC) AddPrincipal(name, password string) error {
+	if k.Flavor == Heimdal {
+		if password == "" {
+			return k.kadmin("add", "--random-key", "--use-defaults", k.Principal(name))
+		}
+		return k.kadmin("add", "--password="+password, "--use-defaults", k.Principal(name))
+	}
+	if password == "" {
+		return k.kadmin("addprinc", "-randkey", k.Principal(name))
+	}
+	return k.kadmin("addprinc", "-pw", password, k.Principal(name))
+}
+
+// AddService creates a random-keyed principal for service on the KDC's
+// host and adds its keys to the default keytab.  It returns the service's
+// name in host-based form, as accepted by ImportName with
+// C_NT_HOSTBASED_SERVICE.
+func (k *KDC) AddService(service string) (string, error) {
+	principal := service + "/" + k.Host
+	if err := k.AddPrincipal(principal, ""); err != nil {
+		return "", err
+	}
+	if err := k.ExportKeytab(k.Keytab, principal); err != nil {
+		return "", err
+	}
+	return service + "@" + k.Host, nil
+}
+
+// ExportKeytab adds the current keys of the named principals to keytab,
+// without changing them.
+func (k *KDC) ExportKeytab(keytab string, principals ...string) error {
+	for _, name := range principals {
+		var err error
+		if k.Flavor == Heimdal {
+			err = k.kadmin("ext_keytab", "--keytab="+keytab, k.Principal(name))
+		} else {
+			err = k.kadmin("ktadd", "-k", keytab, "-norandkey", k.Principal(name))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// NewKeytab creates a keytab holding the keys of the named principals, and
+// returns its name.
+func (k *KDC) NewKeytab(principals ...string) (string, error) {
+	f, err := ioutil.TempFile(k.Dir, "keytab")
+	if err != nil {
+		return "", err
+	}
+	path := f.Name()
+	f.Close()
+	// kadmin won't add entries to an empty file.
+	os.Remove(path)
+	keytab := "FILE:" + path
+	return keytab, k.ExportKeytab(keytab, principals...)
+}
+
+// Kinit obtains initial credentials for principal using the keys in keytab,
+// storing them in ccache.  Empty keytab or ccache names select the KDC's
+// defaults.
+func (k *KDC) Kinit(principal, keytab, ccache string) error {
+	if keytab == "" {
+		keytab = k.Keytab
+	}
+	if ccache == "" {
+		ccache = k.CCache
+	}
+	_, err := k.run("kinit", "-k", "-t", keytab, "-c", ccache, k.Principal(principal))
+	return err
+}
+
+// AddUser creates a user principal with a random key, and obtains initial
+// credentials for it in the default ccache.
+func (k *KDC) AddUser(name string) error {
+	if err := k.AddPrincipal(name, ""); err != nil {
+		return err
+	}
+	keytab, err := k.NewKeytab(name)
+	if err != nil {
+		return err
+	}
+	return k.Kinit(name, keytab, "")
+}
+
+// Close stops the KDC and removes its files.
+func (k *KDC) Close() error {
+	if k.cmd != nil && k.cmd.Process != nil {
+		k.cmd.Process.Kill()
+		<-k.exited
+		k.cmd = nil
+	}
+	if k.Dir != "" {
+		return os.RemoveAll(k.Dir)
+	}
+	return nil
+}
+
+// Start is a convenience wrapper for use in tests.  It creates a KDC, points
+// the current process at it for the duration of the test, and arranges for
+// it to be cleaned up afterwards.  The test is skipped if no KDC is
+// installed.
+func Start(tb testing.TB, opts Options) *KDC {
+	tb.Helper()
+	k, err := New(opts)
+	if err == ErrNoKDC {
+		tb.Skip(err)
+	}
+	if err != nil {
+		tb.Fatal(err)
+	}
+	restore := k.Setenv()
+	tb.Cleanup(func() {
+		restore()
+		k.Close()
+	})
+	return k
+}
@@ -0,0 +1,58 @@
+package gss
+
+import (
+	"errors"
+	"time"
+
+	"github.com/twistlock/gss/pkg/gss/handoff"
+	"github.com/twistlock/gss/pkg/gss/mech"
+)
+
+/* ExportHandoff() captures an established security context, along with information about it, so that it can be resumed by another process using ImportHandoff().  Upon success, contextHandle will have become invalid, and should be neither used nor deleted. */
+func ExportHandoff(contextHandle ContextHandle) (*handoff.State, error) {
+	major, minor, srcName, targName, lifetime, mechType, flags, _, _, locallyInitiated, open := InquireContext(contextHandle)
+	if major != S_COMPLETE {
+		return nil, NewGSSError("inquiring about context", major, minor, nil)
+	}
+	if srcName != nil {
+		defer ReleaseName(srcName)
+	}
+	if targName != nil {
+		defer ReleaseName(targName)
+	}
+	if !open {
+		return nil, errors.New("security context is not established")
+	}
+
+	state := &handoff.State{
+		Backend:          handoff.BackendGSS,
+		Mech:             mechType,
+		Flags:            uint64(FlagsToRaw(flags)),
+		Expires:          mech.Expiration(time.Now(), uint64(lifetime), C_INDEFINITE),
+		LocallyInitiated: locallyInitiated,
+	}
+	if srcName != nil {
+		_, _, state.SrcName, _ = DisplayName(srcName)
+	}
+	if targName != nil {
+		_, _, state.TargName, _ = DisplayName(targName)
+	}
+
+	major, minor, state.Context = ExportSecContext(contextHandle)
+	if major != S_COMPLETE {
+		return nil, NewGSSError("exporting context", major, minor, &mechType)
+	}
+	return state, nil
+}
+
+/* ImportHandoff() resumes a security context captured by ExportHandoff().  The returned contextHandle should eventually be freed using gss.DeleteSecContext(). */
+func ImportHandoff(state *handoff.State) (ContextHandle, error) {
+	if state.Backend != handoff.BackendGSS {
+		return nil, errors.New("context was not exported by the gss package")
+	}
+	major, minor, contextHandle := ImportSecContext(state.Context)
+	if major != S_COMPLETE {
+		return nil, NewGSSError("importing context", major, minor, &state.Mech)
+	}
+	return contextHandle, nil
+}
@@ -0,0 +1,139 @@
+/*
+Package handoff moves established security contexts between processes.
+
+A State holds everything needed to resume using a context in another process:
+the mechanism's interprocess token (which carries the keys and sequence
+numbers), along with metadata about the peer so that the receiving process
+doesn't need to ask for it again.  States are serialized into versioned blobs
+which can optionally be encrypted, and can be sent over a unix socket along
+with the descriptor of the client connection that the context protects.
+
+Use gss.ExportHandoff() and gss.ImportHandoff(), or their counterparts in the
+proxy package, to convert between contexts and States.
+*/
+package handoff
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/asn1"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Version is the blob format version written by Marshal.
+const Version = 1
+
+const (
+	flagEncrypted = 1 << 0
+	headerLen     = 6
+)
+
+var magic = []byte("GSSH")
+
+// Backend identifies the implementation which produced a context.
+type Backend string
+
+const (
+	BackendGSS   Backend = "gss"
+	BackendProxy Backend = "proxy"
+)
+
+// State describes an established security context which is being handed off.
+type State struct {
+	Backend Backend
+	// Context is the backend's serialized form of the context.
+	Context []byte
+	Mech    asn1.ObjectIdentifier
+	// SrcName and TargName are the display forms of the initiator and
+	// acceptor names.
+	SrcName, TargName string
+	// Flags are the context flags, in their integer representation.
+	Flags            uint64
+	Expires          time.Time
+	LocallyInitiated bool
+	// Data is for the application's use, for example to carry input which
+	// was read from the client but not yet processed.
+	Data []byte
+}
+
+// Marshal serializes state.  If key is not nil, it must be a 16, 24 or 32 byte
+// AES key, which will be used to encrypt the blob.
+func Marshal(state *State, key []byte) ([]byte, error) {
+	var body bytes.Buffer
+
+	err := gob.NewEncoder(&body).Encode(state)
+	if err != nil {
+		return nil, err
+	}
+	header := append([]byte{}, magic...)
+	header = append(header, Version, 0)
+	if key == nil {
+		return append(header, body.Bytes()...), nil
+	}
+
+	header[headerLen-1] |= flagEncrypted
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return nil, err
+	}
+	blob := append(header, nonce...)
+	return aead.Seal(blob, nonce, body.Bytes(), header), nil
+}
+
+// Unmarshal parses a blob produced by Marshal.  key must match the one which
+// was used to produce the blob, and must be nil if the blob isn't encrypted.
+func Unmarshal(blob, key []byte) (*State, error) {
+	var state State
+
+	if len(blob) < headerLen || !bytes.Equal(blob[:len(magic)], magic) {
+		return nil, errors.New("not a context handoff blob")
+	}
+	if blob[len(magic)] != Version {
+		return nil, fmt.Errorf("unsupported context handoff blob version %d", blob[len(magic)])
+	}
+	header, body := blob[:headerLen], blob[headerLen:]
+	encrypted := header[headerLen-1]&flagEncrypted != 0
+
+	switch {
+	case encrypted && key == nil:
+		return nil, errors.New("context handoff blob is encrypted, but no key was supplied")
+	case !encrypted && key != nil:
+		return nil, errors.New("context handoff blob is not encrypted")
+	case encrypted:
+		aead, err := newAEAD(key)
+		if err != nil {
+			return nil, err
+		}
+		if len(body) < aead.NonceSize() {
+			return nil, errors.New("truncated context handoff blob")
+		}
+		body, err = aead.Open(nil, body[:aead.NonceSize()], body[aead.NonceSize():], header)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err := gob.NewDecoder(bytes.NewReader(body)).Decode(&state)
+	if err != nil {
+		return nil, err
+	}
+	return &state, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
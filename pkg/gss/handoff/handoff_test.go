@@ -0,0 +1,81 @@
+package handoff_test
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/twistlock/gss/pkg/gss/handoff"
+)
+
+var state = &handoff.State{
+	Backend:          handoff.BackendGSS,
+	Context:          []byte("interprocess token"),
+	Mech:             []int{1, 2, 840, 113554, 1, 2, 2},
+	SrcName:          "alice@EXAMPLE.COM",
+	TargName:         "host/server.example.com@EXAMPLE.COM",
+	Flags:            0x3e,
+	Expires:          time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC),
+	LocallyInitiated: true,
+	Data:             []byte("unread input"),
+}
+
+func TestMarshal(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, 32)
+	for _, key := range [][]byte{nil, key} {
+		blob, err := handoff.Marshal(state, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if key != nil && bytes.Contains(blob, state.Context) {
+			t.Error("encrypted blob contains the context in the clear")
+		}
+		got, err := handoff.Unmarshal(blob, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, state) {
+			t.Errorf("round trip with key %v returned %+v, want %+v", key != nil, got, state)
+		}
+	}
+}
+
+func TestUnmarshalErrors(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, 16)
+	plain, err := handoff.Marshal(state, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := handoff.Marshal(state, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = handoff.Marshal(state, []byte("short")); err == nil {
+		t.Error("Marshal accepted a bad key")
+	}
+
+	badVersion := append([]byte{}, plain...)
+	badVersion[4] = handoff.Version + 1
+	tampered := append([]byte{}, sealed...)
+	tampered[len(tampered)-1] ^= 1
+	for _, tc := range []struct {
+		name string
+		blob []byte
+		key  []byte
+	}{
+		{"wrong key", sealed, bytes.Repeat([]byte{8}, 16)},
+		{"missing key", sealed, nil},
+		{"unexpected key", plain, key},
+		{"tampered", tampered, key},
+		{"bad version", badVersion, nil},
+		{"bad magic", append([]byte("XXXX"), plain[4:]...), nil},
+		{"truncated header", plain[:3], nil},
+		{"truncated nonce", sealed[:8], key},
+		{"truncated body", plain[:len(plain)/2], nil},
+	} {
+		if _, err := handoff.Unmarshal(tc.blob, tc.key); err == nil {
+			t.Errorf("%s: Unmarshal succeeded", tc.name)
+		}
+	}
+}
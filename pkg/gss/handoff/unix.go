@@ -0,0 +1,115 @@
+package handoff
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"syscall"
+)
+
+// MaxBlobSize limits the size of blobs accepted by Receive.
+const MaxBlobSize = 1 << 20
+
+// fileConn is implemented by connections whose descriptors can be passed,
+// such as *net.TCPConn and *net.UnixConn.
+type fileConn interface {
+	File() (*os.File, error)
+}
+
+// Send writes blob to via, passing along the descriptor for conn using
+// SCM_RIGHTS.  conn can be closed once Send returns.
+func Send(via *net.UnixConn, blob []byte, conn net.Conn) error {
+	fc, ok := conn.(fileConn)
+	if !ok {
+		return errors.New("connection's descriptor can not be passed")
+	}
+	f, err := fc.File()
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	msg := make([]byte, 4, 4+len(blob))
+	binary.BigEndian.PutUint32(msg, uint32(len(blob)))
+	msg = append(msg, blob...)
+	n, _, err := via.WriteMsgUnix(msg, syscall.UnixRights(int(f.Fd())), nil)
+	if err != nil {
+		return err
+	}
+	if n < len(msg) {
+		_, err = via.Write(msg[n:])
+	}
+	return err
+}
+
+// Receive reads a blob and connection sent using Send.
+func Receive(via *net.UnixConn) (blob []byte, conn net.Conn, err error) {
+	var header [4]byte
+	oob := make([]byte, syscall.CmsgSpace(4))
+
+	// Only read the length along with the descriptor, so that we don't consume
+	// part of a following message.
+	n, oobn, flags, _, err := via.ReadMsgUnix(header[:], oob)
+	if err != nil {
+		return nil, nil, err
+	}
+	passed, err := connFromRights(oob[:oobn], flags&syscall.MSG_CTRUNC != 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer func() {
+		if err != nil {
+			passed.Close()
+		}
+	}()
+
+	_, err = io.ReadFull(via, header[n:])
+	if err != nil {
+		return nil, nil, err
+	}
+	length := binary.BigEndian.Uint32(header[:])
+	if length > MaxBlobSize {
+		return nil, nil, errors.New("context handoff blob is too large")
+	}
+	blob = make([]byte, length)
+	_, err = io.ReadFull(via, blob)
+	if err != nil {
+		return nil, nil, err
+	}
+	return blob, passed, nil
+}
+
+// connFromRights turns the first descriptor passed in oob into a connection,
+// closing any others, whichever control message they arrived in.  If the
+// control data was truncated, the kernel has discarded some of what was
+// passed, so we close everything and fail.
+func connFromRights(oob []byte, truncated bool) (net.Conn, error) {
+	msgs, err := syscall.ParseSocketControlMessage(oob)
+	if err != nil {
+		return nil, err
+	}
+	var fds []int
+	for i := range msgs {
+		rights, err := syscall.ParseUnixRights(&msgs[i])
+		if err == nil {
+			fds = append(fds, rights...)
+		}
+	}
+	if truncated || len(fds) == 0 {
+		for _, fd := range fds {
+			syscall.Close(fd)
+		}
+		if truncated {
+			return nil, errors.New("control data passed along with the context was truncated")
+		}
+		return nil, errors.New("no connection was passed along with the context")
+	}
+	for _, fd := range fds[1:] {
+		syscall.Close(fd)
+	}
+	f := os.NewFile(uintptr(fds[0]), "handoff")
+	defer f.Close()
+	return net.FileConn(f)
+}
@@ -0,0 +1,178 @@
+package handoff
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+)
+
+// socketpair returns both ends of a connected unix socket.
+func socketpair(t *testing.T) (*net.UnixConn, *net.UnixConn) {
+	t.Helper()
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var conns [2]*net.UnixConn
+	for i, fd := range fds {
+		f := os.NewFile(uintptr(fd), "socketpair")
+		c, err := net.FileConn(f)
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		conns[i] = c.(*net.UnixConn)
+		t.Cleanup(func() { c.Close() })
+	}
+	return conns[0], conns[1]
+}
+
+// isOpen reports whether fd is an open descriptor.
+func isOpen(fd int) bool {
+	_, _, errno := syscall.Syscall(syscall.SYS_FCNTL, uintptr(fd), syscall.F_GETFD, 0)
+	return errno == 0
+}
+
+// openDescriptors counts this process's open descriptors.
+func openDescriptors(t *testing.T) int {
+	t.Helper()
+	entries, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		t.Skip(err)
+	}
+	return len(entries)
+}
+
+func TestSendReceive(t *testing.T) {
+	a, b := socketpair(t)
+	client, server := socketpair(t)
+	blob := bytes.Repeat([]byte("blob"), 1000)
+
+	errs := make(chan error, 1)
+	go func() { errs <- Send(a, blob, server) }()
+	got, conn, err := Receive(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if err = <-errs; err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, blob) {
+		t.Errorf("received a %d byte blob, want %d bytes", len(got), len(blob))
+	}
+
+	// The received connection is the one which was sent.
+	server.Close()
+	go conn.Write([]byte("hello"))
+	buf := make([]byte, 5)
+	if _, err = io.ReadFull(client, buf); err != nil || string(buf) != "hello" {
+		t.Errorf("read %q, %v through the passed connection", buf, err)
+	}
+}
+
+func TestReceiveWithoutDescriptor(t *testing.T) {
+	a, b := socketpair(t)
+	go a.Write([]byte{0, 0, 0, 0})
+	if _, _, err := Receive(b); err == nil {
+		t.Error("Receive succeeded without a descriptor")
+	}
+}
+
+func TestReceiveTooLarge(t *testing.T) {
+	a, b := socketpair(t)
+	_, server := socketpair(t)
+	f, err := server.File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	before := openDescriptors(t)
+	var header [4]byte
+	binary.BigEndian.PutUint32(header[:], MaxBlobSize+1)
+	if _, _, err = a.WriteMsgUnix(header[:], syscall.UnixRights(int(f.Fd())), nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err = Receive(b); err == nil {
+		t.Error("Receive accepted an oversized blob")
+	}
+	if after := openDescriptors(t); after != before {
+		t.Errorf("%d descriptors were open before, %d after", before, after)
+	}
+}
+
+// TestReceiveTruncated passes more descriptors than Receive makes room for.
+// The kernel discards the ones which don't fit, so Receive must fail, and
+// must close the one which did.
+func TestReceiveTruncated(t *testing.T) {
+	a, b := socketpair(t)
+	_, server := socketpair(t)
+	f, err := server.File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	before := openDescriptors(t)
+	var header [4]byte
+	fd := int(f.Fd())
+	if _, _, err = a.WriteMsgUnix(header[:], syscall.UnixRights(fd, fd, fd), nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err = Receive(b); err == nil {
+		t.Error("Receive succeeded with truncated control data")
+	}
+	if after := openDescriptors(t); after != before {
+		t.Errorf("%d descriptors were open before, %d after", before, after)
+	}
+}
+
+// TestConnFromRights checks that descriptors beyond the first are closed,
+// including ones in later control messages.
+func TestConnFromRights(t *testing.T) {
+	_, server := socketpair(t)
+	f, err := server.File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var fds []int
+	for i := 0; i < 4; i++ {
+		fd, err := syscall.Dup(int(f.Fd()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		fds = append(fds, fd)
+	}
+	oob := append(syscall.UnixRights(fds[0], fds[1]), syscall.UnixRights(fds[2], fds[3])...)
+	conn, err := connFromRights(oob, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	for _, fd := range fds {
+		if isOpen(fd) {
+			t.Errorf("descriptor %d is still open", fd)
+			syscall.Close(fd)
+		}
+	}
+
+	// Nothing is kept if the control data was truncated.
+	for i := range fds[:2] {
+		if fds[i], err = syscall.Dup(int(f.Fd())); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err = connFromRights(syscall.UnixRights(fds[0], fds[1]), true); err == nil {
+		t.Error("truncated control data was accepted")
+	}
+	for _, fd := range fds[:2] {
+		if isOpen(fd) {
+			t.Errorf("descriptor %d is still open after truncation", fd)
+			syscall.Close(fd)
+		}
+	}
+}
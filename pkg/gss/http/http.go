@@ -13,8 +13,23 @@ import (
 
 type NegotiateRoundTripper struct {
 	Transport http.RoundTripper
-	Flags     gss.Flags
-	Mech      asn1.ObjectIdentifier
+	// Flags are passed to InitSecContext.  Set Deleg to forward the initiator
+	// credentials to the server, or DelegPolicy to forward them only if the
+	// KDC's policy allows delegating to the server.
+	Flags gss.Flags
+	Mech  asn1.ObjectIdentifier
+
+	// Cred, if not nil, is used as the initiator credential in place of the
+	// default credentials.  Delegated credentials returned by
+	// gss.AcceptSecContext can be used here to call back-end services on behalf
+	// of a client.  The caller retains ownership of the handle.
+	Cred gss.CredHandle
+
+	// Impersonate, if set, is the name of a user on whose behalf requests should
+	// be made.  Credentials for the user are obtained using
+	// gss.AcquireCredImpersonateName, with Cred (or the default initiator
+	// credentials, if Cred is nil) acting as the impersonator.
+	Impersonate string
 }
 
 func NewNegotiateRoundTripper(rt http.RoundTripper) http.RoundTripper {
@@ -41,6 +56,15 @@ func (rt *NegotiateRoundTripper) RoundTrip(req *http.Request) (*http.Response, e
 		}
 		defer gss.ReleaseName(name)
 
+		cred := rt.Cred
+		if rt.Impersonate != "" {
+			cred, err = ImpersonateCred(rt.Cred, rt.Impersonate)
+			if err != nil {
+				return nil, err
+			}
+			defer gss.ReleaseCred(cred)
+		}
+
 		var ctx gss.ContextHandle
 		defer gss.DeleteSecContext(ctx)
 
@@ -69,7 +93,7 @@ func (rt *NegotiateRoundTripper) RoundTrip(req *http.Request) (*http.Response, e
 
 			// call gss_init_sec_context to validate the incoming token (if given), and get our outgoing token (if needed)
 			var outgoingToken []byte
-			major, minor, _, outgoingToken, flags, _, _, _ = gss.InitSecContext(nil, &ctx, name, rt.Mech, flags, gss.C_INDEFINITE, nil, incomingToken)
+			major, minor, _, outgoingToken, flags, _, _, _ = gss.InitSecContext(cred, &ctx, name, rt.Mech, flags, gss.C_INDEFINITE, nil, incomingToken)
 			if major != gss.S_COMPLETE && major != gss.S_CONTINUE_NEEDED {
 				return nil, gss.NewGSSError(fmt.Sprintf("initializing security context (step %d)", i+1), major, minor, &rt.Mech)
 			}
@@ -149,3 +173,30 @@ func ImportName(hostname string) (gss.InternalName, error) {
 	}
 	return name, nil
 }
+
+// ImpersonateCred returns initiator credentials which can be used to act on
+// behalf of user, obtained using impersonator (or the default initiator
+// credentials, if impersonator is nil).  The caller is responsible for
+// releasing the returned credentials using gss.ReleaseCred.
+func ImpersonateCred(impersonator gss.CredHandle, user string) (gss.CredHandle, error) {
+	major, minor, name := gss.ImportName(user, gss.KRB5_NT_PRINCIPAL_NAME)
+	if major != gss.S_COMPLETE {
+		return nil, gss.NewGSSError("importing impersonated user name", major, minor, nil)
+	}
+	defer gss.ReleaseName(name)
+
+	// The library won't impersonate using default credentials, so get some.
+	if impersonator == nil {
+		major, minor, impersonator, _, _ = gss.AcquireCred(nil, gss.C_INDEFINITE, nil, gss.C_INITIATE)
+		if major != gss.S_COMPLETE {
+			return nil, gss.NewGSSError("acquiring impersonator credentials", major, minor, nil)
+		}
+		defer gss.ReleaseCred(impersonator)
+	}
+
+	major, minor, cred, _, _ := gss.AcquireCredImpersonateName(impersonator, name, gss.C_INDEFINITE, nil, gss.C_INITIATE)
+	if major != gss.S_COMPLETE {
+		return nil, gss.NewGSSError("acquiring impersonated credentials", major, minor, nil)
+	}
+	return cred, nil
+}
@@ -0,0 +1,243 @@
+package http_test
+
+import (
+	"context"
+	"encoding/base64"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/twistlock/gss/pkg/gss"
+	"github.com/twistlock/gss/pkg/gss/credstore"
+	"github.com/twistlock/gss/pkg/gss/gsstest"
+	gsshttp "github.com/twistlock/gss/pkg/gss/http"
+)
+
+// negotiateServer starts a server which requires Negotiate authentication,
+// using the KDC's default keytab, and replies with the client's name.
+func negotiateServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		if !strings.HasPrefix(auth, "Negotiate ") {
+			w.Header().Set("WWW-Authenticate", "Negotiate")
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		token, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(auth, "Negotiate "))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var ctx gss.ContextHandle
+		defer gss.DeleteSecContext(ctx)
+		major, minor, src, _, _, _, _, _, _, output := gss.AcceptSecContext(nil, &ctx, nil, token)
+		if major != gss.S_COMPLETE {
+			t.Errorf("server: %v", gss.NewGSSError("accepting context", major, minor, nil))
+			w.Header().Set("WWW-Authenticate", "Negotiate")
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		defer gss.ReleaseName(src)
+		_, _, client, _ := gss.DisplayName(src)
+		if len(output) > 0 {
+			w.Header().Set("WWW-Authenticate", "Negotiate "+base64.StdEncoding.EncodeToString(output))
+		}
+		w.Write([]byte(client))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// localhostTransport sends requests for any host to srv, so that the
+// service name is built from "localhost" without a port.
+func localhostTransport(srv *httptest.Server) *http.Transport {
+	return &http.Transport{
+		DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, srv.Listener.Addr().String())
+		},
+	}
+}
+
+// get makes a request using rt and returns the name of the client which the
+// server authenticated.
+func get(t *testing.T, rt http.RoundTripper) string {
+	t.Helper()
+	client := &http.Client{Transport: rt}
+	resp, err := client.Get("http://localhost/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status %s: %s", resp.Status, body)
+	}
+	return string(body)
+}
+
+// ccacheCred obtains initial credentials for principal in a new ccache and
+// returns initiator credentials which use it.
+func ccacheCred(t *testing.T, kdc *gsstest.KDC, principal, keytab string) gss.CredHandle {
+	t.Helper()
+	ccache := "FILE:" + filepath.Join(kdc.Dir, "ccache."+strings.Replace(principal, "/", "_", -1))
+	if err := kdc.Kinit(principal, keytab, ccache); err != nil {
+		t.Fatal(err)
+	}
+	major, minor, cred, _, _ := gss.AcquireCredFrom(nil, gss.C_INDEFINITE, nil, gss.C_INITIATE, credstore.New().SetCCache(ccache))
+	if major != gss.S_COMPLETE {
+		t.Fatal(gss.NewGSSError("acquiring credentials", major, minor, nil))
+	}
+	t.Cleanup(func() { gss.ReleaseCred(cred) })
+	return cred
+}
+
+func TestNegotiateRoundTripper(t *testing.T) {
+	kdc := gsstest.Start(t, gsstest.Options{})
+	if err := kdc.AddUser("alice"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := kdc.AddService("HTTP"); err != nil {
+		t.Fatal(err)
+	}
+	srv := negotiateServer(t)
+
+	rt := &gsshttp.NegotiateRoundTripper{
+		Transport: localhostTransport(srv),
+		Flags:     gss.Flags{Mutual: true},
+	}
+	client := &http.Client{Transport: rt}
+	resp, err := client.Get("http://localhost/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status %s: %s", resp.Status, body)
+	}
+	if want := kdc.Principal("alice"); string(body) != want {
+		t.Errorf("server saw client %q, want %q", body, want)
+	}
+}
+
+func TestNegotiateRoundTripperUnknownService(t *testing.T) {
+	kdc := gsstest.Start(t, gsstest.Options{})
+	if err := kdc.AddUser("alice"); err != nil {
+		t.Fatal(err)
+	}
+	srv := negotiateServer(t)
+
+	client := &http.Client{Transport: gsshttp.NewNegotiateRoundTripper(localhostTransport(srv))}
+	resp, err := client.Get("http://localhost/")
+	if err == nil {
+		resp.Body.Close()
+		t.Fatal("request to a service without a principal succeeded")
+	}
+}
+
+func TestNegotiateRoundTripperCred(t *testing.T) {
+	kdc := gsstest.Start(t, gsstest.Options{})
+	for _, user := range []string{"alice", "bob"} {
+		if err := kdc.AddUser(user); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := kdc.AddService("HTTP"); err != nil {
+		t.Fatal(err)
+	}
+	srv := negotiateServer(t)
+
+	// The default ccache now holds bob's credentials, so use alice's.
+	keytab, err := kdc.NewKeytab("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rt := &gsshttp.NegotiateRoundTripper{
+		Transport: localhostTransport(srv),
+		Flags:     gss.Flags{Mutual: true},
+		Cred:      ccacheCred(t, kdc, "alice", keytab),
+	}
+	if client, want := get(t, rt), kdc.Principal("alice"); client != want {
+		t.Errorf("server saw client %q, want %q", client, want)
+	}
+}
+
+// impersonationRealm sets up a KDC in which the HTTP service, whose keys are
+// in the default keytab, can obtain tickets to itself on behalf of carol.
+// Such tickets can be used without constrained delegation, since the target
+// is the impersonator.
+func impersonationRealm(t *testing.T) *gsstest.KDC {
+	t.Helper()
+	kdc := gsstest.Start(t, gsstest.Options{})
+	if kdc.Flavor == gsstest.Heimdal {
+		t.Skip("impersonating a user with Heimdal's KDC is not supported")
+	}
+	if err := kdc.AddPrincipal("carol", ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := kdc.AddService("HTTP"); err != nil {
+		t.Fatal(err)
+	}
+	return kdc
+}
+
+func TestNegotiateRoundTripperImpersonate(t *testing.T) {
+	kdc := impersonationRealm(t)
+	srv := negotiateServer(t)
+
+	// With Cred, the service acts as the impersonator.
+	rt := &gsshttp.NegotiateRoundTripper{
+		Transport:   localhostTransport(srv),
+		Flags:       gss.Flags{Mutual: true},
+		Cred:        ccacheCred(t, kdc, "HTTP/"+kdc.Host, ""),
+		Impersonate: kdc.Principal("carol"),
+	}
+	if client, want := get(t, rt), kdc.Principal("carol"); client != want {
+		t.Errorf("with Cred: server saw client %q, want %q", client, want)
+	}
+
+	// Without it, the service's credentials in the default ccache are used.
+	if err := kdc.Kinit("HTTP/"+kdc.Host, "", ""); err != nil {
+		t.Fatal(err)
+	}
+	rt.Cred = nil
+	if client, want := get(t, rt), kdc.Principal("carol"); client != want {
+		t.Errorf("without Cred: server saw client %q, want %q", client, want)
+	}
+}
+
+func TestImpersonateCred(t *testing.T) {
+	kdc := impersonationRealm(t)
+	srv := negotiateServer(t)
+
+	impersonator := ccacheCred(t, kdc, "HTTP/"+kdc.Host, "")
+	cred, err := gsshttp.ImpersonateCred(impersonator, kdc.Principal("carol"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer gss.ReleaseCred(cred)
+	rt := &gsshttp.NegotiateRoundTripper{
+		Transport: localhostTransport(srv),
+		Flags:     gss.Flags{Mutual: true},
+		Cred:      cred,
+	}
+	if client, want := get(t, rt), kdc.Principal("carol"); client != want {
+		t.Errorf("server saw client %q, want %q", client, want)
+	}
+
+	if _, err = gsshttp.ImpersonateCred(impersonator, "nobody@"+kdc.Realm); err == nil {
+		t.Error("impersonating a user who doesn't exist succeeded")
+	}
+}
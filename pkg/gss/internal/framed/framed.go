@@ -0,0 +1,133 @@
+/*
+Package framed holds the protected connection which is shared by the socks5,
+sasl and pgssenc packages.  Once a context is established, each of them
+wraps the data written to a Conn and carries the tokens in frames of its
+own, and unwraps the tokens in the frames which it reads.  A Framing
+describes the frames, and whether the data must be encrypted.
+*/
+package framed
+
+import (
+	"errors"
+	"io"
+	"net"
+	"sync"
+
+	"github.com/twistlock/gss/pkg/gss/mech"
+)
+
+// ErrNotEncrypted is returned by a Conn which requires encryption when the
+// peer sends data which is only integrity-protected.
+var ErrNotEncrypted = errors.New("peer sent data without encrypting it")
+
+// Framing describes how a protocol carries wrapped data.
+type Framing struct {
+	// WriteToken sends a wrapped token in one frame.
+	WriteToken func(w io.Writer, token []byte) error
+	// ReadToken reads the token in the peer's next frame.
+	ReadToken func(r io.Reader) ([]byte, error)
+	// MaxChunk is the most data which is wrapped into one token.  It must
+	// be positive.
+	MaxChunk int
+	// Conf requests encryption, both of the data which we send and of
+	// the data which the peer sends.  Otherwise data is only
+	// integrity-protected, and the peer may encrypt it or not.
+	Conf bool
+}
+
+// Conn is a net.Conn which protects data written to it, and checks and
+// removes the protection from data read from it.
+type Conn struct {
+	net.Conn
+	ctx     mech.Context
+	framing Framing
+
+	rmu     sync.Mutex // serializes reads, and protects pending
+	wmu     sync.Mutex // serializes writes
+	pending []byte
+}
+
+// New returns a Conn which protects data sent over conn using ctx and
+// carries it as framing describes.  The Conn takes ownership of ctx, and
+// releases it when it is closed.
+func New(conn net.Conn, ctx mech.Context, framing Framing) *Conn {
+	return &Conn{Conn: conn, ctx: ctx, framing: framing}
+}
+
+// Context returns the context which protects the connection, or nil once
+// the Conn has been closed.
+func (c *Conn) Context() mech.Context {
+	return c.ctx
+}
+
+// Write wraps p and sends it in as many frames as it takes.
+func (c *Conn) Write(p []byte) (int, error) {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	if c.ctx == nil {
+		return 0, net.ErrClosed
+	}
+	written := 0
+	for len(p) > 0 {
+		n := len(p)
+		if n > c.framing.MaxChunk {
+			n = c.framing.MaxChunk
+		}
+		token, err := c.ctx.Wrap(p[:n], c.framing.Conf)
+		if err == nil {
+			err = c.framing.WriteToken(c.Conn, token)
+		}
+		if err != nil {
+			return written, err
+		}
+		written += n
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// Read reads data sent by the peer, after checking and removing its
+// protection.
+func (c *Conn) Read(p []byte) (int, error) {
+	c.rmu.Lock()
+	defer c.rmu.Unlock()
+
+	for len(c.pending) == 0 {
+		if c.ctx == nil {
+			return 0, net.ErrClosed
+		}
+		token, err := c.framing.ReadToken(c.Conn)
+		if err != nil {
+			return 0, err
+		}
+		message, conf, err := c.ctx.Unwrap(token)
+		if err != nil {
+			return 0, err
+		}
+		if c.framing.Conf && !conf {
+			return 0, ErrNotEncrypted
+		}
+		c.pending = message
+	}
+	n := copy(p, c.pending)
+	c.pending = c.pending[n:]
+	return n, nil
+}
+
+// Close closes the connection and releases the context.
+func (c *Conn) Close() error {
+	err := c.Conn.Close()
+
+	// Wait for anyone who's still using the context.
+	c.rmu.Lock()
+	defer c.rmu.Unlock()
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	if c.ctx != nil {
+		c.ctx.Release()
+		c.ctx = nil
+	}
+	return err
+}
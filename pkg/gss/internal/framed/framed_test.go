@@ -0,0 +1,97 @@
+package framed
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/twistlock/gss/pkg/gss/mech/fake"
+)
+
+// byteFraming carries each token after a one-octet length.
+func byteFraming(maxChunk int, conf bool) Framing {
+	return Framing{
+		WriteToken: func(w io.Writer, token []byte) error {
+			_, err := w.Write(append([]byte{byte(len(token))}, token...))
+			return err
+		},
+		ReadToken: func(r io.Reader) ([]byte, error) {
+			var length [1]byte
+			if _, err := io.ReadFull(r, length[:]); err != nil {
+				return nil, err
+			}
+			token := make([]byte, length[0])
+			_, err := io.ReadFull(r, token)
+			return token, err
+		},
+		MaxChunk: maxChunk,
+		Conf:     conf,
+	}
+}
+
+// pipe returns Conns on either end of a pipe, protected by an established
+// fake context.
+func pipe(t *testing.T, initiator, acceptor Framing) (*Conn, *Conn, *fake.Context) {
+	t.Helper()
+	cfg := fake.Config{Initiator: "alice@EXAMPLE.COM", Acceptor: "host@example.com", Key: []byte("key"), Flags: fake.FlagInteg | fake.FlagConf}
+	ictx, actx := fake.NewInitiator(cfg), fake.NewAcceptor(cfg)
+	token, _, err := ictx.Step(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err = actx.Step(token); err != nil {
+		t.Fatal(err)
+	}
+	a, b := net.Pipe()
+	c, s := New(a, ictx, initiator), New(b, actx, acceptor)
+	t.Cleanup(func() {
+		c.Close()
+		s.Close()
+	})
+	return c, s, ictx
+}
+
+func TestConn(t *testing.T) {
+	c, s, ctx := pipe(t, byteFraming(3, true), byteFraming(3, true))
+	data := []byte("split into several tokens")
+	go func() {
+		if n, err := c.Write(data); err != nil || n != len(data) {
+			t.Errorf("Write() = %d, %v", n, err)
+		}
+	}()
+	got := make([]byte, len(data))
+	if _, err := io.ReadFull(s, got); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("read %q, want %q", got, data)
+	}
+
+	c.Close()
+	if _, err := c.Read(got); err != net.ErrClosed {
+		t.Errorf("reading from a closed Conn returned %v", err)
+	}
+	if _, err := c.Write(got); err != net.ErrClosed {
+		t.Errorf("writing to a closed Conn returned %v", err)
+	}
+	if _, err := ctx.Wrap(nil, false); err != fake.ErrReleased {
+		t.Errorf("context was not released: Wrap returned %v", err)
+	}
+}
+
+func TestConnRequiresEncryption(t *testing.T) {
+	c, s, _ := pipe(t, byteFraming(16, false), byteFraming(16, true))
+	go c.Write([]byte("plain"))
+	if _, err := s.Read(make([]byte, 16)); err != ErrNotEncrypted {
+		t.Errorf("reading unencrypted data returned %v, want %v", err, ErrNotEncrypted)
+	}
+
+	// Without Conf, encrypted data is accepted too.
+	c, s, _ = pipe(t, byteFraming(16, true), byteFraming(16, false))
+	go c.Write([]byte("sealed"))
+	buf := make([]byte, 16)
+	if n, err := s.Read(buf); err != nil || string(buf[:n]) != "sealed" {
+		t.Errorf("Read() = %q, %v", buf[:n], err)
+	}
+}
@@ -0,0 +1,319 @@
+/*
+Package renewal holds the scheduling and reference counting which are shared by
+the credmgr packages.  A Manager acquires credentials using a Backend, renews
+them before they expire, and hands them out to callers, releasing credentials
+which have been replaced once nobody is using them any more.
+*/
+package renewal
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultRenewBefore is used if Options.RenewBefore is not set.
+	DefaultRenewBefore = 5 * time.Minute
+	// DefaultRetryInterval is used if Options.RetryInterval is not set.
+	DefaultRetryInterval = 30 * time.Second
+	// DefaultMaxRetryInterval is used if Options.MaxRetryInterval is not set.
+	DefaultMaxRetryInterval = 10 * time.Minute
+	// DefaultMinInterval is used if Options.MinInterval is not set.
+	DefaultMinInterval = 10 * time.Second
+)
+
+// ErrClosed is returned by Renew once the Manager has been closed.
+var ErrClosed = errors.New("credential manager is closed")
+
+// Backend acquires and releases one kind of credentials.
+type Backend[C comparable] interface {
+	// Acquire obtains new credentials, and reports how much longer they
+	// will be valid.  A negative lifetime means that they don't expire.
+	Acquire() (cred C, lifetime time.Duration, err error)
+	// Release frees credentials which have been replaced and are no longer
+	// in use.
+	Release(cred C)
+}
+
+// Event describes the outcome of an attempt to renew credentials.
+type Event struct {
+	Time time.Time
+	// Err is nil if the credentials were renewed.
+	Err error
+	// Lifetime is the remaining lifetime of the credentials which are now current.
+	Lifetime time.Duration
+}
+
+// Stats counts a Manager's renewal attempts.
+type Stats struct {
+	Renewals, Failures uint64
+	LastRenewal        time.Time
+	LastError          error
+	Expires            time.Time
+}
+
+// Options control when a Manager renews its credentials.
+type Options struct {
+	// RenewBefore is how long before expiration the credentials are
+	// renewed.  Credentials whose lifetime is too short for that are
+	// renewed halfway through their remaining lifetime instead.
+	RenewBefore time.Duration
+	// MinInterval is the shortest time to wait between scheduled renewals.
+	MinInterval time.Duration
+	// RetryInterval is how long to wait before trying again after a failed
+	// renewal.  It doubles after each consecutive failure, up to
+	// MaxRetryInterval.
+	RetryInterval    time.Duration
+	MaxRetryInterval time.Duration
+	// OnEvent, if set, is called after every renewal attempt.
+	OnEvent func(Event)
+}
+
+type entry[C comparable] struct {
+	cred     C
+	expires  time.Time
+	refs     int
+	retired  bool
+	released bool
+}
+
+// Manager hands out credentials which it renews in the background.
+type Manager[C comparable] struct {
+	backend Backend[C]
+	opts    Options
+
+	mu        sync.Mutex
+	current   *entry[C]
+	stats     Stats
+	failures  int // consecutive failed renewals
+	stop      chan struct{}
+	renew     chan chan error
+	done      sync.WaitGroup
+	closeOnce sync.Once
+}
+
+// New acquires an initial set of credentials using backend and starts
+// renewing them in the background.  The Manager should be shut down using
+// Close when it's no longer needed.
+func New[C comparable](backend Backend[C], opts Options) (*Manager[C], error) {
+	if opts.RenewBefore == 0 {
+		opts.RenewBefore = DefaultRenewBefore
+	}
+	if opts.MinInterval == 0 {
+		opts.MinInterval = DefaultMinInterval
+	}
+	if opts.RetryInterval == 0 {
+		opts.RetryInterval = DefaultRetryInterval
+	}
+	if opts.MaxRetryInterval == 0 {
+		opts.MaxRetryInterval = DefaultMaxRetryInterval
+	}
+	if opts.MaxRetryInterval < opts.RetryInterval {
+		opts.MaxRetryInterval = opts.RetryInterval
+	}
+	m := &Manager[C]{
+		backend: backend,
+		opts:    opts,
+		stop:    make(chan struct{}),
+		renew:   make(chan chan error),
+	}
+	if err := m.replace(); err != nil {
+		return nil, err
+	}
+	m.done.Add(1)
+	go m.run()
+	return m, nil
+}
+
+// Get returns the current credentials, along with a function which must be
+// called once the caller is finished with them.
+func (m *Manager[C]) Get() (cred C, done func()) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e := m.current
+	e.refs++
+	return e.cred, func() {
+		m.mu.Lock()
+		e.refs--
+		release := m.unused(e)
+		m.mu.Unlock()
+		if release {
+			m.backend.Release(e.cred)
+		}
+	}
+}
+
+// Expired tells the Manager that cred was rejected as expired, so that it can
+// be replaced without waiting for the next scheduled renewal.
+func (m *Manager[C]) Expired(cred C) {
+	m.mu.Lock()
+	current := m.current.cred == cred
+	m.mu.Unlock()
+	if current {
+		go m.Renew()
+	}
+}
+
+// Renew acquires new credentials immediately, without waiting for the current
+// ones to near expiration.
+func (m *Manager[C]) Renew() error {
+	result := make(chan error, 1)
+	select {
+	case m.renew <- result:
+		return <-result
+	case <-m.stop:
+		return ErrClosed
+	}
+}
+
+// Stats returns counts of the renewals which have been attempted so far.
+func (m *Manager[C]) Stats() Stats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stats := m.stats
+	stats.Expires = m.current.expires
+	return stats
+}
+
+// Close stops renewing the credentials, and releases them once they're no
+// longer in use.  Calling it more than once has no further effect.
+func (m *Manager[C]) Close() {
+	m.closeOnce.Do(func() {
+		close(m.stop)
+		m.done.Wait()
+
+		m.mu.Lock()
+		e := m.current
+		e.retired = true
+		release := m.unused(e)
+		m.mu.Unlock()
+		if release {
+			m.backend.Release(e.cred)
+		}
+	})
+}
+
+// unused reports whether e should be released now, and marks it as released
+// if so.  m.mu must be held.
+func (m *Manager[C]) unused(e *entry[C]) bool {
+	if e.retired && e.refs == 0 && !e.released {
+		e.released = true
+		return true
+	}
+	return false
+}
+
+// replace acquires new credentials and swaps them in for the current ones.
+func (m *Manager[C]) replace() error {
+	now := time.Now()
+	cred, lifetime, err := m.backend.Acquire()
+
+	var old *entry[C]
+	var release bool
+	m.mu.Lock()
+	if err != nil {
+		m.stats.Failures++
+		m.stats.LastError = err
+		m.failures++
+	} else {
+		e := &entry[C]{cred: cred}
+		if lifetime >= 0 {
+			e.expires = now.Add(lifetime)
+		}
+		if old = m.current; old != nil {
+			old.retired = true
+			release = m.unused(old)
+		}
+		m.current = e
+		m.stats.Renewals++
+		m.stats.LastRenewal = now
+		m.stats.LastError = nil
+		m.failures = 0
+	}
+	notify := m.current != nil
+	m.mu.Unlock()
+
+	if release {
+		m.backend.Release(old.cred)
+	}
+	if m.opts.OnEvent != nil && notify {
+		m.opts.OnEvent(Event{Time: now, Err: err, Lifetime: lifetime})
+	}
+	return err
+}
+
+// nextRenewal computes when we should next try to renew the credentials, or
+// returns false if they don't need renewing.
+func (m *Manager[C]) nextRenewal() (time.Duration, bool) {
+	m.mu.Lock()
+	expires := m.current.expires
+	failures := m.failures
+	m.mu.Unlock()
+
+	if failures > 0 {
+		wait := m.opts.RetryInterval
+		for i := 1; i < failures && wait < m.opts.MaxRetryInterval; i++ {
+			wait *= 2
+		}
+		if wait > m.opts.MaxRetryInterval {
+			wait = m.opts.MaxRetryInterval
+		}
+		// Don't back off past the point where the credentials expire.
+		if remaining := time.Until(expires); !expires.IsZero() && remaining > 0 {
+			if soonest := schedule(remaining, 0, m.opts.MinInterval); soonest < wait {
+				wait = soonest
+			}
+		}
+		return wait, true
+	}
+	if expires.IsZero() {
+		return 0, false
+	}
+	return schedule(time.Until(expires), m.opts.RenewBefore, m.opts.MinInterval), true
+}
+
+// schedule picks how long to wait before renewing credentials which will
+// expire after remaining: RenewBefore ahead of expiration, or halfway there if
+// that's later, but never sooner than minInterval, so that credentials whose
+// replacements don't last any longer can't keep us busy.
+func schedule(remaining, renewBefore, minInterval time.Duration) time.Duration {
+	wait := remaining - renewBefore
+	if half := remaining / 2; wait < half {
+		wait = half
+	}
+	if wait < minInterval {
+		wait = minInterval
+	}
+	return wait
+}
+
+func (m *Manager[C]) run() {
+	defer m.done.Done()
+
+	for {
+		var timer *time.Timer
+		var expired <-chan time.Time
+		if wait, ok := m.nextRenewal(); ok {
+			timer = time.NewTimer(wait)
+			expired = timer.C
+		}
+		select {
+		case <-m.stop:
+		case result := <-m.renew:
+			result <- m.replace()
+		case <-expired:
+			m.replace()
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		select {
+		case <-m.stop:
+			return
+		default:
+		}
+	}
+}
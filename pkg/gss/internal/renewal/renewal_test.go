@@ -0,0 +1,128 @@
+package renewal
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeBackend struct {
+	mu       sync.Mutex
+	next     int
+	lifetime time.Duration
+	err      error
+	released []int
+}
+
+func (b *fakeBackend) Acquire() (int, time.Duration, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.err != nil {
+		return 0, 0, b.err
+	}
+	b.next++
+	return b.next, b.lifetime, nil
+}
+
+func (b *fakeBackend) Release(cred int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.released = append(b.released, cred)
+}
+
+func (b *fakeBackend) releasedCreds() []int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]int(nil), b.released...)
+}
+
+func TestSchedule(t *testing.T) {
+	for _, tc := range []struct {
+		remaining, renewBefore, minInterval, want time.Duration
+	}{
+		{10 * time.Hour, 5 * time.Minute, 10 * time.Second, 10*time.Hour - 5*time.Minute},
+		{6 * time.Minute, 5 * time.Minute, 10 * time.Second, 3 * time.Minute},
+		{time.Minute, 5 * time.Minute, 10 * time.Second, 30 * time.Second},
+		{4 * time.Second, 5 * time.Minute, 10 * time.Second, 10 * time.Second},
+		{-time.Minute, 5 * time.Minute, 10 * time.Second, 10 * time.Second},
+	} {
+		if got := schedule(tc.remaining, tc.renewBefore, tc.minInterval); got != tc.want {
+			t.Errorf("schedule(%v, %v, %v) = %v, want %v", tc.remaining, tc.renewBefore, tc.minInterval, got, tc.want)
+		}
+	}
+}
+
+func TestShortLifetimeDoesNotSpin(t *testing.T) {
+	b := &fakeBackend{lifetime: time.Second}
+	m, err := New[int](b, Options{MinInterval: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	time.Sleep(50 * time.Millisecond)
+	if stats := m.Stats(); stats.Renewals != 1 {
+		t.Errorf("got %d renewals, want only the initial one", stats.Renewals)
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	b := &fakeBackend{lifetime: -1}
+	m, err := New[int](b, Options{RetryInterval: time.Minute, MaxRetryInterval: 5 * time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	b.mu.Lock()
+	b.err = errors.New("no KDC")
+	b.mu.Unlock()
+	for i, want := range []time.Duration{time.Minute, 2 * time.Minute, 4 * time.Minute, 5 * time.Minute, 5 * time.Minute} {
+		if err := m.Renew(); err == nil {
+			t.Fatal("renewal succeeded unexpectedly")
+		}
+		if wait, ok := m.nextRenewal(); !ok || wait != want {
+			t.Errorf("after %d failures, waiting %v (%v), want %v", i+1, wait, ok, want)
+		}
+	}
+
+	b.mu.Lock()
+	b.err = nil
+	b.mu.Unlock()
+	if err := m.Renew(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := m.nextRenewal(); ok {
+		t.Error("credentials which don't expire were scheduled for renewal")
+	}
+}
+
+func TestReleaseWhenUnused(t *testing.T) {
+	b := &fakeBackend{lifetime: -1}
+	m, err := New[int](b, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cred, done := m.Get()
+	if err := m.Renew(); err != nil {
+		t.Fatal(err)
+	}
+	if released := b.releasedCreds(); len(released) != 0 {
+		t.Fatalf("released %v while it was in use", released)
+	}
+	done()
+	if released := b.releasedCreds(); len(released) != 1 || released[0] != cred {
+		t.Fatalf("released %v, want [%d]", released, cred)
+	}
+
+	m.Close()
+	m.Close()
+	if released := b.releasedCreds(); len(released) != 2 {
+		t.Fatalf("released %v after closing twice, want two credentials", released)
+	}
+	if err := m.Renew(); err != ErrClosed {
+		t.Errorf("Renew after Close returned %v, want ErrClosed", err)
+	}
+}
@@ -0,0 +1,319 @@
+package gss
+
+/*
+#include <stdlib.h>
+#include <string.h>
+#include "loader.h"
+
+static gss_iov_buffer_desc *alloc_iov(int n)
+{
+	return calloc(n, sizeof(gss_iov_buffer_desc));
+}
+static gss_iov_buffer_desc *nth_iov(gss_iov_buffer_desc *iov, int n)
+{
+	return &iov[n];
+}
+*/
+import "C"
+import "unsafe"
+
+const (
+	// Buffer types for use in an IOV.
+	IOV_BUFFER_TYPE_EMPTY       = C.GSS_IOV_BUFFER_TYPE_EMPTY
+	IOV_BUFFER_TYPE_DATA        = C.GSS_IOV_BUFFER_TYPE_DATA
+	IOV_BUFFER_TYPE_HEADER      = C.GSS_IOV_BUFFER_TYPE_HEADER
+	IOV_BUFFER_TYPE_MECH_PARAMS = C.GSS_IOV_BUFFER_TYPE_MECH_PARAMS
+	IOV_BUFFER_TYPE_TRAILER     = C.GSS_IOV_BUFFER_TYPE_TRAILER
+	IOV_BUFFER_TYPE_PADDING     = C.GSS_IOV_BUFFER_TYPE_PADDING
+	IOV_BUFFER_TYPE_STREAM      = C.GSS_IOV_BUFFER_TYPE_STREAM
+	IOV_BUFFER_TYPE_SIGN_ONLY   = C.GSS_IOV_BUFFER_TYPE_SIGN_ONLY
+	IOV_BUFFER_TYPE_MIC_TOKEN   = C.GSS_IOV_BUFFER_TYPE_MIC_TOKEN
+
+	// Flags which can be combined with a buffer type.
+	IOV_BUFFER_FLAG_MASK      = C.GSS_IOV_BUFFER_FLAG_MASK
+	IOV_BUFFER_FLAG_ALLOCATE  = C.GSS_IOV_BUFFER_FLAG_ALLOCATE
+	IOV_BUFFER_FLAG_ALLOCATED = C.GSS_IOV_BUFFER_FLAG_ALLOCATED
+)
+
+/* IOV is an array of typed buffers for use with WrapIOV() and related functions.  The buffers are kept in C memory so that they can be protected in place without being copied across cgo, and an IOV can be reused for any number of messages.  It should be freed using Free() when it's no longer needed. */
+type IOV struct {
+	descs *C.gss_iov_buffer_desc
+	n     int
+	/* Storage which we allocated for each buffer, and its size. */
+	own  []unsafe.Pointer
+	caps []int
+}
+
+/* NewIOV() creates an IOV containing empty buffers of the specified types. */
+func NewIOV(types ...uint32) *IOV {
+	iov := &IOV{n: len(types), own: make([]unsafe.Pointer, len(types)), caps: make([]int, len(types))}
+	if len(types) > 0 {
+		iov.descs = C.alloc_iov(C.int(len(types)))
+	}
+	for i, t := range types {
+		iov.desc(i)._type = C.OM_uint32(t)
+	}
+	return iov
+}
+
+func (iov *IOV) desc(i int) *C.gss_iov_buffer_desc {
+	return C.nth_iov(iov.descs, C.int(i))
+}
+
+/* Len() returns the number of buffers in the IOV. */
+func (iov *IOV) Len() int {
+	return iov.n
+}
+
+/* Type() returns the type of the i'th buffer, without any flags. */
+func (iov *IOV) Type(i int) uint32 {
+	return uint32(iov.desc(i)._type) &^ IOV_BUFFER_FLAG_MASK
+}
+
+/* SetType() changes the type of the i'th buffer, which may include IOV_BUFFER_FLAG_ALLOCATE. */
+func (iov *IOV) SetType(i int, t uint32) {
+	iov.desc(i)._type = C.OM_uint32(t)
+}
+
+/* Buffer() returns the contents of the i'th buffer.  The returned slice refers directly to C memory, and is only valid until the next call to Resize(), SetBytes() or Free(), or the next call which lets the library allocate the buffer. */
+func (iov *IOV) Buffer(i int) []byte {
+	d := iov.desc(i)
+	if d.buffer.value == nil || d.buffer.length == 0 {
+		return nil
+	}
+	return unsafe.Slice((*byte)(d.buffer.value), int(d.buffer.length))
+}
+
+/* Resize() sets the length of the i'th buffer, growing its storage if need be, and returns it as Buffer() would.  The contents are preserved up to the smaller of the old and new lengths.  A buffer which the library allocated, or which UnwrapIOV() pointed into a STREAM buffer, is first copied into storage of our own. */
+func (iov *IOV) Resize(i, length int) []byte {
+	d := iov.desc(i)
+	if d.buffer.value != nil && d.buffer.value != iov.own[i] {
+		old := iov.Buffer(i)
+		iov.grow(i, length)
+		copy(unsafe.Slice((*byte)(iov.own[i]), length), old)
+		iov.releaseAllocated(i)
+	} else {
+		iov.grow(i, length)
+	}
+	d.buffer.value = iov.own[i]
+	d.buffer.length = C.size_t(length)
+	return iov.Buffer(i)
+}
+
+/* grow makes sure that our storage for the i'th buffer can hold length bytes, keeping what it already holds. */
+func (iov *IOV) grow(i, length int) {
+	if length > iov.caps[i] {
+		p := C.realloc(iov.own[i], C.size_t(length))
+		if p == nil {
+			panic("out of memory")
+		}
+		iov.own[i] = p
+		iov.caps[i] = length
+	}
+}
+
+/* SetBytes() replaces the contents of the i'th buffer with a copy of data. */
+func (iov *IOV) SetBytes(i int, data []byte) {
+	buf := iov.Resize(i, len(data))
+	copy(buf, data)
+}
+
+/* Bytes() returns a copy of the contents of the i'th buffer. */
+func (iov *IOV) Bytes(i int) []byte {
+	return append([]byte(nil), iov.Buffer(i)...)
+}
+
+/* allocate makes sure that our storage can hold each buffer's length, after the library has told us how long they need to be. */
+func (iov *IOV) allocate() {
+	for i := 0; i < iov.n; i++ {
+		d := iov.desc(i)
+		if d.buffer.value == nil || d.buffer.value == iov.own[i] {
+			iov.Resize(i, int(d.buffer.length))
+		}
+	}
+}
+
+/* releaseAllocated frees the i'th buffer if the library allocated it for us. */
+func (iov *IOV) releaseAllocated(i int) {
+	var minor C.OM_uint32
+	d := iov.desc(i)
+	if d._type&IOV_BUFFER_FLAG_ALLOCATED != 0 {
+		C.gss_release_iov_buffer(&minor, d, 1)
+		d._type &^= IOV_BUFFER_FLAG_ALLOCATED
+		d.buffer.value = nil
+		d.buffer.length = 0
+	}
+}
+
+/* Free() releases all of the IOV's buffers. */
+func (iov *IOV) Free() {
+	for i := 0; i < iov.n; i++ {
+		iov.releaseAllocated(i)
+		C.free(iov.own[i])
+		iov.own[i] = nil
+		iov.caps[i] = 0
+	}
+	C.free(unsafe.Pointer(iov.descs))
+	iov.descs = nil
+	iov.n = 0
+}
+
+/* WrapIOV() protects the DATA buffers in iov in place, using the HEADER, TRAILER and PADDING buffers for the rest of the token, and including SIGN_ONLY buffers in the integrity check.  Call WrapIOVLength() first to size the HEADER, TRAILER and PADDING buffers, or mark them with IOV_BUFFER_FLAG_ALLOCATE. */
+func WrapIOV(contextHandle ContextHandle, confReq bool, qopReq uint32, iov *IOV) (majorStatus, minorStatus uint32, confState bool) {
+	handle := C.gss_ctx_id_t(contextHandle)
+	qop := C.gss_qop_t(qopReq)
+	var major, minor C.OM_uint32
+	var conf C.int
+
+	if confReq {
+		conf = 1
+	}
+	major = C.gss_wrap_iov(&minor, handle, conf, qop, &conf, iov.descs, C.int(iov.n))
+
+	majorStatus = uint32(major)
+	minorStatus = uint32(minor)
+	confState = (conf != 0)
+	return
+}
+
+/* WrapIOVLength() computes the lengths of the HEADER, TRAILER and PADDING buffers which WrapIOV() will need for the DATA buffers in iov at their current lengths, and resizes those buffers to match. */
+func WrapIOVLength(contextHandle ContextHandle, confReq bool, qopReq uint32, iov *IOV) (majorStatus, minorStatus uint32, confState bool) {
+	handle := C.gss_ctx_id_t(contextHandle)
+	qop := C.gss_qop_t(qopReq)
+	var major, minor C.OM_uint32
+	var conf C.int
+
+	if confReq {
+		conf = 1
+	}
+	major = C.gss_wrap_iov_length(&minor, handle, conf, qop, &conf, iov.descs, C.int(iov.n))
+
+	majorStatus = uint32(major)
+	minorStatus = uint32(minor)
+	confState = (conf != 0)
+	if major == C.GSS_S_COMPLETE {
+		iov.allocate()
+	}
+	return
+}
+
+/* UnwrapIOV() verifies protection on the buffers in iov, decrypting DATA buffers in place.  A token received as a single message can be placed in a STREAM buffer, followed by a DATA buffer which will be pointed at the plaintext within it. */
+func UnwrapIOV(contextHandle ContextHandle, iov *IOV) (majorStatus, minorStatus uint32, confState bool, qopState uint32) {
+	handle := C.gss_ctx_id_t(contextHandle)
+	var major, minor C.OM_uint32
+	var conf C.int
+	var qop C.gss_qop_t
+
+	major = C.gss_unwrap_iov(&minor, handle, &conf, &qop, iov.descs, C.int(iov.n))
+
+	majorStatus = uint32(major)
+	minorStatus = uint32(minor)
+	confState = (conf != 0)
+	qopState = uint32(qop)
+	return
+}
+
+/* GetMICIOV() computes a checksum over the DATA and SIGN_ONLY buffers in iov, storing it in the MIC_TOKEN buffer.  Call GetMICIOVLength() first to size the MIC_TOKEN buffer, or mark it with IOV_BUFFER_FLAG_ALLOCATE. */
+func GetMICIOV(contextHandle ContextHandle, qopReq uint32, iov *IOV) (majorStatus, minorStatus uint32) {
+	handle := C.gss_ctx_id_t(contextHandle)
+	qop := C.gss_qop_t(qopReq)
+	var major, minor C.OM_uint32
+
+	major = C.gss_get_mic_iov(&minor, handle, qop, iov.descs, C.int(iov.n))
+
+	majorStatus = uint32(major)
+	minorStatus = uint32(minor)
+	return
+}
+
+/* GetMICIOVLength() computes the length of the MIC_TOKEN buffer which GetMICIOV() will need, and resizes that buffer to match. */
+func GetMICIOVLength(contextHandle ContextHandle, qopReq uint32, iov *IOV) (majorStatus, minorStatus uint32) {
+	handle := C.gss_ctx_id_t(contextHandle)
+	qop := C.gss_qop_t(qopReq)
+	var major, minor C.OM_uint32
+
+	major = C.gss_get_mic_iov_length(&minor, handle, qop, iov.descs, C.int(iov.n))
+
+	majorStatus = uint32(major)
+	minorStatus = uint32(minor)
+	if major == C.GSS_S_COMPLETE {
+		iov.allocate()
+	}
+	return
+}
+
+/* VerifyMICIOV() checks the checksum in the MIC_TOKEN buffer in iov against the DATA and SIGN_ONLY buffers. */
+func VerifyMICIOV(contextHandle ContextHandle, iov *IOV) (majorStatus, minorStatus, qopState uint32) {
+	handle := C.gss_ctx_id_t(contextHandle)
+	var major, minor C.OM_uint32
+	var qop C.gss_qop_t
+
+	major = C.gss_verify_mic_iov(&minor, handle, &qop, iov.descs, C.int(iov.n))
+
+	majorStatus = uint32(major)
+	minorStatus = uint32(minor)
+	qopState = uint32(qop)
+	return
+}
+
+/* WrapAEAD() produces a token containing inputMessage which also protects the integrity of assocData, which is not included in the token and must be supplied separately to UnwrapAEAD(). */
+func WrapAEAD(contextHandle ContextHandle, confReq bool, qopReq uint32, assocData, inputMessage []byte) (majorStatus, minorStatus uint32, confState bool, outputMessage []byte) {
+	handle := C.gss_ctx_id_t(contextHandle)
+	qop := C.gss_qop_t(qopReq)
+	var major, minor C.OM_uint32
+	var assoc, msg, wrapped C.gss_buffer_desc
+	var passoc C.gss_buffer_t
+	var conf C.int
+
+	if confReq {
+		conf = 1
+	}
+	if assocData != nil {
+		assoc = bytesToBuffer(assocData)
+		defer C.free(assoc.value)
+		passoc = &assoc
+	}
+	msg = bytesToBuffer(inputMessage)
+	defer C.free(msg.value)
+
+	major = C.gss_wrap_aead(&minor, handle, conf, qop, passoc, &msg, &conf, &wrapped)
+
+	majorStatus = uint32(major)
+	minorStatus = uint32(minor)
+	confState = (conf != 0)
+	if wrapped.length > 0 {
+		outputMessage = bufferToBytes(wrapped)
+		major = C.gss_release_buffer(&minor, &wrapped)
+	}
+	return
+}
+
+/* UnwrapAEAD() accepts a token produced by WrapAEAD() and returns the plaintext, after checking the integrity of both it and assocData. */
+func UnwrapAEAD(contextHandle ContextHandle, inputMessage, assocData []byte) (majorStatus, minorStatus uint32, confState bool, qopState uint32, outputMessage []byte) {
+	handle := C.gss_ctx_id_t(contextHandle)
+	var major, minor C.OM_uint32
+	var assoc, wrapped, msg C.gss_buffer_desc
+	var passoc C.gss_buffer_t
+	var conf C.int
+	var qop C.gss_qop_t
+
+	if assocData != nil {
+		assoc = bytesToBuffer(assocData)
+		defer C.free(assoc.value)
+		passoc = &assoc
+	}
+	wrapped = bytesToBuffer(inputMessage)
+	defer C.free(wrapped.value)
+
+	major = C.gss_unwrap_aead(&minor, handle, &wrapped, passoc, &msg, &conf, &qop)
+
+	majorStatus = uint32(major)
+	minorStatus = uint32(minor)
+	confState = (conf != 0)
+	qopState = uint32(qop)
+	if msg.length > 0 {
+		outputMessage = bufferToBytes(msg)
+		major = C.gss_release_buffer(&minor, &msg)
+	}
+	return
+}
@@ -0,0 +1,95 @@
+package gss_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/twistlock/gss/pkg/gss"
+)
+
+func TestIOVRoundTrip(t *testing.T) {
+	initiator, acceptor := krb5Contexts(t)
+	message := []byte("protected in place")
+
+	iov := gss.NewIOV(gss.IOV_BUFFER_TYPE_HEADER, gss.IOV_BUFFER_TYPE_DATA, gss.IOV_BUFFER_TYPE_PADDING, gss.IOV_BUFFER_TYPE_TRAILER)
+	defer iov.Free()
+	iov.SetBytes(1, message)
+	if major, minor, _ := gss.WrapIOVLength(initiator, true, 0, iov); major != gss.S_COMPLETE {
+		t.Fatal(gss.NewGSSError("sizing IOV", major, minor, nil))
+	}
+	if major, minor, _ := gss.WrapIOV(initiator, true, 0, iov); major != gss.S_COMPLETE {
+		t.Fatal(gss.NewGSSError("wrapping IOV", major, minor, nil))
+	}
+	var token []byte
+	for i := 0; i < iov.Len(); i++ {
+		token = append(token, iov.Buffer(i)...)
+	}
+
+	in := gss.NewIOV(gss.IOV_BUFFER_TYPE_STREAM, gss.IOV_BUFFER_TYPE_DATA)
+	defer in.Free()
+	in.SetBytes(0, token)
+	major, minor, conf, _ := gss.UnwrapIOV(acceptor, in)
+	if major != gss.S_COMPLETE {
+		t.Fatal(gss.NewGSSError("unwrapping IOV", major, minor, nil))
+	}
+	if !conf || !bytes.Equal(in.Buffer(1), message) {
+		t.Fatalf("unwrapped %q (conf %v), want %q", in.Buffer(1), conf, message)
+	}
+
+	// The DATA buffer points into the STREAM buffer; resizing it has to
+	// keep the plaintext.
+	if got := in.Resize(1, len(message)+8)[:len(message)]; !bytes.Equal(got, message) {
+		t.Errorf("after Resize, buffer holds %q, want %q", got, message)
+	}
+	in.SetBytes(0, nil)
+	if got := in.Buffer(1)[:len(message)]; !bytes.Equal(got, message) {
+		t.Errorf("after replacing the STREAM buffer, DATA holds %q, want %q", got, message)
+	}
+}
+
+const benchmarkMessageSize = 16 * 1024
+
+func BenchmarkWrap(b *testing.B) {
+	initiator, _ := krb5Contexts(b)
+	message := make([]byte, benchmarkMessageSize)
+	b.SetBytes(benchmarkMessageSize)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if major, minor, _, _ := gss.Wrap(initiator, true, 0, message); major != gss.S_COMPLETE {
+			b.Fatal(gss.NewGSSError("wrapping", major, minor, nil))
+		}
+	}
+}
+
+func BenchmarkWrapIOV(b *testing.B) {
+	initiator, _ := krb5Contexts(b)
+	iov := gss.NewIOV(gss.IOV_BUFFER_TYPE_HEADER, gss.IOV_BUFFER_TYPE_DATA, gss.IOV_BUFFER_TYPE_PADDING, gss.IOV_BUFFER_TYPE_TRAILER)
+	defer iov.Free()
+	iov.Resize(1, benchmarkMessageSize)
+	if major, minor, _ := gss.WrapIOVLength(initiator, true, 0, iov); major != gss.S_COMPLETE {
+		b.Fatal(gss.NewGSSError("sizing IOV", major, minor, nil))
+	}
+	b.SetBytes(benchmarkMessageSize)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if major, minor, _ := gss.WrapIOV(initiator, true, 0, iov); major != gss.S_COMPLETE {
+			b.Fatal(gss.NewGSSError("wrapping IOV", major, minor, nil))
+		}
+	}
+}
+
+func BenchmarkWrapAEAD(b *testing.B) {
+	initiator, _ := krb5Contexts(b)
+	message := make([]byte, benchmarkMessageSize)
+	assoc := []byte("header")
+	b.SetBytes(benchmarkMessageSize)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if major, minor, _, _ := gss.WrapAEAD(initiator, true, 0, assoc, message); major != gss.S_COMPLETE {
+			b.Fatal(gss.NewGSSError("wrapping AEAD", major, minor, nil))
+		}
+	}
+}
@@ -0,0 +1,330 @@
+package gss
+
+/*
+#include <stdlib.h>
+#include <string.h>
+#include "loader.h"
+
+static OM_uint32
+import_krb5_cred(OM_uint32 *minor, const char *ccname, const char *princname, const char *ktname, gss_cred_id_t *cred)
+{
+	krb5_context ctx;
+	krb5_ccache cc = NULL;
+	krb5_principal princ = NULL;
+	krb5_keytab kt = NULL;
+	krb5_error_code ret;
+	OM_uint32 major = GSS_S_FAILURE;
+
+	ret = krb5_init_context(&ctx);
+	if (ret) {
+		*minor = ret;
+		return GSS_S_FAILURE;
+	}
+	if (ccname != NULL) {
+		ret = krb5_cc_resolve(ctx, ccname, &cc);
+	}
+	if (ret == 0 && princname != NULL) {
+		ret = krb5_parse_name(ctx, princname, &princ);
+	}
+	if (ret == 0 && ktname != NULL) {
+		ret = krb5_kt_resolve(ctx, ktname, &kt);
+	}
+	if (ret == 0) {
+		major = gss_krb5_import_cred(minor, cc, princ, kt, cred);
+	} else {
+		*minor = ret;
+	}
+	if (kt != NULL) {
+		krb5_kt_close(ctx, kt);
+	}
+	if (princ != NULL) {
+		krb5_free_principal(ctx, princ);
+	}
+	if (cc != NULL) {
+		krb5_cc_close(ctx, cc);
+	}
+	krb5_free_context(ctx);
+	return major;
+}
+
+static OM_uint32
+set_krb5_cred_rcache(OM_uint32 *minor, gss_cred_id_t cred, const char *name)
+{
+	krb5_context ctx;
+	krb5_rcache rc = NULL;
+	krb5_data piece;
+	// Heimdal's krb5_data has no magic field, and a size_t length.
+	struct {
+		size_t length;
+		void *data;
+	} heimdal_piece;
+	const krb5_data *p = &piece;
+	krb5_error_code ret;
+	OM_uint32 major;
+
+	// Check first, so that we don't create a replay cache that nothing owns.
+	if (!gssdl_has("gss_krb5_set_cred_rcache")) {
+		return GSS_S_UNAVAILABLE;
+	}
+	ret = krb5_init_context(&ctx);
+	if (ret) {
+		*minor = ret;
+		return GSS_S_FAILURE;
+	}
+	piece.magic = 0;
+	piece.length = strlen(name);
+	piece.data = (char *) name;
+	if (gssdl_heimdal()) {
+		heimdal_piece.length = strlen(name);
+		heimdal_piece.data = (void *) name;
+		p = (const krb5_data *) &heimdal_piece;
+	}
+	ret = krb5_get_server_rcache(ctx, p, &rc);
+	if (ret) {
+		*minor = ret;
+		krb5_free_context(ctx);
+		return GSS_S_FAILURE;
+	}
+	// On success, the credential takes ownership of the replay cache.
+	major = gss_krb5_set_cred_rcache(minor, cred, rc);
+	krb5_free_context(ctx);
+	return major;
+}
+
+static OM_uint32
+destroy_krb5_ccache(OM_uint32 *minor, const char *name)
+{
+	krb5_context ctx;
+	krb5_ccache cc;
+	krb5_error_code ret;
+
+	ret = krb5_init_context(&ctx);
+	if (ret == 0) {
+		ret = krb5_cc_resolve(ctx, name, &cc);
+		if (ret == 0) {
+			ret = krb5_cc_destroy(ctx, cc);
+		}
+		krb5_free_context(ctx);
+	}
+	*minor = ret;
+	return ret ? GSS_S_FAILURE : GSS_S_COMPLETE;
+}
+
+static gss_krb5_lucid_context_v1_t *
+lucid_v1(void *lucid)
+{
+	return (gss_krb5_lucid_context_v1_t *) lucid;
+}
+*/
+import "C"
+import "unsafe"
+
+const (
+	// Encryption types which can be passed to Krb5SetAllowableEnctypes().
+	ENCTYPE_DES3_CBC_SHA1              = C.ENCTYPE_DES3_CBC_SHA1
+	ENCTYPE_AES128_CTS_HMAC_SHA1_96    = C.ENCTYPE_AES128_CTS_HMAC_SHA1_96
+	ENCTYPE_AES256_CTS_HMAC_SHA1_96    = C.ENCTYPE_AES256_CTS_HMAC_SHA1_96
+	ENCTYPE_AES128_CTS_HMAC_SHA256_128 = C.ENCTYPE_AES128_CTS_HMAC_SHA256_128
+	ENCTYPE_AES256_CTS_HMAC_SHA384_192 = C.ENCTYPE_AES256_CTS_HMAC_SHA384_192
+	ENCTYPE_ARCFOUR_HMAC               = C.ENCTYPE_ARCFOUR_HMAC
+	ENCTYPE_CAMELLIA128_CTS_CMAC       = C.ENCTYPE_CAMELLIA128_CTS_CMAC
+	ENCTYPE_CAMELLIA256_CTS_CMAC       = C.ENCTYPE_CAMELLIA256_CTS_CMAC
+
+	// Ticket flags returned by Krb5GetTktFlags().
+	TKT_FLG_FORWARDABLE            = C.TKT_FLG_FORWARDABLE
+	TKT_FLG_FORWARDED              = C.TKT_FLG_FORWARDED
+	TKT_FLG_PROXIABLE              = C.TKT_FLG_PROXIABLE
+	TKT_FLG_PROXY                  = C.TKT_FLG_PROXY
+	TKT_FLG_MAY_POSTDATE           = C.TKT_FLG_MAY_POSTDATE
+	TKT_FLG_POSTDATED              = C.TKT_FLG_POSTDATED
+	TKT_FLG_INVALID                = C.TKT_FLG_INVALID
+	TKT_FLG_RENEWABLE              = C.TKT_FLG_RENEWABLE
+	TKT_FLG_INITIAL                = C.TKT_FLG_INITIAL
+	TKT_FLG_PRE_AUTH               = C.TKT_FLG_PRE_AUTH
+	TKT_FLG_HW_AUTH                = C.TKT_FLG_HW_AUTH
+	TKT_FLG_TRANSIT_POLICY_CHECKED = C.TKT_FLG_TRANSIT_POLICY_CHECKED
+	TKT_FLG_OK_AS_DELEGATE         = C.TKT_FLG_OK_AS_DELEGATE
+	TKT_FLG_ENC_PA_REP             = C.TKT_FLG_ENC_PA_REP
+	TKT_FLG_ANONYMOUS              = C.TKT_FLG_ANONYMOUS
+
+	// Values of Krb5LucidContext.Protocol.
+	KRB5_LUCID_PROTOCOL_RFC1964 = 0
+	KRB5_LUCID_PROTOCOL_CFX     = 1
+)
+
+/* Krb5LucidKey is a key taken from a lucid security context. */
+type Krb5LucidKey struct {
+	Type uint32
+	Data []byte
+}
+
+/* Krb5LucidContext holds the state of a Kerberos 5 security context, as exported by Krb5ExportLucidSecContext().  Only one of RFC1964 and CFX is meaningful, depending on the value of Protocol. */
+type Krb5LucidContext struct {
+	Version  uint32
+	Initiate bool
+	// Endtime is the context's expiration time, in seconds since the epoch.
+	Endtime          uint32
+	SendSeq, RecvSeq uint64
+	Protocol         uint32
+	RFC1964          struct {
+		SignAlg, SealAlg uint32
+		CtxKey           Krb5LucidKey
+	}
+	CFX struct {
+		HaveAcceptorSubkey     bool
+		CtxKey, AcceptorSubkey Krb5LucidKey
+	}
+}
+
+func lucidKeyToKey(key C.gss_krb5_lucid_key_t) Krb5LucidKey {
+	return Krb5LucidKey{Type: uint32(key._type), Data: C.GoBytes(key.data, C.int(key.length))}
+}
+
+/* Krb5ExportLucidSecContext() exports the keys and sequence numbers of an established Kerberos 5 security context, for use by an implementation of the mechanism elsewhere, such as in the kernel.  Only version 1 is currently defined.  Upon success, contextHandle will have become invalid. */
+func Krb5ExportLucidSecContext(contextHandle *ContextHandle, version uint32) (majorStatus, minorStatus uint32, lucid *Krb5LucidContext) {
+	handle := C.gss_ctx_id_t(*contextHandle)
+	var major, minor C.OM_uint32
+	var raw unsafe.Pointer
+
+	major = C.gss_krb5_export_lucid_sec_context(&minor, &handle, C.OM_uint32(version), &raw)
+
+	*contextHandle = ContextHandle(handle)
+	majorStatus = uint32(major)
+	minorStatus = uint32(minor)
+	if major != C.GSS_S_COMPLETE || raw == nil {
+		return
+	}
+	defer C.gss_krb5_free_lucid_sec_context(&minor, raw)
+
+	v1 := C.lucid_v1(raw)
+	lucid = &Krb5LucidContext{
+		Version:  uint32(v1.version),
+		Initiate: v1.initiate != 0,
+		Endtime:  uint32(v1.endtime),
+		SendSeq:  uint64(v1.send_seq),
+		RecvSeq:  uint64(v1.recv_seq),
+		Protocol: uint32(v1.protocol),
+	}
+	switch lucid.Protocol {
+	case KRB5_LUCID_PROTOCOL_RFC1964:
+		lucid.RFC1964.SignAlg = uint32(v1.rfc1964_kd.sign_alg)
+		lucid.RFC1964.SealAlg = uint32(v1.rfc1964_kd.seal_alg)
+		lucid.RFC1964.CtxKey = lucidKeyToKey(v1.rfc1964_kd.ctx_key)
+	case KRB5_LUCID_PROTOCOL_CFX:
+		lucid.CFX.HaveAcceptorSubkey = v1.cfx_kd.have_acceptor_subkey != 0
+		lucid.CFX.CtxKey = lucidKeyToKey(v1.cfx_kd.ctx_key)
+		if lucid.CFX.HaveAcceptorSubkey {
+			lucid.CFX.AcceptorSubkey = lucidKeyToKey(v1.cfx_kd.acceptor_subkey)
+		}
+	}
+	return
+}
+
+/* Krb5CcacheName() sets the name of the credential cache which the Kerberos 5 mechanism will use for the calling thread, and returns the name which it was using before.  Since the setting is per-thread, callers should use runtime.LockOSThread() for as long as it needs to be in effect. */
+func Krb5CcacheName(name string) (majorStatus, minorStatus uint32, oldName string) {
+	var major, minor C.OM_uint32
+	var old *C.char
+
+	cname := C.CString(name)
+	defer C.free(unsafe.Pointer(cname))
+
+	major = C.gss_krb5_ccache_name(&minor, cname, &old)
+
+	majorStatus = uint32(major)
+	minorStatus = uint32(minor)
+	if old != nil {
+		oldName = C.GoString(old)
+	}
+	return
+}
+
+/* Krb5SetAllowableEnctypes() limits the encryption types which will be used with credHandle to the ones listed, which should be specified in order of preference. */
+func Krb5SetAllowableEnctypes(credHandle CredHandle, enctypes []int32) (majorStatus, minorStatus uint32) {
+	handle := C.gss_cred_id_t(credHandle)
+	var major, minor C.OM_uint32
+	var ktypes *C.krb5_enctype
+
+	list := make([]C.krb5_enctype, len(enctypes))
+	for i, enctype := range enctypes {
+		list[i] = C.krb5_enctype(enctype)
+	}
+	if len(list) > 0 {
+		ktypes = &list[0]
+	}
+
+	major = C.gss_krb5_set_allowable_enctypes(&minor, handle, C.OM_uint32(len(list)), ktypes)
+
+	majorStatus = uint32(major)
+	minorStatus = uint32(minor)
+	return
+}
+
+/* Krb5ImportCred() creates a credential handle from a Kerberos 5 credential cache, principal name and keytab, any of which may be empty.  The named objects are resolved using a private library context.  The returned credHandle should eventually be freed using gss.ReleaseCred(). */
+func Krb5ImportCred(ccacheName, principal, keytabName string) (majorStatus, minorStatus uint32, credHandle CredHandle) {
+	var major, minor C.OM_uint32
+	var ccname, princname, ktname *C.char
+	var handle C.gss_cred_id_t
+
+	if ccacheName != "" {
+		ccname = C.CString(ccacheName)
+		defer C.free(unsafe.Pointer(ccname))
+	}
+	if principal != "" {
+		princname = C.CString(principal)
+		defer C.free(unsafe.Pointer(princname))
+	}
+	if keytabName != "" {
+		ktname = C.CString(keytabName)
+		defer C.free(unsafe.Pointer(ktname))
+	}
+
+	major = C.import_krb5_cred(&minor, ccname, princname, ktname, &handle)
+
+	majorStatus = uint32(major)
+	minorStatus = uint32(minor)
+	credHandle = CredHandle(handle)
+	return
+}
+
+/* Krb5GetTktFlags() returns the flags (TKT_FLG_...) of the ticket which was used to establish an acceptor's security context. */
+func Krb5GetTktFlags(contextHandle ContextHandle) (majorStatus, minorStatus, ticketFlags uint32) {
+	handle := C.gss_ctx_id_t(contextHandle)
+	var major, minor C.OM_uint32
+	var flags C.krb5_flags
+
+	major = C.gss_krb5_get_tkt_flags(&minor, handle, &flags)
+
+	majorStatus = uint32(major)
+	minorStatus = uint32(minor)
+	ticketFlags = uint32(flags)
+	return
+}
+
+/* Krb5SetCredRcache() makes an acceptor credential use the default type of replay cache, opened for the named service. */
+func Krb5SetCredRcache(credHandle CredHandle, name string) (majorStatus, minorStatus uint32) {
+	handle := C.gss_cred_id_t(credHandle)
+	var major, minor C.OM_uint32
+
+	cname := C.CString(name)
+	defer C.free(unsafe.Pointer(cname))
+
+	major = C.set_krb5_cred_rcache(&minor, handle, cname)
+
+	majorStatus = uint32(major)
+	minorStatus = uint32(minor)
+	return
+}
+
+/* Krb5DestroyCcache() destroys the named Kerberos credential cache.  On failure, minorStatus is a Kerberos error code. */
+func Krb5DestroyCcache(name string) (majorStatus, minorStatus uint32) {
+	var minor C.OM_uint32
+
+	cname := C.CString(name)
+	defer C.free(unsafe.Pointer(cname))
+
+	major := C.destroy_krb5_ccache(&minor, cname)
+
+	majorStatus = uint32(major)
+	minorStatus = uint32(minor)
+	return
+}
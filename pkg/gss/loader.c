@@ -0,0 +1,286 @@
+// Runtime loading of the GSSAPI library, see loader.h.
+
+#include <dlfcn.h>
+#include <errno.h>
+#include <pthread.h>
+#include <stdio.h>
+#include <stdlib.h>
+#include <string.h>
+
+#define GSSDL_NO_RENAME
+#include "loader.h"
+
+// Libraries tried, in order, when none has been chosen by calling
+// gssdl_load() and GSSAPI_LIBRARY isn't set in the environment.  The first is
+// MIT krb5's, the others are Heimdal's.
+static const char *gssdl_default_paths[] = {
+	"libgssapi_krb5.so.2",
+	"libgssapi.so.3",
+	"libgssapi.so.2",
+	NULL,
+};
+
+static pthread_mutex_t gssdl_lock = PTHREAD_MUTEX_INITIALIZER;
+static void *gssdl_handle;
+static int gssdl_tried;
+static char gssdl_loaded_path[1024];
+static char gssdl_errbuf[1024];
+
+// Must be called with gssdl_lock held.
+static int
+gssdl_open(const char *path)
+{
+	const char *err;
+
+	dlerror();
+	gssdl_handle = dlopen(path, RTLD_NOW | RTLD_LOCAL);
+	if (gssdl_handle == NULL) {
+		err = dlerror();
+		snprintf(gssdl_errbuf, sizeof(gssdl_errbuf), "%s", err ? err : path);
+		return -1;
+	}
+	snprintf(gssdl_loaded_path, sizeof(gssdl_loaded_path), "%s", path);
+	gssdl_errbuf[0] = '\0';
+	return 0;
+}
+
+// Must be called with gssdl_lock held.
+static void
+gssdl_open_default(void)
+{
+	const char *env;
+	int i;
+
+	if (gssdl_handle != NULL || gssdl_tried) {
+		return;
+	}
+	gssdl_tried = 1;
+	env = getenv("GSSAPI_LIBRARY");
+	if (env != NULL && env[0] != '\0') {
+		gssdl_open(env);
+		return;
+	}
+	for (i = 0; gssdl_default_paths[i] != NULL; i++) {
+		if (gssdl_open(gssdl_default_paths[i]) == 0) {
+			return;
+		}
+	}
+}
+
+// Opens the library at path, or the default one if path is NULL.  Returns 0
+// on success, 1 if a library was already loaded, or -1 if it can't be opened.
+int
+gssdl_load(const char *path)
+{
+	int ret;
+
+	pthread_mutex_lock(&gssdl_lock);
+	if (gssdl_handle != NULL) {
+		ret = 1;
+	} else if (path == NULL) {
+		gssdl_tried = 0;
+		gssdl_open_default();
+		ret = gssdl_handle != NULL ? 0 : -1;
+	} else {
+		gssdl_tried = 1;
+		ret = gssdl_open(path);
+	}
+	pthread_mutex_unlock(&gssdl_lock);
+	return ret;
+}
+
+int
+gssdl_loaded(void)
+{
+	int ret;
+
+	pthread_mutex_lock(&gssdl_lock);
+	gssdl_open_default();
+	ret = gssdl_handle != NULL;
+	pthread_mutex_unlock(&gssdl_lock);
+	return ret;
+}
+
+const char *
+gssdl_path(void)
+{
+	return gssdl_loaded_path;
+}
+
+const char *
+gssdl_error(void)
+{
+	return gssdl_errbuf;
+}
+
+// Reports whether the library provides the named symbol.
+int
+gssdl_has(const char *name)
+{
+	void *handle;
+
+	pthread_mutex_lock(&gssdl_lock);
+	gssdl_open_default();
+	handle = gssdl_handle;
+	pthread_mutex_unlock(&gssdl_lock);
+	return handle != NULL && dlsym(handle, name) != NULL;
+}
+
+// Reports whether the library is Heimdal's.  Its mechanism option interfaces
+// aren't found anywhere else.
+int
+gssdl_heimdal(void)
+{
+	return gssdl_has("gss_mo_name");
+}
+
+// Returns the address of the named function, caching it in *slot.  The
+// library's own dependencies are searched too, which is where the krb5_*
+// functions are found.
+static void *
+gssdl_sym(void **slot, const char *name)
+{
+	void *fn, *handle;
+
+	fn = __atomic_load_n(slot, __ATOMIC_ACQUIRE);
+	if (fn != NULL) {
+		return fn;
+	}
+	pthread_mutex_lock(&gssdl_lock);
+	gssdl_open_default();
+	handle = gssdl_handle;
+	pthread_mutex_unlock(&gssdl_lock);
+	if (handle == NULL) {
+		return NULL;
+	}
+	fn = dlsym(handle, name);
+	if (fn != NULL) {
+		__atomic_store_n(slot, fn, __ATOMIC_RELEASE);
+	}
+	return fn;
+}
+
+#define GSSDL_TRAMPOLINE(ret, name, params, args, fail) \
+	ret gssdl_##name params \
+	{ \
+		static void *slot; \
+		ret (*fn) params; \
+		*(void **) &fn = gssdl_sym(&slot, #name); \
+		if (fn == NULL) { \
+			fail; \
+		} \
+		return fn args; \
+	}
+
+#define GSSDL_FUNC(name, params, args) \
+	GSSDL_TRAMPOLINE(OM_uint32, name, params, args, return GSS_S_UNAVAILABLE)
+#define GSSDL_INT_FUNC(name, params, args) \
+	GSSDL_TRAMPOLINE(int, name, params, args, return 0)
+#define GSSDL_KRB5_FUNC(name, params, args) \
+	GSSDL_TRAMPOLINE(krb5_error_code, name, params, args, return ENOSYS)
+#define GSSDL_VOID_FUNC(name, params, args) \
+	void gssdl_##name params \
+	{ \
+		static void *slot; \
+		void (*fn) params; \
+		*(void **) &fn = gssdl_sym(&slot, #name); \
+		if (fn != NULL) { \
+			fn args; \
+		} \
+	}
+
+GSSDL_FUNC(gss_accept_sec_context, (OM_uint32 *a0, gss_ctx_id_t *a1, gss_cred_id_t a2, gss_buffer_t a3, gss_channel_bindings_t a4, gss_name_t *a5, gss_OID *a6, gss_buffer_t a7, OM_uint32 *a8, OM_uint32 *a9, gss_cred_id_t *a10), (a0, a1, a2, a3, a4, a5, a6, a7, a8, a9, a10))
+GSSDL_FUNC(gss_acquire_cred, (OM_uint32 *a0, gss_name_t a1, OM_uint32 a2, gss_OID_set a3, gss_cred_usage_t a4, gss_cred_id_t *a5, gss_OID_set *a6, OM_uint32 *a7), (a0, a1, a2, a3, a4, a5, a6, a7))
+GSSDL_FUNC(gss_acquire_cred_from, (OM_uint32 *a0, gss_name_t a1, OM_uint32 a2, gss_OID_set a3, gss_cred_usage_t a4, gss_const_key_value_set_t a5, gss_cred_id_t *a6, gss_OID_set *a7, OM_uint32 *a8), (a0, a1, a2, a3, a4, a5, a6, a7, a8))
+GSSDL_FUNC(gss_acquire_cred_impersonate_name, (OM_uint32 *a0, const gss_cred_id_t a1, const gss_name_t a2, OM_uint32 a3, const gss_OID_set a4, gss_cred_usage_t a5, gss_cred_id_t *a6, gss_OID_set *a7, OM_uint32 *a8), (a0, a1, a2, a3, a4, a5, a6, a7, a8))
+GSSDL_FUNC(gss_acquire_cred_with_password, (OM_uint32 *a0, const gss_name_t a1, const gss_buffer_t a2, OM_uint32 a3, const gss_OID_set a4, gss_cred_usage_t a5, gss_cred_id_t *a6, gss_OID_set *a7, OM_uint32 *a8), (a0, a1, a2, a3, a4, a5, a6, a7, a8))
+GSSDL_FUNC(gss_add_cred, (OM_uint32 *a0, gss_cred_id_t a1, gss_name_t a2, gss_OID a3, gss_cred_usage_t a4, OM_uint32 a5, OM_uint32 a6, gss_cred_id_t *a7, gss_OID_set *a8, OM_uint32 *a9, OM_uint32 *a10), (a0, a1, a2, a3, a4, a5, a6, a7, a8, a9, a10))
+GSSDL_FUNC(gss_add_cred_from, (OM_uint32 *a0, gss_cred_id_t a1, gss_name_t a2, gss_OID a3, gss_cred_usage_t a4, OM_uint32 a5, OM_uint32 a6, gss_const_key_value_set_t a7, gss_cred_id_t *a8, gss_OID_set *a9, OM_uint32 *a10, OM_uint32 *a11), (a0, a1, a2, a3, a4, a5, a6, a7, a8, a9, a10, a11))
+GSSDL_FUNC(gss_add_cred_impersonate_name, (OM_uint32 *a0, gss_cred_id_t a1, const gss_cred_id_t a2, const gss_name_t a3, gss_OID a4, gss_cred_usage_t a5, OM_uint32 a6, OM_uint32 a7, gss_cred_id_t *a8, gss_OID_set *a9, OM_uint32 *a10, OM_uint32 *a11), (a0, a1, a2, a3, a4, a5, a6, a7, a8, a9, a10, a11))
+GSSDL_FUNC(gss_add_cred_with_password, (OM_uint32 *a0, const gss_cred_id_t a1, const gss_name_t a2, const gss_OID a3, const gss_buffer_t a4, gss_cred_usage_t a5, OM_uint32 a6, OM_uint32 a7, gss_cred_id_t *a8, gss_OID_set *a9, OM_uint32 *a10, OM_uint32 *a11), (a0, a1, a2, a3, a4, a5, a6, a7, a8, a9, a10, a11))
+GSSDL_FUNC(gss_add_oid_set_member, (OM_uint32 *a0, gss_OID a1, gss_OID_set *a2), (a0, a1, a2))
+GSSDL_FUNC(gss_authorize_localname, (OM_uint32 *a0, const gss_name_t a1, const gss_name_t a2), (a0, a1, a2))
+GSSDL_FUNC(gss_canonicalize_name, (OM_uint32 *a0, const gss_name_t a1, const gss_OID a2, gss_name_t *a3), (a0, a1, a2, a3))
+GSSDL_FUNC(gss_compare_name, (OM_uint32 *a0, gss_name_t a1, gss_name_t a2, int *a3), (a0, a1, a2, a3))
+GSSDL_FUNC(gss_complete_auth_token, (OM_uint32 *a0, const gss_ctx_id_t a1, gss_buffer_t a2), (a0, a1, a2))
+GSSDL_FUNC(gss_context_time, (OM_uint32 *a0, gss_ctx_id_t a1, OM_uint32 *a2), (a0, a1, a2))
+GSSDL_FUNC(gss_create_empty_oid_set, (OM_uint32 *a0, gss_OID_set *a1), (a0, a1))
+GSSDL_FUNC(gss_delete_name_attribute, (OM_uint32 *a0, gss_name_t a1, gss_buffer_t a2), (a0, a1, a2))
+GSSDL_FUNC(gss_delete_sec_context, (OM_uint32 *a0, gss_ctx_id_t *a1, gss_buffer_t a2), (a0, a1, a2))
+GSSDL_FUNC(gss_display_name, (OM_uint32 *a0, gss_name_t a1, gss_buffer_t a2, gss_OID *a3), (a0, a1, a2, a3))
+GSSDL_FUNC(gss_display_name_ext, (OM_uint32 *a0, gss_name_t a1, gss_OID a2, gss_buffer_t a3), (a0, a1, a2, a3))
+GSSDL_FUNC(gss_display_status, (OM_uint32 *a0, OM_uint32 a1, int a2, gss_OID a3, OM_uint32 *a4, gss_buffer_t a5), (a0, a1, a2, a3, a4, a5))
+GSSDL_FUNC(gss_duplicate_name, (OM_uint32 *a0, const gss_name_t a1, gss_name_t *a2), (a0, a1, a2))
+GSSDL_FUNC(gss_export_cred, (OM_uint32 *a0, gss_cred_id_t a1, gss_buffer_t a2), (a0, a1, a2))
+GSSDL_FUNC(gss_export_name, (OM_uint32 *a0, const gss_name_t a1, gss_buffer_t a2), (a0, a1, a2))
+GSSDL_FUNC(gss_export_name_composite, (OM_uint32 *a0, gss_name_t a1, gss_buffer_t a2), (a0, a1, a2))
+GSSDL_FUNC(gss_export_sec_context, (OM_uint32 *a0, gss_ctx_id_t *a1, gss_buffer_t a2), (a0, a1, a2))
+GSSDL_FUNC(gss_get_mic, (OM_uint32 *a0, gss_ctx_id_t a1, gss_qop_t a2, gss_buffer_t a3, gss_buffer_t a4), (a0, a1, a2, a3, a4))
+GSSDL_FUNC(gss_get_mic_iov, (OM_uint32 *a0, gss_ctx_id_t a1, gss_qop_t a2, gss_iov_buffer_desc *a3, int a4), (a0, a1, a2, a3, a4))
+GSSDL_FUNC(gss_get_mic_iov_length, (OM_uint32 *a0, gss_ctx_id_t a1, gss_qop_t a2, gss_iov_buffer_desc *a3, int a4), (a0, a1, a2, a3, a4))
+GSSDL_FUNC(gss_get_name_attribute, (OM_uint32 *a0, gss_name_t a1, gss_buffer_t a2, int *a3, int *a4, gss_buffer_t a5, gss_buffer_t a6, int *a7), (a0, a1, a2, a3, a4, a5, a6, a7))
+GSSDL_FUNC(gss_import_cred, (OM_uint32 *a0, gss_buffer_t a1, gss_cred_id_t *a2), (a0, a1, a2))
+GSSDL_FUNC(gss_import_name, (OM_uint32 *a0, gss_buffer_t a1, gss_OID a2, gss_name_t *a3), (a0, a1, a2, a3))
+GSSDL_FUNC(gss_import_sec_context, (OM_uint32 *a0, gss_buffer_t a1, gss_ctx_id_t *a2), (a0, a1, a2))
+GSSDL_FUNC(gss_indicate_mechs, (OM_uint32 *a0, gss_OID_set *a1), (a0, a1))
+GSSDL_FUNC(gss_indicate_mechs_by_attrs, (OM_uint32 *a0, const gss_OID_set_desc *a1, const gss_OID_set_desc *a2, const gss_OID_set_desc *a3, gss_OID_set *a4), (a0, a1, a2, a3, a4))
+GSSDL_FUNC(gss_init_sec_context, (OM_uint32 *a0, gss_cred_id_t a1, gss_ctx_id_t *a2, gss_name_t a3, gss_OID a4, OM_uint32 a5, OM_uint32 a6, gss_channel_bindings_t a7, gss_buffer_t a8, gss_OID *a9, gss_buffer_t a10, OM_uint32 *a11, OM_uint32 *a12), (a0, a1, a2, a3, a4, a5, a6, a7, a8, a9, a10, a11, a12))
+GSSDL_FUNC(gss_inquire_context, (OM_uint32 *a0, gss_ctx_id_t a1, gss_name_t *a2, gss_name_t *a3, OM_uint32 *a4, gss_OID *a5, OM_uint32 *a6, int *a7, int *a8), (a0, a1, a2, a3, a4, a5, a6, a7, a8))
+GSSDL_FUNC(gss_inquire_cred, (OM_uint32 *a0, gss_cred_id_t a1, gss_name_t *a2, OM_uint32 *a3, gss_cred_usage_t *a4, gss_OID_set *a5), (a0, a1, a2, a3, a4, a5))
+GSSDL_FUNC(gss_inquire_cred_by_mech, (OM_uint32 *a0, gss_cred_id_t a1, gss_OID a2, gss_name_t *a3, OM_uint32 *a4, OM_uint32 *a5, gss_cred_usage_t *a6), (a0, a1, a2, a3, a4, a5, a6))
+GSSDL_FUNC(gss_inquire_cred_by_oid, (OM_uint32 *a0, const gss_cred_id_t a1, const gss_OID a2, gss_buffer_set_t *a3), (a0, a1, a2, a3))
+GSSDL_FUNC(gss_inquire_mech_for_saslname, (OM_uint32 *a0, const gss_buffer_t a1, gss_OID *a2), (a0, a1, a2))
+GSSDL_FUNC(gss_inquire_mechs_for_name, (OM_uint32 *a0, const gss_name_t a1, gss_OID_set *a2), (a0, a1, a2))
+GSSDL_FUNC(gss_inquire_name, (OM_uint32 *a0, gss_name_t a1, int *a2, gss_OID *a3, gss_buffer_set_t *a4), (a0, a1, a2, a3, a4))
+GSSDL_FUNC(gss_inquire_names_for_mech, (OM_uint32 *a0, gss_OID a1, gss_OID_set *a2), (a0, a1, a2))
+GSSDL_FUNC(gss_inquire_saslname_for_mech, (OM_uint32 *a0, const gss_OID a1, gss_buffer_t a2, gss_buffer_t a3, gss_buffer_t a4), (a0, a1, a2, a3, a4))
+GSSDL_FUNC(gss_inquire_sec_context_by_oid, (OM_uint32 *a0, const gss_ctx_id_t a1, const gss_OID a2, gss_buffer_set_t *a3), (a0, a1, a2, a3))
+GSSDL_FUNC(gss_krb5_ccache_name, (OM_uint32 *a0, const char *a1, const char* *a2), (a0, a1, a2))
+GSSDL_FUNC(gss_krb5_export_lucid_sec_context, (OM_uint32 *a0, gss_ctx_id_t *a1, OM_uint32 a2, void* *a3), (a0, a1, a2, a3))
+GSSDL_FUNC(gss_krb5_free_lucid_sec_context, (OM_uint32 *a0, void *a1), (a0, a1))
+GSSDL_FUNC(gss_krb5_get_tkt_flags, (OM_uint32 *a0, gss_ctx_id_t a1, krb5_flags *a2), (a0, a1, a2))
+GSSDL_FUNC(gss_krb5_import_cred, (OM_uint32 *a0, krb5_ccache a1, krb5_principal a2, krb5_keytab a3, gss_cred_id_t *a4), (a0, a1, a2, a3, a4))
+GSSDL_FUNC(gss_krb5_set_allowable_enctypes, (OM_uint32 *a0, gss_cred_id_t a1, OM_uint32 a2, krb5_enctype *a3), (a0, a1, a2, a3))
+GSSDL_FUNC(gss_krb5_set_cred_rcache, (OM_uint32 *a0, gss_cred_id_t a1, krb5_rcache a2), (a0, a1, a2))
+GSSDL_FUNC(gss_localname, (OM_uint32 *a0, const gss_name_t a1, gss_const_OID a2, gss_buffer_t a3), (a0, a1, a2, a3))
+GSSDL_FUNC(gss_oid_to_str, (OM_uint32 *a0, gss_OID a1, gss_buffer_t a2), (a0, a1, a2))
+GSSDL_FUNC(gss_pname_to_uid, (OM_uint32 *a0, const gss_name_t a1, const gss_OID a2, uid_t *a3), (a0, a1, a2, a3))
+GSSDL_FUNC(gss_process_context_token, (OM_uint32 *a0, gss_ctx_id_t a1, gss_buffer_t a2), (a0, a1, a2))
+GSSDL_FUNC(gss_pseudo_random, (OM_uint32 *a0, gss_ctx_id_t a1, int a2, const gss_buffer_t a3, ssize_t a4, gss_buffer_t a5), (a0, a1, a2, a3, a4, a5))
+GSSDL_FUNC(gss_release_buffer, (OM_uint32 *a0, gss_buffer_t a1), (a0, a1))
+GSSDL_FUNC(gss_release_buffer_set, (OM_uint32 *a0, gss_buffer_set_t *a1), (a0, a1))
+GSSDL_FUNC(gss_release_cred, (OM_uint32 *a0, gss_cred_id_t *a1), (a0, a1))
+GSSDL_FUNC(gss_release_iov_buffer, (OM_uint32 *a0, gss_iov_buffer_desc *a1, int a2), (a0, a1, a2))
+GSSDL_FUNC(gss_release_name, (OM_uint32 *a0, gss_name_t *a1), (a0, a1))
+GSSDL_FUNC(gss_release_oid, (OM_uint32 *a0, gss_OID *a1), (a0, a1))
+GSSDL_FUNC(gss_release_oid_set, (OM_uint32 *a0, gss_OID_set *a1), (a0, a1))
+GSSDL_FUNC(gss_set_cred_option, (OM_uint32 *a0, gss_cred_id_t *a1, const gss_OID a2, const gss_buffer_t a3), (a0, a1, a2, a3))
+GSSDL_FUNC(gss_set_name_attribute, (OM_uint32 *a0, gss_name_t a1, int a2, gss_buffer_t a3, gss_buffer_t a4), (a0, a1, a2, a3, a4))
+GSSDL_FUNC(gss_set_neg_mechs, (OM_uint32 *a0, gss_cred_id_t a1, const gss_OID_set a2), (a0, a1, a2))
+GSSDL_FUNC(gss_set_sec_context_option, (OM_uint32 *a0, gss_ctx_id_t *a1, const gss_OID a2, const gss_buffer_t a3), (a0, a1, a2, a3))
+GSSDL_FUNC(gss_store_cred, (OM_uint32 *a0, gss_cred_id_t a1, gss_cred_usage_t a2, const gss_OID a3, OM_uint32 a4, OM_uint32 a5, gss_OID_set *a6, gss_cred_usage_t *a7), (a0, a1, a2, a3, a4, a5, a6, a7))
+GSSDL_FUNC(gss_store_cred_into, (OM_uint32 *a0, gss_cred_id_t a1, gss_cred_usage_t a2, gss_OID a3, OM_uint32 a4, OM_uint32 a5, gss_const_key_value_set_t a6, gss_OID_set *a7, gss_cred_usage_t *a8), (a0, a1, a2, a3, a4, a5, a6, a7, a8))
+GSSDL_FUNC(gss_unwrap, (OM_uint32 *a0, gss_ctx_id_t a1, gss_buffer_t a2, gss_buffer_t a3, int *a4, gss_qop_t *a5), (a0, a1, a2, a3, a4, a5))
+GSSDL_FUNC(gss_unwrap_aead, (OM_uint32 *a0, gss_ctx_id_t a1, gss_buffer_t a2, gss_buffer_t a3, gss_buffer_t a4, int *a5, gss_qop_t *a6), (a0, a1, a2, a3, a4, a5, a6))
+GSSDL_FUNC(gss_unwrap_iov, (OM_uint32 *a0, gss_ctx_id_t a1, int *a2, gss_qop_t *a3, gss_iov_buffer_desc *a4, int a5), (a0, a1, a2, a3, a4, a5))
+GSSDL_INT_FUNC(gss_userok, (const gss_name_t a0, const char *a1), (a0, a1))
+GSSDL_FUNC(gss_verify_mic, (OM_uint32 *a0, gss_ctx_id_t a1, gss_buffer_t a2, gss_buffer_t a3, gss_qop_t *a4), (a0, a1, a2, a3, a4))
+GSSDL_FUNC(gss_verify_mic_iov, (OM_uint32 *a0, gss_ctx_id_t a1, gss_qop_t *a2, gss_iov_buffer_desc *a3, int a4), (a0, a1, a2, a3, a4))
+GSSDL_FUNC(gss_wrap, (OM_uint32 *a0, gss_ctx_id_t a1, int a2, gss_qop_t a3, gss_buffer_t a4, int *a5, gss_buffer_t a6), (a0, a1, a2, a3, a4, a5, a6))
+GSSDL_FUNC(gss_wrap_aead, (OM_uint32 *a0, gss_ctx_id_t a1, int a2, gss_qop_t a3, gss_buffer_t a4, gss_buffer_t a5, int *a6, gss_buffer_t a7), (a0, a1, a2, a3, a4, a5, a6, a7))
+GSSDL_FUNC(gss_wrap_iov, (OM_uint32 *a0, gss_ctx_id_t a1, int a2, gss_qop_t a3, int *a4, gss_iov_buffer_desc *a5, int a6), (a0, a1, a2, a3, a4, a5, a6))
+GSSDL_FUNC(gss_wrap_iov_length, (OM_uint32 *a0, gss_ctx_id_t a1, int a2, gss_qop_t a3, int *a4, gss_iov_buffer_desc *a5, int a6), (a0, a1, a2, a3, a4, a5, a6))
+GSSDL_FUNC(gss_wrap_size_limit, (OM_uint32 *a0, gss_ctx_id_t a1, int a2, gss_qop_t a3, OM_uint32 a4, OM_uint32 *a5), (a0, a1, a2, a3, a4, a5))
+GSSDL_FUNC(gsskrb5_extract_authz_data_from_sec_context, (OM_uint32 *a0, const gss_ctx_id_t a1, int a2, gss_buffer_t a3), (a0, a1, a2, a3))
+GSSDL_FUNC(gssspi_mech_invoke, (OM_uint32 *a0, const gss_OID a1, const gss_OID a2, gss_buffer_t a3), (a0, a1, a2, a3))
+GSSDL_FUNC(krb5_gss_register_acceptor_identity, (const char *a0), (a0))
+GSSDL_KRB5_FUNC(krb5_cc_close, (krb5_context a0, krb5_ccache a1), (a0, a1))
+GSSDL_KRB5_FUNC(krb5_cc_destroy, (krb5_context a0, krb5_ccache a1), (a0, a1))
+GSSDL_KRB5_FUNC(krb5_cc_resolve, (krb5_context a0, const char *a1, krb5_ccache *a2), (a0, a1, a2))
+GSSDL_VOID_FUNC(krb5_free_context, (krb5_context a0), (a0))
+GSSDL_VOID_FUNC(krb5_free_principal, (krb5_context a0, krb5_principal a1), (a0, a1))
+GSSDL_KRB5_FUNC(krb5_get_server_rcache, (krb5_context a0, const krb5_data *a1, krb5_rcache *a2), (a0, a1, a2))
+GSSDL_KRB5_FUNC(krb5_init_context, (krb5_context *a0), (a0))
+GSSDL_KRB5_FUNC(krb5_kt_close, (krb5_context a0, krb5_keytab a1), (a0, a1))
+GSSDL_KRB5_FUNC(krb5_kt_resolve, (krb5_context a0, const char *a1, krb5_keytab *a2), (a0, a1, a2))
+GSSDL_KRB5_FUNC(krb5_parse_name, (krb5_context a0, const char *a1, krb5_principal *a2), (a0, a1, a2))
@@ -0,0 +1,48 @@
+package gss
+
+/*
+#include <stdlib.h>
+#include "loader.h"
+*/
+import "C"
+import "errors"
+import "fmt"
+import "unsafe"
+
+/* ErrGSSUnavailable is returned in place of other errors when the GSSAPI library couldn't be loaded. */
+var ErrGSSUnavailable = errors.New("GSSAPI library is not available")
+
+/* Load() opens the GSSAPI library at path, which can be Heimdal's libgssapi instead of MIT's libgssapi_krb5.  It must be called before any other function in this package if it's going to be called at all, as otherwise the first call loads the library named by $GSSAPI_LIBRARY, or the first of libgssapi_krb5.so.2, libgssapi.so.3 and libgssapi.so.2 which can be found. */
+func Load(path string) error {
+	var cpath *C.char
+	if path != "" {
+		cpath = C.CString(path)
+		defer C.free(unsafe.Pointer(cpath))
+	}
+	switch C.gssdl_load(cpath) {
+	case 0:
+		return nil
+	case 1:
+		if path == "" || path == LibraryPath() {
+			return nil
+		}
+		return fmt.Errorf("can't load %s: %s is already loaded", path, LibraryPath())
+	default:
+		return fmt.Errorf("%w: %s", ErrGSSUnavailable, C.GoString(C.gssdl_error()))
+	}
+}
+
+/* Available() loads the GSSAPI library if it hasn't been loaded yet, and reports whether or not that succeeded. */
+func Available() bool {
+	return C.gssdl_loaded() != 0
+}
+
+/* LibraryPath() returns the name of the GSSAPI library which was loaded, or "" if none was. */
+func LibraryPath() string {
+	return C.GoString(C.gssdl_path())
+}
+
+/* LoadError() returns a description of why the GSSAPI library couldn't be loaded, if it couldn't be. */
+func LoadError() string {
+	return C.GoString(C.gssdl_error())
+}
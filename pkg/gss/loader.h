@@ -0,0 +1,212 @@
+#ifndef GSS_LOADER_H
+#define GSS_LOADER_H
+
+// The GSSAPI library is opened with dlopen() the first time one of its
+// functions is called, rather than being linked in.  Every function which the
+// bindings use is renamed here to a trampoline in loader.c which looks up the
+// real function on first use, and which returns GSS_S_UNAVAILABLE (or an
+// equivalent failure) if the library or the function can't be found.
+
+#include "gssapi_defs.h"
+
+int gssdl_load(const char *path);
+int gssdl_loaded(void);
+const char *gssdl_path(void);
+const char *gssdl_error(void);
+int gssdl_has(const char *name);
+int gssdl_heimdal(void);
+
+#ifndef GSSDL_NO_RENAME
+extern __typeof__(gss_accept_sec_context) gssdl_gss_accept_sec_context;
+#define gss_accept_sec_context gssdl_gss_accept_sec_context
+extern __typeof__(gss_acquire_cred) gssdl_gss_acquire_cred;
+#define gss_acquire_cred gssdl_gss_acquire_cred
+extern __typeof__(gss_acquire_cred_from) gssdl_gss_acquire_cred_from;
+#define gss_acquire_cred_from gssdl_gss_acquire_cred_from
+extern __typeof__(gss_acquire_cred_impersonate_name) gssdl_gss_acquire_cred_impersonate_name;
+#define gss_acquire_cred_impersonate_name gssdl_gss_acquire_cred_impersonate_name
+extern __typeof__(gss_acquire_cred_with_password) gssdl_gss_acquire_cred_with_password;
+#define gss_acquire_cred_with_password gssdl_gss_acquire_cred_with_password
+extern __typeof__(gss_add_cred) gssdl_gss_add_cred;
+#define gss_add_cred gssdl_gss_add_cred
+extern __typeof__(gss_add_cred_from) gssdl_gss_add_cred_from;
+#define gss_add_cred_from gssdl_gss_add_cred_from
+extern __typeof__(gss_add_cred_impersonate_name) gssdl_gss_add_cred_impersonate_name;
+#define gss_add_cred_impersonate_name gssdl_gss_add_cred_impersonate_name
+extern __typeof__(gss_add_cred_with_password) gssdl_gss_add_cred_with_password;
+#define gss_add_cred_with_password gssdl_gss_add_cred_with_password
+extern __typeof__(gss_add_oid_set_member) gssdl_gss_add_oid_set_member;
+#define gss_add_oid_set_member gssdl_gss_add_oid_set_member
+extern __typeof__(gss_authorize_localname) gssdl_gss_authorize_localname;
+#define gss_authorize_localname gssdl_gss_authorize_localname
+extern __typeof__(gss_canonicalize_name) gssdl_gss_canonicalize_name;
+#define gss_canonicalize_name gssdl_gss_canonicalize_name
+extern __typeof__(gss_compare_name) gssdl_gss_compare_name;
+#define gss_compare_name gssdl_gss_compare_name
+extern __typeof__(gss_complete_auth_token) gssdl_gss_complete_auth_token;
+#define gss_complete_auth_token gssdl_gss_complete_auth_token
+extern __typeof__(gss_context_time) gssdl_gss_context_time;
+#define gss_context_time gssdl_gss_context_time
+extern __typeof__(gss_create_empty_oid_set) gssdl_gss_create_empty_oid_set;
+#define gss_create_empty_oid_set gssdl_gss_create_empty_oid_set
+extern __typeof__(gss_delete_name_attribute) gssdl_gss_delete_name_attribute;
+#define gss_delete_name_attribute gssdl_gss_delete_name_attribute
+extern __typeof__(gss_delete_sec_context) gssdl_gss_delete_sec_context;
+#define gss_delete_sec_context gssdl_gss_delete_sec_context
+extern __typeof__(gss_display_name) gssdl_gss_display_name;
+#define gss_display_name gssdl_gss_display_name
+extern __typeof__(gss_display_name_ext) gssdl_gss_display_name_ext;
+#define gss_display_name_ext gssdl_gss_display_name_ext
+extern __typeof__(gss_display_status) gssdl_gss_display_status;
+#define gss_display_status gssdl_gss_display_status
+extern __typeof__(gss_duplicate_name) gssdl_gss_duplicate_name;
+#define gss_duplicate_name gssdl_gss_duplicate_name
+extern __typeof__(gss_export_cred) gssdl_gss_export_cred;
+#define gss_export_cred gssdl_gss_export_cred
+extern __typeof__(gss_export_name) gssdl_gss_export_name;
+#define gss_export_name gssdl_gss_export_name
+extern __typeof__(gss_export_name_composite) gssdl_gss_export_name_composite;
+#define gss_export_name_composite gssdl_gss_export_name_composite
+extern __typeof__(gss_export_sec_context) gssdl_gss_export_sec_context;
+#define gss_export_sec_context gssdl_gss_export_sec_context
+extern __typeof__(gss_get_mic) gssdl_gss_get_mic;
+#define gss_get_mic gssdl_gss_get_mic
+extern __typeof__(gss_get_mic_iov) gssdl_gss_get_mic_iov;
+#define gss_get_mic_iov gssdl_gss_get_mic_iov
+extern __typeof__(gss_get_mic_iov_length) gssdl_gss_get_mic_iov_length;
+#define gss_get_mic_iov_length gssdl_gss_get_mic_iov_length
+extern __typeof__(gss_get_name_attribute) gssdl_gss_get_name_attribute;
+#define gss_get_name_attribute gssdl_gss_get_name_attribute
+extern __typeof__(gss_import_cred) gssdl_gss_import_cred;
+#define gss_import_cred gssdl_gss_import_cred
+extern __typeof__(gss_import_name) gssdl_gss_import_name;
+#define gss_import_name gssdl_gss_import_name
+extern __typeof__(gss_import_sec_context) gssdl_gss_import_sec_context;
+#define gss_import_sec_context gssdl_gss_import_sec_context
+extern __typeof__(gss_indicate_mechs) gssdl_gss_indicate_mechs;
+#define gss_indicate_mechs gssdl_gss_indicate_mechs
+extern __typeof__(gss_indicate_mechs_by_attrs) gssdl_gss_indicate_mechs_by_attrs;
+#define gss_indicate_mechs_by_attrs gssdl_gss_indicate_mechs_by_attrs
+extern __typeof__(gss_init_sec_context) gssdl_gss_init_sec_context;
+#define gss_init_sec_context gssdl_gss_init_sec_context
+extern __typeof__(gss_inquire_context) gssdl_gss_inquire_context;
+#define gss_inquire_context gssdl_gss_inquire_context
+extern __typeof__(gss_inquire_cred) gssdl_gss_inquire_cred;
+#define gss_inquire_cred gssdl_gss_inquire_cred
+extern __typeof__(gss_inquire_cred_by_mech) gssdl_gss_inquire_cred_by_mech;
+#define gss_inquire_cred_by_mech gssdl_gss_inquire_cred_by_mech
+extern __typeof__(gss_inquire_cred_by_oid) gssdl_gss_inquire_cred_by_oid;
+#define gss_inquire_cred_by_oid gssdl_gss_inquire_cred_by_oid
+extern __typeof__(gss_inquire_mech_for_saslname) gssdl_gss_inquire_mech_for_saslname;
+#define gss_inquire_mech_for_saslname gssdl_gss_inquire_mech_for_saslname
+extern __typeof__(gss_inquire_mechs_for_name) gssdl_gss_inquire_mechs_for_name;
+#define gss_inquire_mechs_for_name gssdl_gss_inquire_mechs_for_name
+extern __typeof__(gss_inquire_name) gssdl_gss_inquire_name;
+#define gss_inquire_name gssdl_gss_inquire_name
+extern __typeof__(gss_inquire_names_for_mech) gssdl_gss_inquire_names_for_mech;
+#define gss_inquire_names_for_mech gssdl_gss_inquire_names_for_mech
+extern __typeof__(gss_inquire_saslname_for_mech) gssdl_gss_inquire_saslname_for_mech;
+#define gss_inquire_saslname_for_mech gssdl_gss_inquire_saslname_for_mech
+extern __typeof__(gss_inquire_sec_context_by_oid) gssdl_gss_inquire_sec_context_by_oid;
+#define gss_inquire_sec_context_by_oid gssdl_gss_inquire_sec_context_by_oid
+extern __typeof__(gss_krb5_ccache_name) gssdl_gss_krb5_ccache_name;
+#define gss_krb5_ccache_name gssdl_gss_krb5_ccache_name
+extern __typeof__(gss_krb5_export_lucid_sec_context) gssdl_gss_krb5_export_lucid_sec_context;
+#define gss_krb5_export_lucid_sec_context gssdl_gss_krb5_export_lucid_sec_context
+extern __typeof__(gss_krb5_free_lucid_sec_context) gssdl_gss_krb5_free_lucid_sec_context;
+#define gss_krb5_free_lucid_sec_context gssdl_gss_krb5_free_lucid_sec_context
+extern __typeof__(gss_krb5_get_tkt_flags) gssdl_gss_krb5_get_tkt_flags;
+#define gss_krb5_get_tkt_flags gssdl_gss_krb5_get_tkt_flags
+extern __typeof__(gss_krb5_import_cred) gssdl_gss_krb5_import_cred;
+#define gss_krb5_import_cred gssdl_gss_krb5_import_cred
+extern __typeof__(gss_krb5_set_allowable_enctypes) gssdl_gss_krb5_set_allowable_enctypes;
+#define gss_krb5_set_allowable_enctypes gssdl_gss_krb5_set_allowable_enctypes
+extern __typeof__(gss_krb5_set_cred_rcache) gssdl_gss_krb5_set_cred_rcache;
+#define gss_krb5_set_cred_rcache gssdl_gss_krb5_set_cred_rcache
+extern __typeof__(gss_localname) gssdl_gss_localname;
+#define gss_localname gssdl_gss_localname
+extern __typeof__(gss_oid_to_str) gssdl_gss_oid_to_str;
+#define gss_oid_to_str gssdl_gss_oid_to_str
+extern __typeof__(gss_pname_to_uid) gssdl_gss_pname_to_uid;
+#define gss_pname_to_uid gssdl_gss_pname_to_uid
+extern __typeof__(gss_process_context_token) gssdl_gss_process_context_token;
+#define gss_process_context_token gssdl_gss_process_context_token
+extern __typeof__(gss_pseudo_random) gssdl_gss_pseudo_random;
+#define gss_pseudo_random gssdl_gss_pseudo_random
+extern __typeof__(gss_release_buffer) gssdl_gss_release_buffer;
+#define gss_release_buffer gssdl_gss_release_buffer
+extern __typeof__(gss_release_buffer_set) gssdl_gss_release_buffer_set;
+#define gss_release_buffer_set gssdl_gss_release_buffer_set
+extern __typeof__(gss_release_cred) gssdl_gss_release_cred;
+#define gss_release_cred gssdl_gss_release_cred
+extern __typeof__(gss_release_iov_buffer) gssdl_gss_release_iov_buffer;
+#define gss_release_iov_buffer gssdl_gss_release_iov_buffer
+extern __typeof__(gss_release_name) gssdl_gss_release_name;
+#define gss_release_name gssdl_gss_release_name
+extern __typeof__(gss_release_oid) gssdl_gss_release_oid;
+#define gss_release_oid gssdl_gss_release_oid
+extern __typeof__(gss_release_oid_set) gssdl_gss_release_oid_set;
+#define gss_release_oid_set gssdl_gss_release_oid_set
+extern __typeof__(gss_set_cred_option) gssdl_gss_set_cred_option;
+#define gss_set_cred_option gssdl_gss_set_cred_option
+extern __typeof__(gss_set_name_attribute) gssdl_gss_set_name_attribute;
+#define gss_set_name_attribute gssdl_gss_set_name_attribute
+extern __typeof__(gss_set_neg_mechs) gssdl_gss_set_neg_mechs;
+#define gss_set_neg_mechs gssdl_gss_set_neg_mechs
+extern __typeof__(gss_set_sec_context_option) gssdl_gss_set_sec_context_option;
+#define gss_set_sec_context_option gssdl_gss_set_sec_context_option
+extern __typeof__(gss_store_cred) gssdl_gss_store_cred;
+#define gss_store_cred gssdl_gss_store_cred
+extern __typeof__(gss_store_cred_into) gssdl_gss_store_cred_into;
+#define gss_store_cred_into gssdl_gss_store_cred_into
+extern __typeof__(gss_unwrap) gssdl_gss_unwrap;
+#define gss_unwrap gssdl_gss_unwrap
+extern __typeof__(gss_unwrap_aead) gssdl_gss_unwrap_aead;
+#define gss_unwrap_aead gssdl_gss_unwrap_aead
+extern __typeof__(gss_unwrap_iov) gssdl_gss_unwrap_iov;
+#define gss_unwrap_iov gssdl_gss_unwrap_iov
+extern __typeof__(gss_userok) gssdl_gss_userok;
+#define gss_userok gssdl_gss_userok
+extern __typeof__(gss_verify_mic) gssdl_gss_verify_mic;
+#define gss_verify_mic gssdl_gss_verify_mic
+extern __typeof__(gss_verify_mic_iov) gssdl_gss_verify_mic_iov;
+#define gss_verify_mic_iov gssdl_gss_verify_mic_iov
+extern __typeof__(gss_wrap) gssdl_gss_wrap;
+#define gss_wrap gssdl_gss_wrap
+extern __typeof__(gss_wrap_aead) gssdl_gss_wrap_aead;
+#define gss_wrap_aead gssdl_gss_wrap_aead
+extern __typeof__(gss_wrap_iov) gssdl_gss_wrap_iov;
+#define gss_wrap_iov gssdl_gss_wrap_iov
+extern __typeof__(gss_wrap_iov_length) gssdl_gss_wrap_iov_length;
+#define gss_wrap_iov_length gssdl_gss_wrap_iov_length
+extern __typeof__(gss_wrap_size_limit) gssdl_gss_wrap_size_limit;
+#define gss_wrap_size_limit gssdl_gss_wrap_size_limit
+extern __typeof__(gsskrb5_extract_authz_data_from_sec_context) gssdl_gsskrb5_extract_authz_data_from_sec_context;
+#define gsskrb5_extract_authz_data_from_sec_context gssdl_gsskrb5_extract_authz_data_from_sec_context
+extern __typeof__(gssspi_mech_invoke) gssdl_gssspi_mech_invoke;
+#define gssspi_mech_invoke gssdl_gssspi_mech_invoke
+extern __typeof__(krb5_gss_register_acceptor_identity) gssdl_krb5_gss_register_acceptor_identity;
+#define krb5_gss_register_acceptor_identity gssdl_krb5_gss_register_acceptor_identity
+extern __typeof__(krb5_cc_close) gssdl_krb5_cc_close;
+#define krb5_cc_close gssdl_krb5_cc_close
+extern __typeof__(krb5_cc_destroy) gssdl_krb5_cc_destroy;
+#define krb5_cc_destroy gssdl_krb5_cc_destroy
+extern __typeof__(krb5_cc_resolve) gssdl_krb5_cc_resolve;
+#define krb5_cc_resolve gssdl_krb5_cc_resolve
+extern __typeof__(krb5_free_context) gssdl_krb5_free_context;
+#define krb5_free_context gssdl_krb5_free_context
+extern __typeof__(krb5_free_principal) gssdl_krb5_free_principal;
+#define krb5_free_principal gssdl_krb5_free_principal
+extern __typeof__(krb5_get_server_rcache) gssdl_krb5_get_server_rcache;
+#define krb5_get_server_rcache gssdl_krb5_get_server_rcache
+extern __typeof__(krb5_init_context) gssdl_krb5_init_context;
+#define krb5_init_context gssdl_krb5_init_context
+extern __typeof__(krb5_kt_close) gssdl_krb5_kt_close;
+#define krb5_kt_close gssdl_krb5_kt_close
+extern __typeof__(krb5_kt_resolve) gssdl_krb5_kt_resolve;
+#define krb5_kt_resolve gssdl_krb5_kt_resolve
+extern __typeof__(krb5_parse_name) gssdl_krb5_parse_name;
+#define krb5_parse_name gssdl_krb5_parse_name
+#endif
+
+#endif
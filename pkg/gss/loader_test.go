@@ -0,0 +1,18 @@
+package gss_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/twistlock/gss/pkg/gss"
+)
+
+func TestLoadError(t *testing.T) {
+	if gss.Available() {
+		t.Skip("a GSSAPI library is already loaded")
+	}
+	err := gss.Load("/nonexistent/libgssapi.so")
+	if !errors.Is(err, gss.ErrGSSUnavailable) {
+		t.Errorf("Load of a missing library returned %v, want ErrGSSUnavailable", err)
+	}
+}
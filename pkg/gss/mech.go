@@ -0,0 +1,133 @@
+package gss
+
+import (
+	"encoding/asn1"
+	"time"
+
+	"github.com/twistlock/gss/pkg/gss/mech"
+)
+
+/* secContext adapts a ContextHandle to the mech.Context interface. */
+type secContext struct {
+	cred     CredHandle
+	target   InternalName
+	mechType asn1.ObjectIdentifier
+	flags    Flags
+	bindings *ChannelBindings
+	initiate bool
+	ctx      ContextHandle
+	peer     string
+	expires  time.Time
+}
+
+/* NewInitiatorContext returns a mech.Context which initiates a context with target using cred, or the default initiator credentials if cred is nil.  The caller retains ownership of cred and target, and must not release them before the returned context is released. */
+func NewInitiatorContext(cred CredHandle, target InternalName, mechType asn1.ObjectIdentifier, flags Flags) mech.Context {
+	return &secContext{cred: cred, target: target, mechType: mechType, flags: flags, initiate: true}
+}
+
+/* NewInitiatorContextWithBindings is like NewInitiatorContext, but binds the context to a channel, such as a TLS connection, described by bindings. */
+func NewInitiatorContextWithBindings(cred CredHandle, target InternalName, mechType asn1.ObjectIdentifier, flags Flags, bindings *ChannelBindings) mech.Context {
+	return &secContext{cred: cred, target: target, mechType: mechType, flags: flags, bindings: bindings, initiate: true}
+}
+
+/* NewAcceptorContext returns a mech.Context which accepts a context from a peer using cred, or the default acceptor credentials if cred is nil.  The caller retains ownership of cred. */
+func NewAcceptorContext(cred CredHandle) mech.Context {
+	return &secContext{cred: cred}
+}
+
+/* NewAcceptorContextWithBindings is like NewAcceptorContext, but only accepts contexts which the peer bound to the channel described by bindings. */
+func NewAcceptorContextWithBindings(cred CredHandle, bindings *ChannelBindings) mech.Context {
+	return &secContext{cred: cred, bindings: bindings}
+}
+
+/* contextError converts a failure status to an error, using mech.ErrContextExpired where the caller may want to check for it. */
+func (c *secContext) contextError(when string, major, minor uint32) error {
+	if major == S_CONTEXT_EXPIRED {
+		return mech.ErrContextExpired
+	}
+	return NewGSSError(when, major, minor, &c.mechType)
+}
+
+func (c *secContext) Step(input []byte) (output []byte, complete bool, err error) {
+	var major, minor, lifetime uint32
+	var srcName InternalName
+	var deleg CredHandle
+
+	if c.initiate {
+		major, minor, _, output, _, _, _, lifetime = InitSecContext(c.cred, &c.ctx, c.target, c.mechType, c.flags, C_INDEFINITE, c.bindings, input)
+	} else {
+		major, minor, srcName, c.mechType, _, _, _, lifetime, deleg, output = AcceptSecContext(c.cred, &c.ctx, c.bindings, input)
+		if srcName != nil {
+			if major == S_COMPLETE {
+				_, _, c.peer, _ = DisplayName(srcName)
+			}
+			ReleaseName(srcName)
+		}
+		if deleg != nil {
+			ReleaseCred(deleg)
+		}
+	}
+	switch major {
+	case S_COMPLETE:
+		if c.initiate && c.target != nil {
+			_, _, c.peer, _ = DisplayName(c.target)
+		}
+		c.expires = mech.Expiration(time.Now(), uint64(lifetime), C_INDEFINITE)
+		return output, true, nil
+	case S_CONTINUE_NEEDED:
+		return output, false, nil
+	}
+	return output, false, c.contextError("establishing security context", major, minor)
+}
+
+func (c *secContext) Wrap(message []byte, conf bool) ([]byte, error) {
+	major, minor, _, token := Wrap(c.ctx, conf, C_QOP_DEFAULT, message)
+	if major != S_COMPLETE {
+		return nil, c.contextError("wrapping data", major, minor)
+	}
+	return token, nil
+}
+
+func (c *secContext) Unwrap(token []byte) ([]byte, bool, error) {
+	major, minor, conf, _, message := Unwrap(c.ctx, token)
+	if major != S_COMPLETE {
+		return nil, false, c.contextError("unwrapping data", major, minor)
+	}
+	return message, conf, nil
+}
+
+func (c *secContext) GetMIC(message []byte) ([]byte, error) {
+	major, minor, mic := GetMIC(c.ctx, C_QOP_DEFAULT, message)
+	if major != S_COMPLETE {
+		return nil, c.contextError("computing MIC", major, minor)
+	}
+	return mic, nil
+}
+
+func (c *secContext) VerifyMIC(message, mic []byte) error {
+	major, minor, _ := VerifyMIC(c.ctx, message, mic)
+	if major != S_COMPLETE {
+		return c.contextError("verifying MIC", major, minor)
+	}
+	return nil
+}
+
+func (c *secContext) PeerName() string {
+	return c.peer
+}
+
+func (c *secContext) Expires() time.Time {
+	return c.expires
+}
+
+func (c *secContext) Release() error {
+	if c.ctx == nil {
+		return nil
+	}
+	major, minor, _ := DeleteSecContext(c.ctx)
+	c.ctx = nil
+	if major != S_COMPLETE {
+		return NewGSSError("deleting security context", major, minor, nil)
+	}
+	return nil
+}
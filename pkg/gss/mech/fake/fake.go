@@ -0,0 +1,586 @@
+/*
+Package fake implements a deterministic GSSAPI-like mechanism in pure Go, for testing code which is written against mech.Context without needing Kerberos.
+
+Context tokens are framed as RFC 2743 InitialContextTokens naming Mech, and
+carry the principal names, the requested flags and a digest of any channel
+bindings, authenticated with a key which both sides are configured with.
+Per-message tokens are protected using HMAC-SHA256 and a SHA-256 keystream
+derived from that key and the principal names.  None of this is meant to be
+secure; it only makes mismatched configurations and tampered tokens fail the
+way a real mechanism would.
+
+Both sides of a context are configured using the same Config.  Failures can be
+injected into any operation using Config.Inject.
+*/
+package fake
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/asn1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/twistlock/gss/pkg/gss/mech"
+	"github.com/twistlock/gss/pkg/gss/token"
+)
+
+// Mech is the fake mechanism's OID, from the arc reserved for documentation
+// by RFC 5612.
+var Mech = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 32473, 1, 1}
+
+// Context flags, using the values which RFC 2744 gives them.
+const (
+	FlagDeleg     = 1
+	FlagMutual    = 2
+	FlagReplay    = 4
+	FlagSequence  = 8
+	FlagConf      = 16
+	FlagInteg     = 32
+	FlagAnon      = 64
+	FlagProtReady = 128
+	FlagTrans     = 256
+)
+
+var (
+	// ErrInjected is the error returned by injectors created by FailOn.
+	ErrInjected = errors.New("injected failure")
+	// ErrDefectiveToken is returned for tokens which can't be parsed or
+	// which arrive out of turn.
+	ErrDefectiveToken = errors.New("defective token")
+	// ErrBadIntegrity is returned for tokens whose checksums don't match,
+	// for example because the two sides were configured with different keys.
+	ErrBadIntegrity = errors.New("token failed integrity check")
+	// ErrWrongPrincipal is returned by an acceptor for initiators which
+	// asked for a different acceptor principal.
+	ErrWrongPrincipal = errors.New("token is for a different principal")
+	// ErrReplay is returned when a per-message token is replayed or arrives
+	// out of order, if the replay or sequence flags were requested.
+	ErrReplay = errors.New("token was replayed or reordered")
+	// ErrBadBindings is returned by an acceptor for initiators whose channel
+	// bindings don't match its own.
+	ErrBadBindings = errors.New("channel bindings don't match")
+	// ErrNotEstablished is returned for per-message operations attempted
+	// before the context is established.
+	ErrNotEstablished = errors.New("security context is not established")
+	// ErrReleased is returned for operations attempted after Release.
+	ErrReleased = errors.New("security context has been released")
+)
+
+// Op identifies a Context operation, for use with Config.Inject.
+type Op int
+
+const (
+	OpStep Op = iota
+	OpWrap
+	OpUnwrap
+	OpGetMIC
+	OpVerifyMIC
+)
+
+func (op Op) String() string {
+	switch op {
+	case OpStep:
+		return "Step"
+	case OpWrap:
+		return "Wrap"
+	case OpUnwrap:
+		return "Unwrap"
+	case OpGetMIC:
+		return "GetMIC"
+	case OpVerifyMIC:
+		return "VerifyMIC"
+	}
+	return fmt.Sprintf("Op(%d)", int(op))
+}
+
+// Config describes a fake security context.
+type Config struct {
+	// Initiator and Acceptor are the principal names which the two sides
+	// use.  An acceptor with a non-empty Acceptor rejects initiators which
+	// ask for a different one.
+	Initiator, Acceptor string
+	// Key is shared by both sides.  Contexts whose keys differ fail to
+	// authenticate each other.
+	Key []byte
+	// Flags are requested by the initiator, and granted by the acceptor.
+	Flags uint32
+	// ChannelBindings are sent by the initiator.  An acceptor with non-nil
+	// ChannelBindings rejects initiators which send different ones.
+	ChannelBindings []byte
+	// RoundTrips is the number of tokens which the initiator sends before
+	// the context is established.  It defaults to 1.  If FlagMutual is set,
+	// the acceptor replies to the last one as well.
+	RoundTrips int
+	// Lifetime is how long established contexts last.  Zero means forever.
+	Lifetime time.Duration
+	// Now, if set, is used in place of time.Now.
+	Now func() time.Time
+	// Inject, if set, is called before each operation with the number of
+	// times that operation has been attempted on the context, counting from
+	// 1.  If it returns an error, the operation fails with that error.
+	Inject func(op Op, n int) error
+}
+
+// FailOn returns an injector which fails the n'th attempt at op, or every
+// attempt if n is 0, with ErrInjected.
+func FailOn(op Op, n int) func(Op, int) error {
+	return func(o Op, count int) error {
+		if o == op && (n == 0 || n == count) {
+			return ErrInjected
+		}
+		return nil
+	}
+}
+
+const (
+	tokInitiator = 0x0100
+	tokAcceptor  = 0x0200
+	tokMIC       = 0x0404
+	tokWrap      = 0x0504
+
+	dirAcceptor = 1
+	sealed      = 2
+
+	macSize = 16
+)
+
+// Context is one side of a fake security context.  It implements
+// mech.Context.
+type Context struct {
+	cfg      Config
+	initiate bool
+
+	mu        sync.Mutex
+	calls     map[Op]int
+	step      int
+	complete  bool
+	released  bool
+	peer      string
+	flags     uint32
+	key       []byte
+	expires   time.Time
+	seq, next uint64
+}
+
+// NewInitiator creates the initiating side of a context.
+func NewInitiator(cfg Config) *Context {
+	return newContext(cfg, true)
+}
+
+// NewAcceptor creates the accepting side of a context.
+func NewAcceptor(cfg Config) *Context {
+	return newContext(cfg, false)
+}
+
+// InitiatorFactory returns a mech.Factory which creates initiators.
+func InitiatorFactory(cfg Config) mech.Factory {
+	return func() (mech.Context, error) {
+		return NewInitiator(cfg), nil
+	}
+}
+
+// AcceptorFactory returns a mech.Factory which creates acceptors.
+func AcceptorFactory(cfg Config) mech.Factory {
+	return func() (mech.Context, error) {
+		return NewAcceptor(cfg), nil
+	}
+}
+
+func newContext(cfg Config, initiate bool) *Context {
+	if cfg.RoundTrips <= 0 {
+		cfg.RoundTrips = 1
+	}
+	return &Context{cfg: cfg, initiate: initiate, calls: make(map[Op]int)}
+}
+
+func (c *Context) now() time.Time {
+	if c.cfg.Now != nil {
+		return c.cfg.Now()
+	}
+	return time.Now()
+}
+
+// begin checks whether op may proceed.  The caller must hold mu.
+func (c *Context) begin(op Op) error {
+	if c.released {
+		return ErrReleased
+	}
+	c.calls[op]++
+	if c.cfg.Inject != nil {
+		if err := c.cfg.Inject(op, c.calls[op]); err != nil {
+			return err
+		}
+	}
+	if op == OpStep {
+		return nil
+	}
+	if !c.complete {
+		return ErrNotEstablished
+	}
+	if !c.expires.IsZero() && !c.now().Before(c.expires) {
+		return mech.ErrContextExpired
+	}
+	return nil
+}
+
+// PeerName returns the peer's principal name, once the context is
+// established.  It's empty if the initiator requested anonymity.
+func (c *Context) PeerName() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.peer
+}
+
+// Flags returns the context's flags.  FlagProtReady is set once the context
+// is established.
+func (c *Context) Flags() uint32 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.complete {
+		return c.flags | FlagProtReady
+	}
+	return c.flags
+}
+
+func (c *Context) tokenMAC(data []byte) []byte {
+	m := hmac.New(sha256.New, c.cfg.Key)
+	m.Write([]byte("fake context token"))
+	m.Write(data)
+	return m.Sum(nil)[:macSize]
+}
+
+func appendName(b []byte, name string) []byte {
+	b = binary.BigEndian.AppendUint16(b, uint16(len(name)))
+	return append(b, name...)
+}
+
+func readName(b []byte) (string, []byte, error) {
+	if len(b) < 2 || len(b) < 2+int(binary.BigEndian.Uint16(b)) {
+		return "", nil, ErrDefectiveToken
+	}
+	n := int(binary.BigEndian.Uint16(b))
+	return string(b[2 : 2+n]), b[2+n:], nil
+}
+
+// bindings returns a digest of the channel bindings, or nothing if there
+// aren't any.
+func (c *Context) bindings() string {
+	if c.cfg.ChannelBindings == nil {
+		return ""
+	}
+	sum := sha256.Sum256(c.cfg.ChannelBindings)
+	return string(sum[:])
+}
+
+// contextToken builds a framed context token.  from is the sender's name
+// and to is the name of the intended recipient.
+func (c *Context) contextToken(id uint16, from, to string) ([]byte, error) {
+	b := binary.BigEndian.AppendUint16(nil, id)
+	b = append(b, byte(c.step))
+	b = binary.BigEndian.AppendUint32(b, c.flags)
+	b = appendName(b, from)
+	b = appendName(b, to)
+	b = appendName(b, c.bindings())
+	b = append(b, c.tokenMAC(b)...)
+	return token.Frame(Mech, b)
+}
+
+// parseContextToken checks a framed context token and returns its contents.
+func (c *Context) parseContextToken(tok []byte, id uint16) (step int, flags uint32, from, to, bindings string, err error) {
+	oid, inner, err := token.Unframe(tok)
+	if err != nil {
+		return 0, 0, "", "", "", fmt.Errorf("%v: %v", ErrDefectiveToken, err)
+	}
+	if !oid.Equal(Mech) || len(inner) < 7+macSize {
+		return 0, 0, "", "", "", ErrDefectiveToken
+	}
+	body, mac := inner[:len(inner)-macSize], inner[len(inner)-macSize:]
+	if !hmac.Equal(mac, c.tokenMAC(body)) {
+		return 0, 0, "", "", "", ErrBadIntegrity
+	}
+	if binary.BigEndian.Uint16(body) != id {
+		return 0, 0, "", "", "", ErrDefectiveToken
+	}
+	step = int(body[2])
+	flags = binary.BigEndian.Uint32(body[3:])
+	rest := body[7:]
+	if from, rest, err = readName(rest); err != nil {
+		return 0, 0, "", "", "", err
+	}
+	if to, rest, err = readName(rest); err != nil {
+		return 0, 0, "", "", "", err
+	}
+	if bindings, rest, err = readName(rest); err != nil {
+		return 0, 0, "", "", "", err
+	}
+	if len(rest) != 0 {
+		return 0, 0, "", "", "", ErrDefectiveToken
+	}
+	return step, flags, from, to, bindings, nil
+}
+
+// establish marks the context as complete.  The caller must hold mu.
+func (c *Context) establish(initiator, acceptor string) {
+	m := hmac.New(sha256.New, c.cfg.Key)
+	m.Write([]byte("fake session key"))
+	m.Write(appendName(nil, initiator))
+	m.Write(appendName(nil, acceptor))
+	c.key = m.Sum(nil)
+	c.complete = true
+	if c.cfg.Lifetime != 0 {
+		c.expires = c.now().Add(c.cfg.Lifetime)
+	}
+}
+
+// Step implements mech.Context.
+func (c *Context) Step(input []byte) (output []byte, complete bool, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.begin(OpStep); err != nil {
+		return nil, false, err
+	}
+	if c.complete {
+		return nil, true, ErrDefectiveToken
+	}
+	if c.initiate {
+		output, err = c.initiatorStep(input)
+	} else {
+		output, err = c.acceptorStep(input)
+	}
+	return output, c.complete, err
+}
+
+func (c *Context) initiatorStep(input []byte) ([]byte, error) {
+	mutual := c.cfg.Flags&FlagMutual != 0
+	name := c.cfg.Initiator
+	if c.cfg.Flags&FlagAnon != 0 {
+		name = ""
+	}
+	if input == nil {
+		if c.step != 0 {
+			return nil, ErrDefectiveToken
+		}
+		c.flags = c.cfg.Flags
+	} else {
+		step, flags, from, _, _, err := c.parseContextToken(input, tokAcceptor)
+		if err != nil {
+			return nil, err
+		}
+		if step != c.step {
+			return nil, ErrDefectiveToken
+		}
+		c.flags = flags
+		c.peer = from
+		if c.step == c.cfg.RoundTrips {
+			// The acceptor's reply to our last token.
+			c.establish(name, c.peer)
+			return nil, nil
+		}
+	}
+	c.step++
+	output, err := c.contextToken(tokInitiator, name, c.cfg.Acceptor)
+	if err != nil {
+		return nil, err
+	}
+	if c.step == c.cfg.RoundTrips && !mutual {
+		c.peer = c.cfg.Acceptor
+		c.establish(name, c.peer)
+	}
+	return output, nil
+}
+
+func (c *Context) acceptorStep(input []byte) ([]byte, error) {
+	step, flags, from, to, bindings, err := c.parseContextToken(input, tokInitiator)
+	if err != nil {
+		return nil, err
+	}
+	if c.cfg.ChannelBindings != nil && bindings != c.bindings() {
+		return nil, ErrBadBindings
+	}
+	if step != c.step+1 {
+		return nil, ErrDefectiveToken
+	}
+	if c.cfg.Acceptor != "" && to != "" && to != c.cfg.Acceptor {
+		return nil, ErrWrongPrincipal
+	}
+	c.step = step
+	c.flags = flags
+	c.peer = from
+	name := c.cfg.Acceptor
+	if name == "" {
+		name = to
+	}
+	if c.step == c.cfg.RoundTrips {
+		c.establish(c.peer, name)
+		if c.flags&FlagMutual == 0 {
+			return nil, nil
+		}
+	}
+	return c.contextToken(tokAcceptor, name, c.peer)
+}
+
+// header builds a per-message token header.  The caller must hold mu.
+func (c *Context) header(id uint16, conf bool) []byte {
+	var flags byte
+	if !c.initiate {
+		flags |= dirAcceptor
+	}
+	if conf {
+		flags |= sealed
+	}
+	b := binary.BigEndian.AppendUint16(nil, id)
+	b = append(b, flags)
+	b = binary.BigEndian.AppendUint64(b, c.seq)
+	c.seq++
+	return b
+}
+
+// checkHeader checks a per-message token header received from the peer and
+// returns its flags.  The caller must hold mu.
+func (c *Context) checkHeader(tok []byte, id uint16) (byte, error) {
+	if len(tok) < 11+macSize || binary.BigEndian.Uint16(tok) != id {
+		return 0, ErrDefectiveToken
+	}
+	flags := tok[2]
+	if (flags&dirAcceptor != 0) != c.initiate {
+		// It's one of our own tokens, reflected back at us.
+		return 0, ErrBadIntegrity
+	}
+	return flags, nil
+}
+
+// checkSequence applies replay and sequence detection, if they were
+// requested.  The caller must hold mu.
+func (c *Context) checkSequence(tok []byte) error {
+	seq := binary.BigEndian.Uint64(tok[3:])
+	if c.flags&(FlagReplay|FlagSequence) != 0 && seq != c.next {
+		return ErrReplay
+	}
+	c.next = seq + 1
+	return nil
+}
+
+func (c *Context) mac(header, data []byte) []byte {
+	m := hmac.New(sha256.New, c.key)
+	m.Write(header)
+	m.Write(data)
+	return m.Sum(nil)[:macSize]
+}
+
+// crypt XORs data with a keystream derived from the session key and header.
+func (c *Context) crypt(header, data []byte) []byte {
+	out := make([]byte, len(data))
+	var block []byte
+	for i := range data {
+		if i%sha256.Size == 0 {
+			h := sha256.New()
+			h.Write(c.key)
+			h.Write(header)
+			h.Write(binary.BigEndian.AppendUint64(nil, uint64(i/sha256.Size)))
+			block = h.Sum(nil)
+		}
+		out[i] = data[i] ^ block[i%sha256.Size]
+	}
+	return out
+}
+
+// Wrap implements mech.Context.
+func (c *Context) Wrap(message []byte, conf bool) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.begin(OpWrap); err != nil {
+		return nil, err
+	}
+	header := c.header(tokWrap, conf)
+	payload := message
+	if conf {
+		payload = c.crypt(header, message)
+	}
+	tok := append(append([]byte{}, header...), payload...)
+	return append(tok, c.mac(header, message)...), nil
+}
+
+// Unwrap implements mech.Context.
+func (c *Context) Unwrap(tok []byte) ([]byte, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.begin(OpUnwrap); err != nil {
+		return nil, false, err
+	}
+	flags, err := c.checkHeader(tok, tokWrap)
+	if err != nil {
+		return nil, false, err
+	}
+	header := tok[:11]
+	payload, mac := tok[11:len(tok)-macSize], tok[len(tok)-macSize:]
+	conf := flags&sealed != 0
+	message := payload
+	if conf {
+		message = c.crypt(header, payload)
+	}
+	if !hmac.Equal(mac, c.mac(header, message)) {
+		return nil, false, ErrBadIntegrity
+	}
+	if err := c.checkSequence(tok); err != nil {
+		return nil, false, err
+	}
+	return bytes.Clone(message), conf, nil
+}
+
+// GetMIC implements mech.Context.
+func (c *Context) GetMIC(message []byte) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.begin(OpGetMIC); err != nil {
+		return nil, err
+	}
+	header := c.header(tokMIC, false)
+	return append(header, c.mac(header, message)...), nil
+}
+
+// VerifyMIC implements mech.Context.
+func (c *Context) VerifyMIC(message, mic []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.begin(OpVerifyMIC); err != nil {
+		return err
+	}
+	if _, err := c.checkHeader(mic, tokMIC); err != nil {
+		return err
+	}
+	if len(mic) != 11+macSize {
+		return ErrDefectiveToken
+	}
+	if !hmac.Equal(mic[11:], c.mac(mic[:11], message)) {
+		return ErrBadIntegrity
+	}
+	return c.checkSequence(mic)
+}
+
+// Expires implements mech.Context.
+func (c *Context) Expires() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.expires
+}
+
+// Release implements mech.Context.
+func (c *Context) Release() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.released {
+		return ErrReleased
+	}
+	c.released = true
+	return nil
+}
@@ -0,0 +1,52 @@
+/*
+Package mech describes security contexts independently of whether they are provided by the local GSSAPI library or by gss-proxy.
+
+Code which only needs to establish a context and protect messages with it can
+be written against the Context interface, and then be used with either
+gss.NewInitiatorContext()/gss.NewAcceptorContext() or their counterparts in the
+proxy package.
+*/
+package mech
+
+import (
+	"errors"
+	"time"
+)
+
+/* ErrContextExpired is returned by a Context when the underlying security context has expired. */
+var ErrContextExpired = errors.New("security context has expired")
+
+/* Context is a security context which is either being established with a peer or has been. */
+type Context interface {
+	// Step processes a token received from the peer, which is nil on an initiator's first call, and returns a token to send to the peer, if there is one.  Once complete is true, the context is established.
+	Step(input []byte) (output []byte, complete bool, err error)
+	// Wrap protects message for delivery to the peer, encrypting it if conf is true.
+	Wrap(message []byte, conf bool) (token []byte, err error)
+	// Unwrap reverses Wrap, reporting whether or not the message was encrypted.
+	Unwrap(token []byte) (message []byte, conf bool, err error)
+	// GetMIC computes an integrity checksum over message.
+	GetMIC(message []byte) (mic []byte, err error)
+	// VerifyMIC checks a checksum computed by the peer using GetMIC.
+	VerifyMIC(message, mic []byte) error
+	// Expires returns the time at which the established context will stop being usable, or the zero time if it won't.
+	Expires() time.Time
+	// Release frees the resources used by the context.
+	Release() error
+}
+
+/* PeerNamer is implemented by Contexts which can report the peer's name once they are established, as those from both backends and the fake mechanism do. */
+type PeerNamer interface {
+	// PeerName returns the display form of the peer's name, which is the initiator's name on an acceptor, and the target's on an initiator.  It is empty until the context is established.
+	PeerName() string
+}
+
+/* Factory creates a new Context, for example to replace one which is about to expire. */
+type Factory func() (Context, error)
+
+/* Expiration converts a lifetime in seconds, as reported when a context is established, to the time at which it will expire.  A lifetime of indefinite yields the zero time. */
+func Expiration(now time.Time, lifetime, indefinite uint64) time.Time {
+	if lifetime == indefinite {
+		return time.Time{}
+	}
+	return now.Add(time.Duration(lifetime) * time.Second)
+}
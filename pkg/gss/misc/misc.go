@@ -1,6 +1,8 @@
 package misc
 
+import "errors"
 import "fmt"
+import "io"
 import "net"
 import "strconv"
 import "strings"
@@ -17,8 +19,19 @@ const (
 	TOKEN_WRAPPED      byte = (1 << 5)
 	TOKEN_ENCRYPTED    byte = (1 << 6)
 	TOKEN_SEND_MIC     byte = (1 << 7)
+
+	/* TOKEN_RECONTEXT carries tokens for a replacement context on an established connection.  An empty one marks the point after which the sender protects its messages using the replacement. */
+	TOKEN_RECONTEXT byte = TOKEN_CONTEXT | TOKEN_CONTEXT_NEXT
+	/* TOKEN_EOF marks the end of the data which its sender will write on an established connection.  It carries an empty wrapped message, so that the peer can tell it from a forged one. */
+	TOKEN_EOF byte = TOKEN_DATA | TOKEN_NOOP
+
+	/* MaxTokenSize is the length of the largest token which ReadToken will accept.  The length comes from the peer, so it is checked before any memory is allocated for the token. */
+	MaxTokenSize = 16 * 1024 * 1024
 )
 
+/* ErrTokenTooLarge is returned by ReadToken when the peer announces a token which is longer than MaxTokenSize. */
+var ErrTokenTooLarge = errors.New("token is too large")
+
 /* ParseOid returns an asn1.ObjectIdentifier based on the dotted form input string. */
 func ParseOid(oids string) (oid asn1.ObjectIdentifier) {
 	components := strings.Split(oids, ".")
@@ -52,40 +65,56 @@ func SendToken(conn net.Conn, tag byte, token []byte) {
 
 /* RecvToken reads a token sent by SendToken over a newtork connection. */
 func RecvToken(conn net.Conn) (tag byte, token []byte) {
-	var tlen uint32
-	tmp := make([]byte, 1)
+	tag, token, err := ReadToken(conn)
+	if err != nil {
+		fmt.Printf("Error reading token: %s.\n", err)
+	}
+	return
+}
 
-	n, err := conn.Read(tmp)
-	if n == 0 {
-		fmt.Printf("reading token flags: 0 bytes read\n")
-		return
+/* WriteToken sends a token in the same format as SendToken, but reports errors to the caller.  The tag must not be 0. */
+func WriteToken(w io.Writer, tag byte, token []byte) error {
+	if tag == 0 {
+		return errors.New("token tag must not be 0")
 	}
+	buf := make([]byte, 5, 5+len(token))
+	buf[0] = tag
+	binary.BigEndian.PutUint32(buf[1:], uint32(len(token)))
+	_, err := w.Write(append(buf, token...))
+	return err
+}
+
+/* ReadToken reads a complete token sent by SendToken or WriteToken, reporting errors to the caller.  Tokens without a tag are returned with a tag of 0.  Tokens longer than MaxTokenSize are refused with ErrTokenTooLarge. */
+func ReadToken(r io.Reader) (tag byte, token []byte, err error) {
+	var header [4]byte
+	var tlen uint32
+
+	_, err = io.ReadFull(r, header[:1])
 	if err != nil {
-		fmt.Printf("Error reading flag: %s.\n", err)
 		return
 	}
-	tag = tmp[0]
+	tag = header[0]
 	if tag != 0 {
-		err = binary.Read(conn, binary.BigEndian, &tlen)
+		_, err = io.ReadFull(r, header[:])
 		if err != nil {
-			fmt.Printf("Error reading tag: %s.\n", err)
 			return
 		}
 	} else {
-		tags := make([]byte, 3)
-		_, err := conn.Read(tags)
+		_, err = io.ReadFull(r, header[1:])
 		if err != nil {
-			fmt.Printf("Error reading length: %s.\n", err)
 			return
 		}
-		tlen = uint32((tag << 24) | (tags[0] << 16) | (tags[1] << 8) | tags[2])
+	}
+	tlen = binary.BigEndian.Uint32(header[:])
+	if tlen > MaxTokenSize {
+		err = ErrTokenTooLarge
+		return
 	}
 	if tlen > 0 {
 		token = make([]byte, tlen)
-		_, err = conn.Read(token)
-		if err != nil {
-			fmt.Printf("Error reading: %s.\n", err)
-			return
+		_, err = io.ReadFull(r, token)
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
 		}
 	}
 	return
@@ -0,0 +1,32 @@
+package misc
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestReadTokenRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteToken(&buf, TOKEN_DATA, []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	tag, token, err := ReadToken(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tag != TOKEN_DATA || string(token) != "hello" {
+		t.Errorf("read tag %#x token %q", tag, token)
+	}
+}
+
+func TestReadTokenTooLarge(t *testing.T) {
+	for _, header := range [][]byte{
+		{TOKEN_DATA, 0xff, 0xff, 0xff, 0xff},
+		{TOKEN_CONTEXT, 0x01, 0x00, 0x00, 0x01},
+	} {
+		_, token, err := ReadToken(bytes.NewReader(header))
+		if err != ErrTokenTooLarge || token != nil {
+			t.Errorf("header %x: got %d bytes, error %v; want ErrTokenTooLarge", header, len(token), err)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package oncrpc
+
+import (
+	"bytes"
+	"os"
+
+	xdr "github.com/davecgh/go-xdr/xdr2"
+)
+
+// Auth supplies the authentication for calls.  Implementations other than
+// the ones in this package can be used to add flavors.
+type Auth interface {
+	// NewCall returns the authentication for one call.
+	NewCall() (CallAuth, error)
+}
+
+// CallAuth authenticates a single call, checks the verifier in its reply,
+// and protects its arguments and results.
+type CallAuth interface {
+	// Credential returns the call's credential.
+	Credential() (OpaqueAuth, error)
+	// Verifier returns the call's verifier, given the encoded call header
+	// up to and including the credential.
+	Verifier(header []byte) (OpaqueAuth, error)
+	// CheckVerifier checks the verifier in the reply to the call.
+	CheckVerifier(verf OpaqueAuth) error
+	// WrapArgs protects the call's encoded arguments.
+	WrapArgs(args []byte) ([]byte, error)
+	// UnwrapResults checks and removes the protection from the encoded
+	// results in a successful reply.
+	UnwrapResults(results []byte) ([]byte, error)
+}
+
+// plainCall is the CallAuth for flavors which only supply a credential.
+// Replies' verifiers aren't checked, since servers may use them to hand out
+// AUTH_SHORT credentials.
+type plainCall struct {
+	cred OpaqueAuth
+}
+
+func (c plainCall) Credential() (OpaqueAuth, error) {
+	return c.cred, nil
+}
+
+func (c plainCall) Verifier(header []byte) (OpaqueAuth, error) {
+	return emptyAuth(), nil
+}
+
+func (c plainCall) CheckVerifier(verf OpaqueAuth) error {
+	return nil
+}
+
+func (c plainCall) WrapArgs(args []byte) ([]byte, error) {
+	return args, nil
+}
+
+func (c plainCall) UnwrapResults(results []byte) ([]byte, error) {
+	return results, nil
+}
+
+type noneAuth struct{}
+
+func (noneAuth) NewCall() (CallAuth, error) {
+	return plainCall{cred: emptyAuth()}, nil
+}
+
+// AuthNone supplies AUTH_NONE credentials.
+var AuthNone Auth = noneAuth{}
+
+// SysAuth supplies AUTH_SYS credentials, which assert the caller's identity
+// without proving it.
+type SysAuth struct {
+	Stamp       uint32
+	MachineName string
+	UID, GID    uint32
+	GIDs        []uint32
+}
+
+// NewSysAuth returns a SysAuth describing the current process.
+func NewSysAuth() *SysAuth {
+	a := &SysAuth{MachineName: "localhost", UID: uint32(os.Getuid()), GID: uint32(os.Getgid()), GIDs: []uint32{}}
+	if hostname, err := os.Hostname(); err == nil {
+		a.MachineName = hostname
+	}
+	gids, _ := os.Getgroups()
+	for _, gid := range gids {
+		a.GIDs = append(a.GIDs, uint32(gid))
+	}
+	return a
+}
+
+// NewCall returns the authentication for one call.
+func (a *SysAuth) NewCall() (CallAuth, error) {
+	var buf bytes.Buffer
+	if _, err := xdr.Marshal(&buf, a); err != nil {
+		return nil, err
+	}
+	return plainCall{cred: OpaqueAuth{Flavor: FlavorSys, Body: buf.Bytes()}}, nil
+}
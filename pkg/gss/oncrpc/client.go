@@ -0,0 +1,207 @@
+package oncrpc
+
+import (
+	"errors"
+	"io"
+	"sync"
+)
+
+// ErrShutdown is returned for calls which are made, or still waiting for a
+// reply, when a Client is closed.
+var ErrShutdown = errors.New("RPC client is shut down")
+
+// Call is a call made using Client.Go.
+type Call struct {
+	Prog, Vers, Proc uint32
+	Args             []byte
+	// Results holds the results once the call is done, if Error is nil.
+	Results []byte
+	Error   error
+	// Done receives the call when it completes.
+	Done chan *Call
+
+	auth CallAuth
+}
+
+func (call *Call) done() {
+	select {
+	case call.Done <- call:
+	default:
+		// The caller didn't leave room in Done, as Go asks; don't block
+		// the other calls on its account.
+	}
+}
+
+// Client makes calls over a connection, which it reads from in the
+// background so that any number of calls can be waiting for replies at once.
+type Client struct {
+	conn io.ReadWriteCloser
+
+	wmu sync.Mutex // serializes writing records
+
+	mu      sync.Mutex
+	pending map[uint32]*Call
+	err     error // set once the connection can't be used
+}
+
+// NewClient returns a client which makes calls over conn, and closes it when
+// it is closed.
+func NewClient(conn io.ReadWriteCloser) *Client {
+	c := &Client{conn: conn, pending: make(map[uint32]*Call)}
+	go c.read()
+	return c
+}
+
+// Go starts a call, and returns without waiting for it to complete.  done
+// receives the call when it completes; it must be buffered, and one is
+// allocated if it is nil.
+func (c *Client) Go(prog, vers, proc uint32, auth Auth, args []byte, done chan *Call) *Call {
+	if done == nil {
+		done = make(chan *Call, 1)
+	}
+	call := &Call{Prog: prog, Vers: vers, Proc: proc, Args: args, Done: done}
+	if call.auth, call.Error = auth.NewCall(); call.Error != nil {
+		call.done()
+		return call
+	}
+
+	c.mu.Lock()
+	if c.err != nil {
+		c.mu.Unlock()
+		call.Error = c.err
+		call.done()
+		return call
+	}
+	xid := nextXID()
+	for c.pending[xid] != nil {
+		xid = nextXID()
+	}
+	c.pending[xid] = call
+	c.mu.Unlock()
+
+	record, err := encodeCall(xid, prog, vers, proc, call.auth, args)
+	if err == nil {
+		c.wmu.Lock()
+		err = WriteRecord(c.conn, record)
+		c.wmu.Unlock()
+	}
+	if err != nil {
+		c.mu.Lock()
+		// The reader may have failed the call in the meantime.
+		if c.pending[xid] == call {
+			delete(c.pending, xid)
+			call.Error = err
+			call.done()
+		}
+		c.mu.Unlock()
+	}
+	return call
+}
+
+// Call makes a call and waits for its results.
+func (c *Client) Call(prog, vers, proc uint32, auth Auth, args []byte) ([]byte, error) {
+	call := <-c.Go(prog, vers, proc, auth, args, make(chan *Call, 1)).Done
+	return call.Results, call.Error
+}
+
+// Close closes the connection, failing any calls which are still waiting for
+// replies.
+func (c *Client) Close() error {
+	c.mu.Lock()
+	if c.err == ErrShutdown {
+		c.mu.Unlock()
+		return ErrShutdown
+	}
+	c.err = ErrShutdown
+	c.mu.Unlock()
+	return c.conn.Close()
+}
+
+// read matches replies to calls until the connection fails.
+func (c *Client) read() {
+	var err error
+	for err == nil {
+		var record []byte
+		var xid uint32
+		if record, err = ReadRecord(c.conn, DefaultMaxRecordSize); err != nil {
+			break
+		}
+		if xid, err = replyXID(record); err != nil {
+			break
+		}
+		c.mu.Lock()
+		call := c.pending[xid]
+		delete(c.pending, xid)
+		c.mu.Unlock()
+		if call == nil {
+			// A reply to a call which failed while it was being sent,
+			// or a duplicate.
+			continue
+		}
+		call.Results, call.Error = decodeReply(record, call.auth)
+		call.done()
+	}
+
+	c.mu.Lock()
+	if c.err == nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		c.err = err
+	}
+	for xid, call := range c.pending {
+		delete(c.pending, xid)
+		call.Error = c.err
+		call.done()
+	}
+	c.mu.Unlock()
+}
+
+// SyncClient makes one call at a time over a connection, reading its reply
+// before returning.  Unlike Client, it only reads from the connection while
+// a call is being made, so the connection can be handed to other code in
+// between.
+type SyncClient struct {
+	mu sync.Mutex
+	rw io.ReadWriter
+}
+
+// NewSyncClient returns a client which makes calls over rw.
+func NewSyncClient(rw io.ReadWriter) *SyncClient {
+	return &SyncClient{rw: rw}
+}
+
+// Call makes a call and waits for its results.  Replies to other calls are
+// skipped.
+func (c *SyncClient) Call(prog, vers, proc uint32, auth Auth, args []byte) ([]byte, error) {
+	callAuth, err := auth.NewCall()
+	if err != nil {
+		return nil, err
+	}
+	xid := nextXID()
+	record, err := encodeCall(xid, prog, vers, proc, callAuth, args)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err = WriteRecord(c.rw, record); err != nil {
+		return nil, err
+	}
+	for {
+		if record, err = ReadRecord(c.rw, DefaultMaxRecordSize); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		replyTo, err := replyXID(record)
+		if err != nil {
+			return nil, err
+		}
+		if replyTo == xid {
+			return decodeReply(record, callAuth)
+		}
+	}
+}
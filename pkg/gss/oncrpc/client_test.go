@@ -0,0 +1,158 @@
+package oncrpc_test
+
+import (
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/twistlock/gss/pkg/gss/oncrpc"
+)
+
+const (
+	testProg = 400200
+	testVers = 1
+	slowProc = 1
+	fastProc = 2
+)
+
+// serve runs srv on one end of a pipe, and returns a Client for the other.
+func serve(t *testing.T, srv *oncrpc.Server) *oncrpc.Client {
+	t.Helper()
+	a, b := net.Pipe()
+	go srv.ServeConn(b)
+	c := oncrpc.NewClient(a)
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func echo(req *oncrpc.Request) ([]byte, error) {
+	return req.Args, nil
+}
+
+func TestClientMatchesReplies(t *testing.T) {
+	// The slow procedure only replies once the fast one has.
+	fastDone := make(chan struct{})
+	srv := oncrpc.NewServer()
+	srv.Handle(testProg, testVers, slowProc, func(req *oncrpc.Request) ([]byte, error) {
+		<-fastDone
+		return req.Args, nil
+	})
+	srv.Handle(testProg, testVers, fastProc, echo)
+	c := serve(t, srv)
+
+	slow := c.Go(testProg, testVers, slowProc, oncrpc.AuthNone, []byte("slow"), nil)
+	fast := c.Go(testProg, testVers, fastProc, oncrpc.AuthNone, []byte("fast"), nil)
+	<-fast.Done
+	close(fastDone)
+	<-slow.Done
+	if fast.Error != nil || string(fast.Results) != "fast" {
+		t.Errorf("fast call returned %q, %v", fast.Results, fast.Error)
+	}
+	if slow.Error != nil || string(slow.Results) != "slow" {
+		t.Errorf("slow call returned %q, %v", slow.Results, slow.Error)
+	}
+
+	// Errors come back to the call which caused them.
+	if _, err := c.Call(testProg, testVers+1, fastProc, oncrpc.AuthNone, nil); !errors.As(err, new(oncrpc.AcceptError)) {
+		t.Errorf("call to a missing version returned %v", err)
+	}
+	if results, err := c.Call(testProg, testVers, fastProc, oncrpc.AuthNone, []byte("again")); err != nil || string(results) != "again" {
+		t.Errorf("call after an error returned %q, %v", results, err)
+	}
+}
+
+func TestClientReadError(t *testing.T) {
+	a, b := net.Pipe()
+	c := oncrpc.NewClient(a)
+	defer c.Close()
+
+	// Two calls are waiting when the server hangs up.
+	go func() {
+		for i := 0; i < 2; i++ {
+			if _, err := oncrpc.ReadRecord(b, oncrpc.DefaultMaxRecordSize); err != nil {
+				break
+			}
+		}
+		b.Close()
+	}()
+	var calls []*oncrpc.Call
+	for i := 0; i < 2; i++ {
+		calls = append(calls, c.Go(testProg, testVers, fastProc, oncrpc.AuthNone, nil, nil))
+	}
+	for i, call := range calls {
+		select {
+		case <-call.Done:
+		case <-time.After(10 * time.Second):
+			t.Fatalf("call %d is still waiting", i)
+		}
+		if call.Error != io.ErrUnexpectedEOF {
+			t.Errorf("call %d failed with %v", i, call.Error)
+		}
+	}
+	// Later calls fail at once, with the same error.
+	if _, err := c.Call(testProg, testVers, fastProc, oncrpc.AuthNone, nil); err != io.ErrUnexpectedEOF {
+		t.Errorf("call on a broken connection returned %v", err)
+	}
+}
+
+func TestClientBadReply(t *testing.T) {
+	a, b := net.Pipe()
+	defer b.Close()
+	c := oncrpc.NewClient(a)
+	defer c.Close()
+
+	// Send the call back, which isn't a reply.
+	go func() {
+		if record, err := oncrpc.ReadRecord(b, oncrpc.DefaultMaxRecordSize); err == nil {
+			oncrpc.WriteRecord(b, record)
+		}
+	}()
+	call := c.Go(testProg, testVers, fastProc, oncrpc.AuthNone, nil, nil)
+	<-call.Done
+	if call.Error != oncrpc.ErrNotReply {
+		t.Errorf("call failed with %v", call.Error)
+	}
+}
+
+func TestClientClose(t *testing.T) {
+	srv := oncrpc.NewServer()
+	srv.Handle(testProg, testVers, fastProc, echo)
+	c := serve(t, srv)
+
+	// Calls started while the client closes either finish or fail, and
+	// none are left waiting.
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				call := c.Go(testProg, testVers, fastProc, oncrpc.AuthNone, []byte("args"), nil)
+				select {
+				case <-call.Done:
+				case <-time.After(10 * time.Second):
+					t.Error("call is still waiting after Close")
+					return
+				}
+				if call.Error == nil && string(call.Results) != "args" {
+					t.Errorf("call returned %q", call.Results)
+				}
+			}
+		}()
+	}
+	time.Sleep(time.Millisecond)
+	if err := c.Close(); err != nil {
+		t.Errorf("Close returned %v", err)
+	}
+	wg.Wait()
+
+	if _, err := c.Call(testProg, testVers, fastProc, oncrpc.AuthNone, nil); err != oncrpc.ErrShutdown {
+		t.Errorf("call after Close returned %v", err)
+	}
+	if err := c.Close(); err != oncrpc.ErrShutdown {
+		t.Errorf("second Close returned %v", err)
+	}
+}
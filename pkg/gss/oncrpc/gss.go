@@ -0,0 +1,402 @@
+package oncrpc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+
+	xdr "github.com/davecgh/go-xdr/xdr2"
+	"github.com/twistlock/gss/pkg/gss/mech"
+)
+
+// GSSService says how RPCSEC_GSS protects calls' arguments and results.
+type GSSService uint32
+
+// RPCSEC_GSS services.
+const (
+	// GSSServiceNone authenticates calls, but leaves their arguments and
+	// results unprotected.
+	GSSServiceNone GSSService = 1
+	// GSSServiceIntegrity adds a MIC to arguments and results.
+	GSSServiceIntegrity GSSService = 2
+	// GSSServicePrivacy encrypts arguments and results.
+	GSSServicePrivacy GSSService = 3
+)
+
+const (
+	gssVersion1 = 1
+
+	// Control procedures.
+	gssProcData         = 0
+	gssProcInit         = 1
+	gssProcContinueInit = 2
+	gssProcDestroy      = 3
+
+	// Sequence numbers must stay below this.
+	gssMaxSeq = 0x80000000
+
+	// GSSAPI major status values which servers report during context
+	// creation.
+	gssComplete       = 0
+	gssContinueNeeded = 1
+)
+
+// ErrGSSDestroyed is returned when a call is made using a GSSAuth which has
+// been destroyed.
+var ErrGSSDestroyed = errors.New("RPCSEC_GSS context has been destroyed")
+
+// Caller makes calls.  It is implemented by Client and SyncClient.
+type Caller interface {
+	Call(prog, vers, proc uint32, auth Auth, args []byte) ([]byte, error)
+}
+
+type gssCred struct {
+	Version, GssProc, SeqNum uint32
+	Service                  GSSService
+	Handle                   []byte
+}
+
+type gssInitArg struct {
+	GssToken []byte
+}
+
+type gssInitRes struct {
+	Handle                        []byte
+	GssMajor, GssMinor, SeqWindow uint32
+	GssToken                      []byte
+}
+
+type gssIntegData struct {
+	DatabodyInteg, Checksum []byte
+}
+
+type gssPrivData struct {
+	DatabodyPriv []byte
+}
+
+// GSSAuth is an RPCSEC_GSS session with one program on a server.  It
+// authenticates calls with sequence-numbered credentials, checks the
+// server's reply verifiers, and protects arguments and results as its
+// service requires.  Only version 1 credentials are used, so RFC 5403's
+// channel binding isn't available.  It can be used by more than one
+// goroutine.
+type GSSAuth struct {
+	caller     Caller
+	prog, vers uint32
+	service    GSSService
+	handle     []byte
+	window     uint32
+
+	ctxMu sync.RWMutex // held for reading while ctx is in use
+	ctx   mech.Context
+
+	mu        sync.Mutex
+	seq       uint32
+	destroyed bool
+}
+
+// NewGSSAuth establishes an RPCSEC_GSS session with program prog version
+// vers, making control calls using caller.  ctx should be a newly-created
+// initiator context from either backend, such as one returned by
+// gss.NewInitiatorContext() or proxy.NewInitiatorContext() for
+// "nfs@server.example.com".  The session takes ownership of ctx once it is
+// established, and Destroy releases it; if establishing it fails, the caller
+// is still responsible for releasing ctx.
+func NewGSSAuth(caller Caller, prog, vers uint32, ctx mech.Context, service GSSService) (*GSSAuth, error) {
+	switch service {
+	case GSSServiceNone, GSSServiceIntegrity, GSSServicePrivacy:
+	default:
+		return nil, fmt.Errorf("unknown RPCSEC_GSS service %d", service)
+	}
+	g := &GSSAuth{caller: caller, prog: prog, vers: vers, ctx: ctx, service: service}
+
+	// Pass context tokens using NullProc calls until both ends are done.
+	output, complete, err := ctx.Step(nil)
+	gssProc := uint32(gssProcInit)
+	for {
+		if err != nil {
+			return nil, err
+		}
+		var arg, reply bytes.Buffer
+		var res gssInitRes
+		if _, err = xdr.Marshal(&arg, &gssInitArg{GssToken: output}); err != nil {
+			return nil, err
+		}
+		call := &gssInitCall{gssProc: gssProc, handle: g.handle}
+		results, err := caller.Call(prog, vers, NullProc, onceAuth{call}, arg.Bytes())
+		if err != nil {
+			return nil, err
+		}
+		reply.Write(results)
+		if _, err = xdr.Unmarshal(&reply, &res); err != nil {
+			return nil, err
+		}
+		g.handle = res.Handle
+
+		switch res.GssMajor {
+		case gssComplete:
+			// Process the server's last token, if it sent one.
+			if !complete {
+				output, complete, err = ctx.Step(res.GssToken)
+				if err != nil {
+					return nil, err
+				}
+				if !complete || len(output) != 0 {
+					return nil, errors.New("RPCSEC_GSS server finished establishing the context before we did")
+				}
+			}
+			// The reply's verifier is a MIC over the sequence window.
+			if call.replyVerf.Flavor != FlavorRPCSECGSS {
+				return nil, errors.New("RPCSEC_GSS context creation reply has no RPCSEC_GSS verifier")
+			}
+			if err = ctx.VerifyMIC(uint32Bytes(res.SeqWindow), call.replyVerf.Body); err != nil {
+				return nil, fmt.Errorf("verifying RPCSEC_GSS context creation reply: %v", err)
+			}
+			g.window = res.SeqWindow
+			return g, nil
+		case gssContinueNeeded:
+			if complete {
+				return nil, errors.New("RPCSEC_GSS server expects more context tokens than we have")
+			}
+			output, complete, err = ctx.Step(res.GssToken)
+			gssProc = gssProcContinueInit
+		default:
+			return nil, fmt.Errorf("RPCSEC_GSS context creation failed (major status %#x, minor status %#x)", res.GssMajor, res.GssMinor)
+		}
+	}
+}
+
+// SeqWindow returns the number of calls which the server allows to be
+// outstanding at once.
+func (g *GSSAuth) SeqWindow() uint32 {
+	return g.window
+}
+
+// Destroy asks the server to discard the session's context, and releases it
+// locally whether or not the server could be reached.  Calls made after
+// Destroy starts, and calls which are still waiting for replies once it
+// returns, fail with ErrGSSDestroyed.
+func (g *GSSAuth) Destroy() error {
+	call, err := g.newCall(gssProcDestroy)
+	if err == nil {
+		_, err = g.caller.Call(g.prog, g.vers, NullProc, onceAuth{call}, nil)
+	}
+	// Wait for calls which are using the context to finish with it.
+	g.ctxMu.Lock()
+	defer g.ctxMu.Unlock()
+	if g.ctx != nil {
+		g.ctx.Release()
+		g.ctx = nil
+	}
+	return err
+}
+
+// useContext runs f with the context, unless it has been released.
+func (g *GSSAuth) useContext(f func(ctx mech.Context) error) error {
+	g.ctxMu.RLock()
+	defer g.ctxMu.RUnlock()
+	if g.ctx == nil {
+		return ErrGSSDestroyed
+	}
+	return f(g.ctx)
+}
+
+// NewCall returns the authentication for one call, assigning it the next
+// sequence number.
+func (g *GSSAuth) NewCall() (CallAuth, error) {
+	return g.newCall(gssProcData)
+}
+
+func (g *GSSAuth) newCall(gssProc uint32) (*gssCall, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.destroyed {
+		return nil, ErrGSSDestroyed
+	}
+	if g.seq >= gssMaxSeq {
+		return nil, errors.New("RPCSEC_GSS sequence numbers are exhausted")
+	}
+	// No more calls may start once the server has been asked to destroy
+	// the context.
+	g.destroyed = gssProc == gssProcDestroy
+	seq := g.seq
+	g.seq++
+	return &gssCall{auth: g, gssProc: gssProc, seq: seq, service: g.service, handle: g.handle}, nil
+}
+
+// onceAuth is the Auth for a control call, whose CallAuth is made in advance.
+type onceAuth struct {
+	call CallAuth
+}
+
+func (a onceAuth) NewCall() (CallAuth, error) {
+	return a.call, nil
+}
+
+func uint32Bytes(v uint32) []byte {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, v)
+	return b
+}
+
+func marshalGSSCred(cred gssCred) (OpaqueAuth, error) {
+	var buf bytes.Buffer
+	if cred.Handle == nil {
+		cred.Handle = []byte{}
+	}
+	if _, err := xdr.Marshal(&buf, &cred); err != nil {
+		return OpaqueAuth{}, err
+	}
+	return OpaqueAuth{Flavor: FlavorRPCSECGSS, Body: buf.Bytes()}, nil
+}
+
+// gssCall is a call which uses an established context.
+type gssCall struct {
+	auth         *GSSAuth
+	gssProc, seq uint32
+	service      GSSService
+	handle       []byte
+}
+
+func (c *gssCall) Credential() (OpaqueAuth, error) {
+	return marshalGSSCred(gssCred{Version: gssVersion1, GssProc: c.gssProc, SeqNum: c.seq, Service: c.service, Handle: c.handle})
+}
+
+func (c *gssCall) Verifier(header []byte) (OpaqueAuth, error) {
+	var mic []byte
+	err := c.auth.useContext(func(ctx mech.Context) (err error) {
+		mic, err = ctx.GetMIC(header)
+		return err
+	})
+	if err != nil {
+		return OpaqueAuth{}, err
+	}
+	return OpaqueAuth{Flavor: FlavorRPCSECGSS, Body: mic}, nil
+}
+
+func (c *gssCall) CheckVerifier(verf OpaqueAuth) error {
+	if verf.Flavor != FlavorRPCSECGSS {
+		return errors.New("RPC reply has no RPCSEC_GSS verifier")
+	}
+	return c.auth.useContext(func(ctx mech.Context) error {
+		if err := ctx.VerifyMIC(uint32Bytes(c.seq), verf.Body); err != nil {
+			return fmt.Errorf("verifying RPC reply: %v", err)
+		}
+		return nil
+	})
+}
+
+func (c *gssCall) WrapArgs(args []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	var err error
+
+	// Only data calls are protected.  A destroy call's arguments and
+	// results are void, and go as they are, as Linux sends and expects.
+	if c.gssProc != gssProcData {
+		return args, nil
+	}
+	data := append(uint32Bytes(c.seq), args...)
+	switch c.service {
+	case GSSServiceIntegrity:
+		integ := gssIntegData{DatabodyInteg: data}
+		if err = c.auth.useContext(func(ctx mech.Context) (err error) {
+			integ.Checksum, err = ctx.GetMIC(data)
+			return err
+		}); err != nil {
+			return nil, err
+		}
+		_, err = xdr.Marshal(&buf, &integ)
+	case GSSServicePrivacy:
+		var priv gssPrivData
+		if err = c.auth.useContext(func(ctx mech.Context) (err error) {
+			priv.DatabodyPriv, err = ctx.Wrap(data, true)
+			return err
+		}); err != nil {
+			return nil, err
+		}
+		_, err = xdr.Marshal(&buf, &priv)
+	default:
+		return args, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (c *gssCall) UnwrapResults(results []byte) ([]byte, error) {
+	var data []byte
+
+	if c.gssProc != gssProcData {
+		return results, nil
+	}
+	switch c.service {
+	case GSSServiceIntegrity:
+		var integ gssIntegData
+		if _, err := xdr.Unmarshal(bytes.NewReader(results), &integ); err != nil {
+			return nil, err
+		}
+		if err := c.auth.useContext(func(ctx mech.Context) error {
+			return ctx.VerifyMIC(integ.DatabodyInteg, integ.Checksum)
+		}); err != nil {
+			return nil, fmt.Errorf("verifying RPC results: %w", err)
+		}
+		data = integ.DatabodyInteg
+	case GSSServicePrivacy:
+		var priv gssPrivData
+		if _, err := xdr.Unmarshal(bytes.NewReader(results), &priv); err != nil {
+			return nil, err
+		}
+		var message []byte
+		var conf bool
+		if err := c.auth.useContext(func(ctx mech.Context) (err error) {
+			message, conf, err = ctx.Unwrap(priv.DatabodyPriv)
+			return err
+		}); err != nil {
+			return nil, fmt.Errorf("unwrapping RPC results: %w", err)
+		}
+		if !conf {
+			return nil, errors.New("RPC results were not encrypted")
+		}
+		data = message
+	default:
+		return results, nil
+	}
+	// The results start with the call's sequence number.
+	if len(data) < 4 || binary.BigEndian.Uint32(data) != c.seq {
+		return nil, errors.New("RPC results are for a different sequence number")
+	}
+	return data[4:], nil
+}
+
+// gssInitCall is a context creation call, which has no verifier of its own
+// and whose arguments are a context token.  The reply's verifier is kept for
+// checking once the context is established.
+type gssInitCall struct {
+	gssProc   uint32
+	handle    []byte
+	replyVerf OpaqueAuth
+}
+
+func (c *gssInitCall) Credential() (OpaqueAuth, error) {
+	return marshalGSSCred(gssCred{Version: gssVersion1, GssProc: c.gssProc, Service: GSSServiceNone, Handle: c.handle})
+}
+
+func (c *gssInitCall) Verifier(header []byte) (OpaqueAuth, error) {
+	return emptyAuth(), nil
+}
+
+func (c *gssInitCall) CheckVerifier(verf OpaqueAuth) error {
+	c.replyVerf = verf
+	return nil
+}
+
+func (c *gssInitCall) WrapArgs(args []byte) ([]byte, error) {
+	return args, nil
+}
+
+func (c *gssInitCall) UnwrapResults(results []byte) ([]byte, error) {
+	return results, nil
+}
@@ -0,0 +1,374 @@
+package oncrpc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+
+	xdr "github.com/davecgh/go-xdr/xdr2"
+	"github.com/twistlock/gss/pkg/gss/mech"
+	"github.com/twistlock/gss/pkg/gss/mech/fake"
+)
+
+const (
+	testProg  = 400100
+	testVers  = 1
+	echoProc  = 1
+	gssWindow = 16
+)
+
+func fakeConfig(roundTrips int) fake.Config {
+	return fake.Config{Initiator: "alice@EXAMPLE.COM", Acceptor: "nfs@server.example.com", Key: []byte("key"), Flags: fake.FlagMutual | fake.FlagInteg | fake.FlagConf, RoundTrips: roundTrips}
+}
+
+// gssServer is an RPCSEC_GSS server for one context, which echoes the
+// arguments of calls to echoProc.
+type gssServer struct {
+	acceptor *fake.Context
+	handle   []byte
+
+	// Ways for the server to misbehave.
+	badWindowMIC, badReplyMIC, wrongSeq bool
+	// If hold is set, calls to echoProc are reported on held, and answered
+	// once the context is destroyed.
+	hold, held chan struct{}
+
+	mu        sync.Mutex
+	destroyed bool
+	args      [][]byte // as they arrived, protected
+}
+
+func newGSSServer(t *testing.T, s *gssServer, roundTrips int) *Client {
+	t.Helper()
+	s.acceptor = fake.NewAcceptor(fakeConfig(roundTrips))
+	s.handle = []byte("context 1")
+	srv := NewServer()
+	srv.Handle(testProg, testVers, NullProc, s.control)
+	srv.Handle(testProg, testVers, echoProc, s.echo)
+	a, b := net.Pipe()
+	go srv.ServeConn(b)
+	c := NewClient(a)
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func (s *gssServer) mic(v uint32) OpaqueAuth {
+	mic, _ := s.acceptor.GetMIC(uint32Bytes(v))
+	return OpaqueAuth{Flavor: FlavorRPCSECGSS, Body: mic}
+}
+
+func (s *gssServer) cred(req *Request) (gssCred, error) {
+	var cred gssCred
+	if req.Cred.Flavor != FlavorRPCSECGSS {
+		return cred, AuthError{Why: AuthBadCred}
+	}
+	if _, err := xdr.Unmarshal(bytes.NewReader(req.Cred.Body), &cred); err != nil {
+		return cred, AuthError{Why: AuthBadCred}
+	}
+	if cred.GssProc != gssProcInit && !bytes.Equal(cred.Handle, s.handle) {
+		return cred, AuthError{Why: RPCSECGSSCredProblem}
+	}
+	return cred, nil
+}
+
+func (s *gssServer) control(req *Request) ([]byte, error) {
+	cred, err := s.cred(req)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	switch cred.GssProc {
+	case gssProcInit, gssProcContinueInit:
+		var arg gssInitArg
+		if _, err = xdr.Unmarshal(bytes.NewReader(req.Args), &arg); err != nil {
+			return nil, AcceptError{Stat: GarbageArgs}
+		}
+		res := gssInitRes{Handle: s.handle, GssMajor: gssContinueNeeded, SeqWindow: gssWindow}
+		output, complete, err := s.acceptor.Step(arg.GssToken)
+		if err != nil {
+			res.GssMajor = 0x90000
+		} else if complete {
+			res.GssMajor = gssComplete
+			window := uint32(gssWindow)
+			if s.badWindowMIC {
+				window++
+			}
+			req.ReplyVerf = s.mic(window)
+		}
+		res.GssToken = output
+		if res.GssToken == nil {
+			res.GssToken = []byte{}
+		}
+		_, err = xdr.Marshal(&buf, &res)
+		return buf.Bytes(), err
+	case gssProcDestroy:
+		s.mu.Lock()
+		s.destroyed = true
+		s.mu.Unlock()
+		if s.hold != nil {
+			close(s.hold)
+		}
+		req.ReplyVerf = s.mic(cred.SeqNum)
+		return nil, nil
+	}
+	return nil, AuthError{Why: AuthBadCred}
+}
+
+func (s *gssServer) echo(req *Request) ([]byte, error) {
+	cred, err := s.cred(req)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	destroyed := s.destroyed
+	s.args = append(s.args, req.Args)
+	s.mu.Unlock()
+	if destroyed {
+		return nil, AuthError{Why: RPCSECGSSCtxProblem}
+	}
+	if s.hold != nil {
+		s.held <- struct{}{}
+		<-s.hold
+	}
+
+	// Check and remove the arguments' protection.
+	var data []byte
+	switch cred.Service {
+	case GSSServiceIntegrity:
+		var integ gssIntegData
+		if _, err = xdr.Unmarshal(bytes.NewReader(req.Args), &integ); err != nil {
+			return nil, AcceptError{Stat: GarbageArgs}
+		}
+		if err = s.acceptor.VerifyMIC(integ.DatabodyInteg, integ.Checksum); err != nil {
+			return nil, AcceptError{Stat: GarbageArgs}
+		}
+		data = integ.DatabodyInteg
+	case GSSServicePrivacy:
+		var priv gssPrivData
+		if _, err = xdr.Unmarshal(bytes.NewReader(req.Args), &priv); err != nil {
+			return nil, AcceptError{Stat: GarbageArgs}
+		}
+		if data, _, err = s.acceptor.Unwrap(priv.DatabodyPriv); err != nil {
+			return nil, AcceptError{Stat: GarbageArgs}
+		}
+	default:
+		data = append(uint32Bytes(cred.SeqNum), req.Args...)
+	}
+	if len(data) < 4 || binary.BigEndian.Uint32(data) != cred.SeqNum {
+		return nil, AcceptError{Stat: GarbageArgs}
+	}
+
+	seq := cred.SeqNum
+	if s.wrongSeq {
+		seq++
+	}
+	if s.badReplyMIC {
+		req.ReplyVerf = s.mic(cred.SeqNum + 1)
+	} else {
+		req.ReplyVerf = s.mic(cred.SeqNum)
+	}
+	results := append(uint32Bytes(seq), data[4:]...)
+	var buf bytes.Buffer
+	switch cred.Service {
+	case GSSServiceIntegrity:
+		integ := gssIntegData{DatabodyInteg: results}
+		integ.Checksum, _ = s.acceptor.GetMIC(results)
+		_, err = xdr.Marshal(&buf, &integ)
+	case GSSServicePrivacy:
+		var priv gssPrivData
+		priv.DatabodyPriv, _ = s.acceptor.Wrap(results, true)
+		_, err = xdr.Marshal(&buf, &priv)
+	default:
+		return data[4:], nil
+	}
+	return buf.Bytes(), err
+}
+
+func TestGSSAuth(t *testing.T) {
+	for _, service := range []GSSService{GSSServiceNone, GSSServiceIntegrity, GSSServicePrivacy} {
+		for _, roundTrips := range []int{1, 3} {
+			s := &gssServer{}
+			c := newGSSServer(t, s, roundTrips)
+			initiator := fake.NewInitiator(fakeConfig(roundTrips))
+			auth, err := NewGSSAuth(c, testProg, testVers, initiator, service)
+			if err != nil {
+				t.Fatalf("service %d, %d round trips: %v", service, roundTrips, err)
+			}
+			if auth.SeqWindow() != gssWindow {
+				t.Errorf("service %d: window %d, want %d", service, auth.SeqWindow(), gssWindow)
+			}
+			for i := 0; i < 3; i++ {
+				results, err := c.Call(testProg, testVers, echoProc, auth, []byte("secret"))
+				if err != nil {
+					t.Fatalf("service %d, call %d: %v", service, i, err)
+				}
+				if string(results) != "secret" {
+					t.Errorf("service %d, call %d: results %q", service, i, results)
+				}
+			}
+			if sent := s.args[len(s.args)-1]; bytes.Contains(sent, []byte("secret")) != (service != GSSServicePrivacy) {
+				t.Errorf("service %d: arguments went out as %q", service, sent)
+			}
+			if err = auth.Destroy(); err != nil {
+				t.Error(err)
+			}
+		}
+	}
+
+	if _, err := NewGSSAuth(nil, testProg, testVers, nil, 7); err == nil {
+		t.Error("unknown service was accepted")
+	}
+}
+
+func TestGSSAuthWindowMIC(t *testing.T) {
+	s := &gssServer{badWindowMIC: true}
+	c := newGSSServer(t, s, 1)
+	initiator := fake.NewInitiator(fakeConfig(1))
+	defer initiator.Release()
+	if _, err := NewGSSAuth(c, testProg, testVers, initiator, GSSServiceNone); err == nil {
+		t.Error("a reply with the wrong MIC over the sequence window was accepted")
+	}
+}
+
+func TestGSSAuthReplyChecks(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		server  *gssServer
+		service GSSService
+	}{
+		{"reply verifier", &gssServer{badReplyMIC: true}, GSSServiceNone},
+		{"integrity sequence number", &gssServer{wrongSeq: true}, GSSServiceIntegrity},
+		{"privacy sequence number", &gssServer{wrongSeq: true}, GSSServicePrivacy},
+	} {
+		c := newGSSServer(t, tc.server, 1)
+		auth, err := NewGSSAuth(c, testProg, testVers, fake.NewInitiator(fakeConfig(1)), tc.service)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if _, err = c.Call(testProg, testVers, echoProc, auth, []byte("args")); err == nil {
+			t.Errorf("%s: bad reply was accepted", tc.name)
+		}
+		auth.Destroy()
+	}
+}
+
+func TestGSSAuthDestroy(t *testing.T) {
+	s := &gssServer{}
+	c := newGSSServer(t, s, 1)
+	initiator := fake.NewInitiator(fakeConfig(1))
+	auth, err := NewGSSAuth(c, testProg, testVers, initiator, GSSServiceIntegrity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = auth.Destroy(); err != nil {
+		t.Fatal(err)
+	}
+	if !s.destroyed {
+		t.Error("server wasn't asked to destroy the context")
+	}
+	if _, err = initiator.GetMIC(nil); err != fake.ErrReleased {
+		t.Errorf("context wasn't released: GetMIC returned %v", err)
+	}
+	if _, err = c.Call(testProg, testVers, echoProc, auth, nil); err != ErrGSSDestroyed {
+		t.Errorf("call after Destroy returned %v", err)
+	}
+	if err = auth.Destroy(); err != ErrGSSDestroyed {
+		t.Errorf("second Destroy returned %v", err)
+	}
+}
+
+// releaseChecker counts uses of a context which overlap or follow its
+// release.
+type releaseChecker struct {
+	mech.Context
+
+	mu       sync.Mutex
+	active   int
+	released bool
+	misuses  int
+}
+
+func (r *releaseChecker) use() func() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.released {
+		r.misuses++
+	}
+	r.active++
+	return func() {
+		r.mu.Lock()
+		r.active--
+		r.mu.Unlock()
+	}
+}
+
+func (r *releaseChecker) GetMIC(message []byte) ([]byte, error) {
+	defer r.use()()
+	return r.Context.GetMIC(message)
+}
+
+func (r *releaseChecker) VerifyMIC(message, mic []byte) error {
+	defer r.use()()
+	return r.Context.VerifyMIC(message, mic)
+}
+
+func (r *releaseChecker) Wrap(message []byte, conf bool) ([]byte, error) {
+	defer r.use()()
+	return r.Context.Wrap(message, conf)
+}
+
+func (r *releaseChecker) Unwrap(token []byte) ([]byte, bool, error) {
+	defer r.use()()
+	return r.Context.Unwrap(token)
+}
+
+func (r *releaseChecker) Release() error {
+	r.mu.Lock()
+	if r.active > 0 {
+		r.misuses++
+	}
+	r.released = true
+	r.mu.Unlock()
+	return r.Context.Release()
+}
+
+func TestGSSAuthDestroyRace(t *testing.T) {
+	s := &gssServer{hold: make(chan struct{}), held: make(chan struct{})}
+	c := newGSSServer(t, s, 1)
+	ctx := &releaseChecker{Context: fake.NewInitiator(fakeConfig(1))}
+	auth, err := NewGSSAuth(c, testProg, testVers, ctx, GSSServicePrivacy)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The replies to these calls arrive along with the reply to Destroy's
+	// call, and are checked while it releases the context.
+	const n = 8
+	var wg sync.WaitGroup
+	errs := make(chan error, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := c.Call(testProg, testVers, echoProc, auth, []byte("args")); err != nil {
+				errs <- err
+			}
+		}()
+		<-s.held
+	}
+	auth.Destroy()
+	wg.Wait()
+	close(errs)
+
+	if ctx.misuses != 0 {
+		t.Errorf("context was used %d times during or after its release", ctx.misuses)
+	}
+	for err := range errs {
+		if !errors.Is(err, ErrGSSDestroyed) && err != (AuthError{Why: RPCSECGSSCtxProblem}) {
+			t.Errorf("call racing Destroy returned %v", err)
+		}
+	}
+}
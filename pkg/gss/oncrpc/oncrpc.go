@@ -0,0 +1,309 @@
+/*
+Package oncrpc implements ONC RPC version 2 (RFC 5531) over stream connections.
+
+Messages are carried in records using the record marking standard, split into
+as many fragments as needed.  Client multiplexes calls over one connection and
+matches replies to them by transaction ID, while SyncClient makes one call at a
+time over a connection which may be shared with other code.  Calls are
+authenticated by an Auth: AuthNone, a SysAuth, a GSSAuth for RPCSEC_GSS
+(RFC 2203), or anything else which implements the interface.  Server
+dispatches calls to handlers registered by program, version and procedure.
+*/
+package oncrpc
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync/atomic"
+
+	xdr "github.com/davecgh/go-xdr/xdr2"
+)
+
+// RPCVersion is the version of the protocol which the package speaks.
+const RPCVersion = 2
+
+// NullProc is the procedure which every program answers without doing
+// anything, which is used for pinging servers and for RPCSEC_GSS control
+// messages.
+const NullProc = 0
+
+// Message types.
+const (
+	msgCall  = 0
+	msgReply = 1
+)
+
+// Reply status values.
+const (
+	msgAccepted = 0
+	msgDenied   = 1
+)
+
+// AcceptStat says why a server which accepted a call's credentials didn't run
+// it.
+type AcceptStat uint32
+
+// Accept status values.
+const (
+	Success      AcceptStat = 0
+	ProgUnavail  AcceptStat = 1
+	ProgMismatch AcceptStat = 2
+	ProcUnavail  AcceptStat = 3
+	GarbageArgs  AcceptStat = 4
+	SystemErr    AcceptStat = 5
+)
+
+var acceptStatNames = map[AcceptStat]string{
+	Success:      "success",
+	ProgUnavail:  "program unavailable",
+	ProgMismatch: "program version mismatch",
+	ProcUnavail:  "procedure unavailable",
+	GarbageArgs:  "procedure arguments could not be parsed",
+	SystemErr:    "system-level error",
+}
+
+func (s AcceptStat) String() string {
+	if name, ok := acceptStatNames[s]; ok {
+		return name
+	}
+	return fmt.Sprintf("accept status %d", uint32(s))
+}
+
+// Reject status values.
+const (
+	rpcMismatch = 0
+	authError   = 1
+)
+
+// AuthStat says why a server rejected a call's credentials.
+type AuthStat uint32
+
+// Auth status values, including the two which RPCSEC_GSS adds.
+const (
+	AuthOK               AuthStat = 0
+	AuthBadCred          AuthStat = 1
+	AuthRejectedCred     AuthStat = 2
+	AuthBadVerf          AuthStat = 3
+	AuthRejectedVerf     AuthStat = 4
+	AuthTooWeak          AuthStat = 5
+	AuthInvalidResp      AuthStat = 6
+	AuthFailed           AuthStat = 7
+	RPCSECGSSCredProblem AuthStat = 13
+	RPCSECGSSCtxProblem  AuthStat = 14
+)
+
+var authStatNames = map[AuthStat]string{
+	AuthOK:               "ok",
+	AuthBadCred:          "bad credential",
+	AuthRejectedCred:     "credential rejected",
+	AuthBadVerf:          "bad verifier",
+	AuthRejectedVerf:     "verifier rejected",
+	AuthTooWeak:          "authentication too weak",
+	AuthInvalidResp:      "invalid response verifier",
+	AuthFailed:           "authentication failed",
+	RPCSECGSSCredProblem: "RPCSEC_GSS credential problem",
+	RPCSECGSSCtxProblem:  "RPCSEC_GSS context problem",
+}
+
+func (s AuthStat) String() string {
+	if name, ok := authStatNames[s]; ok {
+		return name
+	}
+	return fmt.Sprintf("auth status %d", uint32(s))
+}
+
+// Flavor identifies a kind of credential or verifier.
+type Flavor uint32
+
+// Authentication flavors.
+const (
+	FlavorNone      Flavor = 0
+	FlavorSys       Flavor = 1
+	FlavorShort     Flavor = 2
+	FlavorRPCSECGSS Flavor = 6
+)
+
+// OpaqueAuth is a credential or verifier.
+type OpaqueAuth struct {
+	Flavor Flavor
+	Body   []byte
+}
+
+// AcceptError is returned when a server accepted a call's credentials but
+// didn't run it.  For ProgMismatch, Low and High are the lowest and highest
+// versions of the program which the server supports.
+type AcceptError struct {
+	Stat      AcceptStat
+	Low, High uint32
+}
+
+func (e AcceptError) Error() string {
+	if e.Stat == ProgMismatch {
+		return fmt.Sprintf("RPC %s (server supports versions %d to %d)", e.Stat, e.Low, e.High)
+	}
+	return "RPC " + e.Stat.String()
+}
+
+// MismatchError is returned when a server doesn't speak RPCVersion.
+type MismatchError struct {
+	Low, High uint32
+}
+
+func (e MismatchError) Error() string {
+	return fmt.Sprintf("RPC version mismatch (server supports versions %d to %d)", e.Low, e.High)
+}
+
+// AuthError is returned when a server rejects a call's credentials.  Since it
+// is comparable, errors.Is(err, AuthError{Why: RPCSECGSSCtxProblem}) can be
+// used to tell when an RPCSEC_GSS context needs to be established again.
+type AuthError struct {
+	Why AuthStat
+}
+
+func (e AuthError) Error() string {
+	return "RPC authentication error: " + e.Why.String()
+}
+
+var (
+	// ErrNotReply is returned when a message which should have been a reply
+	// was something else.
+	ErrNotReply = errors.New("RPC message was not marked as a reply")
+	// ErrShortMessage is returned when a message ends before its header does.
+	ErrShortMessage = errors.New("RPC message is truncated")
+)
+
+var lastXID uint32
+
+func init() {
+	var b [4]byte
+	rand.Read(b[:])
+	lastXID = binary.BigEndian.Uint32(b[:])
+}
+
+// nextXID returns a transaction ID.  IDs start at a random value and count
+// up, so that they don't repeat until 2^32 calls have been made.
+func nextXID() uint32 {
+	return atomic.AddUint32(&lastXID, 1)
+}
+
+type callHeader struct {
+	Xid, MsgType, RPCVers, Prog, Vers, Proc uint32
+	Cred                                    OpaqueAuth
+}
+
+type replyHeader struct {
+	Xid, MsgType, ReplyStat uint32
+}
+
+type acceptedReply struct {
+	Verf       OpaqueAuth
+	AcceptStat AcceptStat
+}
+
+type mismatchInfo struct {
+	Low, High uint32
+}
+
+// emptyAuth returns an AUTH_NONE credential or verifier.
+func emptyAuth() OpaqueAuth {
+	return OpaqueAuth{Flavor: FlavorNone, Body: []byte{}}
+}
+
+// encodeCall formats a call message.
+func encodeCall(xid, prog, vers, proc uint32, auth CallAuth, args []byte) ([]byte, error) {
+	var buf bytes.Buffer
+
+	cred, err := auth.Credential()
+	if err != nil {
+		return nil, err
+	}
+	header := callHeader{Xid: xid, MsgType: msgCall, RPCVers: RPCVersion, Prog: prog, Vers: vers, Proc: proc, Cred: cred}
+	if _, err = xdr.Marshal(&buf, &header); err != nil {
+		return nil, err
+	}
+	// The verifier may be computed over the header.
+	verf, err := auth.Verifier(buf.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	if _, err = xdr.Marshal(&buf, &verf); err != nil {
+		return nil, err
+	}
+	if args, err = auth.WrapArgs(args); err != nil {
+		return nil, err
+	}
+	buf.Write(args)
+	return buf.Bytes(), nil
+}
+
+// replyXID returns the transaction ID of a reply, so that it can be matched
+// to its call before it is decoded.
+func replyXID(record []byte) (uint32, error) {
+	if len(record) < 8 {
+		return 0, ErrShortMessage
+	}
+	if binary.BigEndian.Uint32(record[4:]) != msgReply {
+		return 0, ErrNotReply
+	}
+	return binary.BigEndian.Uint32(record), nil
+}
+
+// decodeReply checks a reply to a call which auth authenticated, and returns
+// the call's results.
+func decodeReply(record []byte, auth CallAuth) ([]byte, error) {
+	var header replyHeader
+
+	r := bytes.NewReader(record)
+	if _, err := xdr.Unmarshal(r, &header); err != nil {
+		return nil, err
+	}
+	if header.MsgType != msgReply {
+		return nil, ErrNotReply
+	}
+	switch header.ReplyStat {
+	case msgAccepted:
+		var accepted acceptedReply
+		if _, err := xdr.Unmarshal(r, &accepted); err != nil {
+			return nil, err
+		}
+		// Check that the reply came from the server we authenticated to.
+		if err := auth.CheckVerifier(accepted.Verf); err != nil {
+			return nil, err
+		}
+		switch accepted.AcceptStat {
+		case Success:
+			return auth.UnwrapResults(record[len(record)-r.Len():])
+		case ProgMismatch:
+			var info mismatchInfo
+			if _, err := xdr.Unmarshal(r, &info); err != nil {
+				return nil, err
+			}
+			return nil, AcceptError{Stat: ProgMismatch, Low: info.Low, High: info.High}
+		}
+		return nil, AcceptError{Stat: accepted.AcceptStat}
+	case msgDenied:
+		var rejectStat uint32
+		if _, err := xdr.Unmarshal(r, &rejectStat); err != nil {
+			return nil, err
+		}
+		switch rejectStat {
+		case rpcMismatch:
+			var info mismatchInfo
+			if _, err := xdr.Unmarshal(r, &info); err != nil {
+				return nil, err
+			}
+			return nil, MismatchError{Low: info.Low, High: info.High}
+		case authError:
+			var why AuthStat
+			if _, err := xdr.Unmarshal(r, &why); err != nil {
+				return nil, err
+			}
+			return nil, AuthError{Why: why}
+		}
+		return nil, fmt.Errorf("RPC call rejected with unknown status %d", rejectStat)
+	}
+	return nil, fmt.Errorf("RPC reply has unknown status %d", header.ReplyStat)
+}
@@ -0,0 +1,129 @@
+package oncrpc
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	lastFragment   = 0x80000000
+	fragmentLength = 0x7fffffff
+
+	// DefaultFragmentSize is the size of the fragments which records are
+	// split into when they are sent.
+	DefaultFragmentSize = 64 * 1024
+	// DefaultMaxRecordSize is the size of the largest record which is
+	// accepted from a peer.
+	DefaultMaxRecordSize = 16 * 1024 * 1024
+)
+
+// ErrRecordTooLarge is returned when a peer sends a record which is larger
+// than the reader will accept.
+var ErrRecordTooLarge = errors.New("RPC record is too large")
+
+// ReadRecord reads a record, which may be made up of any number of
+// fragments, returning ErrRecordTooLarge if it would be longer than
+// maxSize bytes.  io.EOF is returned if the stream ends cleanly before the
+// record starts.
+func ReadRecord(r io.Reader, maxSize int) ([]byte, error) {
+	var record []byte
+	var header [4]byte
+
+	for {
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			if err == io.EOF && record != nil {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		marker := binary.BigEndian.Uint32(header[:])
+		length := int(marker & fragmentLength)
+		if length > maxSize-len(record) {
+			return nil, ErrRecordTooLarge
+		}
+		if record == nil {
+			record = make([]byte, 0, length)
+		}
+		start := len(record)
+		record = append(record, make([]byte, length)...)
+		if _, err := io.ReadFull(r, record[start:]); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		if marker&lastFragment != 0 {
+			return record, nil
+		}
+	}
+}
+
+// WriteRecord writes record as a single record, split into fragments of
+// DefaultFragmentSize bytes.
+func WriteRecord(w io.Writer, record []byte) error {
+	rw := NewRecordWriter(w, DefaultFragmentSize)
+	if _, err := rw.Write(record); err != nil {
+		return err
+	}
+	return rw.EndRecord()
+}
+
+// RecordWriter writes a record whose length isn't known in advance, sending
+// each fragment as soon as it fills up.
+type RecordWriter struct {
+	w    io.Writer
+	size int
+	buf  []byte
+}
+
+// NewRecordWriter returns a RecordWriter which writes fragments of
+// fragmentSize bytes to w.  DefaultFragmentSize is used if fragmentSize is
+// not positive.
+func NewRecordWriter(w io.Writer, fragmentSize int) *RecordWriter {
+	if fragmentSize <= 0 || fragmentSize > fragmentLength {
+		fragmentSize = DefaultFragmentSize
+	}
+	return &RecordWriter{w: w, size: fragmentSize}
+}
+
+// Write adds p to the current record.  Data is held back until it is known
+// whether or not it belongs to the record's last fragment.
+func (rw *RecordWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(rw.buf)+len(p) > rw.size {
+		take := rw.size - len(rw.buf)
+		rw.buf = append(rw.buf, p[:take]...)
+		if err := rw.writeFragment(false); err != nil {
+			// The fragment which failed held the last of p that we
+			// took.
+			return written, err
+		}
+		written += take
+		p = p[take:]
+	}
+	rw.buf = append(rw.buf, p...)
+	return written + len(p), nil
+}
+
+// EndRecord writes the current record's last fragment, which may be empty.
+func (rw *RecordWriter) EndRecord() error {
+	return rw.writeFragment(true)
+}
+
+func (rw *RecordWriter) writeFragment(last bool) error {
+	marker := uint32(len(rw.buf))
+	if last {
+		marker |= lastFragment
+	}
+	fragment := make([]byte, 4+len(rw.buf))
+	binary.BigEndian.PutUint32(fragment, marker)
+	copy(fragment[4:], rw.buf)
+	rw.buf = rw.buf[:0]
+	n, err := rw.w.Write(fragment)
+	if err == nil && n != len(fragment) {
+		err = fmt.Errorf("short write of RPC fragment (%d of %d bytes)", n, len(fragment))
+	}
+	return err
+}
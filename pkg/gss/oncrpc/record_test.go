@@ -0,0 +1,136 @@
+package oncrpc_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/twistlock/gss/pkg/gss/oncrpc"
+)
+
+// fragment returns a fragment holding data.
+func fragment(data string, last bool) []byte {
+	marker := uint32(len(data))
+	if last {
+		marker |= 0x80000000
+	}
+	return append(binary.BigEndian.AppendUint32(nil, marker), data...)
+}
+
+func TestReadRecord(t *testing.T) {
+	// Many fragments, some of them empty, make up one record.
+	var stream []byte
+	for i := 0; i < 1000; i++ {
+		stream = append(stream, fragment("ab"[:i%3], false)...)
+	}
+	stream = append(stream, fragment("end", true)...)
+	stream = append(stream, fragment("next", true)...)
+	var want []byte
+	for i := 0; i < 1000; i++ {
+		want = append(want, "ab"[:i%3]...)
+	}
+	want = append(want, "end"...)
+
+	r := bytes.NewReader(stream)
+	record, err := oncrpc.ReadRecord(r, len(want))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(record, want) {
+		t.Errorf("record of %d bytes, want %d", len(record), len(want))
+	}
+	if record, err = oncrpc.ReadRecord(r, len(want)); err != nil || string(record) != "next" {
+		t.Errorf("second record %q, %v", record, err)
+	}
+	if _, err = oncrpc.ReadRecord(r, len(want)); err != io.EOF {
+		t.Errorf("reading at the end of the stream returned %v", err)
+	}
+}
+
+func TestReadRecordErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		stream []byte
+		max    int
+		err    error
+	}{
+		{"too large in total", append(fragment("abcd", false), fragment("ef", true)...), 5, oncrpc.ErrRecordTooLarge},
+		// The length is checked before anything is allocated.
+		{"huge fragment", []byte{0xff, 0xff, 0xff, 0xff}, oncrpc.DefaultMaxRecordSize, oncrpc.ErrRecordTooLarge},
+		{"truncated marker", []byte{0x80, 0}, 10, io.ErrUnexpectedEOF},
+		{"truncated fragment", fragment("abcd", true)[:6], 10, io.ErrUnexpectedEOF},
+		{"missing last fragment", fragment("abcd", false), 10, io.ErrUnexpectedEOF},
+	} {
+		if _, err := oncrpc.ReadRecord(bytes.NewReader(tc.stream), tc.max); err != tc.err {
+			t.Errorf("%s: ReadRecord() returned %v, want %v", tc.name, err, tc.err)
+		}
+	}
+}
+
+func TestRecordWriter(t *testing.T) {
+	var buf bytes.Buffer
+	rw := oncrpc.NewRecordWriter(&buf, 4)
+	for _, s := range []string{"ab", "cdefghij", "k"} {
+		if n, err := rw.Write([]byte(s)); err != nil || n != len(s) {
+			t.Fatalf("Write(%q) = %d, %v", s, n, err)
+		}
+	}
+	if err := rw.EndRecord(); err != nil {
+		t.Fatal(err)
+	}
+	var want []byte
+	for _, f := range []string{"abcd", "efgh"} {
+		want = append(want, fragment(f, false)...)
+	}
+	want = append(want, fragment("ijk", true)...)
+	if !bytes.Equal(buf.Bytes(), want) {
+		t.Errorf("fragments %q, want %q", buf.Bytes(), want)
+	}
+
+	// An empty record is a single, empty, last fragment.
+	buf.Reset()
+	if err := oncrpc.WriteRecord(&buf, nil); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), fragment("", true)) {
+		t.Errorf("empty record written as %x", buf.Bytes())
+	}
+}
+
+// failingWriter fails every write after the first n.
+type failingWriter struct {
+	n int
+}
+
+var errWrite = errors.New("write failed")
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	if w.n == 0 {
+		return 0, errWrite
+	}
+	w.n--
+	return len(p), nil
+}
+
+func TestRecordWriterPartialWrite(t *testing.T) {
+	for _, tc := range []struct {
+		buffered, writes, want int
+	}{
+		{0, 0, 0},
+		{0, 2, 20},
+		// The first fragment holds 3 buffered bytes from an earlier Write.
+		{3, 1, 7},
+		{3, 2, 17},
+	} {
+		rw := oncrpc.NewRecordWriter(&failingWriter{n: tc.writes}, 10)
+		if tc.buffered > 0 {
+			rw.Write(make([]byte, tc.buffered))
+		}
+		n, err := rw.Write(make([]byte, 35))
+		if err != errWrite || n != tc.want {
+			t.Errorf("%d buffered, %d writes: Write() = %d, %v, want %d", tc.buffered, tc.writes, n, err, tc.want)
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package oncrpc
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"sync"
+
+	xdr "github.com/davecgh/go-xdr/xdr2"
+)
+
+// Request is a call which a Server received.
+type Request struct {
+	Prog, Vers, Proc uint32
+	Cred, Verf       OpaqueAuth
+	Args             []byte
+	// ReplyVerf is the verifier sent with a successful reply.  It starts
+	// out as an AUTH_NONE verifier, and handlers for flavors whose replies
+	// carry verifiers of their own, such as RPCSEC_GSS, can replace it.
+	ReplyVerf OpaqueAuth
+}
+
+// Handler runs a procedure, returning its encoded results.  An AcceptError
+// is reported to the caller using its status, such as GarbageArgs; any
+// other error is reported as SystemErr.
+type Handler func(req *Request) ([]byte, error)
+
+// Server dispatches calls to handlers.  Credentials aren't checked, but they
+// are passed to handlers, which can refuse calls by returning an AuthError.
+// Replies carry AUTH_NONE verifiers unless handlers set Request.ReplyVerf.
+type Server struct {
+	mu    sync.RWMutex
+	progs map[uint32]map[uint32]map[uint32]Handler
+}
+
+// NewServer returns a server with no procedures.
+func NewServer() *Server {
+	return &Server{progs: make(map[uint32]map[uint32]map[uint32]Handler)}
+}
+
+// Handle registers h to run procedure proc of version vers of program prog.
+// NullProc is answered for every registered version unless it is registered
+// explicitly.
+func (s *Server) Handle(prog, vers, proc uint32, h Handler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.progs[prog] == nil {
+		s.progs[prog] = make(map[uint32]map[uint32]Handler)
+	}
+	if s.progs[prog][vers] == nil {
+		s.progs[prog][vers] = make(map[uint32]Handler)
+	}
+	s.progs[prog][vers][proc] = h
+}
+
+// Serve accepts connections from l, serving each one in its own goroutine,
+// until l fails.
+func (s *Server) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go s.ServeConn(conn)
+	}
+}
+
+// ServeConn serves calls from conn until it fails, then closes it.  Calls are
+// run concurrently, so replies may be sent in a different order.
+func (s *Server) ServeConn(conn io.ReadWriteCloser) {
+	var wmu sync.Mutex
+	var wg sync.WaitGroup
+
+	defer conn.Close()
+	for {
+		record, err := ReadRecord(conn, DefaultMaxRecordSize)
+		if err != nil {
+			break
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			reply, err := s.dispatch(record)
+			if err != nil {
+				// Not a call that can be answered.
+				return
+			}
+			wmu.Lock()
+			defer wmu.Unlock()
+			WriteRecord(conn, reply)
+		}()
+	}
+	wg.Wait()
+}
+
+// lookup finds the handler for a call, or returns the AcceptError to reply
+// with.
+func (s *Server) lookup(prog, vers, proc uint32) (Handler, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	versions := s.progs[prog]
+	if versions == nil {
+		return nil, AcceptError{Stat: ProgUnavail}
+	}
+	procs := versions[vers]
+	if procs == nil {
+		e := AcceptError{Stat: ProgMismatch, Low: ^uint32(0)}
+		for v := range versions {
+			if v < e.Low {
+				e.Low = v
+			}
+			if v > e.High {
+				e.High = v
+			}
+		}
+		return nil, e
+	}
+	if h := procs[proc]; h != nil {
+		return h, nil
+	}
+	if proc == NullProc {
+		return func(*Request) ([]byte, error) { return nil, nil }, nil
+	}
+	return nil, AcceptError{Stat: ProcUnavail}
+}
+
+// dispatch runs a call, returning the reply to send.
+func (s *Server) dispatch(record []byte) ([]byte, error) {
+	var header callHeader
+	var verf OpaqueAuth
+	var buf bytes.Buffer
+
+	r := bytes.NewReader(record)
+	if _, err := xdr.Unmarshal(r, &header); err != nil {
+		return nil, err
+	}
+	if header.MsgType != msgCall {
+		return nil, errors.New("RPC message was not marked as a call")
+	}
+	xdr.Marshal(&buf, &replyHeader{Xid: header.Xid, MsgType: msgReply, ReplyStat: msgDenied})
+	if header.RPCVers != RPCVersion {
+		xdr.Marshal(&buf, uint32(rpcMismatch))
+		xdr.Marshal(&buf, &mismatchInfo{Low: RPCVersion, High: RPCVersion})
+		return buf.Bytes(), nil
+	}
+	if _, err := xdr.Unmarshal(r, &verf); err != nil {
+		xdr.Marshal(&buf, uint32(authError))
+		xdr.Marshal(&buf, AuthBadVerf)
+		return buf.Bytes(), nil
+	}
+
+	req := &Request{Prog: header.Prog, Vers: header.Vers, Proc: header.Proc, Cred: header.Cred, Verf: verf, Args: record[len(record)-r.Len():], ReplyVerf: emptyAuth()}
+	h, err := s.lookup(req.Prog, req.Vers, req.Proc)
+	var results []byte
+	if err == nil {
+		results, err = h(req)
+	}
+
+	buf.Reset()
+	var authErr AuthError
+	if errors.As(err, &authErr) {
+		xdr.Marshal(&buf, &replyHeader{Xid: header.Xid, MsgType: msgReply, ReplyStat: msgDenied})
+		xdr.Marshal(&buf, uint32(authError))
+		xdr.Marshal(&buf, authErr.Why)
+		return buf.Bytes(), nil
+	}
+	xdr.Marshal(&buf, &replyHeader{Xid: header.Xid, MsgType: msgReply, ReplyStat: msgAccepted})
+	var acceptErr AcceptError
+	switch {
+	case err == nil:
+		xdr.Marshal(&buf, &acceptedReply{Verf: req.ReplyVerf, AcceptStat: Success})
+		buf.Write(results)
+	case errors.As(err, &acceptErr):
+		xdr.Marshal(&buf, &acceptedReply{Verf: emptyAuth(), AcceptStat: acceptErr.Stat})
+		if acceptErr.Stat == ProgMismatch {
+			xdr.Marshal(&buf, &mismatchInfo{Low: acceptErr.Low, High: acceptErr.High})
+		}
+	default:
+		xdr.Marshal(&buf, &acceptedReply{Verf: emptyAuth(), AcceptStat: SystemErr})
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,373 @@
+/*
+Package pgssenc implements PostgreSQL's GSSAPI transport encryption, which a client requests by sending a GSSENCRequest in place of a startup message.
+
+Client performs the negotiation, establishes a context with the server, and
+returns a Conn which encrypts everything written to it.  A pure-Go driver
+can then run the usual startup protocol over the Conn as if it were a plain
+connection; with pgx, for example, set the connection's DialFunc to a
+Client's DialContext and its sslmode to disable:
+
+	config.DialFunc = pgssenc.NewClient().DialContext
+
+Each packet on the wire is a four-octet big-endian length followed by a
+token, both while the context is being established and afterwards, when each
+token wraps part of the stream.  No packet may be larger than 16 KiB,
+including its length.  Accept implements the server's side of the exchange,
+which is mostly of use for tests.
+*/
+package pgssenc
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+
+	"github.com/twistlock/gss/pkg/gss"
+	"github.com/twistlock/gss/pkg/gss/internal/framed"
+	"github.com/twistlock/gss/pkg/gss/mech"
+	"github.com/twistlock/gss/pkg/gss/proxy"
+)
+
+const (
+	// GSSENCRequestCode is the request code which a client sends in place of
+	// a protocol version to ask for GSSAPI encryption.
+	GSSENCRequestCode = 1234<<16 | 5680
+
+	// MaxPacketSize is the largest packet which either side may send,
+	// including its four-octet length.
+	MaxPacketSize = 16384
+
+	// DefaultService is the service part of the server's host-based service
+	// name, unless the server is configured otherwise.
+	DefaultService = "postgres"
+
+	// wrapOverhead is how many bytes we expect wrapping to add to a packet.
+	// Kerberos adds at most 60 with the enctypes in use today.
+	wrapOverhead = 128
+	maxToken     = MaxPacketSize - 4
+	maxChunk     = maxToken - wrapOverhead
+)
+
+var (
+	// ErrRefused is returned when the server answers a GSSENCRequest with
+	// 'N'.  The connection can still be used without encryption.
+	ErrRefused = errors.New("server does not support GSSAPI encryption")
+	// ErrNotRequested is returned by Accept when the client's first packet
+	// isn't a GSSENCRequest.
+	ErrNotRequested = errors.New("client did not send a GSSENCRequest")
+)
+
+// ServerError is an ErrorResponse sent by the server instead of the packet
+// we expected.
+type ServerError struct {
+	Severity string
+	Code     string
+	Message  string
+}
+
+func (e *ServerError) Error() string {
+	return fmt.Sprintf("postgres server reported %s: %s (SQLSTATE %s)", e.Severity, e.Message, e.Code)
+}
+
+// readServerError reads the rest of an ErrorResponse, whose type byte has
+// already been read.
+func readServerError(r io.Reader) error {
+	var length [4]byte
+	if _, err := io.ReadFull(r, length[:]); err != nil {
+		return unexpectedEOF(err)
+	}
+	size := binary.BigEndian.Uint32(length[:])
+	if size < 4 || size > MaxPacketSize {
+		return fmt.Errorf("server sent a malformed %d byte error response", size)
+	}
+	body := make([]byte, size-4)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return unexpectedEOF(err)
+	}
+	e := &ServerError{}
+	for len(body) > 1 {
+		field := body[0]
+		end := 1
+		for end < len(body) && body[end] != 0 {
+			end++
+		}
+		value := string(body[1:end])
+		switch field {
+		case 'S':
+			e.Severity = value
+		case 'C':
+			e.Code = value
+		case 'M':
+			e.Message = value
+		}
+		if end == len(body) {
+			break
+		}
+		body = body[end+1:]
+	}
+	return e
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// writePacket sends a token preceded by its length.
+func writePacket(w io.Writer, token []byte) error {
+	if len(token) > maxToken {
+		return fmt.Errorf("token of %d bytes exceeds the maximum of %d", len(token), maxToken)
+	}
+	buf := binary.BigEndian.AppendUint32(make([]byte, 0, 4+len(token)), uint32(len(token)))
+	_, err := w.Write(append(buf, token...))
+	return err
+}
+
+// readPacket reads a token sent by writePacket.  A server reports errors
+// during the exchange with an ErrorResponse, which can't be mistaken for a
+// packet since no packet's length starts with 'E'.
+func readPacket(r io.Reader, fromServer bool) ([]byte, error) {
+	var length [4]byte
+	if _, err := io.ReadFull(r, length[:1]); err != nil {
+		return nil, err
+	}
+	if fromServer && length[0] == 'E' {
+		return nil, readServerError(r)
+	}
+	if _, err := io.ReadFull(r, length[1:]); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	size := binary.BigEndian.Uint32(length[:])
+	if size > maxToken {
+		return nil, fmt.Errorf("peer sent a %d byte packet, more than the maximum of %d", size, maxToken)
+	}
+	token := make([]byte, size)
+	if _, err := io.ReadFull(r, token); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	return token, nil
+}
+
+// establish passes tokens between ctx and the peer until ctx is
+// established.  An acceptor starts by reading the initiator's first token.
+func establish(rw io.ReadWriter, ctx mech.Context, initiate bool) error {
+	var input []byte
+	var err error
+
+	if !initiate {
+		if input, err = readPacket(rw, false); err != nil {
+			return err
+		}
+	}
+	for {
+		output, complete, err := ctx.Step(input)
+		if err != nil {
+			return err
+		}
+		if len(output) > 0 {
+			if err = writePacket(rw, output); err != nil {
+				return err
+			}
+		}
+		if complete {
+			return nil
+		}
+		if input, err = readPacket(rw, initiate); err != nil {
+			return err
+		}
+	}
+}
+
+// Client negotiates GSSAPI encryption with PostgreSQL servers.
+type Client struct {
+	// Service is the service part of the server's host-based service name,
+	// which is DefaultService if it is empty.
+	Service string
+
+	// Socket, if set, is the path of a gss-proxy socket through which the
+	// context is established, in place of the local GSSAPI library.
+	Socket string
+	// Cred, if not nil, is used in place of the default initiator
+	// credentials when the local library is in use.  The caller retains
+	// ownership of it.
+	Cred gss.CredHandle
+	// NewContext, if set, creates the initiator context for target, such as
+	// "postgres@db.example.com", in place of either backend.
+	NewContext func(target string) (mech.Context, error)
+}
+
+// NewClient returns a Client which uses the local GSSAPI library and the
+// default initiator credentials.
+func NewClient() *Client {
+	return &Client{}
+}
+
+// NewProxyClient returns a Client which uses the gss-proxy listening at
+// socket.
+func NewProxyClient(socket string) *Client {
+	return &Client{Socket: socket}
+}
+
+// newContext creates an initiator context for target using whichever backend
+// is configured.  The returned function releases anything besides the
+// context which must outlive the exchange.
+func (c *Client) newContext(target string) (mech.Context, func(), error) {
+	switch {
+	case c.NewContext != nil:
+		ctx, err := c.NewContext(target)
+		return ctx, func() {}, err
+	case c.Socket != "":
+		name := &proxy.Name{DisplayName: target, NameType: proxy.NT_HOSTBASED_SERVICE}
+		flags := proxy.Flags{Mutual: true, Replay: true, Sequence: true, Conf: true, Integ: true}
+		return proxy.NewInitiatorContext(c.Socket, nil, name, proxy.MechKerberos5, flags), func() {}, nil
+	}
+	major, minor, name := gss.ImportName(target, gss.C_NT_HOSTBASED_SERVICE)
+	if major != gss.S_COMPLETE {
+		return nil, nil, gss.NewGSSError("importing remote service name", major, minor, nil)
+	}
+	flags := gss.Flags{Mutual: true, Replay: true, Sequence: true, Conf: true, Integ: true}
+	return gss.NewInitiatorContext(c.Cred, name, gss.Mech_krb5, flags), func() { gss.ReleaseName(name) }, nil
+}
+
+// Negotiate sends a GSSENCRequest on conn, a new connection to the server
+// named host, and establishes a context with the server.  If the server
+// refuses, it returns ErrRefused and conn is left open.
+func (c *Client) Negotiate(conn net.Conn, host string) (*Conn, error) {
+	request := binary.BigEndian.AppendUint32(binary.BigEndian.AppendUint32(nil, 8), GSSENCRequestCode)
+	if _, err := conn.Write(request); err != nil {
+		return nil, err
+	}
+	var answer [1]byte
+	if _, err := io.ReadFull(conn, answer[:]); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	switch answer[0] {
+	case 'G':
+	case 'N':
+		return nil, ErrRefused
+	case 'E':
+		return nil, readServerError(conn)
+	default:
+		return nil, fmt.Errorf("unexpected answer %q to GSSENCRequest", answer[0])
+	}
+
+	service := c.Service
+	if service == "" {
+		service = DefaultService
+	}
+	ctx, release, err := c.newContext(service + "@" + host)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	if err = establish(conn, ctx, true); err != nil {
+		ctx.Release()
+		return nil, err
+	}
+	return newConn(conn, ctx), nil
+}
+
+// Dial connects to the server at addr and negotiates encryption.
+func (c *Client) Dial(network, addr string) (net.Conn, error) {
+	return c.DialContext(context.Background(), network, addr)
+}
+
+// DialContext connects to the server at addr and negotiates encryption,
+// giving up if ctx is done first.  Its signature matches pgx's DialFunc.
+func (c *Client) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	// Abandon the exchange if ctx is done first.
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	ec, err := c.Negotiate(conn, host)
+	if err != nil {
+		conn.Close()
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, err
+	}
+	return ec, nil
+}
+
+// Accept reads a client's GSSENCRequest from conn, agrees to it, and
+// establishes ctx.  It returns ErrNotRequested if the client sent some other
+// packet first.  The returned Conn takes ownership of ctx.
+func Accept(conn net.Conn, ctx mech.Context) (*Conn, error) {
+	var request [8]byte
+	if _, err := io.ReadFull(conn, request[:]); err != nil {
+		return nil, err
+	}
+	if binary.BigEndian.Uint32(request[:4]) != 8 || binary.BigEndian.Uint32(request[4:]) != GSSENCRequestCode {
+		return nil, ErrNotRequested
+	}
+	if _, err := conn.Write([]byte{'G'}); err != nil {
+		return nil, err
+	}
+	if err := establish(conn, ctx, false); err != nil {
+		return nil, err
+	}
+	return newConn(conn, ctx), nil
+}
+
+// Conn is a net.Conn which encrypts data written to it, and decrypts data
+// read from it, using an established context.
+type Conn struct {
+	net.Conn
+	protected *framed.Conn
+}
+
+func newConn(conn net.Conn, ctx mech.Context) *Conn {
+	framing := framed.Framing{
+		WriteToken: writePacket,
+		ReadToken: func(r io.Reader) ([]byte, error) {
+			return readPacket(r, false)
+		},
+		MaxChunk: maxChunk,
+		Conf:     true,
+	}
+	return &Conn{Conn: conn, protected: framed.New(conn, ctx, framing)}
+}
+
+// Context returns the context which protects the connection.  On a server,
+// it can report the client's name if it implements mech.PeerNamer.
+func (c *Conn) Context() mech.Context {
+	return c.protected.Context()
+}
+
+// Write encrypts p and sends it in as many packets as it takes.
+func (c *Conn) Write(p []byte) (int, error) {
+	return c.protected.Write(p)
+}
+
+// Read reads data sent by the peer, after decrypting it.
+func (c *Conn) Read(p []byte) (int, error) {
+	return c.protected.Read(p)
+}
+
+// Close closes the connection and releases the context.
+func (c *Conn) Close() error {
+	return c.protected.Close()
+}
@@ -0,0 +1,342 @@
+package pgssenc_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/twistlock/gss/pkg/gss/mech"
+	"github.com/twistlock/gss/pkg/gss/mech/fake"
+	"github.com/twistlock/gss/pkg/gss/pgssenc"
+	"github.com/twistlock/gss/pkg/gss/pgssenc/pgssenctest"
+)
+
+const (
+	client = "alice@EXAMPLE.COM"
+	flags  = fake.FlagMutual | fake.FlagInteg | fake.FlagConf
+)
+
+func acceptorConfig(host string, roundTrips int) fake.Config {
+	return fake.Config{Acceptor: "postgres@" + host, Key: []byte("key"), Flags: flags, RoundTrips: roundTrips}
+}
+
+func fakeClient(key string, roundTrips int) *pgssenc.Client {
+	return &pgssenc.Client{
+		NewContext: func(target string) (mech.Context, error) {
+			return fake.NewInitiator(fake.Config{Initiator: client, Acceptor: target, Key: []byte(key), Flags: flags, RoundTrips: roundTrips}), nil
+		},
+	}
+}
+
+// message builds a frontend message, or a startup message if typ is 0.
+func message(typ byte, body []byte) []byte {
+	var b []byte
+	if typ != 0 {
+		b = append(b, typ)
+	}
+	b = binary.BigEndian.AppendUint32(b, uint32(4+len(body)))
+	return append(b, body...)
+}
+
+// readUntilReady reads backend messages until ReadyForQuery, returning the
+// bodies of any DataRows.
+func readUntilReady(r io.Reader) ([][]byte, error) {
+	var rows [][]byte
+	for {
+		var header [5]byte
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			return nil, err
+		}
+		body := make([]byte, binary.BigEndian.Uint32(header[1:])-4)
+		if _, err := io.ReadFull(r, body); err != nil {
+			return nil, err
+		}
+		switch header[0] {
+		case 'D':
+			rows = append(rows, body)
+		case 'Z':
+			return rows, nil
+		}
+	}
+}
+
+// rawServer accepts one connection and passes it to handle.
+func rawServer(t *testing.T, handle func(net.Conn)) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		handle(conn)
+	}()
+	return l.Addr().String()
+}
+
+// readRequest reads a GSSENCRequest.
+func readRequest(conn net.Conn) error {
+	var request [8]byte
+	_, err := io.ReadFull(conn, request[:])
+	return err
+}
+
+// TestServer runs a session against pgssenctest, with contexts which take
+// one and several round trips to establish.
+func TestServer(t *testing.T) {
+	for _, roundTrips := range []int{1, 3} {
+		srv, err := pgssenctest.NewServer(fake.AcceptorFactory(acceptorConfig("127.0.0.1", roundTrips)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer srv.Close()
+
+		conn, err := fakeClient("key", roundTrips).Dial("tcp", srv.Addr)
+		if err != nil {
+			t.Fatalf("%d round trips: %v", roundTrips, err)
+		}
+		defer conn.Close()
+		startup := binary.BigEndian.AppendUint32(nil, 3<<16)
+		startup = append(startup, "user\x00alice\x00database\x00test\x00\x00"...)
+		if _, err = conn.Write(message(0, startup)); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = readUntilReady(conn); err != nil {
+			t.Fatalf("%d round trips: reading startup response: %v", roundTrips, err)
+		}
+		if _, err = conn.Write(message('Q', []byte("SELECT current_user\x00"))); err != nil {
+			t.Fatal(err)
+		}
+		rows, err := readUntilReady(conn)
+		if err != nil {
+			t.Fatalf("%d round trips: reading query response: %v", roundTrips, err)
+		}
+		if len(rows) != 1 || !bytes.HasSuffix(rows[0], []byte(client)) {
+			t.Errorf("%d round trips: got rows %q, want one holding %q", roundTrips, rows, client)
+		}
+		conn.Write(message('X', nil))
+
+		startups := srv.Startups()
+		if len(startups) != 1 || startups[0].SrcName != client || startups[0].Parameters["database"] != "test" {
+			t.Errorf("%d round trips: server recorded startups %+v", roundTrips, startups)
+		}
+	}
+}
+
+func TestWrongKey(t *testing.T) {
+	srv, err := pgssenctest.NewServer(fake.AcceptorFactory(acceptorConfig("127.0.0.1", 1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	if conn, err := fakeClient("other key", 1).Dial("tcp", srv.Addr); err == nil {
+		conn.Close()
+		t.Error("client with the wrong key connected")
+	}
+}
+
+// TestRefused checks that a client which is answered with 'N' can go on to
+// use the connection without encryption.
+func TestRefused(t *testing.T) {
+	addr := rawServer(t, func(conn net.Conn) {
+		if readRequest(conn) != nil {
+			return
+		}
+		conn.Write([]byte{'N'})
+		io.Copy(conn, conn)
+	})
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err = fakeClient("key", 1).Negotiate(conn, "127.0.0.1"); err != pgssenc.ErrRefused {
+		t.Fatalf("got error %v, want %v", err, pgssenc.ErrRefused)
+	}
+	if _, err = conn.Write([]byte("plain")); err != nil {
+		t.Fatal(err)
+	}
+	got := make([]byte, 5)
+	if _, err = io.ReadFull(conn, got); err != nil || string(got) != "plain" {
+		t.Errorf("after refusal, read %q, %v", got, err)
+	}
+}
+
+func TestServerError(t *testing.T) {
+	errorResponse := message('E', []byte("SFATAL\x00C08P01\x00Munsupported frontend protocol\x00\x00"))
+	want := pgssenc.ServerError{Severity: "FATAL", Code: "08P01", Message: "unsupported frontend protocol"}
+
+	// An old server answers the request itself with an error, and a server
+	// can also fail the exchange in place of sending a token.
+	for _, answer := range [][]byte{errorResponse, append([]byte{'G'}, errorResponse...)} {
+		addr := rawServer(t, func(conn net.Conn) {
+			if readRequest(conn) != nil {
+				return
+			}
+			conn.Write(answer)
+		})
+		_, err := fakeClient("key", 1).Dial("tcp", addr)
+		var serverErr *pgssenc.ServerError
+		if !errors.As(err, &serverErr) {
+			t.Fatalf("answer %q: got error %v, want a ServerError", answer[0], err)
+		}
+		if *serverErr != want {
+			t.Errorf("answer %q: got %+v, want %+v", answer[0], *serverErr, want)
+		}
+	}
+}
+
+func TestUnexpectedAnswer(t *testing.T) {
+	addr := rawServer(t, func(conn net.Conn) {
+		if readRequest(conn) != nil {
+			return
+		}
+		conn.Write([]byte{'S'})
+	})
+	if _, err := fakeClient("key", 1).Dial("tcp", addr); err == nil || !strings.Contains(err.Error(), "unexpected answer") {
+		t.Errorf("got error %v, want an unexpected answer", err)
+	}
+}
+
+// TestOversizePacket checks that packets larger than MaxPacketSize are
+// refused by both sides, during the exchange and afterwards.
+func TestOversizePacket(t *testing.T) {
+	oversize := binary.BigEndian.AppendUint32(nil, pgssenc.MaxPacketSize-3)
+
+	// From the server, during the exchange.
+	addr := rawServer(t, func(conn net.Conn) {
+		if readRequest(conn) != nil {
+			return
+		}
+		conn.Write(append([]byte{'G'}, oversize...))
+		io.Copy(io.Discard, conn)
+	})
+	if _, err := fakeClient("key", 1).Dial("tcp", addr); err == nil || !strings.Contains(err.Error(), "maximum") {
+		t.Errorf("client: got error %v, want a refusal of the packet", err)
+	}
+
+	// From the client, during the exchange.
+	server, peer := net.Pipe()
+	go func() {
+		request := binary.BigEndian.AppendUint32(binary.BigEndian.AppendUint32(nil, 8), pgssenc.GSSENCRequestCode)
+		peer.Write(request)
+		io.ReadFull(peer, make([]byte, 1))
+		peer.Write(oversize)
+		peer.Close()
+	}()
+	ctx := fake.NewAcceptor(acceptorConfig("127.0.0.1", 1))
+	if _, err := pgssenc.Accept(server, ctx); err == nil || !strings.Contains(err.Error(), "maximum") {
+		t.Errorf("Accept: got error %v, want a refusal of the packet", err)
+	}
+	ctx.Release()
+	server.Close()
+
+	// On an established connection.
+	c, s := pipe(t)
+	go func() {
+		s.Conn.Write(oversize)
+	}()
+	if _, err := c.Read(make([]byte, 1)); err == nil || !strings.Contains(err.Error(), "maximum") {
+		t.Errorf("Read: got error %v, want a refusal of the packet", err)
+	}
+}
+
+func TestNotRequested(t *testing.T) {
+	server, peer := net.Pipe()
+	defer server.Close()
+	go func() {
+		startup := binary.BigEndian.AppendUint32(nil, 3<<16)
+		peer.Write(message(0, append(startup, 0)))
+		peer.Close()
+	}()
+	ctx := fake.NewAcceptor(acceptorConfig("127.0.0.1", 1))
+	defer ctx.Release()
+	if _, err := pgssenc.Accept(server, ctx); err != pgssenc.ErrNotRequested {
+		t.Errorf("got error %v, want %v", err, pgssenc.ErrNotRequested)
+	}
+}
+
+// pipe negotiates encryption over an in-memory connection, returning the
+// client's and the server's ends.
+func pipe(t *testing.T) (*pgssenc.Conn, *pgssenc.Conn) {
+	t.Helper()
+	clientConn, serverConn := net.Pipe()
+	type result struct {
+		conn *pgssenc.Conn
+		err  error
+	}
+	accepted := make(chan result, 1)
+	go func() {
+		c, err := pgssenc.Accept(serverConn, fake.NewAcceptor(acceptorConfig("db.example.com", 1)))
+		accepted <- result{c, err}
+	}()
+	c, err := fakeClient("key", 1).Negotiate(clientConn, "db.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := <-accepted
+	if r.err != nil {
+		t.Fatal(r.err)
+	}
+	t.Cleanup(func() {
+		c.Close()
+		r.conn.Close()
+	})
+	return c, r.conn
+}
+
+// TestConnRoundTrip sends more than fits in one packet each way, checking
+// that every packet on the wire is within the limit and encrypted.
+func TestConnRoundTrip(t *testing.T) {
+	c, s := pipe(t)
+	if name := s.Context().(mech.PeerNamer).PeerName(); name != client {
+		t.Errorf("server saw client %q, want %q", name, client)
+	}
+	data := bytes.Repeat([]byte("0123456789abcdef"), 4096)
+
+	go c.Write(data)
+	var got []byte
+	for len(got) < len(data) {
+		var length [4]byte
+		if _, err := io.ReadFull(s.Conn, length[:]); err != nil {
+			t.Fatal(err)
+		}
+		size := binary.BigEndian.Uint32(length[:])
+		if size > pgssenc.MaxPacketSize-4 {
+			t.Fatalf("client sent a %d byte packet", size)
+		}
+		token := make([]byte, size)
+		if _, err := io.ReadFull(s.Conn, token); err != nil {
+			t.Fatal(err)
+		}
+		msg, conf, err := s.Context().Unwrap(token)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !conf {
+			t.Fatal("client sent a packet without encrypting it")
+		}
+		got = append(got, msg...)
+	}
+	if !bytes.Equal(got, data) {
+		t.Error("server received different data")
+	}
+
+	go s.Write(data)
+	got = make([]byte, len(data))
+	if _, err := io.ReadFull(c, got); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Error("client received different data")
+	}
+}
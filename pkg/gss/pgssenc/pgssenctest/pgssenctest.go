@@ -0,0 +1,245 @@
+/*
+Package pgssenctest runs an in-process stub PostgreSQL server which only accepts GSSAPI-encrypted connections, for testing clients which use the pgssenc package.
+
+The server accepts contexts using a keytab, such as one made by a
+gsstest.KDC, or contexts created by any mech.Factory.  Once a connection is
+encrypted, it accepts any startup message without further authentication,
+and answers every simple query with a single row holding the principal name
+which the client authenticated as.  It doesn't implement the extended query
+protocol, so drivers must use simple queries; with pgx, set the
+connection's DefaultQueryExecMode to QueryExecModeSimpleProtocol.
+*/
+package pgssenctest
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+
+	"github.com/twistlock/gss/pkg/gss"
+	"github.com/twistlock/gss/pkg/gss/credstore"
+	"github.com/twistlock/gss/pkg/gss/mech"
+	"github.com/twistlock/gss/pkg/gss/pgssenc"
+)
+
+const (
+	protocolVersion = 3 << 16
+	textOID         = 25
+)
+
+var errMalformed = errors.New("malformed message")
+
+// Startup is a startup message which a client sent over an encrypted
+// connection.
+type Startup struct {
+	// Parameters are the startup parameters, such as "user" and
+	// "database".
+	Parameters map[string]string
+	SrcName    string
+}
+
+// Server is a running PostgreSQL server.
+type Server struct {
+	// Addr is the address which the server listens on.
+	Addr string
+
+	factory  mech.Factory
+	cred     gss.CredHandle
+	listener net.Listener
+	wg       sync.WaitGroup
+
+	mu       sync.Mutex
+	startups []Startup
+}
+
+// NewServer starts a server on the loopback interface which accepts clients
+// using contexts created by factory.
+func NewServer(factory mech.Factory) (*Server, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{Addr: l.Addr().String(), factory: factory, listener: l}
+	s.wg.Add(1)
+	go s.serve()
+	return s, nil
+}
+
+// NewKeytabServer starts a server which accepts clients using the keys in
+// keytab.
+func NewKeytabServer(keytab string) (*Server, error) {
+	major, minor, cred, _, _ := gss.AcquireCredFrom(nil, gss.C_INDEFINITE, nil, gss.C_ACCEPT, credstore.New().SetKeytab(keytab))
+	if major != gss.S_COMPLETE {
+		return nil, gss.NewGSSError("acquiring acceptor credentials", major, minor, nil)
+	}
+	s, err := NewServer(func() (mech.Context, error) {
+		return gss.NewAcceptorContext(cred), nil
+	})
+	if err != nil {
+		gss.ReleaseCred(cred)
+		return nil, err
+	}
+	s.cred = cred
+	return s, nil
+}
+
+// Startups returns the startup messages which have been received so far.
+func (s *Server) Startups() []Startup {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Startup(nil), s.startups...)
+}
+
+// Close stops the server and waits for its connections to finish.
+func (s *Server) Close() error {
+	err := s.listener.Close()
+	s.wg.Wait()
+	if s.cred != nil {
+		gss.ReleaseCred(s.cred)
+		s.cred = nil
+	}
+	return err
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer conn.Close()
+			s.handle(conn)
+		}()
+	}
+}
+
+func (s *Server) handle(conn net.Conn) {
+	ctx, err := s.factory()
+	if err != nil {
+		return
+	}
+	ec, err := pgssenc.Accept(conn, ctx)
+	if err != nil {
+		ctx.Release()
+		return
+	}
+	defer ec.Close()
+	var srcName string
+	if namer, ok := ctx.(mech.PeerNamer); ok {
+		srcName = namer.PeerName()
+	}
+
+	params, err := readStartup(ec)
+	if err != nil {
+		return
+	}
+	s.mu.Lock()
+	s.startups = append(s.startups, Startup{Parameters: params, SrcName: srcName})
+	s.mu.Unlock()
+
+	var out bytes.Buffer
+	writeMessage(&out, 'R', binary.BigEndian.AppendUint32(nil, 0)) // AuthenticationOk
+	for _, p := range [][2]string{{"server_version", "16.0"}, {"server_encoding", "UTF8"}, {"client_encoding", "UTF8"}, {"standard_conforming_strings", "on"}, {"DateStyle", "ISO, MDY"}} {
+		writeMessage(&out, 'S', cstrings(p[0], p[1]))
+	}
+	writeMessage(&out, 'K', make([]byte, 8)) // BackendKeyData
+	writeMessage(&out, 'Z', []byte{'I'})
+	if _, err = ec.Write(out.Bytes()); err != nil {
+		return
+	}
+
+	for {
+		typ, _, err := readMessage(ec)
+		if err != nil {
+			return
+		}
+		out.Reset()
+		switch typ {
+		case 'X':
+			return
+		case 'Q':
+			// RowDescription, DataRow and CommandComplete.
+			desc := binary.BigEndian.AppendUint16(nil, 1)
+			desc = append(desc, cstrings("srcname")...)
+			desc = binary.BigEndian.AppendUint32(desc, 0)
+			desc = binary.BigEndian.AppendUint16(desc, 0)
+			desc = binary.BigEndian.AppendUint32(desc, textOID)
+			desc = binary.BigEndian.AppendUint16(desc, 0xffff)
+			desc = binary.BigEndian.AppendUint32(desc, 0xffffffff)
+			desc = binary.BigEndian.AppendUint16(desc, 0)
+			writeMessage(&out, 'T', desc)
+			row := binary.BigEndian.AppendUint16(nil, 1)
+			row = binary.BigEndian.AppendUint32(row, uint32(len(srcName)))
+			writeMessage(&out, 'D', append(row, srcName...))
+			writeMessage(&out, 'C', cstrings("SELECT 1"))
+		default:
+			writeMessage(&out, 'E', cstrings("SERROR", "C0A000", "Monly simple queries are supported", ""))
+		}
+		writeMessage(&out, 'Z', []byte{'I'})
+		if _, err = ec.Write(out.Bytes()); err != nil {
+			return
+		}
+	}
+}
+
+// readStartup reads a startup message and returns its parameters.
+func readStartup(r io.Reader) (map[string]string, error) {
+	var header [8]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+	size := binary.BigEndian.Uint32(header[:4])
+	if size < 8 || size > pgssenc.MaxPacketSize || binary.BigEndian.Uint32(header[4:]) != protocolVersion {
+		return nil, errMalformed
+	}
+	body := make([]byte, size-8)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+	fields := bytes.Split(bytes.TrimRight(body, "\x00"), []byte{0})
+	params := make(map[string]string)
+	for i := 0; i+1 < len(fields); i += 2 {
+		params[string(fields[i])] = string(fields[i+1])
+	}
+	return params, nil
+}
+
+// readMessage reads a message sent after the startup message.
+func readMessage(r io.Reader) (byte, []byte, error) {
+	var header [5]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return 0, nil, err
+	}
+	size := binary.BigEndian.Uint32(header[1:])
+	if size < 4 || size > 1<<24 {
+		return 0, nil, errMalformed
+	}
+	body := make([]byte, size-4)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, nil, err
+	}
+	return header[0], body, nil
+}
+
+// writeMessage appends a message to buf.
+func writeMessage(buf *bytes.Buffer, typ byte, body []byte) {
+	buf.WriteByte(typ)
+	buf.Write(binary.BigEndian.AppendUint32(nil, uint32(4+len(body))))
+	buf.Write(body)
+}
+
+// cstrings returns each string followed by a NUL.
+func cstrings(s ...string) []byte {
+	var b []byte
+	for _, v := range s {
+		b = append(append(b, v...), 0)
+	}
+	return b
+}
@@ -0,0 +1,210 @@
+/*
+Package credmgr keeps a set of gss-proxy credentials fresh for long-running services.
+
+A Manager acquires credentials from a Source, tracks their remaining lifetime
+using the time records of their elements, and acquires replacements before
+they expire.  Callers borrow the current credentials with Get() and give them
+back when they're done with them, so that credentials which have been replaced
+are only released once nobody is using them any more.
+*/
+package credmgr
+
+import (
+	"encoding/asn1"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/twistlock/gss/pkg/gss/internal/renewal"
+	"github.com/twistlock/gss/pkg/gss/proxy"
+)
+
+const (
+	// DefaultRenewBefore is used if Options.RenewBefore is not set.
+	DefaultRenewBefore = renewal.DefaultRenewBefore
+	// DefaultMinInterval is used if Options.MinInterval is not set.
+	DefaultMinInterval = renewal.DefaultMinInterval
+	// DefaultRetryInterval is used if Options.RetryInterval is not set.
+	DefaultRetryInterval = renewal.DefaultRetryInterval
+	// DefaultMaxRetryInterval is used if Options.MaxRetryInterval is not set.
+	DefaultMaxRetryInterval = renewal.DefaultMaxRetryInterval
+)
+
+/* Source obtains fresh credentials for a Manager, and releases them once they've been replaced. */
+type Source interface {
+	Acquire() (*proxy.Cred, error)
+	Release(cred *proxy.Cred) error
+}
+
+/* ProxySource acquires credentials using the gss-proxy listening at Socket.  The proxy obtains them using the keytab or password configured for the service it matches us to, so asking it again is all it takes to renew them. */
+type ProxySource struct {
+	Socket      string
+	DesiredName *proxy.Name
+	Usage       int
+	Mechs       []asn1.ObjectIdentifier
+	Options     []proxy.Option
+}
+
+/* call connects to the proxy, sets up a call context, and runs fn. */
+func (s ProxySource) call(fn func(conn *net.Conn, call *proxy.CallCtx) error) error {
+	var call proxy.CallCtx
+
+	conn, err := net.Dial("unix", s.Socket)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	gccr, err := proxy.GetCallContext(&conn, &call, nil)
+	if err != nil {
+		return err
+	}
+	if gccr.Status.MajorStatus != proxy.S_COMPLETE {
+		return statusError("getting a call context", gccr.Status)
+	}
+	return fn(&conn, &call)
+}
+
+func (s ProxySource) Acquire() (cred *proxy.Cred, err error) {
+	err = s.call(func(conn *net.Conn, call *proxy.CallCtx) error {
+		acr, err := proxy.AcquireCred(conn, call, nil, false, s.DesiredName, proxy.C_INDEFINITE, s.Mechs, s.Usage, proxy.C_INDEFINITE, proxy.C_INDEFINITE, s.Options)
+		if err != nil {
+			return err
+		}
+		if acr.Status.MajorStatus != proxy.S_COMPLETE || acr.OutputCredHandle == nil {
+			return statusError("acquiring credentials", acr.Status)
+		}
+		cred = acr.OutputCredHandle
+		return nil
+	})
+	return
+}
+
+func (s ProxySource) Release(cred *proxy.Cred) error {
+	if !cred.NeedsRelease {
+		return nil
+	}
+	return s.call(func(conn *net.Conn, call *proxy.CallCtx) error {
+		rcr, err := proxy.ReleaseCred(conn, call, cred)
+		if err != nil {
+			return err
+		}
+		if rcr.Status.MajorStatus != proxy.S_COMPLETE {
+			return statusError("releasing credentials", rcr.Status)
+		}
+		return nil
+	})
+}
+
+func statusError(when string, status proxy.Status) error {
+	if status.MinorStatusString != "" {
+		return fmt.Errorf("%s while %s (%s)", status.MajorStatusString, when, status.MinorStatusString)
+	}
+	return fmt.Errorf("%s while %s", status.MajorStatusString, when)
+}
+
+/* Lifetime computes how long cred will remain usable for usage, based on the shortest lifetime of any of its elements.  A negative value means that it doesn't expire. */
+func Lifetime(cred *proxy.Cred, usage int) time.Duration {
+	var lifetime uint64 = proxy.C_INDEFINITE
+
+	for _, element := range cred.Elements {
+		if usage&proxy.C_INITIATE != 0 && element.CredUsage&proxy.C_INITIATE != 0 && element.InitiatorTimeRec < lifetime {
+			lifetime = element.InitiatorTimeRec
+		}
+		if usage&proxy.C_ACCEPT != 0 && element.CredUsage&proxy.C_ACCEPT != 0 && element.AcceptorTimeRec < lifetime {
+			lifetime = element.AcceptorTimeRec
+		}
+	}
+	if lifetime == proxy.C_INDEFINITE {
+		return -1
+	}
+	return time.Duration(lifetime) * time.Second
+}
+
+/* Event describes the outcome of an attempt to renew credentials. */
+type Event = renewal.Event
+
+/* Stats counts a Manager's renewal attempts. */
+type Stats = renewal.Stats
+
+/* Options control how a Manager treats its credentials.  The zero value is usable. */
+type Options struct {
+	// Usage selects which elements' time records are checked.  If it is 0, proxy.C_BOTH is assumed.
+	Usage int
+	// RenewBefore is how long before expiration the credentials are renewed.  Credentials whose lifetime is too short for that are renewed halfway through their remaining lifetime instead.
+	RenewBefore time.Duration
+	// MinInterval is the shortest time to wait between scheduled renewals.
+	MinInterval time.Duration
+	// RetryInterval is how long to wait before trying again after a failed renewal.  It doubles after each consecutive failure, up to MaxRetryInterval.
+	RetryInterval    time.Duration
+	MaxRetryInterval time.Duration
+	// OnEvent, if set, is called after every renewal attempt.
+	OnEvent func(Event)
+}
+
+/* backend adapts a Source to the renewal package. */
+type backend struct {
+	source Source
+	usage  int
+}
+
+func (b backend) Acquire() (*proxy.Cred, time.Duration, error) {
+	cred, err := b.source.Acquire()
+	if err != nil {
+		return nil, 0, err
+	}
+	return cred, Lifetime(cred, b.usage), nil
+}
+
+func (b backend) Release(cred *proxy.Cred) {
+	/* Don't make whoever gave up the last reference wait while we talk to the proxy. */
+	go b.source.Release(cred)
+}
+
+/* Manager hands out credentials which it renews in the background. */
+type Manager struct {
+	m *renewal.Manager[*proxy.Cred]
+}
+
+/* New acquires an initial set of credentials from source and starts renewing them in the background.  The Manager should be shut down using Close() when it's no longer needed. */
+func New(source Source, opts Options) (*Manager, error) {
+	if opts.Usage == 0 {
+		opts.Usage = proxy.C_BOTH
+	}
+	m, err := renewal.New[*proxy.Cred](backend{source: source, usage: opts.Usage}, renewal.Options{
+		RenewBefore:      opts.RenewBefore,
+		MinInterval:      opts.MinInterval,
+		RetryInterval:    opts.RetryInterval,
+		MaxRetryInterval: opts.MaxRetryInterval,
+		OnEvent:          opts.OnEvent,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Manager{m: m}, nil
+}
+
+/* Get returns the current credentials, along with a function which must be called once the caller is finished with them. */
+func (m *Manager) Get() (cred *proxy.Cred, done func()) {
+	return m.m.Get()
+}
+
+/* Expired tells the Manager that cred was rejected as expired, so that it can be replaced without waiting for the next scheduled renewal. */
+func (m *Manager) Expired(cred *proxy.Cred) {
+	m.m.Expired(cred)
+}
+
+/* Renew acquires new credentials immediately, without waiting for the current ones to near expiration. */
+func (m *Manager) Renew() error {
+	return m.m.Renew()
+}
+
+/* Stats returns counts of the renewals which have been attempted so far. */
+func (m *Manager) Stats() Stats {
+	return m.m.Stats()
+}
+
+/* Close stops renewing the credentials, and releases them once they're no longer in use.  Calling it more than once has no further effect. */
+func (m *Manager) Close() {
+	m.m.Close()
+}
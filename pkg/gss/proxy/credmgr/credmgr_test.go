@@ -0,0 +1,149 @@
+package credmgr
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/twistlock/gss/pkg/gss/proxy"
+)
+
+func element(usage int, initiator, acceptor uint64) proxy.CredElement {
+	return proxy.CredElement{CredUsage: usage, InitiatorTimeRec: initiator, AcceptorTimeRec: acceptor}
+}
+
+func TestLifetime(t *testing.T) {
+	cred := &proxy.Cred{Elements: []proxy.CredElement{
+		element(proxy.C_INITIATE, 600, 60),
+		element(proxy.C_ACCEPT, 30, 3600),
+		element(proxy.C_BOTH, 1200, 1800),
+	}}
+	for _, tc := range []struct {
+		usage int
+		want  time.Duration
+	}{
+		// Each usage only looks at the time records of elements which
+		// can be used that way.
+		{proxy.C_INITIATE, 600 * time.Second},
+		{proxy.C_ACCEPT, 1800 * time.Second},
+		{proxy.C_BOTH, 600 * time.Second},
+	} {
+		if got := Lifetime(cred, tc.usage); got != tc.want {
+			t.Errorf("Lifetime(usage %d) = %v, want %v", tc.usage, got, tc.want)
+		}
+	}
+
+	indefinite := &proxy.Cred{Elements: []proxy.CredElement{element(proxy.C_BOTH, proxy.C_INDEFINITE, proxy.C_INDEFINITE)}}
+	if got := Lifetime(indefinite, proxy.C_BOTH); got >= 0 {
+		t.Errorf("Lifetime(indefinite) = %v, want a negative value", got)
+	}
+	acceptOnly := &proxy.Cred{Elements: []proxy.CredElement{element(proxy.C_ACCEPT, 0, proxy.C_INDEFINITE)}}
+	if got := Lifetime(acceptOnly, proxy.C_INITIATE); got >= 0 {
+		t.Errorf("Lifetime(no initiator elements) = %v, want a negative value", got)
+	}
+}
+
+// stubSource hands out credentials with fixed time records, and reports the
+// ones which are released.
+type stubSource struct {
+	elements []proxy.CredElement
+	released chan *proxy.Cred
+
+	mu       sync.Mutex
+	acquired int
+	err      error
+}
+
+func (s *stubSource) Acquire() (*proxy.Cred, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return nil, s.err
+	}
+	s.acquired++
+	return &proxy.Cred{Elements: s.elements, NeedsRelease: true}, nil
+}
+
+func (s *stubSource) Release(cred *proxy.Cred) error {
+	s.released <- cred
+	return nil
+}
+
+func (s *stubSource) waitRelease(t *testing.T, want *proxy.Cred) {
+	t.Helper()
+	select {
+	case cred := <-s.released:
+		if cred != want {
+			t.Error("the wrong credentials were released")
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("credentials weren't released")
+	}
+}
+
+func TestManager(t *testing.T) {
+	s := &stubSource{elements: []proxy.CredElement{element(proxy.C_BOTH, 7200, 3600)}, released: make(chan *proxy.Cred, 4)}
+	var events []Event
+	var mu sync.Mutex
+	m, err := New(s, Options{OnEvent: func(e Event) {
+		mu.Lock()
+		events = append(events, e)
+		mu.Unlock()
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The shorter acceptor lifetime is used, since Usage defaults to both.
+	mu.Lock()
+	if len(events) != 1 || events[0].Lifetime != time.Hour {
+		t.Errorf("initial events %+v, want one with a lifetime of an hour", events)
+	}
+	mu.Unlock()
+
+	first, done := m.Get()
+	if err = m.Renew(); err != nil {
+		t.Fatal(err)
+	}
+	second, done2 := m.Get()
+	if second == first {
+		t.Fatal("Renew didn't replace the credentials")
+	}
+	select {
+	case <-s.released:
+		t.Fatal("credentials were released while still in use")
+	default:
+	}
+	done()
+	s.waitRelease(t, first)
+
+	s.mu.Lock()
+	s.err = errors.New("proxy is down")
+	s.mu.Unlock()
+	if err = m.Renew(); err == nil {
+		t.Error("a failed renewal reported success")
+	}
+	if cred, done3 := m.Get(); cred != second {
+		t.Error("a failed renewal replaced the credentials")
+	} else {
+		done3()
+	}
+
+	m.Close()
+	done2()
+	s.waitRelease(t, second)
+}
+
+func TestManagerUsage(t *testing.T) {
+	s := &stubSource{elements: []proxy.CredElement{element(proxy.C_BOTH, 7200, 3600)}, released: make(chan *proxy.Cred, 1)}
+	var lifetime time.Duration
+	m, err := New(s, Options{Usage: proxy.C_INITIATE, OnEvent: func(e Event) { lifetime = e.Lifetime }})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	if lifetime != 2*time.Hour {
+		t.Errorf("initiator lifetime %v, want 2h", lifetime)
+	}
+}
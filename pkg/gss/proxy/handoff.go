@@ -0,0 +1,50 @@
+package proxy
+
+import "bytes"
+import "errors"
+import "time"
+import "github.com/davecgh/go-xdr/xdr2"
+import "github.com/twistlock/gss/pkg/gss/handoff"
+import "github.com/twistlock/gss/pkg/gss/mech"
+
+/* ExportHandoff captures an established security context, along with information about it, so that it can be resumed by another process using ImportHandoff.  The process which resumes it must use a proxy which treats it as the same service, since only that service will be able to make use of the context's ExportedContextToken. */
+func ExportHandoff(ctx *SecCtx) (*handoff.State, error) {
+	var buf bytes.Buffer
+
+	if !ctx.Open || len(ctx.ExportedContextToken) == 0 {
+		return nil, errors.New("security context is not established")
+	}
+	raw, err := uncookSecCtx(*ctx)
+	if err != nil {
+		return nil, err
+	}
+	_, err = xdr.Marshal(&buf, &raw)
+	if err != nil {
+		return nil, err
+	}
+	return &handoff.State{
+		Backend:          handoff.BackendProxy,
+		Context:          buf.Bytes(),
+		Mech:             ctx.Mech,
+		SrcName:          ctx.SrcName.DisplayName,
+		TargName:         ctx.TargName.DisplayName,
+		Flags:            FlagsToRaw(ctx.Flags),
+		Expires:          mech.Expiration(time.Now(), ctx.Lifetime, C_INDEFINITE),
+		LocallyInitiated: ctx.LocallyInitiated,
+	}, nil
+}
+
+/* ImportHandoff resumes a security context captured by ExportHandoff. */
+func ImportHandoff(state *handoff.State) (ctx SecCtx, err error) {
+	var raw rawSecCtx
+
+	if state.Backend != handoff.BackendProxy {
+		err = errors.New("context was not exported by the proxy package")
+		return
+	}
+	_, err = xdr.Unmarshal(bytes.NewReader(state.Context), &raw)
+	if err != nil {
+		return
+	}
+	return cookSecCtx(raw)
+}
@@ -0,0 +1,239 @@
+package http
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/twistlock/gss/pkg/gss/proxy"
+)
+
+// credRefreshMargin is how long before their expiration we stop handing out
+// cached credentials, so that a context being set up with them doesn't fail
+// halfway through.
+const credRefreshMargin = 30 * time.Second
+
+// proxyConn is a connection to the proxy which is shared by all of a round
+// tripper's requests.  Calls are serialized, since RPCs on the connection
+// can't be interleaved.
+type proxyConn struct {
+	mu   sync.Mutex
+	conn net.Conn
+	call proxy.CallCtx
+}
+
+// do runs fn using the connection, connecting to the proxy at socket first if
+// we aren't already.  If fn returns an error, the connection is dropped so
+// that the next call will reconnect.
+func (pc *proxyConn) do(socket string, fn func(conn *net.Conn, call *proxy.CallCtx) error) error {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+
+	if pc.conn == nil {
+		conn, err := net.Dial("unix", socket)
+		if err != nil {
+			return err
+		}
+		pc.conn = conn
+		pc.call = proxy.CallCtx{}
+		_, err = proxy.GetCallContext(&pc.conn, &pc.call, nil)
+		if err != nil {
+			pc.conn.Close()
+			pc.conn = nil
+			return err
+		}
+	}
+	err := fn(&pc.conn, &pc.call)
+	if err != nil {
+		pc.conn.Close()
+		pc.conn = nil
+	}
+	return err
+}
+
+func (pc *proxyConn) close() error {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+
+	if pc.conn == nil {
+		return nil
+	}
+	err := pc.conn.Close()
+	pc.conn = nil
+	return err
+}
+
+// DefaultMaxCachedCreds is the number of initiator identities whose
+// credentials a round tripper keeps if MaxCachedCreds isn't set.
+const DefaultMaxCachedCreds = 32
+
+// cachedCred is a credential in the cache.  Negotiations hold a reference to
+// it while they use it, so that it's only released once it has been dropped
+// from the cache and the last of them is finished with it.
+type cachedCred struct {
+	cred    *proxy.Cred
+	expires time.Time
+	used    time.Time
+	refs    int
+	dropped bool
+}
+
+// credCache holds credentials acquired for each initiator identity until
+// they're about to expire, or until they're the least recently used of more
+// than max identities.
+type credCache struct {
+	mu      sync.Mutex
+	entries map[string]*cachedCred
+	now     func() time.Time
+}
+
+// clock returns the current time, using cc.now if it's set.
+func (cc *credCache) clock() time.Time {
+	if cc.now != nil {
+		return cc.now()
+	}
+	return time.Now()
+}
+
+// lookup returns a reference to credentials stored using key, calling
+// acquire to obtain and store new ones if there aren't any which are still
+// good, and keeping no more than max entries.  The caller must pass the
+// reference to done, and release any credentials returned in release.
+func (cc *credCache) lookup(key string, max int, acquire func() (*proxy.Cred, error)) (entry *cachedCred, release []*proxy.Cred, err error) {
+	if entry = cc.get(key); entry != nil {
+		return entry, nil, nil
+	}
+	cred, err := acquire()
+	if err != nil {
+		return nil, nil, err
+	}
+	entry, release = cc.put(key, cred, max)
+	return entry, release, nil
+}
+
+// credCacheKey returns the cache key for credentials for name.
+func credCacheKey(name *proxy.Name) string {
+	if name == nil {
+		return ""
+	}
+	return name.NameType.String() + ":" + name.DisplayName
+}
+
+// credExpiration computes when cred will stop being useful to an initiator,
+// based on the shortest lifetime of any of its elements.
+func credExpiration(cred *proxy.Cred, now time.Time) time.Time {
+	var lifetime uint64 = proxy.C_INDEFINITE
+
+	for _, element := range cred.Elements {
+		if element.InitiatorTimeRec < lifetime {
+			lifetime = element.InitiatorTimeRec
+		}
+	}
+	if lifetime == proxy.C_INDEFINITE {
+		return time.Time{}
+	}
+	return now.Add(time.Duration(lifetime) * time.Second)
+}
+
+// get returns unexpired credentials stored using key, or nil.  The caller
+// must pass a non-nil result to done when it's finished with it.
+func (cc *credCache) get(key string) *cachedCred {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+
+	entry, ok := cc.entries[key]
+	if !ok {
+		return nil
+	}
+	now := cc.clock()
+	if !entry.expires.IsZero() && now.Add(credRefreshMargin).After(entry.expires) {
+		return nil
+	}
+	entry.refs++
+	entry.used = now
+	return entry
+}
+
+// put stores cred using key, keeping no more than max entries, and returns a
+// reference to it which the caller must pass to done.  Credentials which it
+// displaced and which nobody is using any more are returned for releasing.
+func (cc *credCache) put(key string, cred *proxy.Cred, max int) (entry *cachedCred, release []*proxy.Cred) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+
+	if cc.entries == nil {
+		cc.entries = make(map[string]*cachedCred)
+	}
+	if max <= 0 {
+		max = DefaultMaxCachedCreds
+	}
+	if old, ok := cc.entries[key]; ok {
+		release = cc.drop(key, old, release)
+	}
+	for len(cc.entries) >= max {
+		var oldestKey string
+		var oldest *cachedCred
+		for k, e := range cc.entries {
+			if oldest == nil || e.used.Before(oldest.used) {
+				oldestKey, oldest = k, e
+			}
+		}
+		release = cc.drop(oldestKey, oldest, release)
+	}
+	now := cc.clock()
+	entry = &cachedCred{cred: cred, expires: credExpiration(cred, now), used: now, refs: 1}
+	cc.entries[key] = entry
+	return
+}
+
+// drop removes an entry from the cache, adding its credential to release if
+// nobody is using it.  cc.mu must be held.
+func (cc *credCache) drop(key string, entry *cachedCred, release []*proxy.Cred) []*proxy.Cred {
+	delete(cc.entries, key)
+	entry.dropped = true
+	if entry.refs == 0 {
+		release = append(release, entry.cred)
+	}
+	return release
+}
+
+// done gives up a reference to entry, returning its credential if it should
+// be released now.
+func (cc *credCache) done(entry *cachedCred) *proxy.Cred {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+
+	entry.refs--
+	if entry.refs == 0 && entry.dropped {
+		return entry.cred
+	}
+	return nil
+}
+
+// remove drops entry from the cache so that it isn't handed out again.  Its
+// credential is released by whichever caller is the last to call done.
+func (cc *credCache) remove(entry *cachedCred) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+
+	for key, e := range cc.entries {
+		if e == entry {
+			delete(cc.entries, key)
+			entry.dropped = true
+			return
+		}
+	}
+}
+
+// flush empties the cache, returning the credentials which nobody is using.
+// The rest are returned by done when their last users are finished.
+func (cc *credCache) flush() (release []*proxy.Cred) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+
+	for key, entry := range cc.entries {
+		release = cc.drop(key, entry, release)
+	}
+	cc.entries = nil
+	return
+}
@@ -0,0 +1,187 @@
+package http
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/twistlock/gss/pkg/gss/proxy"
+)
+
+// stubAcquirer hands out new credentials, counting how many it has made.
+type stubAcquirer struct {
+	calls    int
+	lifetime uint64
+}
+
+func (s *stubAcquirer) acquire() (*proxy.Cred, error) {
+	s.calls++
+	return &proxy.Cred{Elements: []proxy.CredElement{{InitiatorTimeRec: s.lifetime}}}, nil
+}
+
+// fakeClock is a time which tests move along by hand.
+type fakeClock struct {
+	t time.Time
+}
+
+func (c *fakeClock) now() time.Time {
+	return c.t
+}
+
+func newCache() (*credCache, *fakeClock) {
+	clock := &fakeClock{t: time.Unix(1000000, 0)}
+	return &credCache{now: clock.now}, clock
+}
+
+// lookup looks up key and checks that nothing needs to be released.
+func lookup(t *testing.T, cc *credCache, key string, max int, s *stubAcquirer) *cachedCred {
+	t.Helper()
+	entry, release, err := cc.lookup(key, max, s.acquire)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(release) != 0 {
+		t.Fatalf("looking up %q released %d credentials", key, len(release))
+	}
+	return entry
+}
+
+func TestCredCacheReuse(t *testing.T) {
+	cc, _ := newCache()
+	s := &stubAcquirer{lifetime: proxy.C_INDEFINITE}
+
+	a := lookup(t, cc, "a", 0, s)
+	if a2 := lookup(t, cc, "a", 0, s); a2 != a {
+		t.Error("second lookup returned different credentials")
+	}
+	lookup(t, cc, "b", 0, s)
+	if s.calls != 2 {
+		t.Errorf("acquired credentials %d times, want 2", s.calls)
+	}
+	if a.refs != 2 {
+		t.Errorf("entry has %d references, want 2", a.refs)
+	}
+
+	failed := errors.New("no credentials")
+	if _, _, err := cc.lookup("c", 0, func() (*proxy.Cred, error) { return nil, failed }); err != failed {
+		t.Errorf("failed acquisition returned %v", err)
+	}
+	if _, ok := cc.entries["c"]; ok {
+		t.Error("failed acquisition left an entry in the cache")
+	}
+}
+
+func TestCredCacheRefcount(t *testing.T) {
+	cc, _ := newCache()
+	s := &stubAcquirer{lifetime: proxy.C_INDEFINITE}
+
+	a := lookup(t, cc, "a", 0, s)
+	lookup(t, cc, "a", 0, s)
+	if cred := cc.done(a); cred != nil {
+		t.Fatal("credentials in the cache were released")
+	}
+
+	// Flushing leaves credentials in use alone until they're done.
+	if release := cc.flush(); len(release) != 0 {
+		t.Fatalf("flush released %d credentials which were in use", len(release))
+	}
+	if cred := cc.done(a); cred != a.cred {
+		t.Error("last user of flushed credentials didn't release them")
+	}
+
+	// Removed credentials aren't handed out again.
+	b := lookup(t, cc, "b", 0, s)
+	cc.remove(b)
+	if b2 := lookup(t, cc, "b", 0, s); b2 == b {
+		t.Error("removed credentials were handed out again")
+	}
+	if cred := cc.done(b); cred != b.cred {
+		t.Error("last user of removed credentials didn't release them")
+	}
+
+	// Unused credentials are released by flush itself.
+	cc.done(lookup(t, cc, "c", 0, s))
+	release := cc.flush()
+	if len(release) != 1 {
+		t.Errorf("flush released %d unused credentials, want 1", len(release))
+	}
+}
+
+func TestCredCacheLRU(t *testing.T) {
+	cc, clock := newCache()
+	s := &stubAcquirer{lifetime: proxy.C_INDEFINITE}
+
+	a := lookup(t, cc, "a", 2, s)
+	cc.done(a)
+	clock.t = clock.t.Add(time.Second)
+	b := lookup(t, cc, "b", 2, s)
+	clock.t = clock.t.Add(time.Second)
+	cc.done(lookup(t, cc, "a", 2, s))
+	clock.t = clock.t.Add(time.Second)
+
+	// b is the least recently used, but it's in use, so it's only released
+	// once it's done.
+	c, release, err := cc.lookup("c", 2, s.acquire)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(release) != 0 {
+		t.Fatalf("evicting credentials in use released %d", len(release))
+	}
+	if _, ok := cc.entries["b"]; ok {
+		t.Fatal("least recently used entry wasn't evicted")
+	}
+	if _, ok := cc.entries["a"]; !ok {
+		t.Fatal("recently used entry was evicted")
+	}
+	if cred := cc.done(b); cred != b.cred {
+		t.Error("evicted credentials weren't released when done")
+	}
+
+	// Evicting unused credentials releases them at once.
+	cc.done(c)
+	clock.t = clock.t.Add(time.Second)
+	_, release, err = cc.lookup("d", 2, s.acquire)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(release) != 1 || release[0] != a.cred {
+		t.Errorf("evicting a released %v, want its credentials", release)
+	}
+	if len(cc.entries) != 2 {
+		t.Errorf("cache holds %d entries, want 2", len(cc.entries))
+	}
+}
+
+func TestCredCacheExpiry(t *testing.T) {
+	cc, clock := newCache()
+	s := &stubAcquirer{lifetime: 60}
+
+	a := lookup(t, cc, "a", 0, s)
+	cc.done(a)
+	clock.t = clock.t.Add(60*time.Second - credRefreshMargin - time.Second)
+	if a2 := lookup(t, cc, "a", 0, s); a2 != a {
+		t.Error("credentials weren't reused before the refresh margin")
+	}
+	cc.done(a)
+	clock.t = clock.t.Add(2 * time.Second)
+	a3, release, err := cc.lookup("a", 0, s.acquire)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a3 == a || s.calls != 2 {
+		t.Error("credentials were reused within the refresh margin")
+	}
+	if len(release) != 1 || release[0] != a.cred {
+		t.Errorf("replacing expiring credentials released %v", release)
+	}
+
+	// Credentials without a lifetime don't expire.
+	cc, clock = newCache()
+	s = &stubAcquirer{lifetime: proxy.C_INDEFINITE}
+	b := lookup(t, cc, "b", 0, s)
+	clock.t = clock.t.Add(365 * 24 * time.Hour)
+	if b2 := lookup(t, cc, "b", 0, s); b2 != b {
+		t.Error("credentials with an indefinite lifetime expired")
+	}
+}
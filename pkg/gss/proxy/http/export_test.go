@@ -0,0 +1,13 @@
+package http
+
+import "github.com/twistlock/gss/pkg/gss/proxy"
+
+// CachedCred returns the credentials which rt has cached for name, or nil.
+func CachedCred(rt *NegotiateRoundTripper, name *proxy.Name) *proxy.Cred {
+	rt.creds.mu.Lock()
+	defer rt.creds.mu.Unlock()
+	if entry, ok := rt.creds.entries[credCacheKey(name)]; ok {
+		return entry.cred
+	}
+	return nil
+}
@@ -11,22 +11,143 @@ import (
 	"github.com/twistlock/gss/pkg/gss/proxy"
 )
 
-type negotiateRoundTripper struct {
-	proxySocket string
-	rt          http.RoundTripper
+type NegotiateRoundTripper struct {
+	ProxySocket string
+	Transport   http.RoundTripper
+	// Flags are passed to InitSecContext.  Set Deleg to forward the initiator
+	// credentials to the server, or DelegPolicy to forward them only if the
+	// KDC's policy allows delegating to the server.
+	Flags proxy.Flags
+
+	// Cred, if not nil, is used as the initiator credential in place of the
+	// default credentials.  Delegated credentials returned by
+	// proxy.AcceptSecContext can be used here to call back-end services on
+	// behalf of a client.  The caller retains ownership of the credential.
+	Cred *proxy.Cred
+
+	// Impersonate, if set, is the name of a user on whose behalf requests should
+	// be made.  The proxy obtains credentials for the user using Cred (or the
+	// credentials of the service it matches us to, if Cred is nil) as the
+	// impersonator, and will only do so if that service is configured with
+	// "impersonate = yes".
+	Impersonate string
+
+	// DesiredName, if not nil, names the initiator identity for which the proxy
+	// should acquire credentials.  It takes precedence over Impersonate.
+	DesiredName *proxy.Name
+
+	// NameFunc, if not nil, is called for every request to choose the initiator
+	// identity, for example using values stored in the request's context.  A
+	// nil Name selects the default identity.  It takes precedence over
+	// DesiredName and Impersonate.
+	NameFunc func(req *http.Request) (*proxy.Name, error)
+
+	// MaxCachedCreds limits the number of initiator identities whose
+	// credentials are kept for reuse.  The least recently used ones are
+	// released first.  DefaultMaxCachedCreds is used if it isn't positive.
+	MaxCachedCreds int
+
+	conn  proxyConn
+	creds credCache
 }
 
 func NewNegotiateRoundTripper(proxySocket string, rt http.RoundTripper) http.RoundTripper {
-	return &negotiateRoundTripper{proxySocket, rt}
+	return &NegotiateRoundTripper{ProxySocket: proxySocket, Transport: rt, Flags: proxy.Flags{Mutual: true}}
 }
 
-func (rt *negotiateRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
-	var proxyConn net.Conn
+// Close releases any credentials which have been cached, once requests which
+// are using them have finished, and closes the connection to the proxy.  The
+// round tripper can still be used afterward.
+func (rt *NegotiateRoundTripper) Close() error {
+	for _, cred := range rt.creds.flush() {
+		rt.releaseCred(cred)
+	}
+	return rt.conn.close()
+}
+
+// desiredName returns the initiator name to use for req, or nil if the
+// default identity should be used, and whether credentials for it should be
+// obtained by impersonating it.
+func (rt *NegotiateRoundTripper) desiredName(req *http.Request) (name *proxy.Name, impersonate bool, err error) {
+	if rt.NameFunc != nil {
+		name, err = rt.NameFunc(req)
+		return name, false, err
+	}
+	if rt.DesiredName != nil {
+		return rt.DesiredName, false, nil
+	}
+	if rt.Impersonate != "" {
+		return &proxy.Name{DisplayName: rt.Impersonate, NameType: proxy.NT_USER_NAME}, true, nil
+	}
+	return nil, false, nil
+}
+
+// initiatorCred returns credentials for desiredName, impersonating it using
+// Cred if impersonate is set, and reusing ones which we acquired earlier if
+// they haven't expired yet.  The caller must call done when it's finished with
+// the credentials, so that they can be released once they've also been
+// dropped from the cache.
+func (rt *NegotiateRoundTripper) initiatorCred(desiredName *proxy.Name, impersonate bool) (cred *proxy.Cred, done func(expired bool), err error) {
+	if desiredName == nil && rt.Cred != nil {
+		return rt.Cred, func(bool) {}, nil
+	}
+	var options []proxy.Option
+	key := credCacheKey(desiredName)
+	if impersonate {
+		options = proxy.ImpersonateOptions()
+		key = "impersonate:" + key
+	}
+	entry, release, err := rt.creds.lookup(key, rt.MaxCachedCreds, func() (*proxy.Cred, error) {
+		var acr proxy.AcquireCredResults
+		err := rt.conn.do(rt.ProxySocket, func(conn *net.Conn, call *proxy.CallCtx) (err error) {
+			acr, err = proxy.AcquireCred(conn, call, rt.Cred, false, desiredName, proxy.C_INDEFINITE, nil, proxy.C_INITIATE, proxy.C_INDEFINITE, 0, options)
+			return
+		})
+		if err != nil {
+			return nil, err
+		}
+		if acr.Status.MajorStatus != proxy.S_COMPLETE || acr.OutputCredHandle == nil {
+			return nil, proxyError("acquiring credentials", acr.Status)
+		}
+		return acr.OutputCredHandle, nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, replaced := range release {
+		rt.releaseCred(replaced)
+	}
+
+	done = func(expired bool) {
+		if expired {
+			// Don't hand out the same credentials again.
+			rt.creds.remove(entry)
+		}
+		if unused := rt.creds.done(entry); unused != nil {
+			rt.releaseCred(unused)
+		}
+	}
+	return entry.cred, done, nil
+}
+
+// releaseCred releases a credential which we acquired, if the proxy wants us to.
+func (rt *NegotiateRoundTripper) releaseCred(cred *proxy.Cred) {
+	if !cred.NeedsRelease {
+		return
+	}
+	rt.conn.do(rt.ProxySocket, func(conn *net.Conn, call *proxy.CallCtx) (err error) {
+		_, err = proxy.ReleaseCred(conn, call, cred)
+		return
+	})
+}
+
+func (rt *NegotiateRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
 	var proxyCall proxy.CallCtx
-	var cred proxy.Cred
+	var cred *proxy.Cred
+	var credExpired bool
 	req = cloneRequest(req)
 
-	resp, err := rt.rt.RoundTrip(req)
+	resp, err := rt.Transport.RoundTrip(req)
 	if err != nil {
 		return resp, err
 	}
@@ -67,39 +188,42 @@ func (rt *negotiateRoundTripper) RoundTrip(req *http.Request) (*http.Response, e
 				}
 				incomingTokenPtr = &incomingToken
 			} else {
-				proxyConn, err = net.Dial("unix", rt.proxySocket)
+				desiredName, impersonate, err := rt.desiredName(req)
 				if err != nil {
 					return nil, err
 				}
-				defer proxyConn.Close()
-				gcr, err := proxy.GetCallContext(&proxyConn, &proxyCall, nil)
+				var credDone func(expired bool)
+				cred, credDone, err = rt.initiatorCred(desiredName, impersonate)
 				if err != nil {
 					return nil, err
 				}
-				if gcr.Status.MajorStatus != proxy.S_COMPLETE {
-					return nil, errors.New("Error getting gss-proxy call context")
-				}
-				acr, err := proxy.AcquireCred(&proxyConn, &proxyCall, nil, false, nil, proxy.C_INDEFINITE, nil, proxy.C_INITIATE, proxy.C_INDEFINITE, 0, nil)
+				defer func() { credDone(credExpired) }()
+				// Each negotiation gets its own call context, since that's where
+				// SPNEGO state is kept.
+				var gcr proxy.GetCallContextResults
+				err = rt.conn.do(rt.ProxySocket, func(conn *net.Conn, _ *proxy.CallCtx) (err error) {
+					gcr, err = proxy.GetCallContext(conn, &proxyCall, nil)
+					return
+				})
 				if err != nil {
 					return nil, err
 				}
-				if acr.Status.MajorStatus != proxy.S_COMPLETE {
-					return nil, errors.New("Error getting gss-proxy creds")
-				}
-				cred = *acr.OutputCredHandle
-				if cred.NeedsRelease {
-					defer proxy.ReleaseCred(&proxyConn, &proxyCall, &cred)
+				if gcr.Status.MajorStatus != proxy.S_COMPLETE {
+					return nil, errors.New("Error getting gss-proxy call context")
 				}
 			}
 
 			// call gss_init_sec_context to validate the incoming token (if given), and get our outgoing token (if needed)
-			iscr, err = proxy.InitSecContext(&proxyConn, &proxyCall, &ctx, &cred, &name, proxy.MechSPNEGO, proxy.Flags{Mutual: true}, proxy.C_INDEFINITE, nil, incomingTokenPtr, nil)
+			err = rt.conn.do(rt.ProxySocket, func(conn *net.Conn, _ *proxy.CallCtx) (err error) {
+				iscr, err = proxy.InitSecContext(conn, &proxyCall, &ctx, cred, &name, proxy.MechSPNEGO, rt.Flags, proxy.C_INDEFINITE, nil, incomingTokenPtr, nil)
+				return
+			})
+			if err != nil {
+				return nil, err
+			}
 			if iscr.Status.MajorStatus != proxy.S_COMPLETE && iscr.Status.MajorStatus != proxy.S_CONTINUE_NEEDED {
-				if iscr.Status.MinorStatusString != "" {
-					return nil, errors.New(fmt.Sprintf("%s while initializing security context (%s) (step %d)", iscr.Status.MajorStatusString, iscr.Status.MinorStatusString, i+1))
-				} else {
-					return nil, errors.New(fmt.Sprintf("%s while initializing security context (step %d)", iscr.Status.MajorStatusString, i+1))
-				}
+				credExpired = iscr.Status.MajorStatus == proxy.S_CREDENTIALS_EXPIRED
+				return nil, proxyError(fmt.Sprintf("initializing security context (step %d)", i+1), iscr.Status)
 			}
 
 			// fmt.Printf("Complete: %v, Continue: %v\n", major == proxy.S_COMPLETE, major == proxy.S_CONTINUE_NEEDED)
@@ -110,7 +234,7 @@ func (rt *negotiateRoundTripper) RoundTrip(req *http.Request) (*http.Response, e
 				outgoingTokenBase64 := base64.StdEncoding.EncodeToString(*iscr.OutputToken)
 				// fmt.Println("Re-sending request with Authorization token")
 				req.Header.Set("Authorization", "Negotiate "+outgoingTokenBase64)
-				resp, err = rt.rt.RoundTrip(req)
+				resp, err = rt.Transport.RoundTrip(req)
 				if err != nil {
 					return nil, err
 				}
@@ -122,6 +246,14 @@ func (rt *negotiateRoundTripper) RoundTrip(req *http.Request) (*http.Response, e
 	return resp, nil
 }
 
+// proxyError builds an error describing a failed call to the proxy.
+func proxyError(when string, status proxy.Status) error {
+	if status.MinorStatusString != "" {
+		return errors.New(fmt.Sprintf("%s while %s (%s)", status.MajorStatusString, when, status.MinorStatusString))
+	}
+	return errors.New(fmt.Sprintf("%s while %s", status.MajorStatusString, when))
+}
+
 func isInitialChallenge(resp *http.Response) bool {
 	return resp.StatusCode == http.StatusUnauthorized && resp.Header.Get("WWW-Authenticate") == "Negotiate"
 }
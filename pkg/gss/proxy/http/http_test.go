@@ -0,0 +1,284 @@
+package http_test
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/twistlock/gss/pkg/gss"
+	"github.com/twistlock/gss/pkg/gss/gsstest"
+	"github.com/twistlock/gss/pkg/gss/proxy"
+	proxyhttp "github.com/twistlock/gss/pkg/gss/proxy/http"
+)
+
+// startProxy runs gssproxy in the foreground with a service which lets this
+// process use the KDC's default ccache and keytab, and returns the path of
+// its socket.  Any extra lines are added to the service's configuration.
+// The test is skipped if gssproxy isn't installed.
+func startProxy(t *testing.T, kdc *gsstest.KDC, extra ...string) string {
+	t.Helper()
+	path, err := exec.LookPath("gssproxy")
+	if err != nil {
+		path = "/usr/sbin/gssproxy"
+		if _, err := os.Stat(path); err != nil {
+			t.Skip("gssproxy is not installed")
+		}
+	}
+	socket := filepath.Join(kdc.Dir, "gssproxy.sock")
+	config := filepath.Join(kdc.Dir, "gssproxy.conf")
+	conf := fmt.Sprintf(`[gssproxy]
+
+[service/gsstest]
+  mechs = krb5
+  cred_store = ccache:%s
+  cred_store = keytab:%s
+  cred_usage = both
+  euid = %d
+  socket = %s
+  trusted = yes
+`, kdc.CCache, strings.TrimPrefix(kdc.Keytab, "FILE:"), os.Geteuid(), socket)
+	for _, line := range extra {
+		conf += "  " + line + "\n"
+	}
+	if err := ioutil.WriteFile(config, []byte(conf), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := exec.Command(path, "-i", "-c", config, "-s", socket)
+	cmd.Env = append(os.Environ(), kdc.Env()...)
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+	for deadline := time.Now().Add(10 * time.Second); ; time.Sleep(50 * time.Millisecond) {
+		if conn, err := net.Dial("unix", socket); err == nil {
+			conn.Close()
+			return socket
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("gssproxy didn't create its socket")
+		}
+	}
+}
+
+// negotiateServer starts a server which requires Negotiate authentication,
+// using the KDC's default keytab, and replies with the client's name.
+func negotiateServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		if !strings.HasPrefix(auth, "Negotiate ") {
+			w.Header().Set("WWW-Authenticate", "Negotiate")
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		token, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(auth, "Negotiate "))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var ctx gss.ContextHandle
+		defer gss.DeleteSecContext(ctx)
+		major, minor, src, _, _, _, _, _, _, output := gss.AcceptSecContext(nil, &ctx, nil, token)
+		if major != gss.S_COMPLETE {
+			t.Errorf("server: %v", gss.NewGSSError("accepting context", major, minor, nil))
+			w.Header().Set("WWW-Authenticate", "Negotiate")
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		defer gss.ReleaseName(src)
+		_, _, client, _ := gss.DisplayName(src)
+		if len(output) > 0 {
+			w.Header().Set("WWW-Authenticate", "Negotiate "+base64.StdEncoding.EncodeToString(output))
+		}
+		w.Write([]byte(client))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// localhostTransport sends requests for any host to srv, so that the
+// service name is built from "localhost" without a port.
+func localhostTransport(srv *httptest.Server) *http.Transport {
+	return &http.Transport{
+		DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, srv.Listener.Addr().String())
+		},
+	}
+}
+
+// get makes a request using rt and returns the name of the client which the
+// server authenticated.
+func get(t *testing.T, rt http.RoundTripper) string {
+	t.Helper()
+	client := &http.Client{Transport: rt}
+	resp, err := client.Get("http://localhost/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status %s: %s", resp.Status, body)
+	}
+	return string(body)
+}
+
+// acquireCred asks the proxy for initiator credentials for name, which may
+// be nil, passing options along.
+func acquireCred(t *testing.T, socket string, name *proxy.Name, options []proxy.Option) *proxy.Cred {
+	t.Helper()
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	var call proxy.CallCtx
+	if _, err = proxy.GetCallContext(&conn, &call, nil); err != nil {
+		t.Fatal(err)
+	}
+	acr, err := proxy.AcquireCred(&conn, &call, nil, false, name, proxy.C_INDEFINITE, nil, proxy.C_INITIATE, proxy.C_INDEFINITE, 0, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if acr.Status.MajorStatus != proxy.S_COMPLETE || acr.OutputCredHandle == nil {
+		t.Fatalf("acquiring credentials: %s (%s)", acr.Status.MajorStatusString, acr.Status.MinorStatusString)
+	}
+	return acr.OutputCredHandle
+}
+
+func TestNegotiateRoundTripper(t *testing.T) {
+	kdc := gsstest.Start(t, gsstest.Options{})
+	if err := kdc.AddUser("alice"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := kdc.AddService("HTTP"); err != nil {
+		t.Fatal(err)
+	}
+	socket := startProxy(t, kdc)
+	srv := negotiateServer(t)
+
+	rt := proxyhttp.NewNegotiateRoundTripper(socket, &http.Transport{
+		DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, srv.Listener.Addr().String())
+		},
+	})
+	defer rt.(*proxyhttp.NegotiateRoundTripper).Close()
+	client := &http.Client{Transport: rt}
+
+	// The second request reuses the cached credentials.
+	var cached *proxy.Cred
+	for i := 0; i < 2; i++ {
+		resp, err := client.Get("http://localhost/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("request %d: status %s: %s", i+1, resp.Status, body)
+		}
+		if want := kdc.Principal("alice"); string(body) != want {
+			t.Errorf("request %d: server saw client %q, want %q", i+1, body, want)
+		}
+		cred := proxyhttp.CachedCred(rt.(*proxyhttp.NegotiateRoundTripper), nil)
+		if cred == nil {
+			t.Fatalf("request %d: no credentials were cached", i+1)
+		}
+		if i > 0 && cred != cached {
+			t.Errorf("request %d: credentials were acquired again", i+1)
+		}
+		cached = cred
+	}
+}
+
+func TestNegotiateRoundTripperCred(t *testing.T) {
+	kdc := gsstest.Start(t, gsstest.Options{})
+	if err := kdc.AddUser("alice"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := kdc.AddService("HTTP"); err != nil {
+		t.Fatal(err)
+	}
+	socket := startProxy(t, kdc)
+	srv := negotiateServer(t)
+
+	rt := &proxyhttp.NegotiateRoundTripper{
+		ProxySocket: socket,
+		Transport:   localhostTransport(srv),
+		Flags:       proxy.Flags{Mutual: true},
+		Cred:        acquireCred(t, socket, nil, nil),
+	}
+	defer rt.Close()
+	if client, want := get(t, rt), kdc.Principal("alice"); client != want {
+		t.Errorf("server saw client %q, want %q", client, want)
+	}
+}
+
+// TestNegotiateRoundTripperImpersonate has the HTTP service, whose
+// credentials the proxy holds, obtain tickets to itself on behalf of carol.
+// Those can be used without constrained delegation, since the target is the
+// impersonator.
+func TestNegotiateRoundTripperImpersonate(t *testing.T) {
+	kdc := gsstest.Start(t, gsstest.Options{})
+	if kdc.Flavor == gsstest.Heimdal {
+		t.Skip("impersonating a user with Heimdal's KDC is not supported")
+	}
+	if err := kdc.AddPrincipal("carol", ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := kdc.AddService("HTTP"); err != nil {
+		t.Fatal(err)
+	}
+	if err := kdc.Kinit("HTTP/"+kdc.Host, "", ""); err != nil {
+		t.Fatal(err)
+	}
+	socket := startProxy(t, kdc, "impersonate = yes")
+	srv := negotiateServer(t)
+	carol := kdc.Principal("carol")
+
+	rt := &proxyhttp.NegotiateRoundTripper{
+		ProxySocket: socket,
+		Transport:   localhostTransport(srv),
+		Flags:       proxy.Flags{Mutual: true},
+		Impersonate: carol,
+	}
+	defer rt.Close()
+	if client := get(t, rt); client != carol {
+		t.Errorf("Impersonate: server saw client %q, want %q", client, carol)
+	}
+
+	// Credentials obtained with the same options can be passed in as Cred.
+	name := &proxy.Name{DisplayName: carol, NameType: proxy.NT_USER_NAME}
+	rt2 := &proxyhttp.NegotiateRoundTripper{
+		ProxySocket: socket,
+		Transport:   localhostTransport(srv),
+		Flags:       proxy.Flags{Mutual: true},
+		Cred:        acquireCred(t, socket, name, proxy.ImpersonateOptions()),
+	}
+	defer rt2.Close()
+	if client := get(t, rt2); client != carol {
+		t.Errorf("Cred: server saw client %q, want %q", client, carol)
+	}
+}
@@ -0,0 +1,225 @@
+package proxy
+
+import "encoding/asn1"
+import "errors"
+import "fmt"
+import "net"
+import "sync"
+import "time"
+import "github.com/twistlock/gss/pkg/gss/mech"
+
+/* secContext adapts a SecCtx to the mech.Context interface.  Each one uses its own connection to the proxy, since SPNEGO state is kept in the call context. */
+type secContext struct {
+	mu       sync.Mutex
+	socket   string
+	cred     *Cred
+	target   *Name
+	mechType asn1.ObjectIdentifier
+	flags    Flags
+	bindings *[]byte
+	initiate bool
+	conn     net.Conn
+	call     CallCtx
+	ctx      SecCtx
+	open     bool
+	expires  time.Time
+}
+
+/* NewInitiatorContext returns a mech.Context which uses the proxy listening at socket to initiate a context with target, using cred or the default initiator credentials if cred is nil.  The caller retains ownership of cred. */
+func NewInitiatorContext(socket string, cred *Cred, target *Name, mechType asn1.ObjectIdentifier, flags Flags) mech.Context {
+	return &secContext{socket: socket, cred: cred, target: target, mechType: mechType, flags: flags, initiate: true}
+}
+
+/* NewInitiatorContextWithBindings is like NewInitiatorContext, but binds the context to a channel, such as a TLS connection, using bindings as the channel bindings' application data. */
+func NewInitiatorContextWithBindings(socket string, cred *Cred, target *Name, mechType asn1.ObjectIdentifier, flags Flags, bindings []byte) mech.Context {
+	return &secContext{socket: socket, cred: cred, target: target, mechType: mechType, flags: flags, bindings: &bindings, initiate: true}
+}
+
+/* NewAcceptorContext returns a mech.Context which uses the proxy listening at socket to accept a context from a peer, using cred or the default acceptor credentials if cred is nil.  The caller retains ownership of cred. */
+func NewAcceptorContext(socket string, cred *Cred) mech.Context {
+	return &secContext{socket: socket, cred: cred}
+}
+
+/* NewAcceptorContextWithBindings is like NewAcceptorContext, but only accepts contexts which the peer bound to the channel whose channel bindings' application data is bindings. */
+func NewAcceptorContextWithBindings(socket string, cred *Cred, bindings []byte) mech.Context {
+	return &secContext{socket: socket, cred: cred, bindings: &bindings}
+}
+
+/* statusError converts a failure status to an error, using mech.ErrContextExpired where the caller may want to check for it. */
+func statusError(when string, status Status) error {
+	if status.MajorStatus == S_CONTEXT_EXPIRED {
+		return mech.ErrContextExpired
+	}
+	if status.MinorStatusString != "" {
+		return fmt.Errorf("%s while %s (%s)", status.MajorStatusString, when, status.MinorStatusString)
+	}
+	return fmt.Errorf("%s while %s", status.MajorStatusString, when)
+}
+
+/* connect dials the proxy and sets up a call context, if we haven't already. */
+func (c *secContext) connect() error {
+	if c.conn != nil {
+		return nil
+	}
+	conn, err := net.Dial("unix", c.socket)
+	if err != nil {
+		return err
+	}
+	gccr, err := GetCallContext(&conn, &c.call, nil)
+	if err == nil && gccr.Status.MajorStatus != S_COMPLETE {
+		err = statusError("getting a call context", gccr.Status)
+	}
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	c.conn = conn
+	return nil
+}
+
+func (c *secContext) Step(input []byte) (output []byte, complete bool, err error) {
+	var status Status
+	var token *[]byte
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err = c.connect(); err != nil {
+		return
+	}
+	if c.initiate {
+		var iscr InitSecContextResults
+		var ptoken *[]byte
+		if input != nil {
+			ptoken = &input
+		}
+		iscr, err = InitSecContext(&c.conn, &c.call, &c.ctx, c.cred, c.target, c.mechType, c.flags, C_INDEFINITE, c.bindings, ptoken, nil)
+		status, token = iscr.Status, iscr.OutputToken
+	} else {
+		var ascr AcceptSecContextResults
+		ascr, err = AcceptSecContext(&c.conn, &c.call, &c.ctx, c.cred, input, c.bindings, false, nil)
+		status, token = ascr.Status, ascr.OutputToken
+	}
+	if err != nil {
+		return
+	}
+	if token != nil {
+		output = *token
+	}
+	switch status.MajorStatus {
+	case S_COMPLETE:
+		c.open = true
+		c.expires = mech.Expiration(time.Now(), c.ctx.Lifetime, C_INDEFINITE)
+		return output, true, nil
+	case S_CONTINUE_NEEDED:
+		return output, false, nil
+	}
+	return output, false, statusError("establishing security context", status)
+}
+
+func (c *secContext) Wrap(message []byte, conf bool) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.conn == nil {
+		return nil, errors.New("security context is not established")
+	}
+	wr, err := Wrap(&c.conn, &c.call, &c.ctx, conf, [][]byte{message}, C_QOP_DEFAULT)
+	if err != nil {
+		return nil, err
+	}
+	if wr.Status.MajorStatus != S_COMPLETE || len(wr.TokenBuffer) == 0 {
+		return nil, statusError("wrapping data", wr.Status)
+	}
+	return wr.TokenBuffer[0], nil
+}
+
+func (c *secContext) Unwrap(token []byte) ([]byte, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.conn == nil {
+		return nil, false, errors.New("security context is not established")
+	}
+	ur, err := Unwrap(&c.conn, &c.call, &c.ctx, [][]byte{token}, C_QOP_DEFAULT)
+	if err != nil {
+		return nil, false, err
+	}
+	if ur.Status.MajorStatus != S_COMPLETE || len(ur.TokenBuffer) == 0 {
+		return nil, false, statusError("unwrapping data", ur.Status)
+	}
+	return ur.TokenBuffer[0], ur.ConfState, nil
+}
+
+func (c *secContext) GetMIC(message []byte) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.conn == nil {
+		return nil, errors.New("security context is not established")
+	}
+	gmr, err := GetMic(&c.conn, &c.call, &c.ctx, C_QOP_DEFAULT, message)
+	if err != nil {
+		return nil, err
+	}
+	if gmr.Status.MajorStatus != S_COMPLETE {
+		return nil, statusError("computing MIC", gmr.Status)
+	}
+	return gmr.TokenBuffer, nil
+}
+
+func (c *secContext) VerifyMIC(message, mic []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.conn == nil {
+		return errors.New("security context is not established")
+	}
+	vmr, err := VerifyMic(&c.conn, &c.call, &c.ctx, message, mic)
+	if err != nil {
+		return err
+	}
+	if vmr.Status.MajorStatus != S_COMPLETE {
+		return statusError("verifying MIC", vmr.Status)
+	}
+	return nil
+}
+
+func (c *secContext) PeerName() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	switch {
+	case !c.open:
+		return ""
+	case c.initiate:
+		return c.ctx.TargName.DisplayName
+	}
+	return c.ctx.SrcName.DisplayName
+}
+
+func (c *secContext) Expires() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.expires
+}
+
+func (c *secContext) Release() (err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.conn == nil {
+		return nil
+	}
+	if c.ctx.NeedsRelease {
+		var rscr ReleaseSecCtxResults
+		rscr, err = ReleaseSecCtx(&c.conn, &c.call, &c.ctx)
+		if err == nil && rscr.Status.MajorStatus != S_COMPLETE {
+			err = statusError("releasing security context", rscr.Status)
+		}
+	}
+	c.conn.Close()
+	c.conn = nil
+	return
+}
@@ -7,6 +7,7 @@ import "net"
 import "strconv"
 import "strings"
 import "github.com/davecgh/go-xdr/xdr2"
+import gsstoken "github.com/twistlock/gss/pkg/gss/token"
 
 const (
 	/* The server we're using. */
@@ -90,12 +91,6 @@ const (
 
 	/* Default quality of protection, for passing to GetMic()/Wrap(). */
 	C_QOP_DEFAULT = 0
-
-	/* SPNEGO status codes. */
-	negStateAcceptCompleted  = 0
-	negStateAcceptIncomplete = 1
-	negStateReject           = 2
-	negStateRequestMic       = 3
 )
 
 var (
@@ -119,29 +114,6 @@ var (
 	defaultSPNEGOMechs = []asn1.ObjectIdentifier{MechKerberos5, MechKerberos5Draft, MechKerberos5Wrong}
 )
 
-type initialNegContextToken struct {
-	ThisMech     asn1.ObjectIdentifier
-	NegTokenInit negTokenInit `asn1:"explicit,tag:0"`
-}
-type negTokenInit struct {
-	MechTypes   []asn1.ObjectIdentifier `asn1:"explicit,tag:0"`
-	ReqFlags    asn1.BitString          `asn1:"optional,explicit,tag:1"`
-	MechToken   []byte                  `asn1:"optional,explicit,tag:2"`
-	MechListMic []byte                  `asn1:"optional,explicit,tag:3"`
-}
-type negTokenResp struct {
-	NegState      asn1.Enumerated       `asn1:"explicit,tag:0"`
-	SupportedMech asn1.ObjectIdentifier `asn1:"optional,explicit,tag:1"`
-	ResponseToken []byte                `asn1:"optional,explicit,tag:2"`
-	MechListMic   []byte                `asn1:"optional,explicit,tag:3"`
-}
-type negotiateInit struct {
-	NegTokenInit negTokenInit `asn1:"explicit,tag:0"`
-}
-type negotiateResp struct {
-	NegTokenResp negTokenResp `asn1:"explicit,tag:1"`
-}
-
 func parseOid(oids string) (oid asn1.ObjectIdentifier) {
 	components := strings.Split(oids, ".")
 	if len(components) > 0 {
@@ -237,76 +209,8 @@ func FlagsToRaw(flags Flags) uint64 {
 	return uncookFlags(flags)
 }
 
-func makeTagAndLength(tag, length int) (l []byte) {
-	var count, bits int
-
-	if length <= 127 {
-		l = make([]byte, 2)
-		l[0] = byte(tag)
-		l[1] = byte(length)
-		return
-	}
-	count = 0
-	bits = length
-	for bits != 0 {
-		count++
-		bits = bits >> 8
-	}
-	if count > 126 {
-		return nil
-	}
-	l = make([]byte, 2+count)
-	count = 0
-	bits = length
-	l[0] = byte(tag)
-	for bits != 0 {
-		l[len(l)-1-count] = byte(bits & 0xff)
-		count++
-		bits = bits >> 8
-	}
-	l[1] = byte(count | 0x80)
-	return
-}
-
 func cookOid(oid []byte) (cooked asn1.ObjectIdentifier, err error) {
-	_, err = asn1.Unmarshal(append(makeTagAndLength(6, len(oid)), oid...), &cooked)
-	return
-}
-
-func splitTagAndLength(tlv []byte) (class int, constructed bool, tag, length int, value []byte) {
-	tbytes := 1
-	lbytes := 1
-
-	class = int((tlv[0] & 0xc0) >> 6)
-	constructed = (tlv[0] & 0x20) != 0
-	tag = int(tlv[0] & 0x1f)
-	if tag == 0x1f {
-		tag = 0
-		for tlv[tbytes]&0x80 != 0 {
-			tag = (tag << 7) + int(tlv[tbytes]&0x7f)
-			tbytes++
-		}
-		tag = (tag << 7) + int(tlv[tbytes]&0x7f)
-		tbytes++
-	}
-	if tlv[tbytes]&0x80 == 0 {
-		length = int(tlv[tbytes] & 0x7f)
-	} else {
-		lbytes = int(tlv[tbytes] & 0x7f)
-		if lbytes == 0 {
-			value = nil
-			return
-		}
-		for count := 0; count < lbytes; count++ {
-			length = (length << 8) + int(tlv[tbytes+1+count]&0xff)
-		}
-		lbytes++
-	}
-	if len(tlv) != tbytes+lbytes+length {
-		value = nil
-		return
-	}
-	value = tlv[(tbytes + lbytes):]
+	_, err = asn1.Unmarshal(append(gsstoken.TagAndLength(asn1.TagOID, len(oid)), oid...), &cooked)
 	return
 }
 
@@ -315,7 +219,11 @@ func uncookOid(oid asn1.ObjectIdentifier) (raw []byte, err error) {
 	if err != nil {
 		return
 	}
-	_, _, _, _, raw = splitTagAndLength(b)
+	e, err := gsstoken.SplitOne(b)
+	if err != nil {
+		return
+	}
+	raw = e.Value
 	return
 }
 
@@ -704,6 +612,15 @@ func cookCred(c rawCred) (cooked Cred, err error) {
 	return
 }
 
+/* rawCB is a gssx_cb.  Only the application data is ever set, since the addresses are deprecated. */
+type rawCB struct {
+	InitiatorAddrtype uint64
+	InitiatorAddress  []byte
+	AcceptorAddrtype  uint64
+	AcceptorAddress   []byte
+	ApplicationData   []byte
+}
+
 type rawSecCtx struct {
 	ExportedContextToken, State []byte
 	NeedsRelease                bool
@@ -1072,6 +989,11 @@ func ImportCred(conn *net.Conn, callCtx *CallCtx, exportedCred []byte, options [
 	return
 }
 
+/* ImpersonateOptions returns the options which make AcquireCred obtain credentials for desiredName on its behalf, using inputCredHandle (or the service's own credentials, if it is nil) as the impersonator.  gss-proxy's client library sends the same option for gss_acquire_cred_impersonate_name, and the daemon only honors it for services which are configured with "impersonate = yes".  Like the library, we include the terminating NUL in the option's name and value, since the daemon compares them that way. */
+func ImpersonateOptions() []Option {
+	return []Option{{Option: []byte("acquire_type\x00"), Value: []byte("impersonate_name\x00")}}
+}
+
 type AcquireCredResults struct {
 	Status           Status
 	OutputCredHandle *Cred
@@ -1270,11 +1192,11 @@ type InitSecContextResults struct {
 	Options     []Option
 }
 
-/* InitSecContext initiates a security context with a peer.  If the returned Status.MajorStatus is S_CONTINUE_NEEDED, the function should be called again with a token obtained from the peer.  If the OutputToken is not nil, then it should be sent to the peer.  If the returned Status.MajorStatus is S_COMPLETE, then authentication has succeeded.  Any other Status.MajorStatus value is an error. */
+/* InitSecContext initiates a security context with a peer.  If the returned Status.MajorStatus is S_CONTINUE_NEEDED, the function should be called again with a token obtained from the peer.  If the OutputToken is not nil, then it should be sent to the peer.  If the returned Status.MajorStatus is S_COMPLETE, then authentication has succeeded.  Any other Status.MajorStatus value is an error.  If inputCB is not nil, it is the application data of the channel bindings to use. */
 func InitSecContext(conn *net.Conn, callCtx *CallCtx, ctx *SecCtx, cred *Cred, targetName *Name, mechType asn1.ObjectIdentifier, reqFlags Flags, timeReq uint64, inputCB, inputToken *[]byte, options []Option) (results InitSecContextResults, err error) {
-	var inct initialNegContextToken
-	var resp negTokenResp
-	var ntr negotiateResp
+	var nti gsstoken.NegTokenInit
+	var resp gsstoken.NegTokenResp
+	var ntr gsstoken.NegTokenResp
 	var token []byte
 	var gmr GetMicResults
 	var vmr VerifyMicResults
@@ -1287,25 +1209,25 @@ func InitSecContext(conn *net.Conn, callCtx *CallCtx, ctx *SecCtx, cred *Cred, t
 	}
 
 	if cred != nil && cred.negotiateMechs != nil && len(*cred.negotiateMechs) > 0 {
-		inct.NegTokenInit.MechTypes = *cred.negotiateMechs
+		nti.MechTypes = *cred.negotiateMechs
 	} else {
-		inct.NegTokenInit.MechTypes = defaultSPNEGOMechs
+		nti.MechTypes = defaultSPNEGOMechs
 	}
 
 	if inputToken != nil {
 		/* Parse a reply from the peer. */
-		_, err = asn1.UnmarshalWithParams(*inputToken, &resp, "explicit,tag:1")
+		resp, err = gsstoken.ParseNegTokenResp(*inputToken)
 		if err != nil {
 			return proxyInitSecContext(conn, callCtx, ctx, cred, targetName, mechType, reqFlags, timeReq, inputCB, inputToken, options)
 		}
 		/* If the status is "request-mic", make a note and treat it as "incomplete". */
-		if resp.NegState == negStateRequestMic {
+		if resp.NegState == gsstoken.NegStateRequestMic {
 			callCtx.spnegoInit.sendMic = true
 			callCtx.spnegoInit.needMic = true
-			resp.NegState = negStateAcceptIncomplete
+			resp.NegState = gsstoken.NegStateAcceptIncomplete
 		}
 		/* Check that we're still okay. */
-		if resp.NegState != negStateAcceptCompleted && resp.NegState != negStateAcceptIncomplete {
+		if resp.NegState != gsstoken.NegStateAcceptCompleted && resp.NegState != gsstoken.NegStateAcceptIncomplete {
 			results.Status.MajorStatus = S_BAD_STATUS
 			results.Status.MajorStatusString = fmt.Sprintf("SPNEGO status %d not handled by this implementation", resp.NegState)
 			return
@@ -1362,7 +1284,7 @@ func InitSecContext(conn *net.Conn, callCtx *CallCtx, ctx *SecCtx, cred *Cred, t
 	/* If the acceptor sent us a MIC already, verify it now. */
 	if len(resp.MechListMic) > 0 {
 		if callCtx.spnegoInit.baseComplete {
-			token, err = asn1.Marshal(inct.NegTokenInit.MechTypes)
+			token, err = asn1.Marshal(nti.MechTypes)
 			if err != nil {
 				results.OutputToken = nil
 				results.Status.MajorStatus = S_FAILURE
@@ -1395,7 +1317,7 @@ func InitSecContext(conn *net.Conn, callCtx *CallCtx, ctx *SecCtx, cred *Cred, t
 
 	/* If we were told to send a MIC, compute it now. */
 	if callCtx.spnegoInit.sendMic && !callCtx.spnegoInit.sentMic && callCtx.spnegoInit.baseComplete {
-		token, err = asn1.Marshal(inct.NegTokenInit.MechTypes)
+		token, err = asn1.Marshal(nti.MechTypes)
 		if err != nil {
 			results.OutputToken = nil
 			results.Status.MajorStatus = S_FAILURE
@@ -1409,57 +1331,47 @@ func InitSecContext(conn *net.Conn, callCtx *CallCtx, ctx *SecCtx, cred *Cred, t
 			results.Status.MajorStatusString = "internal error in SPNEGO"
 			return
 		}
-		inct.NegTokenInit.MechListMic = gmr.TokenBuffer
+		nti.MechListMic = gmr.TokenBuffer
 		callCtx.spnegoInit.sentMic = true
 		callCtx.spnegoInit.sendMic = false
 	}
 
 	/* Create an SPNEGO token if there's data to send. */
-	inct.ThisMech = MechSPNEGO
-	if results.OutputToken != nil || len(inct.NegTokenInit.MechListMic) > 0 {
+	if results.OutputToken != nil || len(nti.MechListMic) > 0 {
 		if results.OutputToken != nil {
-			inct.NegTokenInit.MechToken = *results.OutputToken
+			nti.MechToken = *results.OutputToken
 		}
 		/* Encode the SPNEGO token. */
 		if inputToken != nil {
 			/* Second-or-later pass, use a Response message. */
 			if !callCtx.spnegoInit.needMic && callCtx.spnegoInit.baseComplete {
-				ntr.NegTokenResp.NegState = negStateAcceptCompleted
+				ntr.NegState = gsstoken.NegStateAcceptCompleted
 			} else {
-				ntr.NegTokenResp.NegState = negStateAcceptIncomplete
+				ntr.NegState = gsstoken.NegStateAcceptIncomplete
 			}
-			ntr.NegTokenResp.SupportedMech = callCtx.spnegoInit.mech
-			ntr.NegTokenResp.ResponseToken = inct.NegTokenInit.MechToken
-			ntr.NegTokenResp.MechListMic = inct.NegTokenInit.MechListMic
-			token, err = asn1.Marshal(ntr)
+			ntr.SupportedMech = callCtx.spnegoInit.mech
+			ntr.ResponseToken = nti.MechToken
+			ntr.MechListMic = nti.MechListMic
+			token, err = gsstoken.MarshalNegTokenResp(ntr)
 			if err != nil {
 				results.OutputToken = nil
 				results.Status.MajorStatus = S_FAILURE
 				results.Status.MajorStatusString = "internal error in SPNEGO"
 				return
 			}
-			/* Strip off the outermost sequence tag. */
-			_, _, _, _, raw := splitTagAndLength(token)
-			token = raw
 		} else {
 			/* First-pass, include the mech OID. */
-			token, err = asn1.Marshal(inct)
+			token, err = gsstoken.MarshalNegTokenInit(nti)
 			if err != nil {
 				results.OutputToken = nil
 				results.Status.MajorStatus = S_FAILURE
 				results.Status.MajorStatusString = "internal error in SPNEGO"
 				return
 			}
-			/* Strip off the outermost sequence tag and add an implicit application one. */
-			_, _, _, _, raw := splitTagAndLength(token)
-			tl := makeTagAndLength(0x60, len(raw))
-			buf := bytes.NewBuffer(tl)
-			buf.Write(raw)
-			token = buf.Bytes()
 		}
 		results.OutputToken = &token
 		/* We always expect more from the acceptor. */
-		if callCtx.spnegoInit.baseComplete && len(inct.NegTokenInit.MechToken) == 0 && !callCtx.spnegoInit.needMic {
+		if callCtx.spnegoInit.baseComplete && len(nti.MechToken) == 0 && !callCtx.spnegoInit.needMic {
 			results.Status.MajorStatus = S_COMPLETE
 			/* Restore the context flag. */
 			results.SecCtx.Flags.ProtReady = callCtx.spnegoInit.protReady
@@ -1491,7 +1403,7 @@ func proxyInitSecContext(conn *net.Conn, callCtx *CallCtx, ctx *SecCtx, cred *Cr
 		TargetName        []rawName
 		MechType          []byte
 		ReqFlags, TimeReq uint64
-		InputCB           [][]byte
+		InputCB           []rawCB
 		InputToken        [][]byte
 		Options           []Option
 	}
@@ -1548,10 +1460,10 @@ func proxyInitSecContext(conn *net.Conn, callCtx *CallCtx, ctx *SecCtx, cred *Cr
 	args.ReqFlags = uncookFlags(reqFlags)
 	args.TimeReq = timeReq
 	if inputCB != nil {
-		args.InputCB = make([][]byte, 1)
-		args.InputCB[0] = *inputCB
+		args.InputCB = make([]rawCB, 1)
+		args.InputCB[0].ApplicationData = *inputCB
 	} else {
-		args.InputCB = make([][]byte, 0)
+		args.InputCB = make([]rawCB, 0)
 	}
 	if inputToken != nil {
 		args.InputToken = make([][]byte, 1)
@@ -1607,11 +1519,11 @@ type AcceptSecContextResults struct {
 	Options             []Option
 }
 
-/* AcceptSecContext accepts a security context initiated by a peer.  If the returned Status.MajorStatus is S_CONTINUE_NEEDED, the function should be called again with a token obtained from the peer.  If the OutputToken is not nil, then it should be sent to the peer.  If the returned Status.MajorStatus is S_COMPLETE, then authentication has succeeded.  Any other Status.MajorStatus value is an error. */
+/* AcceptSecContext accepts a security context initiated by a peer.  If the returned Status.MajorStatus is S_CONTINUE_NEEDED, the function should be called again with a token obtained from the peer.  If the OutputToken is not nil, then it should be sent to the peer.  If the returned Status.MajorStatus is S_COMPLETE, then authentication has succeeded.  Any other Status.MajorStatus value is an error.  If inputCB is not nil, it is the application data of the channel bindings to use. */
 func AcceptSecContext(conn *net.Conn, callCtx *CallCtx, ctx *SecCtx, cred *Cred, inputToken []byte, inputCB *[]byte, retDelegCred bool, options []Option) (results AcceptSecContextResults, err error) {
-	var inct initialNegContextToken
-	var resp negotiateResp
-	var nct negTokenResp
+	var nti gsstoken.NegTokenInit
+	var resp gsstoken.NegTokenResp
+	var nct gsstoken.NegTokenResp
 	var token []byte
 	var vmr VerifyMicResults
 	var gmr GetMicResults
@@ -1622,16 +1534,16 @@ func AcceptSecContext(conn *net.Conn, callCtx *CallCtx, ctx *SecCtx, cred *Cred,
 	}
 
 	/* Try to parse it as a generic initiator token. */
-	_, err = asn1.UnmarshalWithParams(inputToken, &inct, "application,tag:0")
-	if err != nil || !inct.ThisMech.Equal(MechSPNEGO) || len(inct.NegTokenInit.MechTypes) == 0 {
+	nti, err = gsstoken.ParseNegTokenInit(inputToken)
+	if err != nil {
 		/* Try to parse it as a secondary message. */
-		_, err = asn1.UnmarshalWithParams(inputToken, &nct, "explicit,tag:1")
+		nct, err = gsstoken.ParseNegTokenResp(inputToken)
 		if err != nil {
 			callCtx.spnegoAccept = spnegoAcceptState{}
 			return proxyAcceptSecContext(conn, callCtx, ctx, cred, inputToken, inputCB, retDelegCred, options)
 		}
 		/* Check if we're okay. */
-		if nct.NegState != negStateAcceptCompleted && nct.NegState != negStateAcceptIncomplete {
+		if nct.NegState != gsstoken.NegStateAcceptCompleted && nct.NegState != gsstoken.NegStateAcceptIncomplete {
 			results.Status.MajorStatus = S_BAD_STATUS
 			results.Status.MajorStatusString = fmt.Sprintf("SPNEGO status %d not handled by this implementation", nct.NegState)
 			return
@@ -1641,7 +1553,7 @@ func AcceptSecContext(conn *net.Conn, callCtx *CallCtx, ctx *SecCtx, cred *Cred,
 		callCtx.spnegoAccept = spnegoAcceptState{}
 		callCtx.spnegoAccept.needMic = true
 		/* Pull the mechtype list from the initiator token and check for one that we support. */
-		for i, mech := range inct.NegTokenInit.MechTypes {
+		for i, mech := range nti.MechTypes {
 			if mechIsKerberos(mech) {
 				callCtx.spnegoAccept.mech = mech
 				if i == 0 {
@@ -1655,11 +1567,11 @@ func AcceptSecContext(conn *net.Conn, callCtx *CallCtx, ctx *SecCtx, cred *Cred,
 			/* Return an SPNEGO error. */
 			results.Status.MajorStatus = S_BAD_MECH
 			results.Status.MajorStatusString = "bad SPNEGO mechanism list - no compatible mechanism"
-			resp.NegTokenResp.NegState = negStateReject
+			resp.NegState = gsstoken.NegStateReject
 			return
 		}
 		/* Encode the list of mechanisms for signing/verifying. */
-		callCtx.spnegoAccept.mechList, err = asn1.Marshal(inct.NegTokenInit.MechTypes)
+		callCtx.spnegoAccept.mechList, err = asn1.Marshal(nti.MechTypes)
 		if err != nil {
 			results.OutputToken = nil
 			results.Status.MajorStatus = S_DEFECTIVE_TOKEN
@@ -1667,11 +1579,11 @@ func AcceptSecContext(conn *net.Conn, callCtx *CallCtx, ctx *SecCtx, cred *Cred,
 			return
 		}
 		/* Pull out the mech token and the mechlist MIC. */
-		nct.ResponseToken = inct.NegTokenInit.MechToken
-		nct.MechListMic = inct.NegTokenInit.MechListMic
+		nct.ResponseToken = nti.MechToken
+		nct.MechListMic = nti.MechListMic
 	}
 	/* Process the selected mech's token. */
-	resp.NegTokenResp.SupportedMech = callCtx.spnegoAccept.mech
+	resp.SupportedMech = callCtx.spnegoAccept.mech
 	err = nil
 	if !callCtx.spnegoAccept.baseComplete {
 		/* Pass the mechanism-specific token on to the proxy. */
@@ -1680,24 +1592,24 @@ func AcceptSecContext(conn *net.Conn, callCtx *CallCtx, ctx *SecCtx, cred *Cred,
 			/* Interpret the proxy's result. */
 			if results.Status.MajorStatus == S_CONTINUE_NEEDED {
 				if callCtx.spnegoAccept.needMic && !callCtx.spnegoAccept.sentMicRequest {
-					resp.NegTokenResp.NegState = negStateRequestMic
+					resp.NegState = gsstoken.NegStateRequestMic
 					callCtx.spnegoAccept.sentMicRequest = true
 				} else {
-					resp.NegTokenResp.NegState = negStateAcceptIncomplete
+					resp.NegState = gsstoken.NegStateAcceptIncomplete
 				}
 			} else if results.Status.MajorStatus == S_COMPLETE {
 				callCtx.spnegoAccept.baseComplete = true
-				resp.NegTokenResp.NegState = negStateAcceptCompleted
+				resp.NegState = gsstoken.NegStateAcceptCompleted
 				if results.OutputToken != nil && callCtx.spnegoAccept.needMic {
 					/* We send the mech list MIC first. */
 					callCtx.spnegoAccept.sendMic = true
 				}
 			} else {
-				resp.NegTokenResp.NegState = negStateReject
+				resp.NegState = gsstoken.NegStateReject
 			}
 			/* Make sure we'll encapsulate the mech reply. */
 			if results.OutputToken != nil {
-				resp.NegTokenResp.ResponseToken = *results.OutputToken
+				resp.ResponseToken = *results.OutputToken
 			}
 		} else {
 			/* Don't return an SPNEGO error token, but indicate an error. */
@@ -1725,7 +1637,7 @@ func AcceptSecContext(conn *net.Conn, callCtx *CallCtx, ctx *SecCtx, cred *Cred,
 		}
 		results.Status.MajorStatus = S_COMPLETE
 		results.SecCtx = ctx
-		resp.NegTokenResp.NegState = negStateAcceptCompleted
+		resp.NegState = gsstoken.NegStateAcceptCompleted
 		callCtx.spnegoAccept.needMic = false
 		/* Send the MIC if we haven't yet. */
 		callCtx.spnegoAccept.sendMic = !callCtx.spnegoAccept.sentMic
@@ -1745,7 +1657,7 @@ func AcceptSecContext(conn *net.Conn, callCtx *CallCtx, ctx *SecCtx, cred *Cred,
 			results.Status.MajorStatusString = "bad SPNEGO MIC"
 			return
 		}
-		resp.NegTokenResp.MechListMic = gmr.TokenBuffer
+		resp.MechListMic = gmr.TokenBuffer
 		if callCtx.spnegoAccept.needMic {
 			/* We still expect the initiator's MIC. */
 			results.Status.MajorStatus = S_CONTINUE_NEEDED
@@ -1757,17 +1669,15 @@ func AcceptSecContext(conn *net.Conn, callCtx *CallCtx, ctx *SecCtx, cred *Cred,
 		callCtx.spnegoAccept.sendMic = false
 	}
 	/* Encode the SPNEGO reply if we're sending anything back. */
-	if results.OutputToken != nil || len(resp.NegTokenResp.MechListMic) > 0 {
-		token, err = asn1.Marshal(resp)
+	if results.OutputToken != nil || len(resp.MechListMic) > 0 {
+		token, err = gsstoken.MarshalNegTokenResp(resp)
 		if err != nil {
 			results.OutputToken = nil
 			results.Status.MajorStatus = S_FAILURE
 			results.Status.MajorStatusString = "internal error in SPNEGO"
 			return
 		}
-		/* Strip off the outermost sequence tag. */
-		_, _, _, _, raw := splitTagAndLength(token)
-		results.OutputToken = &raw
+		results.OutputToken = &token
 	}
 	return
 }
@@ -1777,7 +1687,7 @@ func proxyAcceptSecContext(conn *net.Conn, callCtx *CallCtx, ctx *SecCtx, cred *
 		Ctx          []rawSecCtx
 		Cred         []rawCred
 		InputToken   []byte
-		InputCB      [][]byte
+		InputCB      []rawCB
 		RetDelegCred bool
 		Options      []Option
 	}
@@ -1818,10 +1728,10 @@ func proxyAcceptSecContext(conn *net.Conn, callCtx *CallCtx, ctx *SecCtx, cred *
 	}
 	args.InputToken = inputToken
 	if inputCB != nil {
-		args.InputCB = make([][]byte, 1)
-		args.InputCB[0] = *inputCB
+		args.InputCB = make([]rawCB, 1)
+		args.InputCB[0].ApplicationData = *inputCB
 	} else {
-		args.InputCB = make([][]byte, 0)
+		args.InputCB = make([]rawCB, 0)
 	}
 	args.RetDelegCred = retDelegCred
 	args.Options = options
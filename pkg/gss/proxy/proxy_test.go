@@ -0,0 +1,56 @@
+package proxy
+
+import (
+	"encoding/asn1"
+	"net"
+	"testing"
+
+	gsstoken "github.com/twistlock/gss/pkg/gss/token"
+)
+
+// closedConn returns a connection whose peer has gone away, so that any
+// call to the proxy fails without blocking.
+func closedConn() *net.Conn {
+	client, server := net.Pipe()
+	server.Close()
+	return &client
+}
+
+func FuzzAcceptSecContext(f *testing.F) {
+	mechList, err := asn1.Marshal([]asn1.ObjectIdentifier{MechKerberos5})
+	if err != nil {
+		f.Fatal(err)
+	}
+	init, err := gsstoken.MarshalNegTokenInit(gsstoken.NegTokenInit{
+		MechTypes:   []asn1.ObjectIdentifier{MechSPNEGO, MechKerberos5},
+		MechToken:   []byte("mech token"),
+		MechListMic: []byte("mic"),
+	})
+	if err != nil {
+		f.Fatal(err)
+	}
+	resp, err := gsstoken.MarshalNegTokenResp(gsstoken.NegTokenResp{
+		NegState:      gsstoken.NegStateAcceptIncomplete,
+		ResponseToken: []byte("mech token"),
+		MechListMic:   []byte("mic"),
+	})
+	if err != nil {
+		f.Fatal(err)
+	}
+	for _, tok := range [][]byte{init, resp, init[:len(init)-1], resp[:len(resp)/2], {0x60, 0x84, 0x7f, 0xff, 0xff, 0xff}, {0xa1, 0x80}} {
+		f.Add(tok, false)
+		f.Add(tok, true)
+	}
+	f.Fuzz(func(t *testing.T, tok []byte, continued bool) {
+		var call CallCtx
+		var ctx SecCtx
+		if continued {
+			// Pretend that the mechanism's exchange has finished, and that
+			// the initiator's MIC is still expected.
+			call.spnegoAccept = spnegoAcceptState{mech: MechKerberos5, mechList: mechList, baseComplete: true, needMic: true}
+		}
+		conn := closedConn()
+		defer (*conn).Close()
+		AcceptSecContext(conn, &call, &ctx, nil, tok, nil, false, nil)
+	})
+}
@@ -0,0 +1,184 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"net"
+	"path/filepath"
+	"testing"
+
+	"github.com/davecgh/go-xdr/xdr2"
+	"github.com/twistlock/gss/pkg/gss/oncrpc"
+)
+
+// fakeServerCtx is the ServerCtx which the fake proxy hands out.
+var fakeServerCtx = []byte("fake server context")
+
+// fakeExtensions returns the extension names which the fake proxy reports,
+// which are large enough that the reply has to be split into several
+// fragments.
+func fakeExtensions() [][]byte {
+	var extensions [][]byte
+	for i := 0; i < 3; i++ {
+		extensions = append(extensions, bytes.Repeat([]byte{'a' + byte(i)}, oncrpc.DefaultFragmentSize-100))
+	}
+	return extensions
+}
+
+// startFakeProxy runs a server which answers gss-proxy's GET_CALL_CONTEXT and
+// INDICATE_MECHS procedures, and returns a connection to it.
+func startFakeProxy(t *testing.T) *net.Conn {
+	t.Helper()
+	srv := oncrpc.NewServer()
+	srv.Handle(intGSSPROXY_PROG, intGSSPROXY_VERS, intGET_CALL_CONTEXT, func(req *oncrpc.Request) ([]byte, error) {
+		var args struct {
+			CallCtx CallCtx
+			Options []Option
+		}
+		if _, err := xdr.Unmarshal(bytes.NewReader(req.Args), &args); err != nil {
+			return nil, oncrpc.AcceptError{Stat: oncrpc.GarbageArgs}
+		}
+		var res struct {
+			Status    rawStatus
+			ServerCtx []byte
+			Options   []Option
+		}
+		res.Status.MajorStatus = S_COMPLETE
+		res.ServerCtx = fakeServerCtx
+		var buf bytes.Buffer
+		_, err := xdr.Marshal(&buf, &res)
+		return buf.Bytes(), err
+	})
+	srv.Handle(intGSSPROXY_PROG, intGSSPROXY_VERS, intINDICATE_MECHS, func(req *oncrpc.Request) ([]byte, error) {
+		var res struct {
+			Status              rawStatus
+			Mechs               []rawMechInfo
+			MechAttrDescs       []rawMechAttr
+			SupportedExtensions [][]byte
+			Extensions          []Option
+		}
+		res.Status.MajorStatus = S_COMPLETE
+		res.Status.ServerCtx = fakeServerCtx
+		res.SupportedExtensions = fakeExtensions()
+		var buf bytes.Buffer
+		_, err := xdr.Marshal(&buf, &res)
+		return buf.Bytes(), err
+	})
+
+	l, err := net.Listen("unix", filepath.Join(t.TempDir(), "gssproxy.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(l)
+	conn, err := net.Dial("unix", l.Addr().String())
+	if err != nil {
+		l.Close()
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		conn.Close()
+		l.Close()
+	})
+	return &conn
+}
+
+func TestFakeProxyCalls(t *testing.T) {
+	conn := startFakeProxy(t)
+	var call CallCtx
+
+	gcr, err := GetCallContext(conn, &call, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gcr.Status.MajorStatus != S_COMPLETE || !bytes.Equal(call.ServerCtx, fakeServerCtx) {
+		t.Errorf("GetCallContext returned status %d and server context %q", gcr.Status.MajorStatus, call.ServerCtx)
+	}
+
+	// The reply is bigger than a fragment.
+	imr, err := IndicateMechs(conn, &call)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := fakeExtensions()
+	if len(imr.SupportedExtensions) != len(want) {
+		t.Fatalf("IndicateMechs returned %d extensions, want %d", len(imr.SupportedExtensions), len(want))
+	}
+	for i := range want {
+		if !bytes.Equal(imr.SupportedExtensions[i], want[i]) {
+			t.Errorf("extension %d came back altered", i)
+		}
+	}
+}
+
+func TestCallRpcRejections(t *testing.T) {
+	conn := startFakeProxy(t)
+	var reply bytes.Buffer
+
+	var accept oncrpc.AcceptError
+	err := CallRpc(conn, intGSSPROXY_PROG, intGSSPROXY_VERS, intWRAP, AUTH_NONE, nil, &reply)
+	if !errors.As(err, &accept) || accept.Stat != oncrpc.ProcUnavail {
+		t.Errorf("unhandled procedure: got error %v, want %v", err, oncrpc.AcceptError{Stat: oncrpc.ProcUnavail})
+	}
+	err = CallRpc(conn, intGSSPROXY_PROG, intGSSPROXY_VERS+1, intGET_CALL_CONTEXT, AUTH_NONE, nil, &reply)
+	if !errors.As(err, &accept) || accept.Stat != oncrpc.ProgMismatch || accept.Low != intGSSPROXY_VERS || accept.High != intGSSPROXY_VERS {
+		t.Errorf("unknown version: got error %v", err)
+	}
+	err = CallRpc(conn, intGSSPROXY_PROG, intGSSPROXY_VERS, intGET_CALL_CONTEXT, AUTH_NONE, []byte{1}, &reply)
+	if !errors.As(err, &accept) || accept.Stat != oncrpc.GarbageArgs {
+		t.Errorf("garbage arguments: got error %v", err)
+	}
+}
+
+// acceptedReply encodes a successful reply to call xid.
+func acceptedReply(xid uint32, results []byte) []byte {
+	// xid, REPLY, MSG_ACCEPTED, an AUTH_NONE verifier, SUCCESS.
+	header := make([]byte, 24)
+	binary.BigEndian.PutUint32(header, xid)
+	binary.BigEndian.PutUint32(header[4:], REPLY)
+	return append(header, results...)
+}
+
+// TestCallRpcXID checks that replies are matched to calls using their XIDs,
+// that each call gets a new XID, and that replies can arrive in several
+// fragments.
+func TestCallRpcXID(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	results := bytes.Repeat([]byte("results!"), 100)
+
+	xids := make(chan uint32, 2)
+	go func() {
+		defer server.Close()
+		for i := 0; i < 2; i++ {
+			call, err := oncrpc.ReadRecord(server, oncrpc.DefaultMaxRecordSize)
+			if err != nil || len(call) < 4 {
+				return
+			}
+			xid := binary.BigEndian.Uint32(call)
+			xids <- xid
+			// A reply to some other call, which should be skipped.
+			if oncrpc.WriteRecord(server, acceptedReply(xid+1, []byte("stale"))) != nil {
+				return
+			}
+			w := oncrpc.NewRecordWriter(server, 64)
+			w.Write(acceptedReply(xid, results))
+			if w.EndRecord() != nil {
+				return
+			}
+		}
+	}()
+
+	for i := 0; i < 2; i++ {
+		var reply bytes.Buffer
+		if err := CallRpc(&client, intGSSPROXY_PROG, intGSSPROXY_VERS, intNULL, AUTH_NONE, nil, &reply); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(reply.Bytes(), results) {
+			t.Fatalf("call %d returned %q", i+1, reply.Bytes())
+		}
+	}
+	if first, second := <-xids, <-xids; first == second {
+		t.Errorf("both calls used XID %#x", first)
+	}
+}
@@ -0,0 +1,90 @@
+package sasl
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+
+	"github.com/twistlock/gss/pkg/gss/internal/framed"
+	"github.com/twistlock/gss/pkg/gss/mech"
+)
+
+// wrapOverhead is how many bytes we expect wrapping to add to a message.
+// Kerberos adds at most 60 with the enctypes in use today, so this leaves
+// room to spare when splitting writes to fit the peer's buffer.
+const wrapOverhead = 128
+
+// Conn is a net.Conn which protects data written to it with a negotiated
+// security layer.  Each wrapped buffer is preceded by its length as a
+// four-octet big-endian number, as RFC 4422 section 3.7 describes.
+type Conn struct {
+	net.Conn
+	protected *framed.Conn
+	maxSend   uint32
+}
+
+// NewConn returns a Conn which protects data sent over conn using ctx,
+// encrypting it if conf is true, and integrity-protecting it otherwise.
+// Wrapped buffers sent to the peer are kept no larger than maxSend, unless
+// it is zero, and those received from it may be no larger than maxRecv.
+// The Conn takes ownership of ctx, and releases it when it is closed.
+func NewConn(conn net.Conn, ctx mech.Context, conf bool, maxSend, maxRecv uint32) *Conn {
+	if maxSend == 0 || maxSend > maxBufferLimit {
+		maxSend = maxBufferLimit
+	}
+	if maxRecv == 0 || maxRecv > maxBufferLimit {
+		maxRecv = maxBufferLimit
+	}
+	framing := framed.Framing{
+		WriteToken: func(w io.Writer, token []byte) error {
+			if len(token) > int(maxSend) {
+				return fmt.Errorf("wrapped buffer of %d bytes exceeds the peer's maximum of %d", len(token), maxSend)
+			}
+			buf := binary.BigEndian.AppendUint32(make([]byte, 0, 4+len(token)), uint32(len(token)))
+			_, err := w.Write(append(buf, token...))
+			return err
+		},
+		ReadToken: func(r io.Reader) ([]byte, error) {
+			var length [4]byte
+			if _, err := io.ReadFull(r, length[:]); err != nil {
+				return nil, err
+			}
+			size := binary.BigEndian.Uint32(length[:])
+			if size > maxRecv {
+				return nil, fmt.Errorf("peer sent a %d byte buffer, more than the maximum of %d", size, maxRecv)
+			}
+			token := make([]byte, size)
+			if _, err := io.ReadFull(r, token); err != nil {
+				if err == io.EOF {
+					err = io.ErrUnexpectedEOF
+				}
+				return nil, err
+			}
+			return token, nil
+		},
+		MaxChunk: int(maxSend) - wrapOverhead,
+		Conf:     conf,
+	}
+	return &Conn{Conn: conn, protected: framed.New(conn, ctx, framing), maxSend: maxSend}
+}
+
+// Write protects p and sends it to the peer, split into as many buffers as
+// the peer's maximum buffer size requires.
+func (c *Conn) Write(p []byte) (int, error) {
+	if c.maxSend <= wrapOverhead {
+		return 0, fmt.Errorf("peer's maximum buffer size of %d is too small to use", c.maxSend)
+	}
+	return c.protected.Write(p)
+}
+
+// Read reads data sent by the peer, after checking and removing its
+// protection.
+func (c *Conn) Read(p []byte) (int, error) {
+	return c.protected.Read(p)
+}
+
+// Close closes the connection and releases the context.
+func (c *Conn) Close() error {
+	return c.protected.Close()
+}
@@ -0,0 +1,369 @@
+package sasl
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/asn1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/twistlock/gss/pkg/gss/mech"
+	"github.com/twistlock/gss/pkg/gss/token"
+)
+
+// The SASL names of the GS2 mechanism for Kerberos (RFC 5801), without and
+// with channel binding.
+const (
+	GS2KRB5     = "GS2-KRB5"
+	GS2KRB5Plus = "GS2-KRB5-PLUS"
+
+	// TLSUnique is the channel binding type which RFC 5929 defines, and
+	// which GS2 uses by default.
+	TLSUnique = "tls-unique"
+
+	plusSuffix = "-PLUS"
+	base32     = "ABCDEFGHIJKLMNOPQRSTUVWXYZ234567"
+)
+
+var mechKrb5 = asn1.ObjectIdentifier{1, 2, 840, 113554, 1, 2, 2}
+
+var (
+	// ErrBadGS2Header is returned by a server when the client's first
+	// message doesn't start with a well-formed gs2-header.
+	ErrBadGS2Header = errors.New("malformed GS2 header")
+	// ErrChannelBinding is returned when the two sides disagree about
+	// channel binding, which can mean that the mechanisms advertised by the
+	// server were tampered with.
+	ErrChannelBinding = errors.New("channel binding mismatch")
+)
+
+// BindingsFactory creates a context which is bound to a channel, using
+// applicationData as the application data of its channel bindings.  It can
+// wrap gss.NewInitiatorContextWithBindings(), proxy.NewAcceptorContextWithBindings()
+// and the like.
+type BindingsFactory func(applicationData []byte) (mech.Context, error)
+
+// GS2Name returns the GS2 SASL name of a mechanism.  Kerberos has a
+// registered name; for other mechanisms, the name is derived from a hash of
+// the OID as RFC 5801 section 3.1 describes.  The local library's idea of a
+// mechanism's name can also be had from gss.InquireSaslnameForMech().
+func GS2Name(oid asn1.ObjectIdentifier) (string, error) {
+	if oid.Equal(mechKrb5) {
+		return GS2KRB5, nil
+	}
+	return derivedGS2Name(oid)
+}
+
+// derivedGS2Name derives a mechanism's GS2 name from its OID, ignoring any
+// name which is registered for it.
+func derivedGS2Name(oid asn1.ObjectIdentifier) (string, error) {
+	der, err := asn1.Marshal(oid)
+	if err != nil {
+		return "", err
+	}
+	sum := sha1.Sum(der)
+	// Keep the first 55 bits, and encode them five at a time.
+	bits := binary.BigEndian.Uint64(sum[:8]) >> 9
+	name := make([]byte, 11)
+	for i := len(name) - 1; i >= 0; i-- {
+		name[i] = base32[bits&31]
+		bits >>= 5
+	}
+	return "GS2-" + string(name), nil
+}
+
+// TLSUniqueBinding returns the tls-unique channel binding data for a TLS
+// connection.  Connections using TLS 1.3 don't have any.
+func TLSUniqueBinding(state tls.ConnectionState) ([]byte, error) {
+	if len(state.TLSUnique) == 0 {
+		return nil, errors.New("tls-unique channel binding is not available for this connection")
+	}
+	return state.TLSUnique, nil
+}
+
+// GS2Config controls a GS2 exchange.
+type GS2Config struct {
+	// Mech is the mechanism in use.  GS2 strips the framing from the first
+	// context token, and the server needs to know which mechanism to
+	// restore it for.  Kerberos is assumed if Mech is nil.
+	Mech asn1.ObjectIdentifier
+	// Plus selects the -PLUS variant of the mechanism, which binds
+	// authentication to the channel described by ChannelBinding.
+	Plus bool
+	// ChannelBindingType names the kind of data in ChannelBinding.
+	// TLSUnique is used if it is empty.
+	ChannelBindingType string
+	// ChannelBinding is the channel binding data for the connection, such
+	// as that returned by TLSUniqueBinding().  A client which has it but
+	// isn't using the -PLUS variant tells the server so, and a server which
+	// has it refuses such clients, since it will have offered the -PLUS
+	// variant and an attacker may have hidden it from the client.
+	ChannelBinding []byte
+	// Authzid is the identity which a client asks to act as.
+	Authzid string
+	// Authorize is called by a server, once the client has authenticated,
+	// if the client supplied an authorization identity.  It returns an
+	// error if the client may not act as it.  If Authorize is nil, clients
+	// which supply an identity are refused.
+	Authorize func(authzid string) error
+}
+
+func (config GS2Config) mech() asn1.ObjectIdentifier {
+	if len(config.Mech) == 0 {
+		return mechKrb5
+	}
+	return config.Mech
+}
+
+func (config GS2Config) bindingType() string {
+	if config.ChannelBindingType == "" {
+		return TLSUnique
+	}
+	return config.ChannelBindingType
+}
+
+func (config GS2Config) mechanism() string {
+	name, err := GS2Name(config.mech())
+	if err != nil {
+		return ""
+	}
+	if config.Plus {
+		return name + plusSuffix
+	}
+	return name
+}
+
+// escapeSaslname escapes the characters which can't appear as themselves in
+// a gs2-header's authzid.
+func escapeSaslname(name string) string {
+	return strings.NewReplacer("=", "=3D", ",", "=2C").Replace(name)
+}
+
+func unescapeSaslname(name string) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(name); i++ {
+		if name[i] != '=' {
+			b.WriteByte(name[i])
+			continue
+		}
+		switch {
+		case strings.HasPrefix(name[i:], "=2C"):
+			b.WriteByte(',')
+		case strings.HasPrefix(name[i:], "=3D"):
+			b.WriteByte('=')
+		default:
+			return "", ErrBadGS2Header
+		}
+		i += 2
+	}
+	return b.String(), nil
+}
+
+// channelBindings returns the application data for the channel bindings,
+// which is the gs2-header without its nonstandard flag, followed by the
+// channel binding data when it is in use.
+func channelBindings(header string, cbData []byte) []byte {
+	return append([]byte(header), cbData...)
+}
+
+// GS2Client is the client side of a GS2 mechanism.
+type GS2Client struct {
+	factory BindingsFactory
+	config  GS2Config
+	ctx     mech.Context
+}
+
+// NewGS2Client returns a client which authenticates using a context created
+// by factory.  The context should request mutual authentication, which GS2
+// requires.
+func NewGS2Client(factory BindingsFactory, config GS2Config) *GS2Client {
+	return &GS2Client{factory: factory, config: config}
+}
+
+// Mechanism returns the SASL mechanism name.
+func (c *GS2Client) Mechanism() string {
+	return c.config.mechanism()
+}
+
+// Context returns the context, once the exchange has started.  The caller
+// is responsible for releasing it.
+func (c *GS2Client) Context() mech.Context {
+	return c.ctx
+}
+
+// Step processes a challenge from the server and returns the response to
+// send to it.  The first call's challenge is ignored, and its response is
+// the initial response.  Once done is true, the context is established, and
+// the response only needs to be sent if it isn't empty.
+func (c *GS2Client) Step(challenge []byte) (response []byte, done bool, err error) {
+	if c.ctx != nil {
+		output, complete, err := c.ctx.Step(challenge)
+		if err != nil {
+			return nil, false, err
+		}
+		if output == nil {
+			output = []byte{}
+		}
+		return output, complete, nil
+	}
+
+	var header string
+	var cbData []byte
+	switch {
+	case c.config.Plus:
+		if len(c.config.ChannelBinding) == 0 {
+			return nil, false, fmt.Errorf("%w: no channel binding data for %s", ErrChannelBinding, c.Mechanism())
+		}
+		header = "p=" + c.config.bindingType() + ","
+		cbData = c.config.ChannelBinding
+	case len(c.config.ChannelBinding) > 0:
+		header = "y,"
+	default:
+		header = "n,"
+	}
+	if c.config.Authzid != "" {
+		header += "a=" + escapeSaslname(c.config.Authzid)
+	}
+	header += ","
+
+	ctx, err := c.factory(channelBindings(header, cbData))
+	if err != nil {
+		return nil, false, err
+	}
+	c.ctx = ctx
+	output, complete, err := ctx.Step(nil)
+	if err != nil {
+		return nil, false, err
+	}
+	oid, inner, err := token.Unframe(output)
+	switch {
+	case err == token.ErrNotFramed:
+		return append([]byte("F,"+header), output...), complete, nil
+	case err != nil:
+		return nil, false, err
+	case !oid.Equal(c.config.mech()):
+		return nil, false, fmt.Errorf("context token is for mechanism %s, not %s", oid, c.config.mech())
+	}
+	return append([]byte(header), inner...), complete, nil
+}
+
+// GS2Server is the server side of a GS2 mechanism.
+type GS2Server struct {
+	factory BindingsFactory
+	config  GS2Config
+	ctx     mech.Context
+	authzid string
+}
+
+// NewGS2Server returns a server which authenticates clients using a context
+// created by factory.  config.Plus says which variant the client selected.
+func NewGS2Server(factory BindingsFactory, config GS2Config) *GS2Server {
+	return &GS2Server{factory: factory, config: config}
+}
+
+// Mechanism returns the SASL mechanism name.
+func (s *GS2Server) Mechanism() string {
+	return s.config.mechanism()
+}
+
+// Context returns the context, once the exchange has started.  The caller
+// is responsible for releasing it.
+func (s *GS2Server) Context() mech.Context {
+	return s.ctx
+}
+
+// Authzid returns the authorization identity supplied by the client, if it
+// supplied one.
+func (s *GS2Server) Authzid() string {
+	return s.authzid
+}
+
+// parseHeader splits the gs2-header from the client's first message.
+func (s *GS2Server) parseHeader(response []byte) (nonstd bool, header string, cbData, rest []byte, err error) {
+	if bytes.HasPrefix(response, []byte("F,")) {
+		nonstd = true
+		response = response[2:]
+	}
+	cbEnd := bytes.IndexByte(response, ',')
+	if cbEnd < 0 {
+		return false, "", nil, nil, ErrBadGS2Header
+	}
+	authzEnd := bytes.IndexByte(response[cbEnd+1:], ',')
+	if authzEnd < 0 {
+		return false, "", nil, nil, ErrBadGS2Header
+	}
+	authzEnd += cbEnd + 1
+	header, rest = string(response[:authzEnd+1]), response[authzEnd+1:]
+
+	cbFlag := string(response[:cbEnd])
+	switch {
+	case strings.HasPrefix(cbFlag, "p="):
+		if !s.config.Plus || len(s.config.ChannelBinding) == 0 {
+			return false, "", nil, nil, fmt.Errorf("%w: client asked for channel binding using %s", ErrChannelBinding, s.Mechanism())
+		}
+		if cbFlag[2:] != s.config.bindingType() {
+			return false, "", nil, nil, fmt.Errorf("%w: unsupported channel binding type %q", ErrChannelBinding, cbFlag[2:])
+		}
+		cbData = s.config.ChannelBinding
+	case cbFlag == "n", cbFlag == "y":
+		if s.config.Plus {
+			return false, "", nil, nil, fmt.Errorf("%w: client didn't use channel binding with %s", ErrChannelBinding, s.Mechanism())
+		}
+		if cbFlag == "y" && len(s.config.ChannelBinding) > 0 {
+			return false, "", nil, nil, fmt.Errorf("%w: client thinks we don't support channel binding", ErrChannelBinding)
+		}
+	default:
+		return false, "", nil, nil, ErrBadGS2Header
+	}
+
+	authzid := string(response[cbEnd+1 : authzEnd])
+	if authzid != "" {
+		if !strings.HasPrefix(authzid, "a=") {
+			return false, "", nil, nil, ErrBadGS2Header
+		}
+		if s.authzid, err = unescapeSaslname(authzid[2:]); err != nil {
+			return false, "", nil, nil, err
+		}
+	}
+	return nonstd, header, cbData, rest, nil
+}
+
+// Step processes a response from the client and returns the challenge to
+// send to it.  Once done is true, authentication has succeeded, and a
+// non-empty challenge is the last context token, which protocols that allow
+// it can send along with the outcome.  If err is not nil, a non-empty
+// challenge may still hold an error token to pass to the client.
+func (s *GS2Server) Step(response []byte) (challenge []byte, done bool, err error) {
+	input := response
+	if s.ctx == nil {
+		nonstd, header, cbData, rest, err := s.parseHeader(response)
+		if err != nil {
+			return nil, false, err
+		}
+		if s.ctx, err = s.factory(channelBindings(header, cbData)); err != nil {
+			return nil, false, err
+		}
+		input = rest
+		if !nonstd {
+			if input, err = token.Frame(s.config.mech(), rest); err != nil {
+				return nil, false, err
+			}
+		}
+	}
+	output, complete, err := s.ctx.Step(input)
+	if err != nil || !complete {
+		return output, false, err
+	}
+	if s.authzid != "" {
+		if s.config.Authorize == nil {
+			return nil, false, ErrAuthzid
+		}
+		if err = s.config.Authorize(s.authzid); err != nil {
+			return nil, false, fmt.Errorf("client may not act as %q: %v", s.authzid, err)
+		}
+	}
+	return output, true, nil
+}
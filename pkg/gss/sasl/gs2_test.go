@@ -0,0 +1,194 @@
+package sasl
+
+import (
+	"bytes"
+	"encoding/asn1"
+	"errors"
+	"testing"
+
+	"github.com/twistlock/gss/pkg/gss/mech"
+	"github.com/twistlock/gss/pkg/gss/mech/fake"
+)
+
+func TestGS2Name(t *testing.T) {
+	// The example from RFC 5801, which derives a name for
+	// Kerberos even though it has a registered one.
+	if name, err := derivedGS2Name(mechKrb5); err != nil || name != "GS2-QLJHGJLWNPL" {
+		t.Errorf("derivedGS2Name(Kerberos) = %q, %v, want GS2-QLJHGJLWNPL", name, err)
+	}
+	if name, err := GS2Name(mechKrb5); err != nil || name != GS2KRB5 {
+		t.Errorf("GS2Name(Kerberos) = %q, %v, want %s", name, err, GS2KRB5)
+	}
+	if name, err := GS2Name(asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 2}); err != nil || name != "GS2-F2YBKH3XPJV" {
+		t.Errorf("GS2Name(SPNEGO) = %q, %v, want GS2-F2YBKH3XPJV", name, err)
+	}
+	if name := (GS2Config{Plus: true}).mechanism(); name != GS2KRB5Plus {
+		t.Errorf("mechanism() with Plus = %q, want %s", name, GS2KRB5Plus)
+	}
+}
+
+func TestSaslnameEscaping(t *testing.T) {
+	if escaped := escapeSaslname("a=b,c"); escaped != "a=3Db=2Cc" {
+		t.Errorf("escapeSaslname() = %q", escaped)
+	}
+	if name, err := unescapeSaslname("a=3Db=2Cc"); err != nil || name != "a=b,c" {
+		t.Errorf("unescapeSaslname() = %q, %v", name, err)
+	}
+	for _, bad := range []string{"=", "a=2", "a=2c", "a=41"} {
+		if _, err := unescapeSaslname(bad); err != ErrBadGS2Header {
+			t.Errorf("unescapeSaslname(%q) returned %v", bad, err)
+		}
+	}
+}
+
+func TestParseHeader(t *testing.T) {
+	binding := []byte("binding")
+	for _, tc := range []struct {
+		name     string
+		config   GS2Config
+		response string
+		nonstd   bool
+		header   string
+		cbData   []byte
+		authzid  string
+		rest     string
+		err      error
+	}{
+		{name: "plain", response: "n,,token", header: "n,,", rest: "token"},
+		{name: "authzid", response: "n,a=b=2Cob=3D,token", header: "n,a=b=2Cob=3D,", authzid: "b,ob=", rest: "token"},
+		{name: "nonstandard", response: "F,n,,token", nonstd: true, header: "n,,", rest: "token"},
+		{name: "client supports binding", response: "y,,token", header: "y,,", rest: "token"},
+		{name: "binding", config: GS2Config{Plus: true, ChannelBinding: binding}, response: "p=tls-unique,,token", header: "p=tls-unique,,", cbData: binding, rest: "token"},
+		{name: "no commas", response: "n", err: ErrBadGS2Header},
+		{name: "one comma", response: "n,token", err: ErrBadGS2Header},
+		{name: "unknown flag", response: "x,,token", err: ErrBadGS2Header},
+		{name: "authzid without a=", response: "n,bob,token", err: ErrBadGS2Header},
+		{name: "bad escape", response: "n,a=b=2X,token", err: ErrBadGS2Header},
+		{name: "binding without -PLUS", config: GS2Config{ChannelBinding: binding}, response: "p=tls-unique,,token", err: ErrChannelBinding},
+		{name: "-PLUS without binding data", config: GS2Config{Plus: true}, response: "p=tls-unique,,token", err: ErrChannelBinding},
+		{name: "other binding type", config: GS2Config{Plus: true, ChannelBinding: binding}, response: "p=tls-server-end-point,,token", err: ErrChannelBinding},
+		{name: "no binding with -PLUS", config: GS2Config{Plus: true, ChannelBinding: binding}, response: "n,,token", err: ErrChannelBinding},
+		{name: "downgrade", config: GS2Config{ChannelBinding: binding}, response: "y,,token", err: ErrChannelBinding},
+	} {
+		s := NewGS2Server(nil, tc.config)
+		nonstd, header, cbData, rest, err := s.parseHeader([]byte(tc.response))
+		if !errors.Is(err, tc.err) {
+			t.Errorf("%s: parseHeader() returned %v, want %v", tc.name, err, tc.err)
+			continue
+		}
+		if tc.err != nil {
+			continue
+		}
+		if nonstd != tc.nonstd || header != tc.header || !bytes.Equal(cbData, tc.cbData) || string(rest) != tc.rest || s.Authzid() != tc.authzid {
+			t.Errorf("%s: parseHeader() = %v, %q, %q, %q with authzid %q", tc.name, nonstd, header, cbData, rest, s.Authzid())
+		}
+	}
+}
+
+// gs2Factory returns a BindingsFactory for fake contexts which use the
+// channel bindings as their own.
+func gs2Factory(initiate bool) BindingsFactory {
+	return func(applicationData []byte) (mech.Context, error) {
+		cfg := fake.Config{Initiator: client, Acceptor: service, Key: []byte("key"), Flags: fake.FlagMutual, ChannelBindings: applicationData}
+		if initiate {
+			return fake.NewInitiator(cfg), nil
+		}
+		return fake.NewAcceptor(cfg), nil
+	}
+}
+
+// gs2Exchange passes messages between client and server until the context
+// is established, passing the first message through tamper if it is set.
+func gs2Exchange(c *GS2Client, s *GS2Server, tamper func([]byte) []byte) error {
+	var challenge []byte
+	var serverDone bool
+	for i := 0; i < 10; i++ {
+		response, clientDone, err := c.Step(challenge)
+		if err != nil {
+			return err
+		}
+		if serverDone {
+			if !clientDone || len(response) != 0 {
+				return errors.New("client wasn't done when the server was")
+			}
+			return nil
+		}
+		if i == 0 && tamper != nil {
+			response = tamper(response)
+		}
+		if challenge, serverDone, err = s.Step(response); err != nil {
+			return err
+		}
+	}
+	return errors.New("exchange didn't finish")
+}
+
+func TestGS2(t *testing.T) {
+	binding := []byte("tls-unique data")
+	for _, tc := range []struct {
+		name           string
+		client, server GS2Config
+		err            error
+	}{
+		{"plain", GS2Config{Mech: fake.Mech}, GS2Config{Mech: fake.Mech}, nil},
+		{"binding", GS2Config{Mech: fake.Mech, Plus: true, ChannelBinding: binding}, GS2Config{Mech: fake.Mech, Plus: true, ChannelBinding: binding}, nil},
+		{"binding mismatch", GS2Config{Mech: fake.Mech, Plus: true, ChannelBinding: binding}, GS2Config{Mech: fake.Mech, Plus: true, ChannelBinding: []byte("other data")}, fake.ErrBadBindings},
+		{"client without binding data", GS2Config{Mech: fake.Mech, Plus: true}, GS2Config{Mech: fake.Mech, Plus: true, ChannelBinding: binding}, ErrChannelBinding},
+		// The client could have bound the channel, but -PLUS was hidden
+		// from it.
+		{"downgrade", GS2Config{Mech: fake.Mech, ChannelBinding: binding}, GS2Config{Mech: fake.Mech, ChannelBinding: binding}, ErrChannelBinding},
+	} {
+		c := NewGS2Client(gs2Factory(true), tc.client)
+		s := NewGS2Server(gs2Factory(false), tc.server)
+		if err := gs2Exchange(c, s, nil); !errors.Is(err, tc.err) {
+			t.Errorf("%s: exchange returned %v, want %v", tc.name, err, tc.err)
+			continue
+		}
+		if tc.err == nil && c.Context().(mech.PeerNamer).PeerName() != service {
+			t.Errorf("%s: client authenticated %q", tc.name, c.Context().(mech.PeerNamer).PeerName())
+		}
+	}
+
+	// The fake mechanism's tokens aren't Kerberos ones.
+	c := NewGS2Client(gs2Factory(true), GS2Config{})
+	if _, _, err := c.Step(nil); err == nil {
+		t.Error("client sent a token for the wrong mechanism")
+	}
+}
+
+func TestGS2HeaderIsBound(t *testing.T) {
+	// A client which could bind the channel says so, and a server which
+	// can't bind it lets that through.  The header is part of the channel
+	// bindings, so an attacker who rewrites it is caught.
+	c := NewGS2Client(gs2Factory(true), GS2Config{Mech: fake.Mech, ChannelBinding: []byte("binding")})
+	s := NewGS2Server(gs2Factory(false), GS2Config{Mech: fake.Mech})
+	err := gs2Exchange(c, s, func(response []byte) []byte {
+		return append([]byte("n,,"), bytes.TrimPrefix(response, []byte("y,,"))...)
+	})
+	if err != fake.ErrBadBindings {
+		t.Errorf("exchange with a rewritten header returned %v", err)
+	}
+}
+
+func TestGS2Authzid(t *testing.T) {
+	newClient := func() *GS2Client {
+		return NewGS2Client(gs2Factory(true), GS2Config{Mech: fake.Mech, Authzid: "b,ob"})
+	}
+
+	s := NewGS2Server(gs2Factory(false), GS2Config{Mech: fake.Mech})
+	if err := gs2Exchange(newClient(), s, nil); err != ErrAuthzid {
+		t.Errorf("server without Authorize returned %v", err)
+	}
+
+	var asked string
+	s = NewGS2Server(gs2Factory(false), GS2Config{Mech: fake.Mech, Authorize: func(authzid string) error {
+		asked = authzid
+		return nil
+	}})
+	if err := gs2Exchange(newClient(), s, nil); err != nil {
+		t.Fatal(err)
+	}
+	if asked != "b,ob" || s.Authzid() != "b,ob" {
+		t.Errorf("server was asked about %q and reports %q, want b,ob", asked, s.Authzid())
+	}
+}
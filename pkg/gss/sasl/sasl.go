@@ -0,0 +1,348 @@
+/*
+Package sasl implements the SASL GSSAPI (RFC 4752), GS2-KRB5 (RFC 5801) and GSS-SPNEGO mechanisms on top of any mech.Context implementation.
+
+LDAP, SMTP, IMAP, Kafka and ZooKeeper servers, among others, expect
+Kerberos authentication to arrive inside SASL rather than as bare context
+tokens.  A GSSAPIClient or GSSAPIServer runs the GSSAPI exchange: context
+tokens are passed through until the context is established, and then the
+server offers its security layers and maximum buffer size in a wrapped
+message, to which the client replies with its choice and an optional
+authorization identity.  GS2Client and GS2Server prefix the first context
+token with a header carrying the authorization identity and channel binding
+flags, and bind the context to a TLS channel with the -PLUS variant.
+SPNEGOClient and SPNEGOServer pass SPNEGO tokens through, as Active
+Directory expects.
+
+The context can come from the local library or from gss-proxy, for example:
+
+	ctx := gss.NewInitiatorContext(nil, target, gss.Mech_krb5, gss.Flags{Mutual: true, Integ: true, Conf: true})
+	ctx := proxy.NewInitiatorContext(socket, nil, target, proxy.MechKerberos5, proxy.Flags{Mutual: true, Integ: true, Conf: true})
+
+where target names the service in host-based form, such as "ldap@host".
+Once a GSSAPI or GSS-SPNEGO exchange is done, Conn wraps the connection with
+whichever security layer is in use.
+*/
+package sasl
+
+import (
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/twistlock/gss/pkg/gss/mech"
+)
+
+// GSSAPI is the SASL name of the mechanism.
+const GSSAPI = "GSSAPI"
+
+// Security layers, as carried in the first octet of the layer negotiation
+// messages.
+const (
+	LayerNone            byte = 1
+	LayerIntegrity       byte = 2
+	LayerConfidentiality byte = 4
+
+	layersAll = LayerNone | LayerIntegrity | LayerConfidentiality
+)
+
+const (
+	// DefaultMaxBufferSize is the largest wrapped message accepted from the
+	// peer if Config.MaxBufferSize is not set.
+	DefaultMaxBufferSize = 65536
+	// maxBufferLimit is the largest size which the three-octet field in the
+	// layer negotiation messages can hold.
+	maxBufferLimit = 1<<24 - 1
+)
+
+var (
+	// ErrNoCommonLayer is returned when none of the security layers offered
+	// by the server is acceptable to the client.
+	ErrNoCommonLayer = errors.New("no security layer in common with the peer")
+	// ErrBadLayerMessage is returned when a layer negotiation message is
+	// malformed, or selects a layer which wasn't offered.
+	ErrBadLayerMessage = errors.New("malformed security layer negotiation message")
+	// ErrAuthzid is returned by a server which has been asked to authorize
+	// an identity and has no Config.Authorize function.
+	ErrAuthzid = errors.New("authorization identities are not accepted")
+	// ErrDone is returned by Step once the exchange is over.
+	ErrDone = errors.New("SASL exchange has already finished")
+	// ErrNotDone is returned by Conn before the exchange is over.
+	ErrNotDone = errors.New("SASL exchange has not finished")
+)
+
+// Client is the client side of a mechanism.
+type Client interface {
+	// Mechanism returns the SASL mechanism name.
+	Mechanism() string
+	// Step processes a challenge from the server, and returns the response
+	// to send to it.
+	Step(challenge []byte) (response []byte, done bool, err error)
+}
+
+// Server is the server side of a mechanism.
+type Server interface {
+	// Mechanism returns the SASL mechanism name.
+	Mechanism() string
+	// Step processes a response from the client, and returns the challenge
+	// to send to it.
+	Step(response []byte) (challenge []byte, done bool, err error)
+}
+
+// Config controls the security layer negotiation.
+type Config struct {
+	// Layers is the set of security layers which are acceptable, as a bit
+	// mask of the Layer constants.  All of them are acceptable if it is zero.
+	// The client picks the strongest layer which both sides allow.
+	Layers byte
+	// MaxBufferSize is the size of the largest wrapped message which will be
+	// accepted from the peer.  DefaultMaxBufferSize is used if it is zero.
+	MaxBufferSize uint32
+	// Authzid is the identity which a client asks to act as.  If it is
+	// empty, the client acts as the identity it authenticated as.
+	Authzid string
+	// Authorize is called by a server when the client supplies an
+	// authorization identity, and returns an error if the client may not act
+	// as it.  Checking this usually requires the client's name, which the
+	// server can get from the context it passed to NewGSSAPIServer.  If
+	// Authorize is nil, clients which supply an identity are refused.
+	Authorize func(authzid string) error
+}
+
+func (config Config) layers() byte {
+	if config.Layers&layersAll == 0 {
+		return layersAll
+	}
+	return config.Layers & layersAll
+}
+
+func (config Config) maxBufferSize() uint32 {
+	switch {
+	case config.MaxBufferSize == 0:
+		return DefaultMaxBufferSize
+	case config.MaxBufferSize > maxBufferLimit:
+		return maxBufferLimit
+	}
+	return config.MaxBufferSize
+}
+
+// layerMessage builds the body of a layer negotiation message.
+func layerMessage(layers byte, maxBufferSize uint32, authzid string) []byte {
+	msg := []byte{layers, byte(maxBufferSize >> 16), byte(maxBufferSize >> 8), byte(maxBufferSize)}
+	return append(msg, authzid...)
+}
+
+// parseLayerMessage splits the body of a layer negotiation message.
+func parseLayerMessage(msg []byte) (layers byte, maxBufferSize uint32, authzid string, err error) {
+	if len(msg) < 4 {
+		return 0, 0, "", ErrBadLayerMessage
+	}
+	maxBufferSize = uint32(msg[1])<<16 | uint32(msg[2])<<8 | uint32(msg[3])
+	return msg[0], maxBufferSize, string(msg[4:]), nil
+}
+
+const (
+	stateContext = iota
+	stateOffer
+	stateLayer
+	stateDone
+)
+
+// negotiated is the outcome of an exchange, which both sides need in order
+// to set up a Conn.
+type negotiated struct {
+	ctx     mech.Context
+	state   int
+	layer   byte
+	maxSend uint32
+	maxRecv uint32
+}
+
+// Layer returns the negotiated security layer, once the exchange is done.
+func (n *negotiated) Layer() byte {
+	return n.layer
+}
+
+// Conn returns a connection which protects data sent over conn using the
+// negotiated security layer.  If no layer was negotiated, conn is returned
+// as is; otherwise the returned Conn releases the context when it is
+// closed.
+func (n *negotiated) Conn(conn net.Conn) (net.Conn, error) {
+	if n.state != stateDone {
+		return nil, ErrNotDone
+	}
+	if n.layer == LayerNone {
+		return conn, nil
+	}
+	return NewConn(conn, n.ctx, n.layer == LayerConfidentiality, n.maxSend, n.maxRecv), nil
+}
+
+// GSSAPIClient is the client side of the GSSAPI mechanism.
+type GSSAPIClient struct {
+	negotiated
+	config  Config
+	started bool
+}
+
+// NewGSSAPIClient returns a client which authenticates using ctx, which
+// should be a newly-created initiator context.
+func NewGSSAPIClient(ctx mech.Context, config Config) *GSSAPIClient {
+	return &GSSAPIClient{negotiated: negotiated{ctx: ctx}, config: config}
+}
+
+// Mechanism returns the SASL mechanism name.
+func (c *GSSAPIClient) Mechanism() string {
+	return GSSAPI
+}
+
+// Step processes a challenge from the server and returns the response to
+// send to it.  The first call's challenge is ignored, and its response is
+// the initial response, which protocols without initial responses send
+// after the server's first, empty, challenge.  Once done is true, the client
+// has sent its last response, and the server's outcome message decides
+// whether authentication succeeded.
+func (c *GSSAPIClient) Step(challenge []byte) (response []byte, done bool, err error) {
+	switch c.state {
+	case stateContext:
+		var input []byte
+		if c.started {
+			input = challenge
+		}
+		c.started = true
+		output, complete, err := c.ctx.Step(input)
+		if err != nil {
+			return nil, false, err
+		}
+		if complete {
+			c.state = stateLayer
+		}
+		if output == nil {
+			output = []byte{}
+		}
+		return output, false, nil
+	case stateLayer:
+		msg, _, err := c.ctx.Unwrap(challenge)
+		if err != nil {
+			return nil, false, err
+		}
+		offered, maxSend, _, err := parseLayerMessage(msg)
+		if err != nil {
+			return nil, false, err
+		}
+		acceptable := offered & c.config.layers()
+		switch {
+		case acceptable&LayerConfidentiality != 0:
+			c.layer = LayerConfidentiality
+		case acceptable&LayerIntegrity != 0:
+			c.layer = LayerIntegrity
+		case acceptable&LayerNone != 0:
+			c.layer = LayerNone
+		default:
+			return nil, false, ErrNoCommonLayer
+		}
+		c.maxSend = maxSend
+		if c.layer != LayerNone {
+			c.maxRecv = c.config.maxBufferSize()
+		}
+		response, err = c.ctx.Wrap(layerMessage(c.layer, c.maxRecv, c.config.Authzid), false)
+		if err != nil {
+			return nil, false, err
+		}
+		c.state = stateDone
+		return response, true, nil
+	}
+	return nil, true, ErrDone
+}
+
+// GSSAPIServer is the server side of the GSSAPI mechanism.
+type GSSAPIServer struct {
+	negotiated
+	config  Config
+	authzid string
+}
+
+// NewGSSAPIServer returns a server which authenticates clients using ctx,
+// which should be a newly-created acceptor context.
+func NewGSSAPIServer(ctx mech.Context, config Config) *GSSAPIServer {
+	return &GSSAPIServer{negotiated: negotiated{ctx: ctx}, config: config}
+}
+
+// Mechanism returns the SASL mechanism name.
+func (s *GSSAPIServer) Mechanism() string {
+	return GSSAPI
+}
+
+// Authzid returns the authorization identity supplied by the client, if it
+// supplied one, once the exchange is done.
+func (s *GSSAPIServer) Authzid() string {
+	return s.authzid
+}
+
+// Step processes a response from the client and returns the challenge to
+// send to it.  Once done is true, authentication has succeeded, and there is
+// no challenge to send.  If err is not nil, a non-empty challenge may still
+// hold an error token to pass to the client.
+func (s *GSSAPIServer) Step(response []byte) (challenge []byte, done bool, err error) {
+	switch s.state {
+	case stateContext:
+		output, complete, err := s.ctx.Step(response)
+		if err != nil {
+			return output, false, err
+		}
+		if !complete {
+			return output, false, nil
+		}
+		if len(output) > 0 {
+			// The client gets the last context token before our offer, and
+			// replies to it with an empty response.
+			s.state = stateOffer
+			return output, false, nil
+		}
+		return s.offer()
+	case stateOffer:
+		return s.offer()
+	case stateLayer:
+		msg, _, err := s.ctx.Unwrap(response)
+		if err != nil {
+			return nil, false, err
+		}
+		layer, maxSend, authzid, err := parseLayerMessage(msg)
+		if err != nil {
+			return nil, false, err
+		}
+		if layer&s.config.layers() == 0 || layer&(layer-1) != 0 {
+			return nil, false, ErrBadLayerMessage
+		}
+		if authzid != "" {
+			if s.config.Authorize == nil {
+				return nil, false, ErrAuthzid
+			}
+			if err = s.config.Authorize(authzid); err != nil {
+				return nil, false, fmt.Errorf("client may not act as %q: %v", authzid, err)
+			}
+		}
+		s.layer = layer
+		s.maxSend = maxSend
+		if layer != LayerNone {
+			s.maxRecv = s.config.maxBufferSize()
+		}
+		s.authzid = authzid
+		s.state = stateDone
+		return nil, true, nil
+	}
+	return nil, true, ErrDone
+}
+
+// offer sends the layers we support, and the largest message we accept.
+func (s *GSSAPIServer) offer() ([]byte, bool, error) {
+	layers := s.config.layers()
+	var maxRecv uint32
+	if layers != LayerNone {
+		maxRecv = s.config.maxBufferSize()
+	}
+	challenge, err := s.ctx.Wrap(layerMessage(layers, maxRecv, ""), false)
+	if err != nil {
+		return nil, false, err
+	}
+	s.state = stateLayer
+	return challenge, false, nil
+}
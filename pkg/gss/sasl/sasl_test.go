@@ -0,0 +1,361 @@
+package sasl
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/twistlock/gss/pkg/gss/mech/fake"
+)
+
+const (
+	client  = "alice@EXAMPLE.COM"
+	service = "ldap@ldap.example.com"
+)
+
+func fakeConfig() fake.Config {
+	return fake.Config{Initiator: client, Acceptor: service, Key: []byte("key"), Flags: fake.FlagInteg | fake.FlagConf}
+}
+
+// exchange passes messages between client and server until both are done,
+// and returns the first error either side reports.
+func exchange(client Client, server Server) error {
+	var challenge []byte
+	for i := 0; i < 10; i++ {
+		response, clientDone, err := client.Step(challenge)
+		if err != nil {
+			return err
+		}
+		var serverDone bool
+		challenge, serverDone, err = server.Step(response)
+		if err != nil {
+			return err
+		}
+		if serverDone != clientDone {
+			return errors.New("one side finished before the other")
+		}
+		if serverDone {
+			return nil
+		}
+	}
+	return errors.New("exchange didn't finish")
+}
+
+// offer runs the context exchange between initiator and server by hand, and
+// returns the server's layer offer, still wrapped.
+func offer(t *testing.T, server *GSSAPIServer, initiator *fake.Context) []byte {
+	t.Helper()
+	token, _, err := initiator.Step(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	challenge, done, err := server.Step(token)
+	if err != nil || done {
+		t.Fatalf("server's first step returned done %v, %v", done, err)
+	}
+	return challenge
+}
+
+func TestLayerMessage(t *testing.T) {
+	msg := layerMessage(LayerNone|LayerIntegrity, 0x123456, "bob")
+	if want := []byte{3, 0x12, 0x34, 0x56, 'b', 'o', 'b'}; !bytes.Equal(msg, want) {
+		t.Errorf("layerMessage() = %x, want %x", msg, want)
+	}
+	layers, size, authzid, err := parseLayerMessage(msg)
+	if err != nil || layers != 3 || size != 0x123456 || authzid != "bob" {
+		t.Errorf("parseLayerMessage() = %d, %#x, %q, %v", layers, size, authzid, err)
+	}
+	if _, _, _, err = parseLayerMessage([]byte{1, 0, 0}); err != ErrBadLayerMessage {
+		t.Errorf("parsing a short message returned %v", err)
+	}
+}
+
+func TestMaxBufferSize(t *testing.T) {
+	for _, tc := range []struct{ set, want uint32 }{
+		{0, DefaultMaxBufferSize},
+		{1000, 1000},
+		{maxBufferLimit, maxBufferLimit},
+		{1 << 30, maxBufferLimit},
+	} {
+		if got := (Config{MaxBufferSize: tc.set}).maxBufferSize(); got != tc.want {
+			t.Errorf("maxBufferSize() with %d = %d, want %d", tc.set, got, tc.want)
+		}
+	}
+}
+
+func TestGSSAPILayers(t *testing.T) {
+	for _, tc := range []struct {
+		client, server byte
+		want           byte
+		err            error
+	}{
+		{0, 0, LayerConfidentiality, nil},
+		{LayerIntegrity | LayerNone, 0, LayerIntegrity, nil},
+		{0, LayerIntegrity | LayerNone, LayerIntegrity, nil},
+		{0, LayerNone, LayerNone, nil},
+		{LayerNone, LayerIntegrity | LayerConfidentiality, 0, ErrNoCommonLayer},
+	} {
+		c := NewGSSAPIClient(fake.NewInitiator(fakeConfig()), Config{Layers: tc.client})
+		s := NewGSSAPIServer(fake.NewAcceptor(fakeConfig()), Config{Layers: tc.server})
+		if err := exchange(c, s); err != tc.err {
+			t.Errorf("layers %d and %d: exchange returned %v, want %v", tc.client, tc.server, err, tc.err)
+			continue
+		}
+		if tc.err != nil {
+			continue
+		}
+		if c.Layer() != tc.want || s.Layer() != tc.want {
+			t.Errorf("layers %d and %d: negotiated %d and %d, want %d", tc.client, tc.server, c.Layer(), s.Layer(), tc.want)
+		}
+		if _, _, err := c.Step(nil); err != ErrDone {
+			t.Errorf("stepping a finished client returned %v", err)
+		}
+		if _, _, err := s.Step(nil); err != ErrDone {
+			t.Errorf("stepping a finished server returned %v", err)
+		}
+	}
+}
+
+func TestGSSAPIMaxBufferSize(t *testing.T) {
+	c := NewGSSAPIClient(fake.NewInitiator(fakeConfig()), Config{MaxBufferSize: 1000})
+	s := NewGSSAPIServer(fake.NewAcceptor(fakeConfig()), Config{MaxBufferSize: 1 << 30})
+	if err := exchange(c, s); err != nil {
+		t.Fatal(err)
+	}
+	// Each side sends what the other receives, and the server's size was
+	// clamped to what the message can hold.
+	if c.maxRecv != 1000 || s.maxSend != 1000 {
+		t.Errorf("client receives %d, server sends %d, want 1000", c.maxRecv, s.maxSend)
+	}
+	if s.maxRecv != maxBufferLimit || c.maxSend != maxBufferLimit {
+		t.Errorf("server receives %d, client sends %d, want %d", s.maxRecv, c.maxSend, maxBufferLimit)
+	}
+
+	// Without a security layer, neither side accepts wrapped buffers.
+	c = NewGSSAPIClient(fake.NewInitiator(fakeConfig()), Config{Layers: LayerNone})
+	s = NewGSSAPIServer(fake.NewAcceptor(fakeConfig()), Config{})
+	if err := exchange(c, s); err != nil {
+		t.Fatal(err)
+	}
+	if c.maxRecv != 0 || s.maxRecv != 0 {
+		t.Errorf("without a layer, client accepts %d byte buffers and server %d", c.maxRecv, s.maxRecv)
+	}
+}
+
+func TestGSSAPIOffer(t *testing.T) {
+	initiator := fake.NewInitiator(fakeConfig())
+	s := NewGSSAPIServer(fake.NewAcceptor(fakeConfig()), Config{Layers: LayerNone})
+	msg, _, err := initiator.Unwrap(offer(t, s, initiator))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A server which only offers no layer has no buffer size to offer.
+	if want := layerMessage(LayerNone, 0, ""); !bytes.Equal(msg, want) {
+		t.Errorf("offer %x, want %x", msg, want)
+	}
+
+	initiator = fake.NewInitiator(fakeConfig())
+	s = NewGSSAPIServer(fake.NewAcceptor(fakeConfig()), Config{Layers: LayerIntegrity, MaxBufferSize: 4096})
+	if msg, _, err = initiator.Unwrap(offer(t, s, initiator)); err != nil {
+		t.Fatal(err)
+	}
+	if want := layerMessage(LayerIntegrity, 4096, ""); !bytes.Equal(msg, want) {
+		t.Errorf("offer %x, want %x", msg, want)
+	}
+}
+
+func TestGSSAPIBadResponse(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		response []byte
+	}{
+		{"more than one layer", layerMessage(LayerIntegrity|LayerConfidentiality, 0, "")},
+		{"no layer", layerMessage(0, 0, "")},
+		{"a layer which wasn't offered", layerMessage(LayerConfidentiality, 0, "")},
+		{"a short message", []byte{LayerIntegrity}},
+	} {
+		initiator := fake.NewInitiator(fakeConfig())
+		s := NewGSSAPIServer(fake.NewAcceptor(fakeConfig()), Config{Layers: LayerNone | LayerIntegrity})
+		offer(t, s, initiator)
+		response, err := initiator.Wrap(tc.response, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, done, err := s.Step(response); err != ErrBadLayerMessage || done {
+			t.Errorf("choosing %s returned done %v, %v", tc.name, done, err)
+		}
+	}
+}
+
+func TestGSSAPIAuthzid(t *testing.T) {
+	newClient := func() *GSSAPIClient {
+		return NewGSSAPIClient(fake.NewInitiator(fakeConfig()), Config{Authzid: "bob"})
+	}
+
+	if err := exchange(newClient(), NewGSSAPIServer(fake.NewAcceptor(fakeConfig()), Config{})); err != ErrAuthzid {
+		t.Errorf("server without Authorize returned %v", err)
+	}
+
+	refused := errors.New("alice may not act as bob")
+	s := NewGSSAPIServer(fake.NewAcceptor(fakeConfig()), Config{Authorize: func(string) error { return refused }})
+	if err := exchange(newClient(), s); err == nil || !strings.Contains(err.Error(), refused.Error()) {
+		t.Errorf("refused authorization returned %v", err)
+	}
+
+	var asked string
+	s = NewGSSAPIServer(fake.NewAcceptor(fakeConfig()), Config{Authorize: func(authzid string) error {
+		asked = authzid
+		return nil
+	}})
+	if err := exchange(newClient(), s); err != nil {
+		t.Fatal(err)
+	}
+	if asked != "bob" || s.Authzid() != "bob" {
+		t.Errorf("server was asked about %q and reports %q, want bob", asked, s.Authzid())
+	}
+}
+
+func TestNegotiatedConn(t *testing.T) {
+	c := NewGSSAPIClient(fake.NewInitiator(fakeConfig()), Config{})
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+	if _, err := c.Conn(a); err != ErrNotDone {
+		t.Errorf("Conn before the exchange returned %v", err)
+	}
+
+	c = NewGSSAPIClient(fake.NewInitiator(fakeConfig()), Config{Layers: LayerNone})
+	if err := exchange(c, NewGSSAPIServer(fake.NewAcceptor(fakeConfig()), Config{})); err != nil {
+		t.Fatal(err)
+	}
+	if conn, err := c.Conn(a); err != nil || conn != a {
+		t.Errorf("Conn without a layer returned %v, %v", conn, err)
+	}
+}
+
+// readFrame reads a length-prefixed buffer.
+func readFrame(r io.Reader) ([]byte, error) {
+	var length [4]byte
+	if _, err := io.ReadFull(r, length[:]); err != nil {
+		return nil, err
+	}
+	frame := make([]byte, binary.BigEndian.Uint32(length[:]))
+	_, err := io.ReadFull(r, frame)
+	return frame, err
+}
+
+func writeFrame(w io.Writer, frame []byte) error {
+	_, err := w.Write(append(binary.BigEndian.AppendUint32(nil, uint32(len(frame))), frame...))
+	return err
+}
+
+// established returns both sides of an established fake context.
+func established(t *testing.T) (initiator, acceptor *fake.Context) {
+	t.Helper()
+	initiator, acceptor = fake.NewInitiator(fakeConfig()), fake.NewAcceptor(fakeConfig())
+	token, _, err := initiator.Step(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err = acceptor.Step(token); err != nil {
+		t.Fatal(err)
+	}
+	return initiator, acceptor
+}
+
+func TestConnSplitsWrites(t *testing.T) {
+	initiator, acceptor := established(t)
+	a, b := net.Pipe()
+	defer b.Close()
+	conn := NewConn(a, initiator, true, 300, 0)
+	defer conn.Close()
+
+	data := bytes.Repeat([]byte("0123456789"), 100)
+	written := make(chan error, 1)
+	go func() {
+		n, err := conn.Write(data)
+		if err == nil && n != len(data) {
+			err = io.ErrShortWrite
+		}
+		written <- err
+	}()
+	var got []byte
+	frames := 0
+	for len(got) < len(data) {
+		frame, err := readFrame(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(frame) > 300 {
+			t.Fatalf("wrapped buffer of %d bytes exceeds the peer's maximum", len(frame))
+		}
+		msg, conf, err := acceptor.Unwrap(frame)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !conf {
+			t.Error("data was sent without encrypting it")
+		}
+		got = append(got, msg...)
+		frames++
+	}
+	if err := <-written; err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) || frames < 2 {
+		t.Errorf("received %d bytes in %d buffers, want %d in several", len(got), frames, len(data))
+	}
+
+	// The peer's buffers can be too small to hold anything.
+	tiny := NewConn(b, acceptor, false, 10, 0)
+	if _, err := tiny.Write([]byte("x")); err == nil {
+		t.Error("writing to a peer with a 10 byte buffer succeeded")
+	}
+}
+
+func TestConnRead(t *testing.T) {
+	initiator, acceptor := established(t)
+	a, b := net.Pipe()
+	defer b.Close()
+	conn := NewConn(a, initiator, true, 0, 100)
+	defer conn.Close()
+
+	go func() {
+		sealed, _ := acceptor.Wrap([]byte("hello"), true)
+		writeFrame(b, sealed)
+		// Only integrity-protected, which a confidential Conn refuses.
+		signed, _ := acceptor.Wrap([]byte("plain"), false)
+		writeFrame(b, signed)
+	}()
+	buf := make([]byte, 3)
+	if n, err := conn.Read(buf); err != nil || string(buf[:n]) != "hel" {
+		t.Fatalf("first read returned %q, %v", buf[:n], err)
+	}
+	if n, err := conn.Read(buf); err != nil || string(buf[:n]) != "lo" {
+		t.Fatalf("second read returned %q, %v", buf[:n], err)
+	}
+	if _, err := conn.Read(buf); err == nil {
+		t.Error("reading unencrypted data succeeded")
+	}
+
+	// Buffers larger than we accept are refused before they're read.
+	initiator, _ = established(t)
+	a, b = net.Pipe()
+	defer b.Close()
+	conn = NewConn(a, initiator, true, 0, 100)
+	go b.Write(binary.BigEndian.AppendUint32(nil, 101))
+	if _, err := conn.Read(buf); err == nil {
+		t.Error("reading an oversized buffer succeeded")
+	}
+	conn.Close()
+	if _, err := conn.Read(buf); err != net.ErrClosed {
+		t.Errorf("reading from a closed Conn returned %v", err)
+	}
+	if _, err := conn.Write(buf); err != net.ErrClosed {
+		t.Errorf("writing to a closed Conn returned %v", err)
+	}
+}
@@ -0,0 +1,112 @@
+package sasl
+
+import (
+	"github.com/twistlock/gss/pkg/gss/mech"
+)
+
+// GSSSPNEGO is the SASL name of Microsoft's GSS-SPNEGO mechanism.
+const GSSSPNEGO = "GSS-SPNEGO"
+
+// SPNEGOConfig controls a GSS-SPNEGO exchange.
+type SPNEGOConfig struct {
+	// Layer is the security layer to apply once the exchange is done.
+	// Unlike GSSAPI, GSS-SPNEGO doesn't negotiate one: Active Directory
+	// protects traffic whenever the context was established with the
+	// integrity or confidentiality flag, so Layer has to match the flags
+	// which were requested of the context.  LayerNone is used if it is zero.
+	Layer byte
+	// MaxBufferSize is the size of the largest wrapped message which is sent
+	// to or accepted from the peer.  DefaultMaxBufferSize is used if it is
+	// zero.
+	MaxBufferSize uint32
+}
+
+func (config SPNEGOConfig) negotiated(ctx mech.Context) negotiated {
+	n := negotiated{ctx: ctx, layer: config.Layer}
+	if n.layer != LayerIntegrity && n.layer != LayerConfidentiality {
+		n.layer = LayerNone
+	}
+	maxBufferSize := Config{MaxBufferSize: config.MaxBufferSize}.maxBufferSize()
+	n.maxSend, n.maxRecv = maxBufferSize, maxBufferSize
+	return n
+}
+
+// SPNEGOClient is the client side of the GSS-SPNEGO mechanism, which passes
+// SPNEGO tokens back and forth without any framing of its own.
+type SPNEGOClient struct {
+	negotiated
+	started bool
+}
+
+// NewSPNEGOClient returns a client which authenticates using ctx, which
+// should be a newly-created initiator context for SPNEGO, such as one
+// returned by gss.NewInitiatorContext() for gss.Mech_spnego, by
+// proxy.NewInitiatorContext() for proxy.MechSPNEGO, or by
+// spnego.NewInitiator().
+func NewSPNEGOClient(ctx mech.Context, config SPNEGOConfig) *SPNEGOClient {
+	return &SPNEGOClient{negotiated: config.negotiated(ctx)}
+}
+
+// Mechanism returns the SASL mechanism name.
+func (c *SPNEGOClient) Mechanism() string {
+	return GSSSPNEGO
+}
+
+// Step processes a challenge from the server and returns the response to
+// send to it.  The first call's challenge is ignored.  Once done is true,
+// the context is established, and the response only needs to be sent if it
+// isn't empty.
+func (c *SPNEGOClient) Step(challenge []byte) (response []byte, done bool, err error) {
+	if c.state == stateDone {
+		return nil, true, ErrDone
+	}
+	var input []byte
+	if c.started {
+		input = challenge
+	}
+	c.started = true
+	output, complete, err := c.ctx.Step(input)
+	if err != nil {
+		return nil, false, err
+	}
+	if complete {
+		c.state = stateDone
+	}
+	if output == nil {
+		output = []byte{}
+	}
+	return output, complete, nil
+}
+
+// SPNEGOServer is the server side of the GSS-SPNEGO mechanism.
+type SPNEGOServer struct {
+	negotiated
+}
+
+// NewSPNEGOServer returns a server which authenticates clients using ctx,
+// which should be a newly-created acceptor context which understands
+// SPNEGO.
+func NewSPNEGOServer(ctx mech.Context, config SPNEGOConfig) *SPNEGOServer {
+	return &SPNEGOServer{negotiated: config.negotiated(ctx)}
+}
+
+// Mechanism returns the SASL mechanism name.
+func (s *SPNEGOServer) Mechanism() string {
+	return GSSSPNEGO
+}
+
+// Step processes a response from the client and returns the challenge to
+// send to it.  Once done is true, authentication has succeeded, and a
+// non-empty challenge is the last SPNEGO token, which Active Directory sends
+// along with the outcome.
+func (s *SPNEGOServer) Step(response []byte) (challenge []byte, done bool, err error) {
+	if s.state == stateDone {
+		return nil, true, ErrDone
+	}
+	output, complete, err := s.ctx.Step(response)
+	if err != nil || !complete {
+		return output, false, err
+	}
+	s.state = stateDone
+	return output, true, nil
+}
@@ -0,0 +1,131 @@
+/*
+Package smtp authenticates net/smtp clients using Kerberos, through the SASL GSSAPI mechanism.
+
+	c, err := smtp.Dial("mail.example.com:25")
+	...
+	err = c.Auth(gsssmtp.GSSAPIAuth())
+
+authenticates to the service "smtp@mail.example.com" using the default
+credentials.  ProxyAuth does the same through gss-proxy.  No security layer
+is negotiated, since net/smtp has no way to apply one; use STARTTLS to
+protect the session instead.
+*/
+package smtp
+
+import (
+	"errors"
+	"net/smtp"
+
+	"github.com/twistlock/gss/pkg/gss"
+	"github.com/twistlock/gss/pkg/gss/mech"
+	"github.com/twistlock/gss/pkg/gss/proxy"
+	"github.com/twistlock/gss/pkg/gss/sasl"
+)
+
+// DefaultService is the service name which RFC 4752 has SMTP clients use.
+const DefaultService = "smtp"
+
+// Auth implements smtp.Auth using the SASL GSSAPI mechanism.  Each Auth
+// holds the state of one exchange, so it should only be used with one
+// smtp.Client at a time.
+type Auth struct {
+	// Socket, if set, is the path of a gss-proxy socket through which the
+	// context is established, in place of the local GSSAPI library.
+	Socket string
+	// Cred, if not nil, is used in place of the default initiator
+	// credentials when the local library is in use.  The caller retains
+	// ownership of it.
+	Cred gss.CredHandle
+	// Service is combined with the server's name to name the service to
+	// authenticate to.  DefaultService is used if it is empty.
+	Service string
+	// Authzid is the identity to act as, if not the authenticated one.
+	Authzid string
+	// NewContext, if set, creates the initiator context for target, such as
+	// "smtp@mail.example.com", in place of either backend.  It lets the
+	// fake mechanism stand in for Kerberos in tests.
+	NewContext func(target string) (mech.Context, error)
+
+	client *sasl.GSSAPIClient
+	ctx    mech.Context
+	name   gss.InternalName
+}
+
+// GSSAPIAuth returns an Auth which uses the local GSSAPI library and the
+// default initiator credentials.
+func GSSAPIAuth() *Auth {
+	return &Auth{}
+}
+
+// ProxyAuth returns an Auth which uses the gss-proxy listening at socket.
+func ProxyAuth(socket string) *Auth {
+	return &Auth{Socket: socket}
+}
+
+// newContext creates an initiator context for target using whichever
+// backend is configured.
+func (a *Auth) newContext(target string) (mech.Context, error) {
+	switch {
+	case a.NewContext != nil:
+		return a.NewContext(target)
+	case a.Socket != "":
+		name := &proxy.Name{DisplayName: target, NameType: proxy.NT_HOSTBASED_SERVICE}
+		return proxy.NewInitiatorContext(a.Socket, nil, name, proxy.MechKerberos5, proxy.Flags{Mutual: true, Integ: true}), nil
+	}
+	major, minor, name := gss.ImportName(target, gss.C_NT_HOSTBASED_SERVICE)
+	if major != gss.S_COMPLETE {
+		return nil, gss.NewGSSError("importing remote service name", major, minor, nil)
+	}
+	a.name = name
+	return gss.NewInitiatorContext(a.Cred, name, gss.Mech_krb5, gss.Flags{Mutual: true, Integ: true}), nil
+}
+
+// release frees the context and name from an exchange.
+func (a *Auth) release() {
+	if a.ctx != nil {
+		a.ctx.Release()
+		a.ctx = nil
+	}
+	if a.name != nil {
+		gss.ReleaseName(a.name)
+		a.name = nil
+	}
+	a.client = nil
+}
+
+// Start implements smtp.Auth.
+func (a *Auth) Start(server *smtp.ServerInfo) (proto string, toServer []byte, err error) {
+	a.release()
+	service := a.Service
+	if service == "" {
+		service = DefaultService
+	}
+	if a.ctx, err = a.newContext(service + "@" + server.Name); err != nil {
+		a.release()
+		return "", nil, err
+	}
+	a.client = sasl.NewGSSAPIClient(a.ctx, sasl.Config{Layers: sasl.LayerNone, Authzid: a.Authzid})
+	toServer, _, err = a.client.Step(nil)
+	if err != nil {
+		a.release()
+		return "", nil, err
+	}
+	return a.client.Mechanism(), toServer, nil
+}
+
+// Next implements smtp.Auth.
+func (a *Auth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if !more {
+		a.release()
+		return nil, nil
+	}
+	if a.client == nil {
+		return nil, errors.New("unexpected server challenge")
+	}
+	toServer, _, err := a.client.Step(fromServer)
+	if err != nil {
+		a.release()
+		return nil, err
+	}
+	return toServer, nil
+}
@@ -0,0 +1,156 @@
+package smtp_test
+
+import (
+	"errors"
+	"net"
+	"net/smtp"
+	"strings"
+	"testing"
+
+	"github.com/twistlock/gss/pkg/gss/mech"
+	"github.com/twistlock/gss/pkg/gss/mech/fake"
+	gsssmtp "github.com/twistlock/gss/pkg/gss/smtp"
+	"github.com/twistlock/gss/pkg/gss/smtp/smtptest"
+)
+
+const (
+	serverName = "mail.example.com"
+	service    = "smtp@" + serverName
+	flags      = fake.FlagMutual | fake.FlagInteg | fake.FlagConf
+)
+
+// newServer starts a server which accepts fake contexts for service, keyed
+// with key.
+func newServer(t *testing.T, key string) *smtptest.Server {
+	t.Helper()
+	srv, err := smtptest.NewServer(fake.AcceptorFactory(fake.Config{Acceptor: service, Key: []byte(key), Flags: flags}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { srv.Close() })
+	return srv
+}
+
+// fakeAuth returns an Auth which authenticates as alice using key.
+func fakeAuth(key string) *gsssmtp.Auth {
+	return &gsssmtp.Auth{
+		NewContext: func(target string) (mech.Context, error) {
+			return fake.NewInitiator(fake.Config{Initiator: "alice@EXAMPLE.COM", Acceptor: target, Key: []byte(key), Flags: flags}), nil
+		},
+	}
+}
+
+func dial(t *testing.T, srv *smtptest.Server) *smtp.Client {
+	t.Helper()
+	conn, err := net.Dial("tcp", srv.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := smtp.NewClient(conn, serverName)
+	if err != nil {
+		conn.Close()
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func send(c *smtp.Client, from, to, body string) error {
+	if err := c.Mail(from); err != nil {
+		return err
+	}
+	if err := c.Rcpt(to); err != nil {
+		return err
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err = w.Write([]byte(body)); err != nil {
+		return err
+	}
+	return w.Close()
+}
+
+func TestAuth(t *testing.T) {
+	srv := newServer(t, "key")
+	c := dial(t, srv)
+	if ok, params := c.Extension("AUTH"); !ok || !strings.Contains(params, "GSSAPI") {
+		t.Fatalf("server doesn't offer AUTH GSSAPI: %q", params)
+	}
+	if err := c.Auth(fakeAuth("key")); err != nil {
+		t.Fatal(err)
+	}
+	if err := send(c, "alice@example.com", "bob@example.com", "Subject: hi\r\n\r\nhello\r\n"); err != nil {
+		t.Fatal(err)
+	}
+	c.Quit()
+
+	messages := srv.Messages()
+	if len(messages) != 1 {
+		t.Fatalf("server has %d messages, want 1", len(messages))
+	}
+	m := messages[0]
+	if m.From != "alice@example.com" || len(m.To) != 1 || m.To[0] != "bob@example.com" || m.Authzid != "" {
+		t.Errorf("unexpected message %+v", m)
+	}
+	if !strings.Contains(string(m.Data), "hello") {
+		t.Errorf("message body %q lacks the text which was sent", m.Data)
+	}
+}
+
+func TestAuthzid(t *testing.T) {
+	srv := newServer(t, "key")
+	srv.Config.Authorize = func(authzid string) error {
+		if authzid != "postmaster" {
+			return errors.New("not allowed")
+		}
+		return nil
+	}
+
+	auth := fakeAuth("key")
+	auth.Authzid = "postmaster"
+	c := dial(t, srv)
+	if err := c.Auth(auth); err != nil {
+		t.Fatal(err)
+	}
+	if err := send(c, "postmaster@example.com", "bob@example.com", "hello\r\n"); err != nil {
+		t.Fatal(err)
+	}
+	if messages := srv.Messages(); len(messages) != 1 || messages[0].Authzid != "postmaster" {
+		t.Errorf("server has messages %+v, want one sent as postmaster", messages)
+	}
+
+	auth = fakeAuth("key")
+	auth.Authzid = "root"
+	if err := dial(t, srv).Auth(auth); err == nil {
+		t.Error("server let the client act as an identity which Authorize refused")
+	}
+}
+
+func TestAuthFailures(t *testing.T) {
+	srv := newServer(t, "key")
+	for _, tc := range []struct {
+		name string
+		auth *gsssmtp.Auth
+	}{
+		{"wrong key", fakeAuth("other key")},
+		{"wrong service", func() *gsssmtp.Auth {
+			auth := fakeAuth("key")
+			auth.Service = "imap"
+			return auth
+		}()},
+	} {
+		c := dial(t, srv)
+		if err := c.Auth(tc.auth); err == nil {
+			t.Errorf("%s: authentication succeeded", tc.name)
+			continue
+		}
+		if err := c.Mail("alice@example.com"); err == nil {
+			t.Errorf("%s: server accepted MAIL without authentication", tc.name)
+		}
+	}
+	if messages := srv.Messages(); len(messages) != 0 {
+		t.Errorf("server accepted messages %+v", messages)
+	}
+}
@@ -0,0 +1,267 @@
+/*
+Package smtptest runs an in-process SMTP server which requires AUTH GSSAPI, for testing clients which authenticate using the smtp package.
+
+The server understands just enough SMTP to authenticate a client and accept
+messages from it, which it keeps for inspection.  Contexts are accepted using
+a keytab, such as one made by a gsstest.KDC, or created by any mech.Factory.
+Since the client names the service after the host it thinks it's talking to,
+it should be created using smtp.NewClient with the service's host name rather
+than with smtp.Dial and the server's loopback address.
+*/
+package smtptest
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/textproto"
+	"strings"
+	"sync"
+
+	"github.com/twistlock/gss/pkg/gss"
+	"github.com/twistlock/gss/pkg/gss/credstore"
+	"github.com/twistlock/gss/pkg/gss/mech"
+	"github.com/twistlock/gss/pkg/gss/sasl"
+)
+
+// Message is a message which a client delivered.
+type Message struct {
+	// Authzid is the authorization identity which the client supplied, if
+	// it supplied one.
+	Authzid string
+	From    string
+	To      []string
+	Data    []byte
+}
+
+// Server is a running SMTP server.
+type Server struct {
+	// Addr is the address which the server listens on.
+	Addr string
+	// Config is used for each client's SASL exchange.  Its Authorize
+	// function decides which authorization identities are accepted.
+	Config sasl.Config
+
+	factory  mech.Factory
+	cred     gss.CredHandle
+	listener net.Listener
+	wg       sync.WaitGroup
+
+	mu       sync.Mutex
+	messages []Message
+}
+
+// NewServer starts a server on the loopback interface which accepts clients
+// using contexts created by factory.
+func NewServer(factory mech.Factory) (*Server, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{Addr: l.Addr().String(), factory: factory, listener: l}
+	s.wg.Add(1)
+	go s.serve()
+	return s, nil
+}
+
+// NewKeytabServer starts a server which accepts clients using the keys in
+// keytab.
+func NewKeytabServer(keytab string) (*Server, error) {
+	major, minor, cred, _, _ := gss.AcquireCredFrom(nil, gss.C_INDEFINITE, nil, gss.C_ACCEPT, credstore.New().SetKeytab(keytab))
+	if major != gss.S_COMPLETE {
+		return nil, gss.NewGSSError("acquiring acceptor credentials", major, minor, nil)
+	}
+	s, err := NewServer(func() (mech.Context, error) {
+		return gss.NewAcceptorContext(cred), nil
+	})
+	if err != nil {
+		gss.ReleaseCred(cred)
+		return nil, err
+	}
+	s.cred = cred
+	return s, nil
+}
+
+// Messages returns the messages which have been delivered so far.
+func (s *Server) Messages() []Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Message(nil), s.messages...)
+}
+
+// Close stops the server and waits for its connections to finish.
+func (s *Server) Close() error {
+	err := s.listener.Close()
+	s.wg.Wait()
+	if s.cred != nil {
+		gss.ReleaseCred(s.cred)
+		s.cred = nil
+	}
+	return err
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer conn.Close()
+			s.handle(textproto.NewConn(conn))
+		}()
+	}
+}
+
+// session is the state of one client's connection.
+type session struct {
+	authenticated bool
+	msg           Message
+}
+
+func (s *Server) handle(c *textproto.Conn) {
+	var sess session
+
+	c.PrintfLine("220 smtptest ESMTP")
+	for {
+		line, err := c.ReadLine()
+		if err != nil {
+			return
+		}
+		verb, arg, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(verb) {
+		case "EHLO":
+			c.PrintfLine("250-smtptest")
+			c.PrintfLine("250 AUTH " + sasl.GSSAPI)
+		case "HELO", "NOOP":
+			c.PrintfLine("250 OK")
+		case "AUTH":
+			if err = s.auth(c, &sess, arg); err != nil {
+				return
+			}
+		case "MAIL":
+			if !sess.authenticated {
+				c.PrintfLine("530 5.7.0 Authentication required")
+				continue
+			}
+			sess.msg = Message{Authzid: sess.msg.Authzid, From: address(arg, "FROM:")}
+			c.PrintfLine("250 OK")
+		case "RCPT":
+			if sess.msg.From == "" {
+				c.PrintfLine("503 5.5.1 Need MAIL first")
+				continue
+			}
+			sess.msg.To = append(sess.msg.To, address(arg, "TO:"))
+			c.PrintfLine("250 OK")
+		case "DATA":
+			if len(sess.msg.To) == 0 {
+				c.PrintfLine("503 5.5.1 Need RCPT first")
+				continue
+			}
+			c.PrintfLine("354 End data with <CR><LF>.<CR><LF>")
+			if sess.msg.Data, err = c.ReadDotBytes(); err != nil {
+				return
+			}
+			s.mu.Lock()
+			s.messages = append(s.messages, sess.msg)
+			s.mu.Unlock()
+			sess.msg = Message{Authzid: sess.msg.Authzid}
+			c.PrintfLine("250 OK")
+		case "RSET":
+			sess.msg = Message{Authzid: sess.msg.Authzid}
+			c.PrintfLine("250 OK")
+		case "QUIT":
+			c.PrintfLine("221 Bye")
+			return
+		default:
+			c.PrintfLine("502 5.5.2 Command not recognized")
+		}
+	}
+}
+
+// address extracts the address from a MAIL or RCPT argument.
+func address(arg, prefix string) string {
+	if len(arg) >= len(prefix) && strings.EqualFold(arg[:len(prefix)], prefix) {
+		arg = arg[len(prefix):]
+	}
+	if i := strings.IndexByte(arg, ' '); i >= 0 {
+		arg = arg[:i]
+	}
+	return strings.Trim(arg, "<>")
+}
+
+// auth runs an AUTH exchange, returning an error only if the connection
+// can't be used any more.
+func (s *Server) auth(c *textproto.Conn, sess *session, arg string) error {
+	mechanism, initial, hasInitial := strings.Cut(arg, " ")
+	if sess.authenticated {
+		return c.PrintfLine("503 5.5.1 Already authenticated")
+	}
+	if !strings.EqualFold(mechanism, sasl.GSSAPI) {
+		return c.PrintfLine("504 5.5.4 Unrecognized authentication type")
+	}
+	ctx, err := s.factory()
+	if err != nil {
+		return c.PrintfLine("454 4.7.0 Temporary authentication failure: %v", err)
+	}
+	defer ctx.Release()
+	server := sasl.NewGSSAPIServer(ctx, s.Config)
+
+	var response []byte
+	if hasInitial && initial != "=" {
+		if response, err = base64.StdEncoding.DecodeString(initial); err != nil {
+			return c.PrintfLine("501 5.5.2 Cannot decode response")
+		}
+	} else {
+		// Ask for the first token.
+		if err = c.PrintfLine("334 "); err != nil {
+			return err
+		}
+		if response, err = readResponse(c); err != nil {
+			return err
+		}
+	}
+	for {
+		if response == nil {
+			return c.PrintfLine("501 5.7.0 Authentication cancelled")
+		}
+		challenge, done, err := server.Step(response)
+		if err != nil {
+			return c.PrintfLine("535 5.7.8 Authentication credentials invalid: %v", err)
+		}
+		if done {
+			sess.authenticated = true
+			sess.msg.Authzid = server.Authzid()
+			return c.PrintfLine("235 2.7.0 Authentication successful")
+		}
+		if err = c.PrintfLine("334 %s", base64.StdEncoding.EncodeToString(challenge)); err != nil {
+			return err
+		}
+		if response, err = readResponse(c); err != nil {
+			return err
+		}
+	}
+}
+
+// readResponse reads a client's response to a challenge, returning nil if
+// the client cancelled the exchange.
+func readResponse(c *textproto.Conn) ([]byte, error) {
+	line, err := c.ReadLine()
+	if err != nil {
+		return nil, err
+	}
+	if line == "*" {
+		return nil, nil
+	}
+	response, err := base64.StdEncoding.DecodeString(line)
+	if err != nil {
+		return nil, fmt.Errorf("cannot decode response: %v", err)
+	}
+	if response == nil {
+		response = []byte{}
+	}
+	return response, nil
+}
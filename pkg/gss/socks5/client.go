@@ -0,0 +1,197 @@
+package socks5
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+
+	"github.com/twistlock/gss/pkg/gss"
+	"github.com/twistlock/gss/pkg/gss/mech"
+	"github.com/twistlock/gss/pkg/gss/proxy"
+)
+
+// DefaultService is the service which SOCKS servers conventionally use for
+// their host-based service name.
+const DefaultService = "rcmd"
+
+// Dialer connects to addresses through a SOCKS5 proxy using the GSSAPI
+// method.
+type Dialer struct {
+	// ProxyAddr is the proxy's address, in host:port form.
+	ProxyAddr string
+	// Service is the service part of the proxy's host-based service name,
+	// which is DefaultService if it is empty.
+	Service string
+	// Level is the lowest protection level which the Dialer accepts, and
+	// the level which it proposes.  It is LevelIntegrity if it is zero.
+	Level byte
+	// Forward, if set, makes the connection to the proxy in place of a
+	// net.Dialer.
+	Forward interface {
+		DialContext(ctx context.Context, network, addr string) (net.Conn, error)
+	}
+
+	// Socket, if set, is the path of a gss-proxy socket through which the
+	// context is established, in place of the local GSSAPI library.
+	Socket string
+	// Cred, if not nil, is used in place of the default initiator
+	// credentials when the local library is in use.  The caller retains
+	// ownership of it.
+	Cred gss.CredHandle
+	// NewContext, if set, creates the initiator context for target, such as
+	// "rcmd@proxy.example.com", in place of either backend.
+	NewContext func(target string) (mech.Context, error)
+}
+
+// NewDialer returns a Dialer which connects through the proxy at addr, using
+// the local GSSAPI library and the default initiator credentials.
+func NewDialer(addr string) *Dialer {
+	return &Dialer{ProxyAddr: addr}
+}
+
+// newContext creates an initiator context for target using whichever backend
+// is configured.  The returned function releases anything besides the
+// context which must outlive the exchange.
+func (d *Dialer) newContext(target string) (mech.Context, func(), error) {
+	switch {
+	case d.NewContext != nil:
+		ctx, err := d.NewContext(target)
+		return ctx, func() {}, err
+	case d.Socket != "":
+		name := &proxy.Name{DisplayName: target, NameType: proxy.NT_HOSTBASED_SERVICE}
+		return proxy.NewInitiatorContext(d.Socket, nil, name, proxy.MechKerberos5, proxy.Flags{Mutual: true, Integ: true, Conf: true}), func() {}, nil
+	}
+	major, minor, name := gss.ImportName(target, gss.C_NT_HOSTBASED_SERVICE)
+	if major != gss.S_COMPLETE {
+		return nil, nil, gss.NewGSSError("importing remote service name", major, minor, nil)
+	}
+	ctx := gss.NewInitiatorContext(d.Cred, name, gss.Mech_krb5, gss.Flags{Mutual: true, Integ: true, Conf: true})
+	return ctx, func() { gss.ReleaseName(name) }, nil
+}
+
+// Dial connects to addr through the proxy.  Only TCP connections are
+// supported.
+func (d *Dialer) Dial(network, addr string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, addr)
+}
+
+// DialContext connects to addr through the proxy, giving up if ctx is done
+// before the connection is made.
+func (d *Dialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+	default:
+		return nil, fmt.Errorf("SOCKS5 GSSAPI dialer does not support network %q", network)
+	}
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port in %q", addr)
+	}
+
+	var conn net.Conn
+	if d.Forward != nil {
+		conn, err = d.Forward.DialContext(ctx, "tcp", d.ProxyAddr)
+	} else {
+		var nd net.Dialer
+		conn, err = nd.DialContext(ctx, "tcp", d.ProxyAddr)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Abandon the handshake if ctx is done first.
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	c, err := d.connect(conn, &Request{Command: CmdConnect, Host: host, Port: uint16(port)})
+	if err != nil {
+		conn.Close()
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, err
+	}
+	return c, nil
+}
+
+// connect authenticates to the proxy on conn and sends req.
+func (d *Dialer) connect(conn net.Conn, req *Request) (*Conn, error) {
+	if _, err := conn.Write([]byte{socksVersion, 1, MethodGSSAPI}); err != nil {
+		return nil, err
+	}
+	var selected [2]byte
+	if _, err := io.ReadFull(conn, selected[:]); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	if selected[0] != socksVersion {
+		return nil, fmt.Errorf("unexpected SOCKS version %d", selected[0])
+	}
+	if selected[1] != MethodGSSAPI {
+		return nil, ErrNoAcceptableMethod
+	}
+
+	proxyHost, _, err := net.SplitHostPort(d.ProxyAddr)
+	if err != nil {
+		return nil, err
+	}
+	service := d.Service
+	if service == "" {
+		service = DefaultService
+	}
+	ctx, release, err := d.newContext(service + "@" + proxyHost)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	level, err := d.authenticate(conn, ctx)
+	if err != nil {
+		ctx.Release()
+		return nil, err
+	}
+
+	c := newConn(conn, ctx, level)
+	if err = WriteRequest(c, req); err == nil {
+		_, _, err = readReply(c)
+	}
+	if err != nil {
+		ctx.Release()
+		return nil, err
+	}
+	return c, nil
+}
+
+// authenticate establishes ctx with the proxy and agrees on a protection
+// level.
+func (d *Dialer) authenticate(conn net.Conn, ctx mech.Context) (byte, error) {
+	if err := establish(conn, ctx, true); err != nil {
+		return 0, err
+	}
+	want := d.Level
+	if want == 0 {
+		want = LevelIntegrity
+	}
+	if err := writeLevel(conn, ctx, want); err != nil {
+		return 0, err
+	}
+	level, err := readLevel(conn, ctx)
+	if err != nil {
+		return 0, err
+	}
+	if level < want || level > LevelConfidentiality {
+		return 0, ErrProtectionLevel
+	}
+	return level, nil
+}
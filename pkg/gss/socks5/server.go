@@ -0,0 +1,123 @@
+package socks5
+
+import (
+	"fmt"
+	"io"
+	"net"
+
+	"github.com/twistlock/gss/pkg/gss"
+	"github.com/twistlock/gss/pkg/gss/mech"
+	"github.com/twistlock/gss/pkg/gss/proxy"
+)
+
+// Server authenticates clients for a SOCKS5 server using the GSSAPI method.
+// A Server can be used by many connections at once.
+type Server struct {
+	// Level is the lowest protection level which the Server accepts.  A
+	// client which proposes less is answered with this level.  It is
+	// LevelIntegrity if it is zero.
+	Level byte
+
+	// Socket, if set, is the path of a gss-proxy socket through which
+	// contexts are accepted, in place of the local GSSAPI library.
+	Socket string
+	// Cred, if not nil, is used in place of the default acceptor
+	// credentials when the local library is in use.  The caller retains
+	// ownership of it.
+	Cred gss.CredHandle
+	// NewContext, if set, creates acceptor contexts in place of either
+	// backend.
+	NewContext mech.Factory
+}
+
+// NewServer returns a Server which uses the local GSSAPI library and the
+// default acceptor credentials.
+func NewServer() *Server {
+	return &Server{}
+}
+
+// NewProxyServer returns a Server which uses the gss-proxy listening at
+// socket.
+func NewProxyServer(socket string) *Server {
+	return &Server{Socket: socket}
+}
+
+func (s *Server) newContext() (mech.Context, error) {
+	switch {
+	case s.NewContext != nil:
+		return s.NewContext()
+	case s.Socket != "":
+		return proxy.NewAcceptorContext(s.Socket, nil), nil
+	}
+	return gss.NewAcceptorContext(s.Cred), nil
+}
+
+// Handshake reads a new client's method selection message, selects the
+// GSSAPI method, and authenticates the client.  If the client doesn't offer
+// the method, it is told that none of its methods are acceptable.
+func (s *Server) Handshake(conn net.Conn) (*Conn, error) {
+	var header [2]byte
+	if _, err := io.ReadFull(conn, header[:]); err != nil {
+		return nil, err
+	}
+	if header[0] != socksVersion {
+		return nil, fmt.Errorf("unexpected SOCKS version %d", header[0])
+	}
+	methods := make([]byte, header[1])
+	if _, err := io.ReadFull(conn, methods); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	for _, method := range methods {
+		if method == MethodGSSAPI {
+			if _, err := conn.Write([]byte{socksVersion, MethodGSSAPI}); err != nil {
+				return nil, err
+			}
+			return s.Authenticate(conn)
+		}
+	}
+	conn.Write([]byte{socksVersion, MethodNoAcceptable})
+	return nil, ErrNoAcceptableMethod
+}
+
+// Authenticate runs the GSSAPI method on conn, once a server which supports
+// other methods as well has selected it.  The returned Conn carries the
+// client's request and the rest of the session; the client's name can be
+// found from its Context if the context implements mech.PeerNamer.
+func (s *Server) Authenticate(conn net.Conn) (*Conn, error) {
+	ctx, err := s.newContext()
+	if err != nil {
+		writeAbort(conn)
+		return nil, err
+	}
+	level, err := s.negotiate(conn, ctx)
+	if err != nil {
+		ctx.Release()
+		return nil, err
+	}
+	return newConn(conn, ctx, level), nil
+}
+
+// negotiate accepts ctx and answers the client's protection level.
+func (s *Server) negotiate(conn net.Conn, ctx mech.Context) (byte, error) {
+	if err := establish(conn, ctx, false); err != nil {
+		return 0, err
+	}
+	level, err := readLevel(conn, ctx)
+	if err != nil {
+		return 0, err
+	}
+	min := s.Level
+	if min == 0 {
+		min = LevelIntegrity
+	}
+	switch {
+	case level < min:
+		level = min
+	case level > LevelConfidentiality:
+		level = LevelConfidentiality
+	}
+	if err = writeLevel(conn, ctx, level); err != nil {
+		return 0, err
+	}
+	return level, nil
+}
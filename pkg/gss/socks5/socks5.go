@@ -0,0 +1,400 @@
+/*
+Package socks5 implements the SOCKS5 GSSAPI authentication method (RFC 1961), on both the client and the server side.
+
+Dialer connects through a SOCKS5 proxy which requires the GSSAPI method,
+and can be used anywhere a golang.org/x/net/proxy Dialer or ContextDialer
+can.  Server authenticates clients for a SOCKS5 server.  Both establish a
+context by passing tokens in RFC 1961 messages, which are framed much like
+the tokens in the misc package, with the message type in place of the tag and
+a 16-bit length.  They then agree on a protection level, and return a Conn,
+which carries the SOCKS request, the reply and all relayed data as wrapped
+tokens.
+*/
+package socks5
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+
+	"github.com/twistlock/gss/pkg/gss/internal/framed"
+	"github.com/twistlock/gss/pkg/gss/mech"
+)
+
+const (
+	socksVersion = 5
+	gssVersion   = 1
+
+	// MethodGSSAPI is the SOCKS5 authentication method which RFC 1961
+	// defines.
+	MethodGSSAPI = 0x01
+	// MethodNoAcceptable is sent by a server which supports none of the
+	// methods which the client offered.
+	MethodNoAcceptable = 0xff
+
+	// RFC 1961 message types.
+	msgAuthentication = 1
+	msgProtection     = 2
+	msgEncapsulation  = 3
+	msgAbort          = 0xff
+
+	// maxToken is the largest token which a message can carry.
+	maxToken = 0xffff
+	// maxChunk is the most data which is wrapped in one message, leaving
+	// room for the mechanism's overhead.
+	maxChunk = 0x8000
+)
+
+// Protection levels.
+const (
+	// LevelIntegrity protects messages against modification.
+	LevelIntegrity = 1
+	// LevelConfidentiality also encrypts messages.
+	LevelConfidentiality = 2
+	// LevelSelective lets each message be protected differently.  It is
+	// not supported, and is answered with LevelConfidentiality.
+	LevelSelective = 3
+)
+
+// SOCKS5 commands.
+const (
+	CmdConnect      = 1
+	CmdBind         = 2
+	CmdUDPAssociate = 3
+)
+
+// Address types.
+const (
+	atypIPv4   = 1
+	atypDomain = 3
+	atypIPv6   = 4
+)
+
+// Reply codes.
+const (
+	ReplySucceeded           = 0
+	ReplyGeneralFailure      = 1
+	ReplyNotAllowed          = 2
+	ReplyNetworkUnreachable  = 3
+	ReplyHostUnreachable     = 4
+	ReplyConnectionRefused   = 5
+	ReplyTTLExpired          = 6
+	ReplyCommandNotSupported = 7
+	ReplyAddressNotSupported = 8
+)
+
+var replyNames = map[byte]string{
+	ReplyGeneralFailure:      "general SOCKS server failure",
+	ReplyNotAllowed:          "connection not allowed by ruleset",
+	ReplyNetworkUnreachable:  "network unreachable",
+	ReplyHostUnreachable:     "host unreachable",
+	ReplyConnectionRefused:   "connection refused",
+	ReplyTTLExpired:          "TTL expired",
+	ReplyCommandNotSupported: "command not supported",
+	ReplyAddressNotSupported: "address type not supported",
+}
+
+// ReplyError is returned by a Dialer when the server refuses a request.
+type ReplyError byte
+
+func (e ReplyError) Error() string {
+	if name, ok := replyNames[byte(e)]; ok {
+		return "SOCKS5 proxy reported " + name
+	}
+	return fmt.Sprintf("SOCKS5 proxy reported error %d", byte(e))
+}
+
+var (
+	// ErrAborted is returned when the peer aborts the context exchange.
+	ErrAborted = errors.New("SOCKS5 GSSAPI authentication aborted by peer")
+	// ErrNoAcceptableMethod is returned when the two sides don't both
+	// support the GSSAPI method.
+	ErrNoAcceptableMethod = errors.New("SOCKS5 GSSAPI method was not accepted")
+	// ErrProtectionLevel is returned when the two sides can't agree on a
+	// protection level.
+	ErrProtectionLevel = errors.New("SOCKS5 GSSAPI protection level was not accepted")
+)
+
+// writeMessage sends an RFC 1961 message.
+func writeMessage(w io.Writer, mtyp byte, token []byte) error {
+	if len(token) > maxToken {
+		return fmt.Errorf("SOCKS5 GSSAPI token is too long (%d bytes)", len(token))
+	}
+	buf := make([]byte, 4, 4+len(token))
+	buf[0], buf[1] = gssVersion, mtyp
+	binary.BigEndian.PutUint16(buf[2:], uint16(len(token)))
+	_, err := w.Write(append(buf, token...))
+	return err
+}
+
+// writeAbort tells the peer that the context exchange failed.
+func writeAbort(w io.Writer) {
+	w.Write([]byte{gssVersion, msgAbort})
+}
+
+// readMessage reads an RFC 1961 message of type mtyp, returning ErrAborted
+// if the peer sent an abort message instead.
+func readMessage(r io.Reader, mtyp byte) ([]byte, error) {
+	var header [4]byte
+	if _, err := io.ReadFull(r, header[:2]); err != nil {
+		return nil, err
+	}
+	if header[0] != gssVersion {
+		return nil, fmt.Errorf("unexpected SOCKS5 GSSAPI message version %d", header[0])
+	}
+	if header[1] == msgAbort {
+		return nil, ErrAborted
+	}
+	if header[1] != mtyp {
+		return nil, fmt.Errorf("unexpected SOCKS5 GSSAPI message type %d", header[1])
+	}
+	if _, err := io.ReadFull(r, header[2:]); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	token := make([]byte, binary.BigEndian.Uint16(header[2:]))
+	if _, err := io.ReadFull(r, token); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	return token, nil
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// establish passes tokens between ctx and the peer until ctx is
+// established.  An acceptor starts by reading the initiator's first token.
+func establish(rw io.ReadWriter, ctx mech.Context, initiate bool) error {
+	var input []byte
+	var err error
+
+	if !initiate {
+		if input, err = readMessage(rw, msgAuthentication); err != nil {
+			return err
+		}
+	}
+	for {
+		output, complete, err := ctx.Step(input)
+		if err != nil {
+			writeAbort(rw)
+			return err
+		}
+		if len(output) > 0 {
+			if err = writeMessage(rw, msgAuthentication, output); err != nil {
+				return err
+			}
+		}
+		if complete {
+			return nil
+		}
+		if input, err = readMessage(rw, msgAuthentication); err != nil {
+			return err
+		}
+	}
+}
+
+// writeLevel sends a protection level, protected but not encrypted.
+func writeLevel(w io.Writer, ctx mech.Context, level byte) error {
+	token, err := ctx.Wrap([]byte{level}, false)
+	if err != nil {
+		return err
+	}
+	return writeMessage(w, msgProtection, token)
+}
+
+// readLevel reads a protection level sent by writeLevel.
+func readLevel(r io.Reader, ctx mech.Context) (byte, error) {
+	token, err := readMessage(r, msgProtection)
+	if err != nil {
+		return 0, err
+	}
+	level, _, err := ctx.Unwrap(token)
+	if err != nil {
+		return 0, err
+	}
+	if len(level) != 1 {
+		return 0, errors.New("malformed SOCKS5 GSSAPI protection level")
+	}
+	return level[0], nil
+}
+
+// Conn carries the rest of a SOCKS5 session, wrapping each write in an
+// encapsulation message and unwrapping them when reading.
+type Conn struct {
+	net.Conn
+	protected *framed.Conn
+	level     byte
+}
+
+func newConn(conn net.Conn, ctx mech.Context, level byte) *Conn {
+	framing := framed.Framing{
+		WriteToken: func(w io.Writer, token []byte) error {
+			return writeMessage(w, msgEncapsulation, token)
+		},
+		ReadToken: func(r io.Reader) ([]byte, error) {
+			return readMessage(r, msgEncapsulation)
+		},
+		MaxChunk: maxChunk,
+		Conf:     level == LevelConfidentiality,
+	}
+	return &Conn{Conn: conn, protected: framed.New(conn, ctx, framing), level: level}
+}
+
+// Context returns the context which protects the session.  On a server, it
+// can report the client's name if it implements mech.PeerNamer.
+func (c *Conn) Context() mech.Context {
+	return c.protected.Context()
+}
+
+// Level returns the protection level which the two sides agreed on.
+func (c *Conn) Level() byte {
+	return c.level
+}
+
+// Write wraps p and sends it in one or more messages.
+func (c *Conn) Write(p []byte) (int, error) {
+	return c.protected.Write(p)
+}
+
+// Read returns data unwrapped from the peer's messages.
+func (c *Conn) Read(p []byte) (int, error) {
+	return c.protected.Read(p)
+}
+
+// Close closes the connection and releases the context.
+func (c *Conn) Close() error {
+	return c.protected.Close()
+}
+
+// Request is a SOCKS5 request.
+type Request struct {
+	Command byte
+	// Host is a host name or an IP address, and Port is the port on it.
+	Host string
+	Port uint16
+}
+
+// Addr returns the request's destination in host:port form.
+func (r *Request) Addr() string {
+	return net.JoinHostPort(r.Host, strconv.Itoa(int(r.Port)))
+}
+
+// appendAddr appends a SOCKS5 address and port.
+func appendAddr(b []byte, host string, port uint16) ([]byte, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			b = append(append(b, atypIPv4), ip4...)
+		} else {
+			b = append(append(b, atypIPv6), ip.To16()...)
+		}
+	} else {
+		if len(host) == 0 || len(host) > 255 {
+			return nil, fmt.Errorf("invalid host name %q", host)
+		}
+		b = append(append(b, atypDomain, byte(len(host))), host...)
+	}
+	return append(b, byte(port>>8), byte(port)), nil
+}
+
+// readAddr reads a SOCKS5 address and port.
+func readAddr(r io.Reader) (string, uint16, error) {
+	var atyp [1]byte
+	if _, err := io.ReadFull(r, atyp[:]); err != nil {
+		return "", 0, err
+	}
+	var addr []byte
+	switch atyp[0] {
+	case atypIPv4:
+		addr = make([]byte, net.IPv4len)
+	case atypIPv6:
+		addr = make([]byte, net.IPv6len)
+	case atypDomain:
+		var length [1]byte
+		if _, err := io.ReadFull(r, length[:]); err != nil {
+			return "", 0, err
+		}
+		addr = make([]byte, length[0])
+	default:
+		return "", 0, ReplyError(ReplyAddressNotSupported)
+	}
+	var port [2]byte
+	if _, err := io.ReadFull(r, addr); err != nil {
+		return "", 0, unexpectedEOF(err)
+	}
+	if _, err := io.ReadFull(r, port[:]); err != nil {
+		return "", 0, unexpectedEOF(err)
+	}
+	host := string(addr)
+	if atyp[0] != atypDomain {
+		host = net.IP(addr).String()
+	}
+	return host, binary.BigEndian.Uint16(port[:]), nil
+}
+
+// WriteRequest sends a request.
+func WriteRequest(w io.Writer, req *Request) error {
+	b, err := appendAddr([]byte{socksVersion, req.Command, 0}, req.Host, req.Port)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(b)
+	return err
+}
+
+// ReadRequest reads a request.  An unsupported address type is reported as
+// a ReplyError, which can be sent back to the client using WriteReply.
+func ReadRequest(r io.Reader) (*Request, error) {
+	var header [3]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+	if header[0] != socksVersion {
+		return nil, fmt.Errorf("unexpected SOCKS version %d", header[0])
+	}
+	req := &Request{Command: header[1]}
+	var err error
+	if req.Host, req.Port, err = readAddr(r); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+// WriteReply sends a reply.  bound is the address which the server used to
+// connect to the destination, and may be nil.
+func WriteReply(w io.Writer, reply byte, bound net.Addr) error {
+	host, port := "0.0.0.0", uint16(0)
+	if tcp, ok := bound.(*net.TCPAddr); ok && tcp != nil {
+		host, port = tcp.IP.String(), uint16(tcp.Port)
+	}
+	b, err := appendAddr([]byte{socksVersion, reply, 0}, host, port)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(b)
+	return err
+}
+
+// readReply reads a reply, returning a ReplyError if it reports failure.
+func readReply(r io.Reader) (string, uint16, error) {
+	var header [3]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return "", 0, unexpectedEOF(err)
+	}
+	if header[0] != socksVersion {
+		return "", 0, fmt.Errorf("unexpected SOCKS version %d", header[0])
+	}
+	host, port, err := readAddr(r)
+	if err != nil {
+		return "", 0, err
+	}
+	if header[1] != ReplySucceeded {
+		return "", 0, ReplyError(header[1])
+	}
+	return host, port, nil
+}
@@ -0,0 +1,258 @@
+package socks5_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/twistlock/gss/pkg/gss/mech"
+	"github.com/twistlock/gss/pkg/gss/mech/fake"
+	"github.com/twistlock/gss/pkg/gss/socks5"
+)
+
+const (
+	target = "rcmd@127.0.0.1"
+	client = "alice@EXAMPLE.COM"
+	flags  = fake.FlagMutual | fake.FlagInteg | fake.FlagConf
+)
+
+func fakeServer(key string, level byte, roundTrips int) *socks5.Server {
+	return &socks5.Server{
+		Level:      level,
+		NewContext: fake.AcceptorFactory(fake.Config{Acceptor: target, Key: []byte(key), Flags: flags, RoundTrips: roundTrips}),
+	}
+}
+
+func fakeDialer(addr, key string, level byte, roundTrips int) *socks5.Dialer {
+	return &socks5.Dialer{
+		ProxyAddr: addr,
+		Level:     level,
+		NewContext: func(name string) (mech.Context, error) {
+			return fake.NewInitiator(fake.Config{Initiator: client, Acceptor: name, Key: []byte(key), Flags: flags, RoundTrips: roundTrips}), nil
+		},
+	}
+}
+
+// serve accepts one connection, runs srv's handshake on it, and passes the
+// result to handle.  The returned channel reports the first error.
+func serve(t *testing.T, srv *socks5.Server, handle func(*socks5.Conn) error) (string, <-chan error) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	done := make(chan error, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			done <- err
+			return
+		}
+		c, err := srv.Handshake(conn)
+		if err != nil {
+			conn.Close()
+			done <- err
+			return
+		}
+		defer c.Close()
+		done <- handle(c)
+	}()
+	return l.Addr().String(), done
+}
+
+// echo answers a CONNECT request and then echoes everything it reads.
+func echo(c *socks5.Conn) error {
+	req, err := socks5.ReadRequest(c)
+	if err != nil {
+		return err
+	}
+	if req.Command != socks5.CmdConnect || req.Addr() != "example.com:80" {
+		return errors.New("unexpected request for " + req.Addr())
+	}
+	if err = socks5.WriteReply(c, socks5.ReplySucceeded, nil); err != nil {
+		return err
+	}
+	_, err = io.Copy(c, c)
+	return err
+}
+
+func TestConnect(t *testing.T) {
+	for _, roundTrips := range []int{1, 3} {
+		var peer string
+		addr, done := serve(t, fakeServer("key", 0, roundTrips), func(c *socks5.Conn) error {
+			peer = c.Context().(mech.PeerNamer).PeerName()
+			return echo(c)
+		})
+		conn, err := fakeDialer(addr, "key", 0, roundTrips).Dial("tcp", "example.com:80")
+		if err != nil {
+			t.Fatalf("%d round trips: %v", roundTrips, err)
+		}
+		if level := conn.(*socks5.Conn).Level(); level != socks5.LevelIntegrity {
+			t.Errorf("%d round trips: negotiated level %d, want %d", roundTrips, level, socks5.LevelIntegrity)
+		}
+
+		// Send more than fits in one message.
+		data := bytes.Repeat([]byte("0123456789abcdef"), 5000)
+		go conn.Write(data)
+		got := make([]byte, len(data))
+		if _, err = io.ReadFull(conn, got); err != nil {
+			t.Fatalf("%d round trips: %v", roundTrips, err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Errorf("%d round trips: echoed data differs", roundTrips)
+		}
+		conn.Close()
+		if err = <-done; err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Errorf("%d round trips: server: %v", roundTrips, err)
+		}
+		if peer != client {
+			t.Errorf("%d round trips: server saw client %q, want %q", roundTrips, peer, client)
+		}
+	}
+}
+
+func TestLevel(t *testing.T) {
+	tests := []struct {
+		dialer, server, want byte
+	}{
+		{0, 0, socks5.LevelIntegrity},
+		{socks5.LevelConfidentiality, 0, socks5.LevelConfidentiality},
+		{socks5.LevelIntegrity, socks5.LevelConfidentiality, socks5.LevelConfidentiality},
+		{socks5.LevelConfidentiality, socks5.LevelIntegrity, socks5.LevelConfidentiality},
+	}
+	for _, test := range tests {
+		var level byte
+		addr, done := serve(t, fakeServer("key", test.server, 1), func(c *socks5.Conn) error {
+			level = c.Level()
+			return echo(c)
+		})
+		conn, err := fakeDialer(addr, "key", test.dialer, 1).Dial("tcp", "example.com:80")
+		if err != nil {
+			t.Fatalf("dialer %d, server %d: %v", test.dialer, test.server, err)
+		}
+		if got := conn.(*socks5.Conn).Level(); got != test.want {
+			t.Errorf("dialer %d, server %d: dialer got level %d, want %d", test.dialer, test.server, got, test.want)
+		}
+		conn.Close()
+		<-done
+		if level != test.want {
+			t.Errorf("dialer %d, server %d: server got level %d, want %d", test.dialer, test.server, level, test.want)
+		}
+	}
+}
+
+// TestWrappedConnect reads the client's request off the wire, checking that
+// it arrives in an encapsulation message which is encrypted when
+// confidentiality was negotiated.
+func TestWrappedConnect(t *testing.T) {
+	for _, level := range []byte{socks5.LevelIntegrity, socks5.LevelConfidentiality} {
+		addr, done := serve(t, fakeServer("key", 0, 1), func(c *socks5.Conn) error {
+			var header [4]byte
+			if _, err := io.ReadFull(c.Conn, header[:]); err != nil {
+				return err
+			}
+			if header[0] != 1 || header[1] != 3 {
+				return errors.New("request is not in an encapsulation message")
+			}
+			token := make([]byte, binary.BigEndian.Uint16(header[2:]))
+			if _, err := io.ReadFull(c.Conn, token); err != nil {
+				return err
+			}
+			if plain := bytes.Contains(token, []byte("example.com")); plain == (level == socks5.LevelConfidentiality) {
+				return errors.New("request was not protected as negotiated")
+			}
+			msg, conf, err := c.Context().Unwrap(token)
+			if err != nil {
+				return err
+			}
+			if conf != (level == socks5.LevelConfidentiality) {
+				return errors.New("request was not protected as negotiated")
+			}
+			want := append([]byte{5, socks5.CmdConnect, 0, 3, 11}, "example.com\x00\x50"...)
+			if !bytes.Equal(msg, want) {
+				return errors.New("malformed request")
+			}
+			return socks5.WriteReply(c, socks5.ReplySucceeded, nil)
+		})
+		conn, err := fakeDialer(addr, "key", level, 1).Dial("tcp", "example.com:80")
+		if err != nil {
+			t.Fatalf("level %d: %v", level, err)
+		}
+		conn.Close()
+		if err = <-done; err != nil {
+			t.Errorf("level %d: server: %v", level, err)
+		}
+	}
+}
+
+func TestReplyError(t *testing.T) {
+	addr, done := serve(t, fakeServer("key", 0, 1), func(c *socks5.Conn) error {
+		if _, err := socks5.ReadRequest(c); err != nil {
+			return err
+		}
+		return socks5.WriteReply(c, socks5.ReplyConnectionRefused, nil)
+	})
+	_, err := fakeDialer(addr, "key", 0, 1).Dial("tcp", "example.com:80")
+	if err != socks5.ReplyError(socks5.ReplyConnectionRefused) {
+		t.Errorf("got error %v, want %v", err, socks5.ReplyError(socks5.ReplyConnectionRefused))
+	}
+	if err = <-done; err != nil {
+		t.Errorf("server: %v", err)
+	}
+}
+
+func TestWrongKey(t *testing.T) {
+	addr, done := serve(t, fakeServer("key", 0, 1), echo)
+	if _, err := fakeDialer(addr, "other key", 0, 1).Dial("tcp", "example.com:80"); err == nil {
+		t.Error("dialer with the wrong key connected")
+	}
+	if err := <-done; err == nil {
+		t.Error("server accepted the wrong key")
+	}
+}
+
+func TestNoAcceptableMethod(t *testing.T) {
+	addr, done := serve(t, fakeServer("key", 0, 1), echo)
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	// Offer only "no authentication required".
+	if _, err = conn.Write([]byte{5, 1, 0}); err != nil {
+		t.Fatal(err)
+	}
+	var reply [2]byte
+	if _, err = io.ReadFull(conn, reply[:]); err != nil {
+		t.Fatal(err)
+	}
+	if reply != [2]byte{5, socks5.MethodNoAcceptable} {
+		t.Errorf("got method selection %v, want %v", reply, [2]byte{5, socks5.MethodNoAcceptable})
+	}
+	if err = <-done; err != socks5.ErrNoAcceptableMethod {
+		t.Errorf("server: got error %v, want %v", err, socks5.ErrNoAcceptableMethod)
+	}
+
+	// A dialer refuses a proxy which doesn't select the GSSAPI method.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		io.ReadFull(conn, make([]byte, 3))
+		conn.Write([]byte{5, socks5.MethodNoAcceptable})
+	}()
+	if _, err = fakeDialer(l.Addr().String(), "key", 0, 1).Dial("tcp", "example.com:80"); err != socks5.ErrNoAcceptableMethod {
+		t.Errorf("dialer: got error %v, want %v", err, socks5.ErrNoAcceptableMethod)
+	}
+}
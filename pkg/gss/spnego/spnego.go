@@ -0,0 +1,375 @@
+/*
+Package spnego negotiates a mechanism using SPNEGO (RFC 4178) on top of any mech.Context implementation.
+
+The proxy package carries its own SPNEGO implementation, since gss-proxy
+doesn't offer SPNEGO itself.  This one follows the same rules, but asks a
+Factory for the underlying contexts, so it works with the local library, with
+gss-proxy, or with the fake mechanism used in tests.
+*/
+package spnego
+
+import (
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/twistlock/gss/pkg/gss/mech"
+	"github.com/twistlock/gss/pkg/gss/token"
+)
+
+// Mech is SPNEGO's OID.
+var Mech = token.MechSPNEGO
+
+var (
+	// ErrNoCommonMech is returned when the two sides share no mechanism.
+	ErrNoCommonMech = errors.New("no mechanism in common with the peer")
+	// ErrRejected is returned when the peer rejects the negotiation.
+	ErrRejected = errors.New("peer rejected the negotiation")
+	// ErrDefectiveToken is returned for negotiation tokens which can't be
+	// parsed or which arrive out of turn.
+	ErrDefectiveToken = errors.New("defective SPNEGO token")
+	// ErrBadMechListMIC is returned when the peer's MIC over the mechanism
+	// list doesn't verify, which can mean that the list was tampered with.
+	ErrBadMechListMIC = errors.New("bad SPNEGO mechanism list MIC")
+	// ErrNotEstablished is returned for per-message operations attempted
+	// before negotiation has finished.
+	ErrNotEstablished = errors.New("SPNEGO negotiation has not finished")
+)
+
+// Factory creates a context for mech, or returns an error if it isn't
+// supported.
+type Factory func(mech asn1.ObjectIdentifier) (mech.Context, error)
+
+// Context negotiates a mechanism and then delegates to a context for it.  It
+// implements mech.Context.
+type Context struct {
+	initiate bool
+	mechs    []asn1.ObjectIdentifier
+	factory  Factory
+
+	mechList     []byte
+	selected     asn1.ObjectIdentifier
+	inner        mech.Context
+	started      bool
+	innerDone    bool
+	needMic      bool
+	sentMic      bool
+	sentMicReq   bool
+	complete     bool
+	peerFinished bool
+}
+
+// NewInitiator creates an initiator which offers mechs, in order of
+// preference, creating contexts for them using factory.
+func NewInitiator(mechs []asn1.ObjectIdentifier, factory Factory) *Context {
+	return &Context{initiate: true, mechs: mechs, factory: factory}
+}
+
+// NewAcceptor creates an acceptor which picks the first of the initiator's
+// mechanisms for which factory succeeds, or the first of those which also
+// appear in mechs, if mechs isn't empty.
+func NewAcceptor(mechs []asn1.ObjectIdentifier, factory Factory) *Context {
+	return &Context{mechs: mechs, factory: factory}
+}
+
+// Mech returns the negotiated mechanism, once one has been selected.
+func (c *Context) Mech() asn1.ObjectIdentifier {
+	return c.selected
+}
+
+// Inner returns the context for the negotiated mechanism, once one has been
+// selected.
+func (c *Context) Inner() mech.Context {
+	return c.inner
+}
+
+func containsOid(list []asn1.ObjectIdentifier, oid asn1.ObjectIdentifier) bool {
+	for _, o := range list {
+		if o.Equal(oid) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Context) selectMech(oid asn1.ObjectIdentifier) error {
+	inner, err := c.factory(oid)
+	if err != nil {
+		return err
+	}
+	if c.inner != nil {
+		c.inner.Release()
+	}
+	c.selected = oid
+	c.inner = inner
+	c.innerDone = false
+	return nil
+}
+
+// stepInner passes a token to the selected mechanism.
+func (c *Context) stepInner(input []byte) (output []byte, err error) {
+	if c.innerDone {
+		if len(input) > 0 {
+			return nil, ErrDefectiveToken
+		}
+		return nil, nil
+	}
+	output, c.innerDone, err = c.inner.Step(input)
+	return output, err
+}
+
+// Step implements mech.Context.
+func (c *Context) Step(input []byte) (output []byte, complete bool, err error) {
+	if c.complete {
+		return nil, true, ErrDefectiveToken
+	}
+	if c.initiate {
+		output, err = c.initiatorStep(input)
+	} else {
+		output, err = c.acceptorStep(input)
+	}
+	return output, c.complete, err
+}
+
+func (c *Context) initiatorStep(input []byte) ([]byte, error) {
+	if !c.started {
+		if input != nil {
+			return nil, ErrDefectiveToken
+		}
+		c.started = true
+		if len(c.mechs) == 0 {
+			return nil, ErrNoCommonMech
+		}
+		var err error
+		if c.mechList, err = asn1.Marshal(c.mechs); err != nil {
+			return nil, err
+		}
+		if err = c.selectMech(c.mechs[0]); err != nil {
+			return nil, err
+		}
+		mechToken, err := c.stepInner(nil)
+		if err != nil {
+			return nil, err
+		}
+		return token.MarshalNegTokenInit(token.NegTokenInit{MechTypes: c.mechs, MechToken: mechToken})
+	}
+
+	resp, err := token.ParseNegTokenResp(input)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", ErrDefectiveToken, err)
+	}
+	switch resp.NegState {
+	case token.NegStateReject:
+		return nil, ErrRejected
+	case token.NegStateRequestMic:
+		c.needMic = true
+	case token.NegStateAcceptCompleted:
+		c.peerFinished = true
+	case token.NegStateAcceptIncomplete:
+	default:
+		return nil, fmt.Errorf("SPNEGO status %d not handled by this implementation", resp.NegState)
+	}
+
+	var mechToken []byte
+	if len(resp.SupportedMech) > 0 && !resp.SupportedMech.Equal(c.selected) {
+		// The acceptor wants a mechanism other than our first choice, so
+		// our optimistic token was wasted, and the mechanism list needs to
+		// be protected with MICs.
+		if !containsOid(c.mechs, resp.SupportedMech) || len(resp.ResponseToken) > 0 {
+			return nil, ErrDefectiveToken
+		}
+		if err = c.selectMech(resp.SupportedMech); err != nil {
+			return nil, err
+		}
+		c.needMic = true
+		if mechToken, err = c.stepInner(nil); err != nil {
+			return nil, err
+		}
+	} else if len(resp.ResponseToken) > 0 {
+		if mechToken, err = c.stepInner(resp.ResponseToken); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(resp.MechListMic) > 0 {
+		if !c.innerDone {
+			return nil, ErrDefectiveToken
+		}
+		if err = c.inner.VerifyMIC(c.mechList, resp.MechListMic); err != nil {
+			return nil, ErrBadMechListMIC
+		}
+		c.needMic = !c.sentMic
+	}
+
+	if c.peerFinished {
+		// The acceptor doesn't expect anything more from us.
+		if !c.innerDone || len(mechToken) > 0 {
+			return nil, ErrDefectiveToken
+		}
+		c.complete = true
+		return nil, nil
+	}
+
+	reply := token.NegTokenResp{NegState: token.NegStateAcceptIncomplete, ResponseToken: mechToken}
+	if c.needMic && c.innerDone && !c.sentMic {
+		if reply.MechListMic, err = c.inner.GetMIC(c.mechList); err != nil {
+			return nil, err
+		}
+		c.sentMic = true
+	}
+	if len(reply.ResponseToken) == 0 && len(reply.MechListMic) == 0 {
+		if c.innerDone {
+			c.complete = true
+			return nil, nil
+		}
+		return nil, ErrDefectiveToken
+	}
+	return token.MarshalNegTokenResp(reply)
+}
+
+func (c *Context) acceptorStep(input []byte) ([]byte, error) {
+	var resp token.NegTokenResp
+	var err error
+
+	if !c.started {
+		c.started = true
+		init, err := token.ParseNegTokenInit(input)
+		if err != nil {
+			return nil, fmt.Errorf("%v: %v", ErrDefectiveToken, err)
+		}
+		if c.mechList, err = asn1.Marshal(init.MechTypes); err != nil {
+			return nil, err
+		}
+		for i, oid := range init.MechTypes {
+			if len(c.mechs) > 0 && !containsOid(c.mechs, oid) {
+				continue
+			}
+			if c.selectMech(oid) == nil {
+				// Unless we picked the initiator's first choice, the
+				// list needs to be protected with MICs.
+				c.needMic = i != 0
+				break
+			}
+		}
+		if c.inner == nil {
+			reject, _ := token.MarshalNegTokenResp(token.NegTokenResp{NegState: token.NegStateReject})
+			return reject, ErrNoCommonMech
+		}
+		resp.SupportedMech = c.selected
+		if !c.needMic {
+			// The optimistic token is only for the first choice.
+			resp.ResponseToken = init.MechToken
+		}
+		resp.MechListMic = init.MechListMic
+	} else {
+		if resp, err = token.ParseNegTokenResp(input); err != nil {
+			return nil, fmt.Errorf("%v: %v", ErrDefectiveToken, err)
+		}
+		if resp.NegState != token.NegStateAcceptCompleted && resp.NegState != token.NegStateAcceptIncomplete {
+			return nil, fmt.Errorf("SPNEGO status %d not handled by this implementation", resp.NegState)
+		}
+		resp.SupportedMech = nil
+	}
+
+	reply := token.NegTokenResp{SupportedMech: resp.SupportedMech}
+	if len(resp.ResponseToken) > 0 {
+		if reply.ResponseToken, err = c.stepInner(resp.ResponseToken); err != nil {
+			reject, _ := token.MarshalNegTokenResp(token.NegTokenResp{NegState: token.NegStateReject})
+			return reject, err
+		}
+	}
+	verified := false
+	if len(resp.MechListMic) > 0 {
+		if !c.innerDone {
+			return nil, ErrDefectiveToken
+		}
+		if err = c.inner.VerifyMIC(c.mechList, resp.MechListMic); err != nil {
+			return nil, ErrBadMechListMIC
+		}
+		verified = true
+	}
+
+	switch {
+	case !c.innerDone:
+		reply.NegState = token.NegStateAcceptIncomplete
+		if c.needMic && !c.sentMicReq {
+			reply.NegState = token.NegStateRequestMic
+			c.sentMicReq = true
+		}
+	case c.needMic && !verified:
+		// Send our MIC, and wait for the initiator's.
+		reply.NegState = token.NegStateAcceptIncomplete
+		if !c.sentMic {
+			if reply.MechListMic, err = c.inner.GetMIC(c.mechList); err != nil {
+				return nil, err
+			}
+			c.sentMic = true
+		}
+	default:
+		reply.NegState = token.NegStateAcceptCompleted
+		if verified && !c.sentMic {
+			if reply.MechListMic, err = c.inner.GetMIC(c.mechList); err != nil {
+				return nil, err
+			}
+			c.sentMic = true
+		}
+		c.complete = true
+	}
+	return token.MarshalNegTokenResp(reply)
+}
+
+func (c *Context) established() error {
+	if !c.complete {
+		return ErrNotEstablished
+	}
+	return nil
+}
+
+// Wrap implements mech.Context.
+func (c *Context) Wrap(message []byte, conf bool) ([]byte, error) {
+	if err := c.established(); err != nil {
+		return nil, err
+	}
+	return c.inner.Wrap(message, conf)
+}
+
+// Unwrap implements mech.Context.
+func (c *Context) Unwrap(tok []byte) ([]byte, bool, error) {
+	if err := c.established(); err != nil {
+		return nil, false, err
+	}
+	return c.inner.Unwrap(tok)
+}
+
+// GetMIC implements mech.Context.
+func (c *Context) GetMIC(message []byte) ([]byte, error) {
+	if err := c.established(); err != nil {
+		return nil, err
+	}
+	return c.inner.GetMIC(message)
+}
+
+// VerifyMIC implements mech.Context.
+func (c *Context) VerifyMIC(message, mic []byte) error {
+	if err := c.established(); err != nil {
+		return err
+	}
+	return c.inner.VerifyMIC(message, mic)
+}
+
+// Expires implements mech.Context.
+func (c *Context) Expires() time.Time {
+	if c.inner == nil {
+		return time.Time{}
+	}
+	return c.inner.Expires()
+}
+
+// Release implements mech.Context.
+func (c *Context) Release() error {
+	if c.inner == nil {
+		return nil
+	}
+	return c.inner.Release()
+}
@@ -0,0 +1,146 @@
+package spnego
+
+import (
+	"encoding/asn1"
+	"testing"
+
+	"github.com/twistlock/gss/pkg/gss/mech"
+	"github.com/twistlock/gss/pkg/gss/mech/fake"
+)
+
+var fakeConfig = fake.Config{
+	Initiator:  "alice@EXAMPLE.COM",
+	Acceptor:   "host/server.example.com@EXAMPLE.COM",
+	Key:        []byte("spnego test key"),
+	Flags:      fake.FlagMutual | fake.FlagConf | fake.FlagInteg,
+	RoundTrips: 2,
+}
+
+var otherMech = asn1.ObjectIdentifier{1, 2, 840, 113554, 1, 2, 2}
+
+// fakeFactory creates fake contexts.  Initiators are created for any
+// mechanism, so that an optimistic token can be sent for otherMech, but
+// acceptors only for the fake one.
+func fakeFactory(initiate bool) Factory {
+	return func(oid asn1.ObjectIdentifier) (mech.Context, error) {
+		if initiate {
+			return fake.NewInitiator(fakeConfig), nil
+		}
+		if !oid.Equal(fake.Mech) {
+			return nil, ErrNoCommonMech
+		}
+		return fake.NewAcceptor(fakeConfig), nil
+	}
+}
+
+// establish runs a negotiation to completion, and returns the tokens which
+// were exchanged.
+func establish(t testing.TB, initiator, acceptor *Context) (tokens [][]byte) {
+	var itok, atok []byte
+	var idone, adone bool
+	var err error
+	for i := 0; !idone || !adone; i++ {
+		if i > 10 {
+			t.Fatal("negotiation didn't finish")
+		}
+		if !idone {
+			itok, idone, err = initiator.Step(atok)
+			if err != nil {
+				t.Fatalf("initiator step %d: %v", i+1, err)
+			}
+			if len(itok) > 0 {
+				tokens = append(tokens, itok)
+			}
+		}
+		if len(itok) > 0 && !adone {
+			atok, adone, err = acceptor.Step(itok)
+			if err != nil {
+				t.Fatalf("acceptor step %d: %v", i+1, err)
+			}
+			if len(atok) > 0 {
+				tokens = append(tokens, atok)
+			}
+		} else {
+			atok = nil
+		}
+	}
+	return tokens
+}
+
+func TestNegotiate(t *testing.T) {
+	for _, mechs := range [][]asn1.ObjectIdentifier{
+		{fake.Mech},
+		// The optimistic token is for the wrong mechanism, so the mechanism
+		// list has to be protected by MICs.
+		{otherMech, fake.Mech},
+	} {
+		initiator := NewInitiator(mechs, fakeFactory(true))
+		acceptor := NewAcceptor(nil, fakeFactory(false))
+		establish(t, initiator, acceptor)
+		if !initiator.Mech().Equal(fake.Mech) || !acceptor.Mech().Equal(fake.Mech) {
+			t.Fatalf("negotiated %v and %v, want %v", initiator.Mech(), acceptor.Mech(), fake.Mech)
+		}
+		wrapped, err := initiator.Wrap([]byte("hello"), true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if message, _, err := acceptor.Unwrap(wrapped); err != nil || string(message) != "hello" {
+			t.Fatalf("Unwrap returned %q, %v", message, err)
+		}
+	}
+}
+
+func TestNoCommonMech(t *testing.T) {
+	initiator := NewInitiator([]asn1.ObjectIdentifier{otherMech}, fakeFactory(true))
+	tok, _, err := initiator.Step(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := NewAcceptor(nil, fakeFactory(false)).Step(tok); err == nil {
+		t.Error("acceptor accepted a token offering no usable mechanism")
+	}
+}
+
+// seedTokens adds the tokens of a complete negotiation to the corpus.
+func seedTokens(f *testing.F) [][]byte {
+	tokens := establish(f, NewInitiator([]asn1.ObjectIdentifier{otherMech, fake.Mech}, fakeFactory(true)), NewAcceptor(nil, fakeFactory(false)))
+	for _, tok := range tokens {
+		f.Add(tok)
+	}
+	f.Add([]byte{})
+	f.Add([]byte{0x60, 0x84, 0x7f, 0xff, 0xff, 0xff})
+	return tokens
+}
+
+func FuzzAcceptorFirstToken(f *testing.F) {
+	seedTokens(f)
+	f.Fuzz(func(t *testing.T, tok []byte) {
+		acceptor := NewAcceptor(nil, fakeFactory(false))
+		defer acceptor.Release()
+		acceptor.Step(tok)
+	})
+}
+
+func FuzzAcceptorLaterToken(f *testing.F) {
+	tokens := seedTokens(f)
+	f.Fuzz(func(t *testing.T, tok []byte) {
+		acceptor := NewAcceptor(nil, fakeFactory(false))
+		defer acceptor.Release()
+		if _, _, err := acceptor.Step(tokens[0]); err != nil {
+			t.Fatal(err)
+		}
+		acceptor.Step(tok)
+	})
+}
+
+func FuzzInitiatorReply(f *testing.F) {
+	seedTokens(f)
+	f.Fuzz(func(t *testing.T, tok []byte) {
+		initiator := NewInitiator([]asn1.ObjectIdentifier{fake.Mech}, fakeFactory(true))
+		defer initiator.Release()
+		if _, _, err := initiator.Step(nil); err != nil {
+			t.Fatal(err)
+		}
+		initiator.Step(tok)
+	})
+}
@@ -0,0 +1,256 @@
+/*
+Package ssh provides Kerberos logins for golang.org/x/crypto/ssh, using the gssapi-with-mic user authentication method from RFC 4462.
+
+Client implements ssh.GSSAPIClient and Server implements ssh.GSSAPIServer,
+using either the local GSSAPI library or gss-proxy:
+
+	config := &ssh.ClientConfig{
+		User: "alice",
+		Auth: []ssh.AuthMethod{ssh.GSSAPIWithMICAuthMethod(gssssh.NewClient(), "server.example.com")},
+		...
+	}
+
+	config := &ssh.ServerConfig{
+		GSSAPIWithMICConfig: &ssh.GSSAPIWithMICConfig{
+			AllowLogin: func(conn ssh.ConnMetadata, srcName string) (*ssh.Permissions, error) { ... },
+			Server:     gssssh.NewServer(),
+		},
+	}
+
+The ssh package builds the MIC field, which covers the session identifier,
+the user name, the service and the method name, and hands it to GetMIC and
+VerifyMIC.  Each Client and Server holds the state of one exchange, and the
+ssh package shares the Server in a ServerConfig among all connections made
+with it, so a server must use a new ServerConfig, with a new Server, for each
+connection.  A Server which is asked to start an exchange while it holds a
+completed one knows that it's being shared: it releases that context and fails
+every later call with ErrServerShared, since it can't tell which connection
+the context belonged to.  Sharing in the middle of a multi-round exchange
+can't be detected.  Key exchange using GSSAPI (gss-keyex) is not supported.
+*/
+package ssh
+
+import (
+	"errors"
+	"strings"
+	"sync"
+
+	"github.com/twistlock/gss/pkg/gss"
+	"github.com/twistlock/gss/pkg/gss/mech"
+	"github.com/twistlock/gss/pkg/gss/proxy"
+)
+
+// ErrNoPeerName is returned by a Server whose context can't report the name
+// of the client which it authenticated.
+var ErrNoPeerName = errors.New("security context does not report the peer's name")
+
+// ErrServerShared is returned by a Server which has found that it's being
+// used by more than one connection.
+var ErrServerShared = errors.New("GSSAPI server is shared between connections")
+
+// serviceName returns the host-based service name for target, which the ssh
+// package passes as "host@" followed by the server's host name.
+func serviceName(target string) string {
+	if strings.Contains(target, "@") {
+		return target
+	}
+	return "host@" + target
+}
+
+// Client implements ssh.GSSAPIClient.
+type Client struct {
+	// Socket, if set, is the path of a gss-proxy socket through which the
+	// context is established, in place of the local GSSAPI library.
+	Socket string
+	// Cred, if not nil, is used in place of the default initiator
+	// credentials when the local library is in use.  The caller retains
+	// ownership of it.
+	Cred gss.CredHandle
+	// NewContext, if set, creates the initiator context for target, such as
+	// "host@server.example.com", in place of either backend.  It lets the
+	// fake mechanism stand in for Kerberos in tests.
+	NewContext func(target string, deleg bool) (mech.Context, error)
+
+	ctx  mech.Context
+	name gss.InternalName
+}
+
+// NewClient returns a Client which uses the local GSSAPI library and the
+// default initiator credentials.
+func NewClient() *Client {
+	return &Client{}
+}
+
+// NewProxyClient returns a Client which uses the gss-proxy listening at
+// socket.
+func NewProxyClient(socket string) *Client {
+	return &Client{Socket: socket}
+}
+
+// newContext creates an initiator context for target using whichever backend
+// is configured.
+func (c *Client) newContext(target string, deleg bool) (mech.Context, error) {
+	switch {
+	case c.NewContext != nil:
+		return c.NewContext(target, deleg)
+	case c.Socket != "":
+		name := &proxy.Name{DisplayName: target, NameType: proxy.NT_HOSTBASED_SERVICE}
+		return proxy.NewInitiatorContext(c.Socket, nil, name, proxy.MechKerberos5, proxy.Flags{Mutual: true, Integ: true, Deleg: deleg}), nil
+	}
+	major, minor, name := gss.ImportName(target, gss.C_NT_HOSTBASED_SERVICE)
+	if major != gss.S_COMPLETE {
+		return nil, gss.NewGSSError("importing remote service name", major, minor, nil)
+	}
+	c.name = name
+	return gss.NewInitiatorContext(c.Cred, name, gss.Mech_krb5, gss.Flags{Mutual: true, Integ: true, Deleg: deleg}), nil
+}
+
+// InitSecContext processes a token from the server, which is nil on the
+// first call, and returns a token to send to it.  isGSSDelegCreds requests
+// that the user's credentials be delegated to the server.
+func (c *Client) InitSecContext(target string, token []byte, isGSSDelegCreds bool) (outputToken []byte, needContinue bool, err error) {
+	if c.ctx == nil {
+		if c.ctx, err = c.newContext(serviceName(target), isGSSDelegCreds); err != nil {
+			c.DeleteSecContext()
+			return nil, false, err
+		}
+	}
+	output, complete, err := c.ctx.Step(token)
+	if err != nil {
+		return nil, false, err
+	}
+	return output, !complete, nil
+}
+
+// GetMIC computes the MIC which proves that the client holds the context.
+func (c *Client) GetMIC(micField []byte) ([]byte, error) {
+	if c.ctx == nil {
+		return nil, errors.New("security context is not established")
+	}
+	return c.ctx.GetMIC(micField)
+}
+
+// DeleteSecContext frees the context and name from an exchange, so that the
+// Client can be used again.
+func (c *Client) DeleteSecContext() error {
+	var err error
+	if c.ctx != nil {
+		err = c.ctx.Release()
+		c.ctx = nil
+	}
+	if c.name != nil {
+		gss.ReleaseName(c.name)
+		c.name = nil
+	}
+	return err
+}
+
+// Server implements ssh.GSSAPIServer.
+type Server struct {
+	// Socket, if set, is the path of a gss-proxy socket through which
+	// contexts are accepted, in place of the local GSSAPI library.
+	Socket string
+	// Cred, if not nil, is used in place of the default acceptor
+	// credentials when the local library is in use.  The caller retains
+	// ownership of it.
+	Cred gss.CredHandle
+	// NewContext, if set, creates acceptor contexts in place of either
+	// backend.  The contexts it creates must implement mech.PeerNamer.
+	NewContext mech.Factory
+
+	mu       sync.Mutex
+	ctx      mech.Context
+	complete bool
+	// shared is set once a second exchange has started while the first
+	// was still in use.  The Server can't tell their calls apart, so it
+	// fails them all from then on.
+	shared bool
+}
+
+// NewServer returns a Server which uses the local GSSAPI library and the
+// default acceptor credentials.
+func NewServer() *Server {
+	return &Server{}
+}
+
+// NewProxyServer returns a Server which uses the gss-proxy listening at
+// socket.
+func NewProxyServer(socket string) *Server {
+	return &Server{Socket: socket}
+}
+
+func (s *Server) newContext() (mech.Context, error) {
+	switch {
+	case s.NewContext != nil:
+		return s.NewContext()
+	case s.Socket != "":
+		return proxy.NewAcceptorContext(s.Socket, nil), nil
+	}
+	return gss.NewAcceptorContext(s.Cred), nil
+}
+
+// AcceptSecContext processes a token from the client and returns a token to
+// send to it.  Once needContinue is false, srcName is the client's principal
+// name, which the ServerConfig's AllowLogin function checks against the user
+// name.
+func (s *Server) AcceptSecContext(token []byte) (outputToken []byte, srcName string, needContinue bool, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.shared {
+		return nil, "", false, ErrServerShared
+	}
+	if s.complete {
+		// This is another connection's first token, and the context
+		// is still in use by the connection which completed it.
+		s.shared = true
+		s.ctx.Release()
+		s.ctx = nil
+		return nil, "", false, ErrServerShared
+	}
+	if s.ctx == nil {
+		if s.ctx, err = s.newContext(); err != nil {
+			return nil, "", false, err
+		}
+	}
+	output, complete, err := s.ctx.Step(token)
+	if err != nil {
+		return output, "", false, err
+	}
+	if !complete {
+		return output, "", true, nil
+	}
+	s.complete = true
+	namer, ok := s.ctx.(mech.PeerNamer)
+	if !ok || namer.PeerName() == "" {
+		return nil, "", false, ErrNoPeerName
+	}
+	return output, namer.PeerName(), false, nil
+}
+
+// VerifyMIC checks the MIC which the client computed over micField.
+func (s *Server) VerifyMIC(micField []byte, micToken []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.shared {
+		return ErrServerShared
+	}
+	if s.ctx == nil {
+		return errors.New("security context is not established")
+	}
+	return s.ctx.VerifyMIC(micField, micToken)
+}
+
+// DeleteSecContext frees the context from an exchange, so that the Server
+// can be used again.  A shared Server has already released its context and
+// stays unusable.
+func (s *Server) DeleteSecContext() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ctx == nil {
+		return nil
+	}
+	err := s.ctx.Release()
+	s.ctx = nil
+	s.complete = false
+	return err
+}
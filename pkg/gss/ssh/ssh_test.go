@@ -0,0 +1,65 @@
+package ssh_test
+
+import (
+	"testing"
+
+	"github.com/twistlock/gss/pkg/gss/mech/fake"
+	gssssh "github.com/twistlock/gss/pkg/gss/ssh"
+)
+
+var fakeConfig = fake.Config{
+	Initiator: "alice@EXAMPLE.COM",
+	Key:       []byte("ssh test key"),
+	Flags:     fake.FlagMutual | fake.FlagInteg,
+}
+
+// TestServerSharedBetweenConnections runs the calls which the ssh package
+// makes for two connections which share a Server, with the second one
+// starting while the first one is still checking the MIC.
+func TestServerSharedBetweenConnections(t *testing.T) {
+	server := &gssssh.Server{NewContext: fake.AcceptorFactory(fakeConfig)}
+	micField := []byte("mic field")
+
+	first := fake.NewInitiator(fakeConfig)
+	defer first.Release()
+	token, _, err := first.Step(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reply, srcName, needContinue, err := server.AcceptSecContext(token)
+	if err != nil || needContinue || srcName != fakeConfig.Initiator {
+		t.Fatalf("AcceptSecContext returned %q, %v, %v", srcName, needContinue, err)
+	}
+	if _, _, err = first.Step(reply); err != nil {
+		t.Fatal(err)
+	}
+
+	second := fake.NewInitiator(fakeConfig)
+	defer second.Release()
+	token, _, err = second.Step(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, err = server.AcceptSecContext(token); err != gssssh.ErrServerShared {
+		t.Fatalf("second exchange: got error %v, want %v", err, gssssh.ErrServerShared)
+	}
+
+	// Whichever connection's DeleteSecContext comes first, neither one
+	// can use the Server afterwards.
+	if err = server.DeleteSecContext(); err != nil {
+		t.Fatal(err)
+	}
+	mic, err := first.GetMIC(micField)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = server.VerifyMIC(micField, mic); err != gssssh.ErrServerShared {
+		t.Fatalf("VerifyMIC on a shared Server returned %v", err)
+	}
+	if err = server.DeleteSecContext(); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, err = server.AcceptSecContext(token); err != gssssh.ErrServerShared {
+		t.Fatalf("retried exchange returned %v", err)
+	}
+}
@@ -0,0 +1,200 @@
+/*
+Package sshtest runs an in-process SSH server which only allows gssapi-with-mic logins, for testing clients which use the ssh package.
+
+The server accepts contexts using a keytab, such as one made by a
+gsstest.KDC, or contexts created by any mech.Factory.  It runs no shell:
+each command which a client execs prints the principal name which the
+client authenticated as, and exits with status 0.  Clients should check
+the server's host key using HostKey().
+*/
+package sshtest
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"fmt"
+	"net"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/twistlock/gss/pkg/gss"
+	"github.com/twistlock/gss/pkg/gss/credstore"
+	"github.com/twistlock/gss/pkg/gss/mech"
+	gssssh "github.com/twistlock/gss/pkg/gss/ssh"
+)
+
+var (
+	_ ssh.GSSAPIClient = (*gssssh.Client)(nil)
+	_ ssh.GSSAPIServer = (*gssssh.Server)(nil)
+)
+
+// Login is a successful login.
+type Login struct {
+	User    string
+	SrcName string
+}
+
+// Server is a running SSH server.
+type Server struct {
+	// Addr is the address which the server listens on.
+	Addr string
+	// AllowLogin decides whether a client which authenticated as srcName
+	// may log in as the user it asked for.  All logins are allowed if it
+	// is nil.
+	AllowLogin func(conn ssh.ConnMetadata, srcName string) (*ssh.Permissions, error)
+
+	factory  mech.Factory
+	cred     gss.CredHandle
+	hostKey  ssh.Signer
+	listener net.Listener
+	wg       sync.WaitGroup
+
+	mu     sync.Mutex
+	logins []Login
+}
+
+// NewServer starts a server on the loopback interface which accepts clients
+// using contexts created by factory.
+func NewServer(factory mech.Factory) (*Server, error) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	hostKey, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		return nil, err
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{Addr: l.Addr().String(), factory: factory, hostKey: hostKey, listener: l}
+	s.wg.Add(1)
+	go s.serve()
+	return s, nil
+}
+
+// NewKeytabServer starts a server which accepts clients using the keys in
+// keytab.
+func NewKeytabServer(keytab string) (*Server, error) {
+	major, minor, cred, _, _ := gss.AcquireCredFrom(nil, gss.C_INDEFINITE, nil, gss.C_ACCEPT, credstore.New().SetKeytab(keytab))
+	if major != gss.S_COMPLETE {
+		return nil, gss.NewGSSError("acquiring acceptor credentials", major, minor, nil)
+	}
+	s, err := NewServer(func() (mech.Context, error) {
+		return gss.NewAcceptorContext(cred), nil
+	})
+	if err != nil {
+		gss.ReleaseCred(cred)
+		return nil, err
+	}
+	s.cred = cred
+	return s, nil
+}
+
+// HostKey returns the server's host key, for use with ssh.FixedHostKey().
+func (s *Server) HostKey() ssh.PublicKey {
+	return s.hostKey.PublicKey()
+}
+
+// Logins returns the logins which have succeeded so far.
+func (s *Server) Logins() []Login {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Login(nil), s.logins...)
+}
+
+// Close stops the server and waits for its connections to finish.
+func (s *Server) Close() error {
+	err := s.listener.Close()
+	s.wg.Wait()
+	if s.cred != nil {
+		gss.ReleaseCred(s.cred)
+		s.cred = nil
+	}
+	return err
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer conn.Close()
+			s.handle(conn)
+		}()
+	}
+}
+
+func (s *Server) allowLogin(conn ssh.ConnMetadata, srcName string) (*ssh.Permissions, error) {
+	perms := &ssh.Permissions{}
+	if s.AllowLogin != nil {
+		var err error
+		if perms, err = s.AllowLogin(conn, srcName); err != nil {
+			return nil, err
+		}
+		if perms == nil {
+			perms = &ssh.Permissions{}
+		}
+	}
+	if perms.Extensions == nil {
+		perms.Extensions = make(map[string]string)
+	}
+	perms.Extensions["gss-src-name"] = srcName
+	return perms, nil
+}
+
+func (s *Server) handle(conn net.Conn) {
+	// The GSSAPIServer holds the state of one exchange, so each connection
+	// gets its own configuration.
+	config := &ssh.ServerConfig{
+		GSSAPIWithMICConfig: &ssh.GSSAPIWithMICConfig{
+			AllowLogin: s.allowLogin,
+			Server:     &gssssh.Server{NewContext: s.factory},
+		},
+	}
+	config.AddHostKey(s.hostKey)
+	sconn, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		return
+	}
+	defer sconn.Close()
+	srcName := sconn.Permissions.Extensions["gss-src-name"]
+	s.mu.Lock()
+	s.logins = append(s.logins, Login{User: sconn.User(), SrcName: srcName})
+	s.mu.Unlock()
+
+	go ssh.DiscardRequests(reqs)
+	for newChannel := range chans {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "only sessions are supported")
+			continue
+		}
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			continue
+		}
+		go session(channel, requests, srcName)
+	}
+}
+
+// session answers exec requests with the client's principal name.
+func session(channel ssh.Channel, requests <-chan *ssh.Request, srcName string) {
+	defer channel.Close()
+	for req := range requests {
+		if req.Type != "exec" {
+			req.Reply(false, nil)
+			continue
+		}
+		req.Reply(true, nil)
+		fmt.Fprintln(channel, srcName)
+		channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{0}))
+		return
+	}
+}
@@ -0,0 +1,610 @@
+/*
+Package stream protects a network connection using a security context, and replaces the context in-band before it expires.
+
+Messages are framed using the token format from the misc package.  Once the
+context in use is due to expire, the initiator negotiates a replacement using
+misc.TOKEN_RECONTEXT tokens, interleaved with data on the same connection.  An
+empty TOKEN_RECONTEXT token marks the point after which its sender protects
+messages using the replacement, so each side switches over independently and
+no messages are lost or dropped.  An acceptor whose context is due to expire
+asks the initiator to start over by sending an empty
+TOKEN_RECONTEXT|TOKEN_NOOP token.
+
+The contents of all of these tokens are wrapped using the sender's current
+context, so that nobody else can start a replacement or switch a side over to
+one.  A replacement must also authenticate the same peer as the context it
+replaces; if it names someone else, it is refused.
+
+Tokens for a replacement context are only processed while the connection is
+being read, so both sides should keep reading for re-establishment to succeed.
+
+CloseWrite ends one direction of the stream by sending a misc.TOKEN_EOF
+token, after which the peer's reads return io.EOF.  The underlying connection
+stays open in both directions, so that a replacement context can still be
+negotiated while the peer goes on writing.  A side which has read the end of
+the stream no longer processes tokens for a replacement, though, so the peer's
+writes fail once its context expires.
+*/
+package stream
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/twistlock/gss/pkg/gss/mech"
+	"github.com/twistlock/gss/pkg/gss/misc"
+)
+
+// DefaultRenewBefore is used if Config.RenewBefore is not set.
+const DefaultRenewBefore = 5 * time.Minute
+
+// maxChunk is the most data which is sent in one token, leaving room under
+// misc.MaxTokenSize for the mechanism's overhead.
+const maxChunk = misc.MaxTokenSize / 2
+
+var (
+	errClosed      = errors.New("use of closed secure stream")
+	errWriteClosed = errors.New("write to secure stream after CloseWrite")
+)
+
+// Config controls how a Conn protects data.
+type Config struct {
+	// Conf requests that data be encrypted, and that the peer's data be
+	// rejected if it isn't.
+	Conf bool
+	// MIC sends data in the clear, followed by a MIC token, instead of wrapping
+	// it.  Conf is ignored when sending if MIC is set.
+	MIC bool
+	// RenewBefore is how long before the context expires that a replacement is
+	// negotiated.
+	RenewBefore time.Duration
+}
+
+// Conn is a net.Conn which protects data written to it using a security
+// context.
+type Conn struct {
+	net.Conn
+	factory  mech.Factory
+	initiate bool
+	config   Config
+	// peer is the name of the peer which the first context authenticated.
+	peer string
+
+	rmu  sync.Mutex // serializes reads from Conn
+	wmu  sync.Mutex // serializes writes to Conn
+	mu   sync.Mutex // protects everything below
+	cond *sync.Cond
+
+	// send and recv are the contexts used for outgoing and incoming messages.
+	// They differ only while a replacement is being switched to.
+	send, recv mech.Context
+	// next is the replacement being negotiated, and old is the context which
+	// it will replace.
+	next, old    mech.Context
+	nextComplete bool
+	sentSwitch   bool
+	rcvdSwitch   bool
+	// requested is set when an acceptor has asked for a replacement.
+	requested bool
+	// stale is a context which we've already tried to replace.
+	stale    mech.Context
+	renewErr error
+	closed   bool
+	// wclosed is set once CloseWrite has been called.
+	wclosed bool
+
+	// pending and eof are protected by rmu.
+	pending []byte
+	eof     bool
+}
+
+// Client establishes a context with the peer at the other end of conn, using
+// factory to create it, and returns a Conn which protects data using it.
+// factory is called again whenever the context needs to be replaced.
+func Client(conn net.Conn, factory mech.Factory, config Config) (*Conn, error) {
+	return newConn(conn, factory, true, config)
+}
+
+// Server accepts a context from the peer at the other end of conn, using
+// factory to create it, and returns a Conn which protects data using it.
+// factory is called again whenever the peer replaces the context.
+func Server(conn net.Conn, factory mech.Factory, config Config) (*Conn, error) {
+	return newConn(conn, factory, false, config)
+}
+
+func newConn(conn net.Conn, factory mech.Factory, initiate bool, config Config) (*Conn, error) {
+	if config.RenewBefore == 0 {
+		config.RenewBefore = DefaultRenewBefore
+	}
+	c := &Conn{Conn: conn, factory: factory, initiate: initiate, config: config}
+	c.cond = sync.NewCond(&c.mu)
+
+	ctx, err := c.handshake()
+	if err != nil {
+		return nil, err
+	}
+	c.send, c.recv = ctx, ctx
+	c.peer = peerName(ctx)
+	return c, nil
+}
+
+// peerName returns the name of ctx's peer, or "" if it can't tell us.
+func peerName(ctx mech.Context) string {
+	if namer, ok := ctx.(mech.PeerNamer); ok {
+		return namer.PeerName()
+	}
+	return ""
+}
+
+// handshake establishes the initial context.
+func (c *Conn) handshake() (mech.Context, error) {
+	var input []byte
+
+	ctx, err := c.factory()
+	if err != nil {
+		return nil, err
+	}
+	if !c.initiate {
+		input, err = c.readContextToken()
+		if err != nil {
+			ctx.Release()
+			return nil, err
+		}
+	}
+	for {
+		output, complete, err := ctx.Step(input)
+		if err == nil && len(output) > 0 {
+			err = misc.WriteToken(c.Conn, misc.TOKEN_CONTEXT, output)
+		}
+		if err != nil {
+			ctx.Release()
+			return nil, err
+		}
+		if complete {
+			return ctx, nil
+		}
+		input, err = c.readContextToken()
+		if err != nil {
+			ctx.Release()
+			return nil, err
+		}
+	}
+}
+
+func (c *Conn) readContextToken() ([]byte, error) {
+	tag, token, err := misc.ReadToken(c.Conn)
+	if err != nil {
+		return nil, err
+	}
+	if tag != misc.TOKEN_CONTEXT {
+		return nil, fmt.Errorf("expected a context token, got tag 0x%x", tag)
+	}
+	return token, nil
+}
+
+// Renew starts negotiating a replacement for the current context without
+// waiting for it to near expiration.  On an acceptor, it asks the initiator to
+// do so.
+func (c *Conn) Renew() error {
+	c.mu.Lock()
+	closed := c.closed
+	c.mu.Unlock()
+	if closed {
+		return errClosed
+	}
+	return c.startRenewal()
+}
+
+// Write protects p using the current context and sends it to the peer.
+func (c *Conn) Write(p []byte) (int, error) {
+	err := c.checkSendContext()
+	if err != nil {
+		return 0, err
+	}
+
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	c.mu.Lock()
+	ctx := c.send
+	wclosed := c.wclosed
+	c.mu.Unlock()
+	if wclosed {
+		return 0, errWriteClosed
+	}
+
+	n := 0
+	for {
+		chunk := p[n:]
+		if len(chunk) > maxChunk {
+			chunk = chunk[:maxChunk]
+		}
+		if err := c.writeChunk(ctx, chunk); err != nil {
+			return n, err
+		}
+		n += len(chunk)
+		if n == len(p) {
+			return n, nil
+		}
+	}
+}
+
+// CloseWrite tells the peer that nothing more will be written, so that its
+// reads return io.EOF once it has read everything written before.  Reading
+// from the connection still works.
+func (c *Conn) CloseWrite() error {
+	err := c.checkSendContext()
+	if err != nil {
+		return err
+	}
+
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	c.mu.Lock()
+	ctx := c.send
+	wclosed := c.wclosed
+	c.wclosed = true
+	c.mu.Unlock()
+	if wclosed {
+		return errWriteClosed
+	}
+
+	token, err := ctx.Wrap(nil, false)
+	if err == nil {
+		err = misc.WriteToken(c.Conn, misc.TOKEN_EOF, token)
+	}
+	return err
+}
+
+// writeChunk protects and sends one piece of a Write, which is small enough
+// that the resulting token stays under misc.MaxTokenSize.
+func (c *Conn) writeChunk(ctx mech.Context, p []byte) error {
+	if c.config.MIC {
+		mic, err := ctx.GetMIC(p)
+		if err == nil {
+			err = misc.WriteToken(c.Conn, misc.TOKEN_DATA, p)
+		}
+		if err == nil {
+			err = misc.WriteToken(c.Conn, misc.TOKEN_MIC, mic)
+		}
+		return err
+	}
+
+	tag := misc.TOKEN_DATA | misc.TOKEN_WRAPPED
+	if c.config.Conf {
+		tag |= misc.TOKEN_ENCRYPTED
+	}
+	token, err := ctx.Wrap(p, c.config.Conf)
+	if err == nil {
+		err = misc.WriteToken(c.Conn, tag, token)
+	}
+	return err
+}
+
+// checkSendContext makes sure that the context we're about to use for sending
+// hasn't expired, starting its replacement if it's due to expire soon.  If it
+// has already expired, we wait for the replacement.
+func (c *Conn) checkSendContext() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for {
+		if c.closed {
+			return errClosed
+		}
+		now := time.Now()
+		expires := c.send.Expires()
+		if expires.IsZero() || now.Add(c.config.RenewBefore).Before(expires) {
+			return nil
+		}
+		if c.next == nil && !c.requested && c.send != c.stale {
+			c.stale = c.send
+			c.mu.Unlock()
+			err := c.startRenewal()
+			c.mu.Lock()
+			if err != nil {
+				c.renewErr = err
+			}
+			continue
+		}
+		if now.Before(expires) {
+			return nil
+		}
+		if c.next == nil && !c.requested {
+			// Nothing is going to replace it.
+			if c.renewErr != nil {
+				return c.renewErr
+			}
+			return mech.ErrContextExpired
+		}
+		c.cond.Wait()
+	}
+}
+
+// startRenewal begins negotiating a replacement context, or asks the
+// initiator to.
+func (c *Conn) startRenewal() error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	c.mu.Lock()
+	busy := c.next != nil || c.requested
+	if !c.initiate {
+		c.requested = true
+	}
+	c.mu.Unlock()
+	if busy {
+		return nil
+	}
+
+	if !c.initiate {
+		return c.writeControl(misc.TOKEN_RECONTEXT|misc.TOKEN_NOOP, nil)
+	}
+	next, err := c.factory()
+	if err != nil {
+		return err
+	}
+	return c.stepNext(next, nil)
+}
+
+// writeControl sends a token which controls replacement of the context,
+// wrapped using the context which we're sending with, so that the peer knows
+// that it came from us.  The caller must hold wmu.
+func (c *Conn) writeControl(tag byte, token []byte) error {
+	c.mu.Lock()
+	ctx := c.send
+	c.mu.Unlock()
+
+	wrapped, err := ctx.Wrap(token, false)
+	if err != nil {
+		return err
+	}
+	return misc.WriteToken(c.Conn, tag, wrapped)
+}
+
+// stepNext feeds a token from the peer to the replacement context and sends
+// whatever it produces, switching our outgoing messages over to it once it's
+// complete.  The caller must hold wmu.
+func (c *Conn) stepNext(next mech.Context, input []byte) error {
+	output, complete, err := next.Step(input)
+	if err == nil && complete {
+		if name := peerName(next); name != c.peer {
+			err = fmt.Errorf("replacement context authenticated %q rather than %q", name, c.peer)
+		}
+	}
+	if err != nil {
+		next.Release()
+		c.mu.Lock()
+		c.next = nil
+		c.requested = false
+		c.cond.Broadcast()
+		c.mu.Unlock()
+		return err
+	}
+
+	c.mu.Lock()
+	if c.next == nil {
+		c.next, c.old = next, c.send
+		c.sentSwitch, c.rcvdSwitch = false, false
+	}
+	c.nextComplete = complete
+	c.mu.Unlock()
+
+	if len(output) > 0 {
+		err = c.writeControl(misc.TOKEN_RECONTEXT, output)
+		if err != nil {
+			return err
+		}
+	}
+	if !complete {
+		return nil
+	}
+	err = c.writeControl(misc.TOKEN_RECONTEXT, nil)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.send = c.next
+	c.sentSwitch = true
+	c.finishSwitch()
+	return nil
+}
+
+// switchRecv starts using the replacement context for incoming messages.
+func (c *Conn) switchRecv() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.next == nil || !c.nextComplete {
+		return errors.New("peer switched to a context which isn't established")
+	}
+	c.recv = c.next
+	c.rcvdSwitch = true
+	c.finishSwitch()
+	return nil
+}
+
+// finishSwitch releases the old context once neither direction is using it
+// any more.  The caller must hold mu.
+func (c *Conn) finishSwitch() {
+	if !c.sentSwitch || !c.rcvdSwitch {
+		return
+	}
+	// If the replacement won't last any longer, don't keep trying.
+	old, next := c.old.Expires(), c.next.Expires()
+	if !old.IsZero() && !next.IsZero() && !next.After(old) {
+		c.stale = c.next
+	}
+	c.old.Release()
+	c.old, c.next = nil, nil
+	c.requested = false
+	c.renewErr = nil
+	c.cond.Broadcast()
+}
+
+// Read reads data sent by the peer, handling any context tokens which arrive
+// along with it.
+func (c *Conn) Read(p []byte) (int, error) {
+	c.rmu.Lock()
+	defer c.rmu.Unlock()
+
+	for len(c.pending) == 0 {
+		if c.eof {
+			return 0, io.EOF
+		}
+		err := c.readMessage()
+		if err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, c.pending)
+	c.pending = c.pending[n:]
+	return n, nil
+}
+
+// readMessage reads and processes one token from the peer.  The caller must
+// hold rmu.
+func (c *Conn) readMessage() error {
+	tag, token, err := misc.ReadToken(c.Conn)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	recv := c.recv
+	closed := c.closed
+	c.mu.Unlock()
+	if closed {
+		return errClosed
+	}
+
+	switch tag {
+	case misc.TOKEN_DATA | misc.TOKEN_WRAPPED, misc.TOKEN_DATA | misc.TOKEN_WRAPPED | misc.TOKEN_ENCRYPTED:
+		message, conf, err := recv.Unwrap(token)
+		if err != nil {
+			return err
+		}
+		if c.config.Conf && !conf {
+			return errors.New("peer sent data without encrypting it")
+		}
+		c.pending = message
+	case misc.TOKEN_DATA:
+		mtag, mic, err := misc.ReadToken(c.Conn)
+		if err != nil {
+			return err
+		}
+		if mtag != misc.TOKEN_MIC {
+			return fmt.Errorf("expected a MIC token, got tag 0x%x", mtag)
+		}
+		if c.config.Conf {
+			return errors.New("peer sent data without encrypting it")
+		}
+		err = recv.VerifyMIC(token, mic)
+		if err != nil {
+			return err
+		}
+		c.pending = token
+	case misc.TOKEN_EOF:
+		message, _, err := recv.Unwrap(token)
+		if err != nil {
+			return err
+		}
+		if len(message) != 0 {
+			return errors.New("peer sent a malformed end of stream marker")
+		}
+		c.eof = true
+	case misc.TOKEN_RECONTEXT:
+		message, _, err := recv.Unwrap(token)
+		if err != nil {
+			return fmt.Errorf("peer sent a context token which isn't protected by the current context: %v", err)
+		}
+		if len(message) == 0 {
+			return c.switchRecv()
+		}
+		return c.handleContextToken(message)
+	case misc.TOKEN_RECONTEXT | misc.TOKEN_NOOP:
+		message, _, err := recv.Unwrap(token)
+		if err != nil {
+			return fmt.Errorf("peer sent a renewal request which isn't protected by the current context: %v", err)
+		}
+		if len(message) != 0 {
+			return errors.New("peer sent a malformed renewal request")
+		}
+		if c.initiate {
+			err = c.startRenewal()
+			if err != nil {
+				c.mu.Lock()
+				c.renewErr = err
+				c.mu.Unlock()
+			}
+		}
+	case misc.TOKEN_NOOP:
+	default:
+		return fmt.Errorf("unexpected token tag 0x%x", tag)
+	}
+	return nil
+}
+
+// handleContextToken passes a token for a replacement context to it, creating
+// the context first if the peer is just starting to negotiate it.  Since only
+// initiators start negotiating, an initiator ignores tokens which aren't for a
+// replacement that it started, such as the rest of an acceptor's reply to one
+// which it has given up on.
+func (c *Conn) handleContextToken(token []byte) error {
+	var err error
+
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	c.mu.Lock()
+	next := c.next
+	c.mu.Unlock()
+	if next == nil {
+		if c.initiate {
+			return nil
+		}
+		next, err = c.factory()
+		if err != nil {
+			return err
+		}
+	}
+	return c.stepNext(next, token)
+}
+
+// Close closes the connection and releases the contexts.
+func (c *Conn) Close() error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return errClosed
+	}
+	c.closed = true
+	c.cond.Broadcast()
+	c.mu.Unlock()
+
+	err := c.Conn.Close()
+
+	// Wait for anyone who's still using a context.
+	c.rmu.Lock()
+	defer c.rmu.Unlock()
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	released := make(map[mech.Context]bool)
+	for _, ctx := range []mech.Context{c.send, c.recv, c.next, c.old} {
+		if ctx != nil && !released[ctx] {
+			ctx.Release()
+			released[ctx] = true
+		}
+	}
+	return err
+}
@@ -0,0 +1,245 @@
+package stream_test
+
+import (
+	"io"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/twistlock/gss/pkg/gss/mech"
+	"github.com/twistlock/gss/pkg/gss/mech/fake"
+	"github.com/twistlock/gss/pkg/gss/misc"
+	"github.com/twistlock/gss/pkg/gss/stream"
+)
+
+var fakeConfig = fake.Config{
+	Initiator: "alice@EXAMPLE.COM",
+	Acceptor:  "host@server.example.com",
+	Key:       []byte("stream test key"),
+	Flags:     fake.FlagMutual | fake.FlagInteg | fake.FlagConf,
+}
+
+// connect establishes a stream over an in-memory connection, returning the
+// client's and the server's ends.
+func connect(t *testing.T, config stream.Config) (*stream.Conn, *stream.Conn) {
+	t.Helper()
+	return connectWith(t, fake.InitiatorFactory(fakeConfig), fake.AcceptorFactory(fakeConfig), config)
+}
+
+// connectWith is connect with the factories of our choice.  It uses a
+// loopback TCP connection, since replacing a context relies on being able to
+// write while the peer is writing too.
+func connectWith(t *testing.T, initiator, acceptor mech.Factory, config stream.Config) (*stream.Conn, *stream.Conn) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	clientConn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverConn, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	type result struct {
+		conn *stream.Conn
+		err  error
+	}
+	accepted := make(chan result, 1)
+	go func() {
+		c, err := stream.Server(serverConn, acceptor, config)
+		accepted <- result{c, err}
+	}()
+	c, err := stream.Client(clientConn, initiator, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := <-accepted
+	if r.err != nil {
+		t.Fatal(r.err)
+	}
+	t.Cleanup(func() {
+		c.Close()
+		r.conn.Close()
+	})
+	return c, r.conn
+}
+
+func TestCloseWrite(t *testing.T) {
+	for _, config := range []stream.Config{{Conf: true}, {MIC: true}} {
+		c, s := connect(t, config)
+		go func() {
+			c.Write([]byte("request"))
+			c.CloseWrite()
+		}()
+		request, err := io.ReadAll(s)
+		if err != nil || string(request) != "request" {
+			t.Fatalf("%+v: server read %q, %v", config, request, err)
+		}
+		if n, err := s.Read(make([]byte, 1)); n != 0 || err != io.EOF {
+			t.Errorf("%+v: read after the end of the stream returned %d, %v", config, n, err)
+		}
+
+		// The other direction still works.
+		go func() {
+			s.Write([]byte("response"))
+			s.CloseWrite()
+		}()
+		response, err := io.ReadAll(c)
+		if err != nil || string(response) != "response" {
+			t.Fatalf("%+v: client read %q, %v", config, response, err)
+		}
+
+		if _, err = c.Write([]byte("more")); err == nil {
+			t.Errorf("%+v: Write after CloseWrite succeeded", config)
+		}
+		if err = c.CloseWrite(); err == nil {
+			t.Errorf("%+v: second CloseWrite succeeded", config)
+		}
+	}
+}
+
+// TestForgedEOF checks that an end of stream marker which wasn't protected
+// using the context is rejected.
+func TestForgedEOF(t *testing.T) {
+	c, s := connect(t, stream.Config{Conf: true})
+	go misc.WriteToken(c.Conn, misc.TOKEN_EOF, []byte("forged"))
+	if _, err := s.Read(make([]byte, 1)); err == nil || err == io.EOF {
+		t.Errorf("forged marker: got error %v", err)
+	}
+}
+
+// counting wraps factory, counting the contexts which it creates.
+func counting(factory mech.Factory, n *int32) mech.Factory {
+	return func() (mech.Context, error) {
+		atomic.AddInt32(n, 1)
+		return factory()
+	}
+}
+
+// echo copies everything which s reads back to it, until reading fails.
+func echo(s *stream.Conn) chan error {
+	done := make(chan error, 1)
+	go func() {
+		buf := make([]byte, 64)
+		for {
+			n, err := s.Read(buf)
+			if err != nil {
+				done <- err
+				return
+			}
+			if _, err = s.Write(buf[:n]); err != nil {
+				done <- err
+				return
+			}
+		}
+	}()
+	return done
+}
+
+// ping sends a message through an echoing peer and checks that it comes back.
+func ping(t *testing.T, c *stream.Conn, message string) {
+	t.Helper()
+	if _, err := c.Write([]byte(message)); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, len(message))
+	if _, err := io.ReadFull(c, buf); err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != message {
+		t.Fatalf("echoed %q, want %q", buf, message)
+	}
+}
+
+func TestRenew(t *testing.T) {
+	var initiators, acceptors int32
+	c, s := connectWith(t, counting(fake.InitiatorFactory(fakeConfig), &initiators), counting(fake.AcceptorFactory(fakeConfig), &acceptors), stream.Config{Conf: true})
+	echo(s)
+
+	ping(t, c, "before")
+	// The initiator starts a replacement.
+	if err := c.Renew(); err != nil {
+		t.Fatal(err)
+	}
+	ping(t, c, "during")
+	ping(t, c, "after")
+	if n := atomic.LoadInt32(&acceptors); n != 2 {
+		t.Fatalf("initiator's renewal created %d acceptors, want 2", n)
+	}
+
+	// The acceptor asks the initiator to start one.
+	if err := s.Renew(); err != nil {
+		t.Fatal(err)
+	}
+	ping(t, c, "requested")
+	ping(t, c, "after request")
+	if n := atomic.LoadInt32(&initiators); n != 3 {
+		t.Errorf("acceptor's request created %d initiators in all, want 3", n)
+	}
+}
+
+// TestForeignRecontext has a third party inject tokens for a replacement
+// context which it established on its own.  They aren't protected by the
+// context in use, so they must be refused.
+func TestForeignRecontext(t *testing.T) {
+	foreign := fakeConfig
+	foreign.Initiator = "mallory@EXAMPLE.COM"
+	for _, tc := range []struct {
+		name    string
+		tag     byte
+		message func() []byte
+		client  bool
+	}{
+		{"context token", misc.TOKEN_RECONTEXT, func() []byte {
+			token, _, err := fake.NewInitiator(foreign).Step(nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return token
+		}, false},
+		{"switch marker", misc.TOKEN_RECONTEXT, func() []byte { return nil }, false},
+		{"renewal request", misc.TOKEN_RECONTEXT | misc.TOKEN_NOOP, func() []byte { return nil }, true},
+	} {
+		var acceptors int32
+		c, s := connectWith(t, fake.InitiatorFactory(fakeConfig), counting(fake.AcceptorFactory(fakeConfig), &acceptors), stream.Config{Conf: true})
+		from, to := c, s
+		if tc.client {
+			from, to = s, c
+		}
+		go misc.WriteToken(from.Conn, tc.tag, tc.message())
+		if _, err := to.Read(make([]byte, 1)); err == nil || !strings.Contains(err.Error(), "isn't protected") {
+			t.Errorf("%s: got error %v", tc.name, err)
+		}
+		if n := atomic.LoadInt32(&acceptors); n != 1 {
+			t.Errorf("%s: created %d acceptors, want 1", tc.name, n)
+		}
+	}
+}
+
+// TestRenewPeerChange has the initiator replace its context with one for a
+// different principal, which the acceptor must refuse.
+func TestRenewPeerChange(t *testing.T) {
+	var initiators int32
+	mallory := fakeConfig
+	mallory.Initiator = "mallory@EXAMPLE.COM"
+	initiator := func() (mech.Context, error) {
+		if atomic.AddInt32(&initiators, 1) == 1 {
+			return fake.NewInitiator(fakeConfig), nil
+		}
+		return fake.NewInitiator(mallory), nil
+	}
+	c, s := connectWith(t, initiator, fake.AcceptorFactory(fakeConfig), stream.Config{Conf: true})
+	done := echo(s)
+	ping(t, c, "before")
+	if err := c.Renew(); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err == nil || !strings.Contains(err.Error(), "mallory") {
+		t.Errorf("acceptor accepted a replacement for another principal, or failed with %v", err)
+	}
+}
@@ -0,0 +1,99 @@
+package token
+
+import (
+	"encoding/asn1"
+	"errors"
+)
+
+// MechSPNEGO is SPNEGO's OID.
+var MechSPNEGO = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 2}
+
+// Values of NegTokenResp.NegState.
+const (
+	NegStateAcceptCompleted  = 0
+	NegStateAcceptIncomplete = 1
+	NegStateReject           = 2
+	NegStateRequestMic       = 3
+)
+
+// ErrNotSPNEGO is returned by ParseNegTokenInit for tokens which are framed
+// for some other mechanism.
+var ErrNotSPNEGO = errors.New("token is not an SPNEGO token")
+
+// NegTokenInit is the initiator's first SPNEGO message (RFC 4178 section
+// 4.2.1).
+type NegTokenInit struct {
+	MechTypes   []asn1.ObjectIdentifier `asn1:"explicit,tag:0"`
+	ReqFlags    asn1.BitString          `asn1:"optional,explicit,tag:1"`
+	MechToken   []byte                  `asn1:"optional,explicit,tag:2"`
+	MechListMic []byte                  `asn1:"optional,explicit,tag:3"`
+}
+
+// NegTokenResp is any later SPNEGO message (RFC 4178 section 4.2.2).
+type NegTokenResp struct {
+	NegState      asn1.Enumerated       `asn1:"explicit,tag:0"`
+	SupportedMech asn1.ObjectIdentifier `asn1:"optional,explicit,tag:1"`
+	ResponseToken []byte                `asn1:"optional,explicit,tag:2"`
+	MechListMic   []byte                `asn1:"optional,explicit,tag:3"`
+}
+
+// unmarshalChoice checks that b holds exactly one context-specific element
+// with the given tag, and decodes its contents into v.
+func unmarshalChoice(b []byte, tag int, v interface{}) error {
+	e, err := SplitOne(b)
+	if err != nil {
+		return err
+	}
+	if e.Class != ClassContext || !e.Constructed || e.Tag != tag {
+		return errors.New("unexpected SPNEGO message type")
+	}
+	rest, err := asn1.Unmarshal(e.Value, v)
+	if err != nil {
+		return err
+	}
+	if len(rest) != 0 {
+		return ErrTrailingData
+	}
+	return nil
+}
+
+// ParseNegTokenInit parses an initiator's first token, an
+// InitialContextToken for SPNEGO holding a NegTokenInit.  A token with no
+// mechanisms listed is rejected.
+func ParseNegTokenInit(tok []byte) (init NegTokenInit, err error) {
+	mech, inner, err := Unframe(tok)
+	if err != nil {
+		return init, err
+	}
+	if !mech.Equal(MechSPNEGO) {
+		return init, ErrNotSPNEGO
+	}
+	if err = unmarshalChoice(inner, 0, &init); err != nil {
+		return init, err
+	}
+	if len(init.MechTypes) == 0 {
+		return init, errors.New("SPNEGO token lists no mechanisms")
+	}
+	return init, nil
+}
+
+// ParseNegTokenResp parses any token after the initiator's first.
+func ParseNegTokenResp(tok []byte) (resp NegTokenResp, err error) {
+	err = unmarshalChoice(tok, 1, &resp)
+	return resp, err
+}
+
+// MarshalNegTokenInit encodes init as an initiator's first token.
+func MarshalNegTokenInit(init NegTokenInit) ([]byte, error) {
+	inner, err := asn1.MarshalWithParams(init, "explicit,tag:0")
+	if err != nil {
+		return nil, err
+	}
+	return Frame(MechSPNEGO, inner)
+}
+
+// MarshalNegTokenResp encodes resp as a token following the initiator's
+// first.
+func MarshalNegTokenResp(resp NegTokenResp) ([]byte, error) {
+	return asn1.MarshalWithParams(resp, "explicit,tag:1")
+}
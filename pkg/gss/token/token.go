@@ -0,0 +1,184 @@
+/*
+Package token builds and parses the framing which GSSAPI mechanisms wrap around their initial context tokens, and SPNEGO's negotiation messages.
+
+RFC 2743 section 3.1 defines an InitialContextToken as an [APPLICATION 0]
+DER element holding the mechanism's OID, followed by mechanism-specific data
+which isn't itself DER-encoded, so encoding/asn1 can't handle it directly.
+*/
+package token
+
+import (
+	"encoding/asn1"
+	"errors"
+	"fmt"
+)
+
+const (
+	ClassUniversal   = 0
+	ClassApplication = 1
+	ClassContext     = 2
+	ClassPrivate     = 3
+
+	tagInitialContextToken = 0x60
+
+	// maxLengthDigits bounds the size of long-form lengths, and of
+	// high-numbered tags, which are accepted.
+	maxLengthDigits = 4
+)
+
+var (
+	// ErrNotFramed is returned by Unframe for tokens which don't start with
+	// an InitialContextToken header.
+	ErrNotFramed = errors.New("token is not an InitialContextToken")
+	// ErrTruncated is returned for elements which extend past the end of
+	// the data.
+	ErrTruncated = errors.New("DER element is truncated")
+	// ErrIndefiniteLength is returned for elements which use the
+	// indefinite-length form, which DER doesn't allow.
+	ErrIndefiniteLength = errors.New("DER element has an indefinite length")
+	// ErrNonMinimal is returned for tags and lengths which aren't encoded
+	// in their shortest form, as DER requires.
+	ErrNonMinimal = errors.New("DER tag or length is not minimally encoded")
+	// ErrTooLarge is returned for tags and lengths too large to handle.
+	ErrTooLarge = errors.New("DER tag or length is too large")
+	// ErrTrailingData is returned when data follows an element which
+	// should have been the last.
+	ErrTrailingData = errors.New("trailing data after DER element")
+)
+
+// Element is a DER element split into its parts.
+type Element struct {
+	Class       int
+	Constructed bool
+	Tag         int
+	// Value is the element's contents, without its tag and length.
+	Value []byte
+}
+
+// Split parses the element at the start of b, returning it and the data
+// which follows it.  Every access is bounds-checked, so malformed input
+// yields an error rather than a panic.
+func Split(b []byte) (e Element, rest []byte, err error) {
+	if len(b) < 2 {
+		return e, nil, ErrTruncated
+	}
+	e.Class = int(b[0] >> 6)
+	e.Constructed = b[0]&0x20 != 0
+	e.Tag = int(b[0] & 0x1f)
+	n := 1
+	if e.Tag == 0x1f {
+		e.Tag = 0
+		for digits := 0; ; digits++ {
+			if n >= len(b) {
+				return e, nil, ErrTruncated
+			}
+			if digits == maxLengthDigits {
+				return e, nil, ErrTooLarge
+			}
+			if digits == 0 && b[n] == 0x80 {
+				return e, nil, ErrNonMinimal
+			}
+			e.Tag = e.Tag<<7 | int(b[n]&0x7f)
+			n++
+			if b[n-1]&0x80 == 0 {
+				break
+			}
+		}
+		if e.Tag < 0x1f {
+			return e, nil, ErrNonMinimal
+		}
+	}
+	if n >= len(b) {
+		return e, nil, ErrTruncated
+	}
+	length := int(b[n])
+	n++
+	if length&0x80 != 0 {
+		digits := length & 0x7f
+		switch {
+		case digits == 0:
+			return e, nil, ErrIndefiniteLength
+		case digits > maxLengthDigits:
+			return e, nil, ErrTooLarge
+		case len(b) < n+digits:
+			return e, nil, ErrTruncated
+		case b[n] == 0:
+			return e, nil, ErrNonMinimal
+		}
+		length = 0
+		for _, d := range b[n : n+digits] {
+			length = length<<8 | int(d)
+		}
+		n += digits
+		if length < 0x80 {
+			return e, nil, ErrNonMinimal
+		}
+	}
+	if length < 0 || length > len(b)-n {
+		return e, nil, ErrTruncated
+	}
+	e.Value = b[n : n+length]
+	return e, b[n+length:], nil
+}
+
+// SplitOne parses b, which must hold exactly one element.
+func SplitOne(b []byte) (Element, error) {
+	e, rest, err := Split(b)
+	if err != nil {
+		return e, err
+	}
+	if len(rest) != 0 {
+		return e, ErrTrailingData
+	}
+	return e, nil
+}
+
+// TagAndLength returns the DER header for an element with the given
+// identifier octet and length.
+func TagAndLength(tag byte, length int) []byte {
+	return AppendLength([]byte{tag}, length)
+}
+
+// AppendLength appends the DER encoding of length to b.
+func AppendLength(b []byte, length int) []byte {
+	if length < 0x80 {
+		return append(b, byte(length))
+	}
+	var digits []byte
+	for l := length; l > 0; l >>= 8 {
+		digits = append([]byte{byte(l)}, digits...)
+	}
+	b = append(b, byte(0x80|len(digits)))
+	return append(b, digits...)
+}
+
+// Frame wraps inner in an InitialContextToken header naming mech.
+func Frame(mech asn1.ObjectIdentifier, inner []byte) ([]byte, error) {
+	oid, err := asn1.Marshal(mech)
+	if err != nil {
+		return nil, err
+	}
+	b := AppendLength([]byte{tagInitialContextToken}, len(oid)+len(inner))
+	b = append(b, oid...)
+	return append(b, inner...), nil
+}
+
+// Unframe removes the InitialContextToken header from tok, returning the
+// mechanism it names and the data which follows it.
+func Unframe(tok []byte) (mech asn1.ObjectIdentifier, inner []byte, err error) {
+	if len(tok) == 0 || tok[0] != tagInitialContextToken {
+		return nil, nil, ErrNotFramed
+	}
+	e, err := SplitOne(tok)
+	if err != nil {
+		return nil, nil, fmt.Errorf("bad InitialContextToken: %v", err)
+	}
+	if e.Class != ClassApplication || !e.Constructed || e.Tag != 0 {
+		return nil, nil, ErrNotFramed
+	}
+	inner, err = asn1.Unmarshal(e.Value, &mech)
+	if err != nil {
+		return nil, nil, fmt.Errorf("bad InitialContextToken mechanism: %v", err)
+	}
+	return mech, inner, nil
+}
@@ -0,0 +1,167 @@
+package token
+
+import (
+	"bytes"
+	"encoding/asn1"
+	"testing"
+)
+
+var testMech = asn1.ObjectIdentifier{1, 2, 840, 113554, 1, 2, 2}
+
+func TestSplit(t *testing.T) {
+	long := append([]byte{0x04, 0x81, 0x80}, make([]byte, 0x80)...)
+	for _, tc := range []struct {
+		name string
+		in   []byte
+		err  error
+	}{
+		{"short form", []byte{0x04, 0x01, 0xff}, nil},
+		{"long form", long, nil},
+		{"high tag", []byte{0x9f, 0x20, 0x00}, nil},
+		{"empty", nil, ErrTruncated},
+		{"no length", []byte{0x04}, ErrTruncated},
+		{"short value", []byte{0x04, 0x02, 0xff}, ErrTruncated},
+		{"short long-form length", []byte{0x04, 0x82, 0x01}, ErrTruncated},
+		{"indefinite", []byte{0x30, 0x80, 0x00, 0x00}, ErrIndefiniteLength},
+		{"oversized length", []byte{0x04, 0x85, 0x01, 0x00, 0x00, 0x00, 0x00}, ErrTooLarge},
+		{"leading zero length", []byte{0x04, 0x82, 0x00, 0x80}, ErrNonMinimal},
+		{"long form for short length", []byte{0x04, 0x81, 0x01, 0xff}, ErrNonMinimal},
+		{"low tag in long form", []byte{0x9f, 0x01, 0x00}, ErrNonMinimal},
+		{"unterminated tag", []byte{0x9f, 0x81}, ErrTruncated},
+		{"huge length", []byte{0x04, 0x84, 0x7f, 0xff, 0xff, 0xff}, ErrTruncated},
+	} {
+		_, _, err := Split(tc.in)
+		if err != tc.err {
+			t.Errorf("%s: got error %v, want %v", tc.name, err, tc.err)
+		}
+	}
+}
+
+func TestFrameRoundTrip(t *testing.T) {
+	inner := bytes.Repeat([]byte{0xa5}, 300)
+	tok, err := Frame(testMech, inner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mech, got, err := Unframe(tok)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !mech.Equal(testMech) || !bytes.Equal(got, inner) {
+		t.Errorf("Unframe returned %v and %d bytes, want %v and %d bytes", mech, len(got), testMech, len(inner))
+	}
+	if _, _, err := Unframe(tok[:len(tok)-1]); err == nil {
+		t.Error("Unframe accepted a truncated token")
+	}
+}
+
+func seedNegTokenInit(f *testing.F) []byte {
+	tok, err := MarshalNegTokenInit(NegTokenInit{
+		MechTypes: []asn1.ObjectIdentifier{testMech, {1, 3, 6, 1, 4, 1, 311, 2, 2, 10}},
+		MechToken: []byte("mechanism token"),
+	})
+	if err != nil {
+		f.Fatal(err)
+	}
+	return tok
+}
+
+func seedNegTokenResp(f *testing.F) []byte {
+	tok, err := MarshalNegTokenResp(NegTokenResp{
+		NegState:      NegStateAcceptIncomplete,
+		SupportedMech: testMech,
+		ResponseToken: []byte("response token"),
+		MechListMic:   []byte("mic"),
+	})
+	if err != nil {
+		f.Fatal(err)
+	}
+	return tok
+}
+
+// addSeeds adds tok to the corpus, along with truncated and corrupted copies.
+func addSeeds(f *testing.F, tok []byte) {
+	f.Add(tok)
+	f.Add(tok[:len(tok)/2])
+	f.Add(tok[:len(tok)-1])
+	corrupt := append([]byte(nil), tok...)
+	corrupt[1] = 0x84
+	f.Add(corrupt)
+}
+
+func FuzzSplit(f *testing.F) {
+	f.Add([]byte{0x04, 0x01, 0xff})
+	f.Add([]byte{0x30, 0x80, 0x00, 0x00})
+	f.Add([]byte{0x9f, 0x20, 0x00})
+	f.Add([]byte{0x04, 0x84, 0x7f, 0xff, 0xff, 0xff})
+	addSeeds(f, seedNegTokenInit(f))
+	f.Fuzz(func(t *testing.T, b []byte) {
+		e, rest, err := Split(b)
+		if err != nil {
+			return
+		}
+		header := len(b) - len(rest) - len(e.Value)
+		if header < 2 || !bytes.Equal(b[header:header+len(e.Value)], e.Value) {
+			t.Fatalf("Split(%x) returned a value which isn't in its input", b)
+		}
+		if e.Tag < 0x1f && !bytes.Equal(TagAndLength(b[0], len(e.Value)), b[:header]) {
+			t.Fatalf("Split(%x) accepted a header which isn't in DER form", b)
+		}
+	})
+}
+
+func FuzzUnframe(f *testing.F) {
+	tok, err := Frame(testMech, []byte("inner"))
+	if err != nil {
+		f.Fatal(err)
+	}
+	addSeeds(f, tok)
+	addSeeds(f, seedNegTokenInit(f))
+	f.Fuzz(func(t *testing.T, tok []byte) {
+		mech, inner, err := Unframe(tok)
+		if err != nil {
+			return
+		}
+		again, err := Frame(mech, inner)
+		if err != nil {
+			t.Fatalf("Frame(%v) after Unframe(%x): %v", mech, tok, err)
+		}
+		if !bytes.Equal(again, tok) {
+			t.Fatalf("Unframe(%x) then Frame gave %x", tok, again)
+		}
+	})
+}
+
+func FuzzParseNegTokenInit(f *testing.F) {
+	addSeeds(f, seedNegTokenInit(f))
+	f.Fuzz(func(t *testing.T, tok []byte) {
+		init, err := ParseNegTokenInit(tok)
+		if err != nil {
+			return
+		}
+		again, err := MarshalNegTokenInit(init)
+		if err != nil {
+			return
+		}
+		if _, err := ParseNegTokenInit(again); err != nil {
+			t.Fatalf("re-encoded NegTokenInit from %x doesn't parse: %v", tok, err)
+		}
+	})
+}
+
+func FuzzParseNegTokenResp(f *testing.F) {
+	addSeeds(f, seedNegTokenResp(f))
+	f.Fuzz(func(t *testing.T, tok []byte) {
+		resp, err := ParseNegTokenResp(tok)
+		if err != nil {
+			return
+		}
+		again, err := MarshalNegTokenResp(resp)
+		if err != nil {
+			return
+		}
+		if _, err := ParseNegTokenResp(again); err != nil {
+			t.Fatalf("re-encoded NegTokenResp from %x doesn't parse: %v", tok, err)
+		}
+	})
+}
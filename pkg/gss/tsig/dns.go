@@ -0,0 +1,393 @@
+package tsig
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+)
+
+// Record types.
+const (
+	TypeA     = 1
+	TypeNS    = 2
+	TypeCNAME = 5
+	TypeSOA   = 6
+	TypePTR   = 12
+	TypeTXT   = 16
+	TypeAAAA  = 28
+	TypeTKEY  = 249
+	TypeTSIG  = 250
+	TypeANY   = 255
+)
+
+// Classes.
+const (
+	ClassINET = 1
+	ClassNONE = 254
+	ClassANY  = 255
+)
+
+// Opcodes.
+const (
+	OpcodeQuery  = 0
+	OpcodeUpdate = 5
+)
+
+// Response codes, including the extended ones which TSIG and TKEY report.
+const (
+	RcodeSuccess  = 0
+	RcodeFormErr  = 1
+	RcodeServFail = 2
+	RcodeNXDomain = 3
+	RcodeNotImp   = 4
+	RcodeRefused  = 5
+	RcodeYXDomain = 6
+	RcodeYXRRSet  = 7
+	RcodeNXRRSet  = 8
+	RcodeNotAuth  = 9
+	RcodeNotZone  = 10
+	RcodeBadSig   = 16
+	RcodeBadKey   = 17
+	RcodeBadTime  = 18
+	RcodeBadMode  = 19
+)
+
+var rcodeNames = map[int]string{
+	RcodeSuccess:  "NOERROR",
+	RcodeFormErr:  "FORMERR",
+	RcodeServFail: "SERVFAIL",
+	RcodeNXDomain: "NXDOMAIN",
+	RcodeNotImp:   "NOTIMP",
+	RcodeRefused:  "REFUSED",
+	RcodeYXDomain: "YXDOMAIN",
+	RcodeYXRRSet:  "YXRRSET",
+	RcodeNXRRSet:  "NXRRSET",
+	RcodeNotAuth:  "NOTAUTH",
+	RcodeNotZone:  "NOTZONE",
+	RcodeBadSig:   "BADSIG",
+	RcodeBadKey:   "BADKEY",
+	RcodeBadTime:  "BADTIME",
+	RcodeBadMode:  "BADMODE",
+}
+
+// RcodeError is returned when a server reports an error.
+type RcodeError int
+
+func (e RcodeError) Error() string {
+	if name, ok := rcodeNames[int(e)]; ok {
+		return "DNS server returned " + name
+	}
+	return fmt.Sprintf("DNS server returned error %d", int(e))
+}
+
+const (
+	flagResponse = 0x8000
+	opcodeShift  = 11
+	opcodeMask   = 0xf
+	rcodeMask    = 0xf
+
+	headerLen   = 12
+	maxNameLen  = 255
+	maxLabelLen = 63
+)
+
+var errMessageTruncated = errors.New("DNS message is truncated")
+
+// Question is an entry in a message's question section, or an UPDATE
+// message's zone section.
+type Question struct {
+	Name        string
+	Type, Class uint16
+}
+
+// RR is a resource record.  Data holds the record's wire-format RDATA,
+// which is not interpreted.
+type RR struct {
+	Name        string
+	Type, Class uint16
+	TTL         uint32
+	Data        []byte
+}
+
+// Message is a DNS message.  In an UPDATE message, the question, answer and
+// authority sections are the zone, prerequisite and update sections.
+type Message struct {
+	ID         uint16
+	Flags      uint16
+	Question   []Question
+	Answer     []RR
+	Authority  []RR
+	Additional []RR
+}
+
+// Response reports whether the message is a response.
+func (m *Message) Response() bool {
+	return m.Flags&flagResponse != 0
+}
+
+// Opcode returns the message's opcode.
+func (m *Message) Opcode() int {
+	return int(m.Flags>>opcodeShift) & opcodeMask
+}
+
+// Rcode returns the message's response code.
+func (m *Message) Rcode() int {
+	return int(m.Flags & rcodeMask)
+}
+
+// SetResponse makes the message a response to req, with the given code.
+func (m *Message) SetResponse(req *Message, rcode int) {
+	m.ID = req.ID
+	m.Flags = flagResponse | req.Flags&(opcodeMask<<opcodeShift) | uint16(rcode)&rcodeMask
+	m.Question = req.Question
+}
+
+// NewUpdate returns an UPDATE message for zone.  Records to add and delete
+// are appended to its Authority section, using A(), AAAA(), PTR(),
+// DeleteRRset() and DeleteName().
+func NewUpdate(zone string) *Message {
+	return &Message{
+		ID:       newID(),
+		Flags:    OpcodeUpdate << opcodeShift,
+		Question: []Question{{Name: zone, Type: TypeSOA, Class: ClassINET}},
+	}
+}
+
+// A returns an address record for an IPv4 address.
+func A(name string, ttl uint32, ip net.IP) RR {
+	return RR{Name: name, Type: TypeA, Class: ClassINET, TTL: ttl, Data: []byte(ip.To4())}
+}
+
+// AAAA returns an address record for an IPv6 address.
+func AAAA(name string, ttl uint32, ip net.IP) RR {
+	return RR{Name: name, Type: TypeAAAA, Class: ClassINET, TTL: ttl, Data: []byte(ip.To16())}
+}
+
+// PTR returns a pointer record, such as one for reverse lookups.
+func PTR(name string, ttl uint32, target string) RR {
+	data, _ := appendName(nil, target)
+	return RR{Name: name, Type: TypePTR, Class: ClassINET, TTL: ttl, Data: data}
+}
+
+// DeleteRRset returns an update which deletes the records of type rrtype
+// which name has.
+func DeleteRRset(name string, rrtype uint16) RR {
+	return RR{Name: name, Type: rrtype, Class: ClassANY}
+}
+
+// DeleteName returns an update which deletes all of name's records.
+func DeleteName(name string) RR {
+	return RR{Name: name, Type: TypeANY, Class: ClassANY}
+}
+
+// Pack returns the message's wire format.  Names are not compressed.
+func (m *Message) Pack() ([]byte, error) {
+	b := make([]byte, headerLen, 512)
+	binary.BigEndian.PutUint16(b[0:], m.ID)
+	binary.BigEndian.PutUint16(b[2:], m.Flags)
+	for i, n := range []int{len(m.Question), len(m.Answer), len(m.Authority), len(m.Additional)} {
+		if n > 0xffff {
+			return nil, errors.New("DNS message has too many records")
+		}
+		binary.BigEndian.PutUint16(b[4+2*i:], uint16(n))
+	}
+	var err error
+	for _, q := range m.Question {
+		if b, err = appendName(b, q.Name); err != nil {
+			return nil, err
+		}
+		b = appendUint16(b, q.Type)
+		b = appendUint16(b, q.Class)
+	}
+	for _, section := range [][]RR{m.Answer, m.Authority, m.Additional} {
+		for _, rr := range section {
+			if b, err = appendRR(b, rr); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return b, nil
+}
+
+func appendUint16(b []byte, v uint16) []byte {
+	return append(b, byte(v>>8), byte(v))
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	return append(b, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+func appendRR(b []byte, rr RR) ([]byte, error) {
+	var err error
+	if b, err = appendName(b, rr.Name); err != nil {
+		return nil, err
+	}
+	if len(rr.Data) > 0xffff {
+		return nil, fmt.Errorf("record data for %s is too long", rr.Name)
+	}
+	b = appendUint16(b, rr.Type)
+	b = appendUint16(b, rr.Class)
+	b = appendUint32(b, rr.TTL)
+	b = appendUint16(b, uint16(len(rr.Data)))
+	return append(b, rr.Data...), nil
+}
+
+// appendName appends name in uncompressed wire format.  A trailing dot is
+// optional.
+func appendName(b []byte, name string) ([]byte, error) {
+	name = strings.TrimSuffix(name, ".")
+	start := len(b)
+	if name != "" {
+		for _, label := range strings.Split(name, ".") {
+			if label == "" || len(label) > maxLabelLen {
+				return nil, fmt.Errorf("invalid DNS name %q", name)
+			}
+			b = append(b, byte(len(label)))
+			b = append(b, label...)
+		}
+	}
+	b = append(b, 0)
+	if len(b)-start > maxNameLen {
+		return nil, fmt.Errorf("DNS name %q is too long", name)
+	}
+	return b, nil
+}
+
+// canonicalName returns name in the lowercase, uncompressed form which TSIG
+// signs.
+func canonicalName(name string) ([]byte, error) {
+	return appendName(nil, strings.ToLower(name))
+}
+
+// Unpack parses a message in wire format.
+func Unpack(b []byte) (*Message, error) {
+	m, _, err := unpack(b)
+	return m, err
+}
+
+// unpack parses a message, also returning the offset of the last record in
+// the additional section, where a TSIG record has to be.
+func unpack(b []byte) (*Message, int, error) {
+	if len(b) < headerLen {
+		return nil, 0, errMessageTruncated
+	}
+	m := &Message{ID: binary.BigEndian.Uint16(b[0:]), Flags: binary.BigEndian.Uint16(b[2:])}
+	var counts [4]int
+	for i := range counts {
+		counts[i] = int(binary.BigEndian.Uint16(b[4+2*i:]))
+	}
+
+	off := headerLen
+	for i := 0; i < counts[0]; i++ {
+		var q Question
+		var err error
+		if q.Name, off, err = readName(b, off); err != nil {
+			return nil, 0, err
+		}
+		if off+4 > len(b) {
+			return nil, 0, errMessageTruncated
+		}
+		q.Type, q.Class = binary.BigEndian.Uint16(b[off:]), binary.BigEndian.Uint16(b[off+2:])
+		off += 4
+		m.Question = append(m.Question, q)
+	}
+	last := len(b)
+	for i, section := range []*[]RR{&m.Answer, &m.Authority, &m.Additional} {
+		for j := 0; j < counts[i+1]; j++ {
+			var rr RR
+			var err error
+			last = off
+			if rr, off, err = readRR(b, off); err != nil {
+				return nil, 0, err
+			}
+			*section = append(*section, rr)
+		}
+	}
+	return m, last, nil
+}
+
+func readRR(b []byte, off int) (RR, int, error) {
+	var rr RR
+	var err error
+	if rr.Name, off, err = readName(b, off); err != nil {
+		return rr, 0, err
+	}
+	if off+10 > len(b) {
+		return rr, 0, errMessageTruncated
+	}
+	rr.Type = binary.BigEndian.Uint16(b[off:])
+	rr.Class = binary.BigEndian.Uint16(b[off+2:])
+	rr.TTL = binary.BigEndian.Uint32(b[off+4:])
+	length := int(binary.BigEndian.Uint16(b[off+8:]))
+	off += 10
+	if off+length > len(b) {
+		return rr, 0, errMessageTruncated
+	}
+	rr.Data = b[off : off+length]
+	return rr, off + length, nil
+}
+
+// readName reads a possibly-compressed name at off, returning it with a
+// trailing dot, and the offset following it.
+func readName(b []byte, off int) (string, int, error) {
+	var labels []string
+	end := -1
+	for jumps := 0; ; {
+		if off >= len(b) {
+			return "", 0, errMessageTruncated
+		}
+		length := int(b[off])
+		switch {
+		case length == 0:
+			if end < 0 {
+				end = off + 1
+			}
+			return strings.Join(labels, ".") + ".", end, nil
+		case length&0xc0 == 0xc0:
+			if off+2 > len(b) {
+				return "", 0, errMessageTruncated
+			}
+			if end < 0 {
+				end = off + 2
+			}
+			if jumps++; jumps > 64 {
+				return "", 0, errors.New("DNS name has a compression loop")
+			}
+			off = int(binary.BigEndian.Uint16(b[off:]) & 0x3fff)
+		case length > maxLabelLen:
+			return "", 0, fmt.Errorf("invalid DNS label length %d", length)
+		default:
+			if off+1+length > len(b) {
+				return "", 0, errMessageTruncated
+			}
+			labels = append(labels, string(b[off+1:off+1+length]))
+			off += 1 + length
+		}
+	}
+}
+
+// ReadMessage reads a message which was sent over TCP, preceded by its
+// length.
+func ReadMessage(r io.Reader) ([]byte, error) {
+	var length [2]byte
+	if _, err := io.ReadFull(r, length[:]); err != nil {
+		return nil, err
+	}
+	msg := make([]byte, binary.BigEndian.Uint16(length[:]))
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// WriteMessage sends a message over TCP, preceded by its length.
+func WriteMessage(w io.Writer, msg []byte) error {
+	if len(msg) > 0xffff {
+		return errors.New("DNS message is too long to send over TCP")
+	}
+	_, err := w.Write(append(appendUint16(nil, uint16(len(msg))), msg...))
+	return err
+}
@@ -0,0 +1,437 @@
+/*
+Package tsig signs DNS messages using GSS-TSIG (RFC 3645), as Active Directory requires of dynamic updates.
+
+A key is negotiated with the server over TCP using TKEY queries which carry
+context tokens, and the resulting context is used to sign messages with
+GetMIC and to verify the server's signatures with VerifyMIC:
+
+	conn, err := net.Dial("tcp", "dc1.example.com:53")
+	...
+	ctx := gss.NewInitiatorContext(nil, name, gss.Mech_spnego, gss.Flags{Mutual: true, Integ: true})
+	key, err := tsig.Negotiate(conn, tsig.NewKeyName("client.example.com"), ctx)
+	...
+	update := tsig.NewUpdate("example.com.")
+	update.Authority = append(update.Authority,
+		tsig.DeleteRRset("client.example.com.", tsig.TypeA),
+		tsig.A("client.example.com.", 3600, net.ParseIP("192.0.2.10")))
+	_, err = key.Exchange(conn, update)
+
+where name was imported from "DNS@dc1.example.com" as a host-based service
+name.  Contexts for either Kerberos or SPNEGO, from either backend, can be
+used.  The package includes just enough of a DNS message codec for this.
+*/
+package tsig
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/twistlock/gss/pkg/gss/mech"
+)
+
+const (
+	// GSSTSIG is the name of the TSIG and TKEY algorithm.
+	GSSTSIG = "gss-tsig."
+	// TKEYModeGSSAPI is the TKEY mode used to negotiate a key.
+	TKEYModeGSSAPI = 3
+	// DefaultFudge is the number of seconds by which the two sides' clocks
+	// may disagree, if Key.Fudge is not set.
+	DefaultFudge = 300
+	// KeyLifetime is the lifetime which is requested for a key.
+	KeyLifetime = 24 * time.Hour
+)
+
+// ErrNotSigned is returned when a message which should have been signed
+// wasn't.
+var ErrNotSigned = errors.New("DNS message is not signed")
+
+// newID returns a random message ID.
+func newID() uint16 {
+	var b [2]byte
+	rand.Read(b[:])
+	return binary.BigEndian.Uint16(b[:])
+}
+
+// NewKeyName returns a unique name for a key negotiated by host.
+func NewKeyName(host string) string {
+	var b [4]byte
+	rand.Read(b[:])
+	return fmt.Sprintf("%d.sig-%s.", binary.BigEndian.Uint32(b[:]), strings.TrimSuffix(host, "."))
+}
+
+// TKEY is the data of a TKEY record.
+type TKEY struct {
+	Algorithm             string
+	Inception, Expiration uint32
+	Mode, Error           uint16
+	Key, Other            []byte
+}
+
+// Pack returns the record data's wire format.
+func (t *TKEY) Pack() ([]byte, error) {
+	b, err := appendName(nil, t.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+	if len(t.Key) > 0xffff || len(t.Other) > 0xffff {
+		return nil, errors.New("TKEY data is too long")
+	}
+	b = appendUint32(b, t.Inception)
+	b = appendUint32(b, t.Expiration)
+	b = appendUint16(b, t.Mode)
+	b = appendUint16(b, t.Error)
+	b = appendUint16(b, uint16(len(t.Key)))
+	b = append(b, t.Key...)
+	b = appendUint16(b, uint16(len(t.Other)))
+	return append(b, t.Other...), nil
+}
+
+// ParseTKEY parses the data of a TKEY record.
+func ParseTKEY(data []byte) (*TKEY, error) {
+	t := &TKEY{}
+	name, off, err := readName(data, 0)
+	if err != nil {
+		return nil, err
+	}
+	t.Algorithm = name
+	if off+14 > len(data) {
+		return nil, errMessageTruncated
+	}
+	t.Inception = binary.BigEndian.Uint32(data[off:])
+	t.Expiration = binary.BigEndian.Uint32(data[off+4:])
+	t.Mode = binary.BigEndian.Uint16(data[off+8:])
+	t.Error = binary.BigEndian.Uint16(data[off+10:])
+	if t.Key, off, err = readData(data, off+12); err != nil {
+		return nil, err
+	}
+	if t.Other, _, err = readData(data, off); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// TSIG is the data of a TSIG record.
+type TSIG struct {
+	Algorithm         string
+	TimeSigned        uint64 // 48 bits
+	Fudge             uint16
+	MAC               []byte
+	OriginalID, Error uint16
+	Other             []byte
+}
+
+// Pack returns the record data's wire format.
+func (t *TSIG) Pack() ([]byte, error) {
+	b, err := appendName(nil, t.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+	if len(t.MAC) > 0xffff || len(t.Other) > 0xffff {
+		return nil, errors.New("TSIG data is too long")
+	}
+	b = appendUint16(b, uint16(t.TimeSigned>>32))
+	b = appendUint32(b, uint32(t.TimeSigned))
+	b = appendUint16(b, t.Fudge)
+	b = appendUint16(b, uint16(len(t.MAC)))
+	b = append(b, t.MAC...)
+	b = appendUint16(b, t.OriginalID)
+	b = appendUint16(b, t.Error)
+	b = appendUint16(b, uint16(len(t.Other)))
+	return append(b, t.Other...), nil
+}
+
+// ParseTSIG parses the data of a TSIG record.
+func ParseTSIG(data []byte) (*TSIG, error) {
+	t := &TSIG{}
+	name, off, err := readName(data, 0)
+	if err != nil {
+		return nil, err
+	}
+	t.Algorithm = name
+	if off+8 > len(data) {
+		return nil, errMessageTruncated
+	}
+	t.TimeSigned = uint64(binary.BigEndian.Uint16(data[off:]))<<32 | uint64(binary.BigEndian.Uint32(data[off+2:]))
+	t.Fudge = binary.BigEndian.Uint16(data[off+6:])
+	if t.MAC, off, err = readData(data, off+8); err != nil {
+		return nil, err
+	}
+	if off+4 > len(data) {
+		return nil, errMessageTruncated
+	}
+	t.OriginalID = binary.BigEndian.Uint16(data[off:])
+	t.Error = binary.BigEndian.Uint16(data[off+2:])
+	if t.Other, _, err = readData(data, off+4); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// readData reads a field which is preceded by its 16-bit length.
+func readData(b []byte, off int) ([]byte, int, error) {
+	if off+2 > len(b) {
+		return nil, 0, errMessageTruncated
+	}
+	length := int(binary.BigEndian.Uint16(b[off:]))
+	off += 2
+	if off+length > len(b) {
+		return nil, 0, errMessageTruncated
+	}
+	return b[off : off+length], off + length, nil
+}
+
+// Key is a negotiated key, which signs and verifies messages.
+type Key struct {
+	// Name is the key's name, which the client chose.
+	Name string
+	// Fudge is the number of seconds by which the two sides' clocks may
+	// disagree.  DefaultFudge is used if it is zero.
+	Fudge uint16
+
+	ctx mech.Context
+}
+
+// NewKey returns a key which signs using ctx, which must be established.
+// Servers use it to sign responses with the contexts they accept.
+func NewKey(name string, ctx mech.Context) *Key {
+	return &Key{Name: name, ctx: ctx}
+}
+
+// Context returns the key's context.
+func (k *Key) Context() mech.Context {
+	return k.ctx
+}
+
+// Release releases the key's context.
+func (k *Key) Release() error {
+	return k.ctx.Release()
+}
+
+func (k *Key) fudge() uint16 {
+	if k.Fudge == 0 {
+		return DefaultFudge
+	}
+	return k.Fudge
+}
+
+// signedData returns the data which a TSIG record's MAC covers: the MAC of
+// the request, when msg is a response, then msg, without the TSIG record,
+// then the TSIG record's variables.
+func (k *Key) signedData(requestMAC, msg []byte, t *TSIG) ([]byte, error) {
+	var b []byte
+	if requestMAC != nil {
+		b = appendUint16(b, uint16(len(requestMAC)))
+		b = append(b, requestMAC...)
+	}
+	b = append(b, msg...)
+	name, err := canonicalName(k.Name)
+	if err != nil {
+		return nil, err
+	}
+	b = append(b, name...)
+	b = appendUint16(b, ClassANY)
+	b = appendUint32(b, 0)
+	algorithm, err := canonicalName(t.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+	b = append(b, algorithm...)
+	b = appendUint16(b, uint16(t.TimeSigned>>32))
+	b = appendUint32(b, uint32(t.TimeSigned))
+	b = appendUint16(b, t.Fudge)
+	b = appendUint16(b, t.Error)
+	b = appendUint16(b, uint16(len(t.Other)))
+	return append(b, t.Other...), nil
+}
+
+// Sign packs m and appends a TSIG record to it.  requestMAC is the MAC of
+// the request when m is a response, and nil otherwise.  The message's MAC
+// is returned along with it, for verifying the response.
+func (k *Key) Sign(m *Message, requestMAC []byte) (msg, mac []byte, err error) {
+	if msg, err = m.Pack(); err != nil {
+		return nil, nil, err
+	}
+	t := &TSIG{Algorithm: GSSTSIG, TimeSigned: uint64(time.Now().Unix()), Fudge: k.fudge(), OriginalID: m.ID}
+	data, err := k.signedData(requestMAC, msg, t)
+	if err != nil {
+		return nil, nil, err
+	}
+	if t.MAC, err = k.ctx.GetMIC(data); err != nil {
+		return nil, nil, err
+	}
+	rdata, err := t.Pack()
+	if err != nil {
+		return nil, nil, err
+	}
+	if msg, err = appendRR(msg, RR{Name: k.Name, Type: TypeTSIG, Class: ClassANY, Data: rdata}); err != nil {
+		return nil, nil, err
+	}
+	arcount := binary.BigEndian.Uint16(msg[10:])
+	binary.BigEndian.PutUint16(msg[10:], arcount+1)
+	return msg, t.MAC, nil
+}
+
+// Verify checks the TSIG record at the end of msg.  requestMAC is the MAC of
+// the request when msg is a response, and nil otherwise.  The message is
+// returned without its TSIG record, along with its MAC.  If the signer
+// reported an error instead of signing, it is returned as an RcodeError.
+func (k *Key) Verify(msg, requestMAC []byte) (*Message, []byte, error) {
+	m, last, err := unpack(msg)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(m.Additional) == 0 || m.Additional[len(m.Additional)-1].Type != TypeTSIG {
+		return m, nil, ErrNotSigned
+	}
+	rr := m.Additional[len(m.Additional)-1]
+	m.Additional = m.Additional[:len(m.Additional)-1]
+	if !strings.EqualFold(strings.TrimSuffix(rr.Name, "."), strings.TrimSuffix(k.Name, ".")) {
+		return m, nil, fmt.Errorf("DNS message is signed with key %q, not %q", rr.Name, k.Name)
+	}
+	t, err := ParseTSIG(rr.Data)
+	if err != nil {
+		return m, nil, err
+	}
+	if t.Error != RcodeSuccess {
+		return m, nil, RcodeError(t.Error)
+	}
+	if !strings.EqualFold(t.Algorithm, GSSTSIG) {
+		return m, nil, fmt.Errorf("DNS message is signed using unsupported algorithm %q", t.Algorithm)
+	}
+
+	// Recover the message as it was before it was signed.
+	unsigned := append([]byte{}, msg[:last]...)
+	binary.BigEndian.PutUint16(unsigned[0:], t.OriginalID)
+	binary.BigEndian.PutUint16(unsigned[10:], uint16(len(m.Additional)))
+	data, err := k.signedData(requestMAC, unsigned, t)
+	if err != nil {
+		return m, nil, err
+	}
+	if err = k.ctx.VerifyMIC(data, t.MAC); err != nil {
+		return m, nil, fmt.Errorf("verifying DNS message signature: %v", err)
+	}
+	now := time.Now().Unix()
+	if skew := now - int64(t.TimeSigned); skew > int64(t.Fudge) || -skew > int64(t.Fudge) {
+		return m, nil, RcodeError(RcodeBadTime)
+	}
+	return m, t.MAC, nil
+}
+
+// Exchange signs m, sends it over conn, which is a TCP connection to the
+// server, and returns the server's verified response.  A response with an
+// error code is returned along with an RcodeError.
+func (k *Key) Exchange(conn io.ReadWriter, m *Message) (*Message, error) {
+	msg, mac, err := k.Sign(m, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err = WriteMessage(conn, msg); err != nil {
+		return nil, err
+	}
+	for {
+		if msg, err = ReadMessage(conn); err != nil {
+			return nil, err
+		}
+		// Skip responses to earlier messages which timed out.
+		if len(msg) >= 2 && binary.BigEndian.Uint16(msg) != m.ID {
+			continue
+		}
+		resp, _, err := k.Verify(msg, mac)
+		if err != nil {
+			return nil, err
+		}
+		if resp.Rcode() != RcodeSuccess {
+			return resp, RcodeError(resp.Rcode())
+		}
+		return resp, nil
+	}
+}
+
+// Negotiate establishes a key named keyName with the server at the other
+// end of conn, which is a TCP connection, by passing tokens produced by ctx
+// in TKEY queries.  ctx should be a newly-created initiator context, for
+// Kerberos or SPNEGO, for the service "DNS@" followed by the server's host
+// name.  The server's last response has to be signed with the new key, and
+// ErrNotSigned is returned if it isn't.  The key takes ownership of ctx; if
+// negotiation fails, the caller is still responsible for releasing it.
+func Negotiate(conn io.ReadWriter, keyName string, ctx mech.Context) (*Key, error) {
+	key := NewKey(keyName, ctx)
+	output, complete, err := ctx.Step(nil)
+	for {
+		if err != nil {
+			return nil, err
+		}
+		now := time.Now()
+		query := &Message{ID: newID(), Question: []Question{{Name: keyName, Type: TypeTKEY, Class: ClassANY}}}
+		tkey := &TKEY{
+			Algorithm:  GSSTSIG,
+			Inception:  uint32(now.Unix()),
+			Expiration: uint32(now.Add(KeyLifetime).Unix()),
+			Mode:       TKEYModeGSSAPI,
+			Key:        output,
+		}
+		rdata, err := tkey.Pack()
+		if err != nil {
+			return nil, err
+		}
+		query.Additional = []RR{{Name: keyName, Type: TypeTKEY, Class: ClassANY, Data: rdata}}
+		msg, err := query.Pack()
+		if err != nil {
+			return nil, err
+		}
+		if err = WriteMessage(conn, msg); err != nil {
+			return nil, err
+		}
+		if msg, err = ReadMessage(conn); err != nil {
+			return nil, err
+		}
+		resp, err := Unpack(msg)
+		if err != nil {
+			return nil, err
+		}
+		if resp.ID != query.ID || !resp.Response() {
+			return nil, errors.New("unexpected response to TKEY query")
+		}
+		if resp.Rcode() != RcodeSuccess {
+			return nil, RcodeError(resp.Rcode())
+		}
+		if tkey, err = answerTKEY(resp, keyName); err != nil {
+			return nil, err
+		}
+		if tkey.Error != RcodeSuccess {
+			return nil, fmt.Errorf("negotiating TSIG key: %v", RcodeError(tkey.Error))
+		}
+
+		if !complete {
+			output, complete, err = ctx.Step(tkey.Key)
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			output = nil
+		}
+		if complete && len(output) == 0 {
+			// The server signs its last response with the new context,
+			// which is what proves that it holds the key.
+			if _, _, err = key.Verify(msg, nil); err != nil {
+				return nil, err
+			}
+			return key, nil
+		}
+	}
+}
+
+// answerTKEY finds the TKEY record for keyName in a response.
+func answerTKEY(resp *Message, keyName string) (*TKEY, error) {
+	for _, rr := range resp.Answer {
+		if rr.Type == TypeTKEY && strings.EqualFold(strings.TrimSuffix(rr.Name, "."), strings.TrimSuffix(keyName, ".")) {
+			return ParseTKEY(rr.Data)
+		}
+	}
+	return nil, errors.New("TKEY response has no TKEY record")
+}
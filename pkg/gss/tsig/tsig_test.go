@@ -0,0 +1,169 @@
+package tsig_test
+
+import (
+	"net"
+	"testing"
+
+	"github.com/twistlock/gss/pkg/gss/mech/fake"
+	"github.com/twistlock/gss/pkg/gss/tsig"
+	"github.com/twistlock/gss/pkg/gss/tsig/tsigtest"
+)
+
+var fakeConfig = fake.Config{
+	Initiator:  "host/client.example.com@EXAMPLE.COM",
+	Acceptor:   "DNS@dc1.example.com",
+	Key:        []byte("tsig test key"),
+	Flags:      fake.FlagMutual | fake.FlagInteg,
+	RoundTrips: 2,
+}
+
+// negotiate starts a server and negotiates a key with it.
+func negotiate(t *testing.T) (*tsigtest.Server, net.Conn, *tsig.Key) {
+	t.Helper()
+	srv, err := tsigtest.NewServer(fake.AcceptorFactory(fakeConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { srv.Close() })
+	conn, err := net.Dial("tcp", srv.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	ctx := fake.NewInitiator(fakeConfig)
+	key, err := tsig.Negotiate(conn, tsig.NewKeyName("client.example.com"), ctx)
+	if err != nil {
+		ctx.Release()
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { key.Release() })
+	return srv, conn, key
+}
+
+func testUpdate() *tsig.Message {
+	update := tsig.NewUpdate("example.com.")
+	update.Authority = append(update.Authority,
+		tsig.DeleteRRset("client.example.com.", tsig.TypeA),
+		tsig.A("client.example.com.", 3600, net.ParseIP("192.0.2.10")))
+	return update
+}
+
+func TestSignedUpdate(t *testing.T) {
+	srv, conn, key := negotiate(t)
+	resp, err := key.Exchange(conn, testUpdate())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Rcode() != tsig.RcodeSuccess {
+		t.Fatalf("update failed with %v", tsig.RcodeError(resp.Rcode()))
+	}
+	updates := srv.Updates()
+	if len(updates) != 1 {
+		t.Fatalf("server kept %d updates, want 1", len(updates))
+	}
+	u := updates[0]
+	if u.KeyName != key.Name || u.Zone != "example.com." || len(u.Updates) != 2 {
+		t.Errorf("unexpected update %+v", u)
+	}
+}
+
+func TestBadMAC(t *testing.T) {
+	srv, conn, key := negotiate(t)
+	msg, _, err := key.Sign(testUpdate(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The MAC is followed by the original ID, the error and the other data's
+	// length, which is zero.
+	msg[len(msg)-7] ^= 1
+	if err = tsig.WriteMessage(conn, msg); err != nil {
+		t.Fatal(err)
+	}
+	reply, err := tsig.ReadMessage(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := tsig.Unpack(reply)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Rcode() != tsig.RcodeNotAuth {
+		t.Errorf("server answered %v, want %v", tsig.RcodeError(resp.Rcode()), tsig.RcodeError(tsig.RcodeNotAuth))
+	}
+	if _, _, err := key.Verify(reply, nil); err != tsig.RcodeError(tsig.RcodeBadSig) {
+		t.Errorf("server's TSIG error is %v, want %v", err, tsig.RcodeError(tsig.RcodeBadSig))
+	}
+	if updates := srv.Updates(); len(updates) != 0 {
+		t.Errorf("server kept updates %+v", updates)
+	}
+}
+
+// TestUnsignedFinalResponse checks that a server which completes the
+// exchange without proving that it holds the key is refused.
+func TestUnsignedFinalResponse(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	go func() {
+		defer server.Close()
+		acceptor := fake.NewAcceptor(fakeConfig)
+		defer acceptor.Release()
+		for {
+			msg, err := tsig.ReadMessage(server)
+			if err != nil {
+				return
+			}
+			req, err := tsig.Unpack(msg)
+			if err != nil || len(req.Additional) == 0 {
+				return
+			}
+			query, err := tsig.ParseTKEY(req.Additional[0].Data)
+			if err != nil {
+				return
+			}
+			answer := *query
+			if answer.Key, _, err = acceptor.Step(query.Key); err != nil {
+				answer.Error = tsig.RcodeBadKey
+			}
+			rdata, err := answer.Pack()
+			if err != nil {
+				return
+			}
+			resp := &tsig.Message{}
+			resp.SetResponse(req, tsig.RcodeSuccess)
+			resp.Answer = []tsig.RR{{Name: req.Question[0].Name, Type: tsig.TypeTKEY, Class: tsig.ClassANY, Data: rdata}}
+			if msg, err = resp.Pack(); err != nil {
+				return
+			}
+			tsig.WriteMessage(server, msg)
+		}
+	}()
+
+	ctx := fake.NewInitiator(fakeConfig)
+	defer ctx.Release()
+	if key, err := tsig.Negotiate(client, tsig.NewKeyName("client.example.com"), ctx); err != tsig.ErrNotSigned {
+		if key != nil {
+			key.Release()
+		}
+		t.Errorf("Negotiate returned %v, want %v", err, tsig.ErrNotSigned)
+	}
+}
+
+func TestWrongKey(t *testing.T) {
+	srv, err := tsigtest.NewServer(fake.AcceptorFactory(fakeConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	conn, err := net.Dial("tcp", srv.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	cfg := fakeConfig
+	cfg.Key = []byte("some other key")
+	ctx := fake.NewInitiator(cfg)
+	defer ctx.Release()
+	if _, err := tsig.Negotiate(conn, tsig.NewKeyName("client.example.com"), ctx); err == nil {
+		t.Error("negotiated a key with a server which has a different key")
+	}
+}
@@ -0,0 +1,273 @@
+/*
+Package tsigtest runs an in-process DNS server which accepts GSS-TSIG-signed dynamic updates, for testing clients which use the tsig package.
+
+The server answers TKEY queries by accepting contexts, using a keytab, such
+as one made by a gsstest.KDC, or contexts created by any mech.Factory.  It
+keeps the UPDATE messages which were signed with a negotiated key for
+inspection, and refuses the rest.  It only listens for TCP connections, and
+doesn't maintain any zone data.
+*/
+package tsigtest
+
+import (
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/twistlock/gss/pkg/gss"
+	"github.com/twistlock/gss/pkg/gss/credstore"
+	"github.com/twistlock/gss/pkg/gss/mech"
+	"github.com/twistlock/gss/pkg/gss/tsig"
+)
+
+// Update is an UPDATE message which a client sent.
+type Update struct {
+	// KeyName is the name of the key which the client signed with.
+	KeyName string
+	Zone    string
+	// Prerequisites and Updates are the message's prerequisite and update
+	// sections.
+	Prerequisites []tsig.RR
+	Updates       []tsig.RR
+}
+
+// Server is a running DNS server.
+type Server struct {
+	// Addr is the address which the server listens on.
+	Addr string
+
+	factory  mech.Factory
+	cred     gss.CredHandle
+	listener net.Listener
+	wg       sync.WaitGroup
+
+	mu      sync.Mutex
+	keys    map[string]*tsig.Key // established keys, by lowercased name
+	pending map[string]mech.Context
+	updates []Update
+}
+
+// NewServer starts a server on the loopback interface which accepts keys
+// using contexts created by factory.
+func NewServer(factory mech.Factory) (*Server, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{
+		Addr:     l.Addr().String(),
+		factory:  factory,
+		listener: l,
+		keys:     make(map[string]*tsig.Key),
+		pending:  make(map[string]mech.Context),
+	}
+	s.wg.Add(1)
+	go s.serve()
+	return s, nil
+}
+
+// NewKeytabServer starts a server which accepts keys using the keys in
+// keytab.
+func NewKeytabServer(keytab string) (*Server, error) {
+	major, minor, cred, _, _ := gss.AcquireCredFrom(nil, gss.C_INDEFINITE, nil, gss.C_ACCEPT, credstore.New().SetKeytab(keytab))
+	if major != gss.S_COMPLETE {
+		return nil, gss.NewGSSError("acquiring acceptor credentials", major, minor, nil)
+	}
+	s, err := NewServer(func() (mech.Context, error) {
+		return gss.NewAcceptorContext(cred), nil
+	})
+	if err != nil {
+		gss.ReleaseCred(cred)
+		return nil, err
+	}
+	s.cred = cred
+	return s, nil
+}
+
+// Updates returns the updates which have been accepted so far.
+func (s *Server) Updates() []Update {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Update(nil), s.updates...)
+}
+
+// Close stops the server, waits for its connections to finish, and
+// releases its contexts.
+func (s *Server) Close() error {
+	err := s.listener.Close()
+	s.wg.Wait()
+	s.mu.Lock()
+	for name, key := range s.keys {
+		key.Release()
+		delete(s.keys, name)
+	}
+	for name, ctx := range s.pending {
+		ctx.Release()
+		delete(s.pending, name)
+	}
+	s.mu.Unlock()
+	if s.cred != nil {
+		gss.ReleaseCred(s.cred)
+		s.cred = nil
+	}
+	return err
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer conn.Close()
+			s.handle(conn)
+		}()
+	}
+}
+
+func (s *Server) handle(conn net.Conn) {
+	for {
+		msg, err := tsig.ReadMessage(conn)
+		if err != nil {
+			return
+		}
+		reply, err := s.respond(msg)
+		if err != nil {
+			return
+		}
+		if err = tsig.WriteMessage(conn, reply); err != nil {
+			return
+		}
+	}
+}
+
+// respond returns the response to a message, or an error if the message
+// can't be parsed well enough to respond to.
+func (s *Server) respond(msg []byte) ([]byte, error) {
+	req, err := tsig.Unpack(msg)
+	if err != nil {
+		return nil, err
+	}
+	resp := &tsig.Message{}
+	switch {
+	case req.Opcode() == tsig.OpcodeQuery && len(req.Question) == 1 && req.Question[0].Type == tsig.TypeTKEY:
+		return s.tkey(req)
+	case req.Opcode() == tsig.OpcodeUpdate:
+		return s.update(req, msg)
+	}
+	resp.SetResponse(req, tsig.RcodeNotImp)
+	return resp.Pack()
+}
+
+// tkey passes a TKEY query's token to the key's context.
+func (s *Server) tkey(req *tsig.Message) ([]byte, error) {
+	resp := &tsig.Message{}
+	keyName := req.Question[0].Name
+	var query *tsig.TKEY
+	for _, rr := range append(req.Answer, req.Additional...) {
+		if rr.Type == tsig.TypeTKEY {
+			if query, _ = tsig.ParseTKEY(rr.Data); query != nil {
+				break
+			}
+		}
+	}
+	if query == nil {
+		resp.SetResponse(req, tsig.RcodeFormErr)
+		return resp.Pack()
+	}
+
+	answer := &tsig.TKEY{Algorithm: query.Algorithm, Inception: query.Inception, Expiration: query.Expiration, Mode: query.Mode}
+	var complete bool
+	s.mu.Lock()
+	ctx := s.pending[strings.ToLower(keyName)]
+	switch {
+	case query.Mode != tsig.TKEYModeGSSAPI:
+		answer.Error = tsig.RcodeBadMode
+	case !strings.EqualFold(query.Algorithm, tsig.GSSTSIG) || s.keys[strings.ToLower(keyName)] != nil:
+		answer.Error = tsig.RcodeBadKey
+	default:
+		var err error
+		if ctx == nil {
+			if ctx, err = s.factory(); err != nil {
+				answer.Error = tsig.RcodeBadKey
+				break
+			}
+			s.pending[strings.ToLower(keyName)] = ctx
+		}
+		answer.Key, complete, err = ctx.Step(query.Key)
+		switch {
+		case err != nil:
+			answer.Error = tsig.RcodeBadKey
+			delete(s.pending, strings.ToLower(keyName))
+			ctx.Release()
+		case complete:
+			delete(s.pending, strings.ToLower(keyName))
+			s.keys[strings.ToLower(keyName)] = tsig.NewKey(keyName, ctx)
+		}
+	}
+	key := s.keys[strings.ToLower(keyName)]
+	s.mu.Unlock()
+
+	resp.SetResponse(req, tsig.RcodeSuccess)
+	rdata, err := answer.Pack()
+	if err != nil {
+		return nil, err
+	}
+	resp.Answer = []tsig.RR{{Name: keyName, Type: tsig.TypeTKEY, Class: tsig.ClassANY, Data: rdata}}
+	if complete {
+		// Sign the last response using the new context.
+		msg, _, err := key.Sign(resp, nil)
+		return msg, err
+	}
+	return resp.Pack()
+}
+
+// update checks an UPDATE message's signature, and keeps it if it is good.
+func (s *Server) update(req *tsig.Message, msg []byte) ([]byte, error) {
+	resp := &tsig.Message{}
+	resp.SetResponse(req, tsig.RcodeNotAuth)
+	if len(req.Additional) == 0 || req.Additional[len(req.Additional)-1].Type != tsig.TypeTSIG {
+		return resp.Pack()
+	}
+	keyName := req.Additional[len(req.Additional)-1].Name
+	s.mu.Lock()
+	key := s.keys[strings.ToLower(keyName)]
+	s.mu.Unlock()
+	if key == nil {
+		return unsignedError(resp, keyName, tsig.RcodeBadKey)
+	}
+	signed, mac, err := key.Verify(msg, nil)
+	if err != nil {
+		if rcode, ok := err.(tsig.RcodeError); ok && rcode == tsig.RcodeBadTime {
+			return unsignedError(resp, keyName, tsig.RcodeBadTime)
+		}
+		return unsignedError(resp, keyName, tsig.RcodeBadSig)
+	}
+
+	if len(signed.Question) != 1 || signed.Question[0].Type != tsig.TypeSOA {
+		resp.SetResponse(req, tsig.RcodeFormErr)
+	} else {
+		s.mu.Lock()
+		s.updates = append(s.updates, Update{KeyName: key.Name, Zone: signed.Question[0].Name, Prerequisites: signed.Answer, Updates: signed.Authority})
+		s.mu.Unlock()
+		resp.SetResponse(req, tsig.RcodeSuccess)
+	}
+	reply, _, err := key.Sign(resp, mac)
+	return reply, err
+}
+
+// unsignedError returns a response which reports a TSIG error, which can't
+// be signed.
+func unsignedError(resp *tsig.Message, keyName string, rcode uint16) ([]byte, error) {
+	t := &tsig.TSIG{Algorithm: tsig.GSSTSIG, OriginalID: resp.ID, Error: rcode}
+	rdata, err := t.Pack()
+	if err != nil {
+		return nil, err
+	}
+	resp.Additional = append(resp.Additional, tsig.RR{Name: keyName, Type: tsig.TypeTSIG, Class: tsig.ClassANY, Data: rdata})
+	return resp.Pack()
+}
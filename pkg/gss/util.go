@@ -14,6 +14,9 @@ func DisplayGSSError(when string, major, minor uint32, mech *asn1.ObjectIdentifi
 }
 
 func NewGSSError(when string, major, minor uint32, mech *asn1.ObjectIdentifier) error {
+	if !Available() {
+		return ErrGSSUnavailable
+	}
 	var b bytes.Buffer
 	fmt.Fprint(&b, DisplayStatus(major, C_GSS_CODE, nil)[3])
 	if len(when) > 0 {
@@ -0,0 +1,293 @@
+/*
+Package vault keeps credentials for many users in one process without letting them mix.
+
+The Kerberos library's default credential cache is shared by the whole process,
+so a service which acts on behalf of several users at once can't rely on it.
+A Vault instead keeps each user's credentials in a private MEMORY: ccache, or
+as an exported credential blob, and always names that location explicitly when
+handing out credential handles, so the default ccache is never consulted or
+modified.  Entries which go unused for longer than the idle timeout are
+evicted and their ccaches destroyed.
+*/
+package vault
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/twistlock/gss/pkg/gss"
+	"github.com/twistlock/gss/pkg/gss/credstore"
+)
+
+// DefaultIdleTimeout is used if New is passed a zero idle timeout.
+const DefaultIdleTimeout = 15 * time.Minute
+
+// ErrNotFound is returned by Get for users who have no entry in the vault.
+var ErrNotFound = errors.New("no credentials stored for user")
+
+type entry struct {
+	// ccache is the MEMORY: ccache holding the user's credentials, or
+	// exported is their exported form.
+	ccache   string
+	exported []byte
+	lastUsed time.Time
+	refs     int
+	removed  bool
+}
+
+// Vault holds credentials for multiple users.
+type Vault struct {
+	idle time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*entry
+	serial  uint64
+	stop    chan struct{}
+	done    sync.WaitGroup
+
+	closeOnce sync.Once
+}
+
+// New creates a vault which evicts entries that haven't been used for idle.
+// It should be shut down using Close() when it's no longer needed.
+func New(idle time.Duration) *Vault {
+	if idle == 0 {
+		idle = DefaultIdleTimeout
+	}
+	v := &Vault{
+		idle:    idle,
+		entries: make(map[string]*entry),
+		stop:    make(chan struct{}),
+	}
+	v.done.Add(1)
+	go v.evictLoop()
+	return v
+}
+
+// newCCacheName picks a MEMORY: ccache name which no other entry uses.
+func (v *Vault) newCCacheName() string {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	v.serial++
+	return fmt.Sprintf("MEMORY:vault-%p-%d", v, v.serial)
+}
+
+// Acquire obtains initiator credentials for user, using the password or
+// client keytab in store, and keeps them in a new ccache for that user.  Any
+// ccache set in store is ignored.
+func (v *Vault) Acquire(user string, store *credstore.CredStore) error {
+	major, minor, name := gss.ImportName(user, gss.KRB5_NT_PRINCIPAL_NAME)
+	if major != gss.S_COMPLETE {
+		return gss.NewGSSError("importing user name", major, minor, nil)
+	}
+	defer gss.ReleaseName(name)
+
+	if err := store.Err(); err != nil {
+		return err
+	}
+	ccache := v.newCCacheName()
+	private := credstore.New()
+	for _, e := range store.Elements() {
+		if e.Key != credstore.CCache {
+			private.Add(e.Key, e.Value)
+		}
+	}
+	private.SetCCache(ccache)
+
+	major, minor, cred, _, _ := gss.AcquireCredFrom(name, gss.C_INDEFINITE, nil, gss.C_INITIATE, private)
+	if major != gss.S_COMPLETE {
+		destroyCCache(ccache)
+		return gss.NewGSSError("acquiring credentials", major, minor, nil)
+	}
+	gss.ReleaseCred(cred)
+
+	v.put(user, &entry{ccache: ccache})
+	return nil
+}
+
+// Store copies cred into a new ccache for user, replacing any credentials
+// which were already stored for them.  The caller retains ownership of cred.
+func (v *Vault) Store(user string, cred gss.CredHandle) error {
+	ccache := v.newCCacheName()
+	store := credstore.New().SetCCache(ccache)
+
+	major, minor, _, _ := gss.StoreCredInto(cred, gss.C_INITIATE, nil, true, false, store)
+	if major != gss.S_COMPLETE {
+		destroyCCache(ccache)
+		return gss.NewGSSError("storing credentials", major, minor, nil)
+	}
+	v.put(user, &entry{ccache: ccache})
+	return nil
+}
+
+// StoreExported keeps an exported copy of cred for user, replacing any
+// credentials which were already stored for them.  This suits credentials
+// which can't be stored in a ccache.  The caller retains ownership of cred.
+func (v *Vault) StoreExported(user string, cred gss.CredHandle) error {
+	major, minor, token := gss.ExportCred(cred)
+	if major != gss.S_COMPLETE {
+		return gss.NewGSSError("exporting credentials", major, minor, nil)
+	}
+	v.put(user, &entry{exported: token})
+	return nil
+}
+
+func (v *Vault) put(user string, e *entry) {
+	e.lastUsed = time.Now()
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if old, ok := v.entries[user]; ok {
+		v.retire(old)
+	}
+	v.entries[user] = e
+}
+
+// Get returns a credential handle for user, along with a function which
+// releases it.  The handle only refers to that user's credentials.
+func (v *Vault) Get(user string) (gss.CredHandle, func(), error) {
+	v.mu.Lock()
+	e, ok := v.entries[user]
+	if ok {
+		e.refs++
+		e.lastUsed = time.Now()
+	}
+	v.mu.Unlock()
+	if !ok {
+		return nil, nil, ErrNotFound
+	}
+
+	var cred gss.CredHandle
+	var err error
+	if e.exported != nil {
+		major, minor, handle := gss.ImportCred(e.exported)
+		if major != gss.S_COMPLETE {
+			err = gss.NewGSSError("importing credentials", major, minor, nil)
+		}
+		cred = handle
+	} else {
+		store := credstore.New().SetCCache(e.ccache)
+		major, minor, handle, _, _ := gss.AcquireCredFrom(nil, gss.C_INDEFINITE, nil, gss.C_INITIATE, store)
+		if major != gss.S_COMPLETE {
+			err = gss.NewGSSError("acquiring credentials", major, minor, nil)
+		}
+		cred = handle
+	}
+	if err != nil {
+		v.release(e)
+		return nil, nil, err
+	}
+
+	var once sync.Once
+	return cred, func() {
+		once.Do(func() {
+			gss.ReleaseCred(cred)
+			v.release(e)
+		})
+	}, nil
+}
+
+func (v *Vault) release(e *entry) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	e.refs--
+	e.lastUsed = time.Now()
+	if e.removed {
+		v.retire(e)
+	}
+}
+
+// Remove discards the credentials stored for user.  Handles which have
+// already been handed out remain usable until they're released.
+func (v *Vault) Remove(user string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if e, ok := v.entries[user]; ok {
+		delete(v.entries, user)
+		v.retire(e)
+	}
+}
+
+// Users returns the names of the users who have entries in the vault.
+func (v *Vault) Users() []string {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	users := make([]string, 0, len(v.entries))
+	for user := range v.entries {
+		users = append(users, user)
+	}
+	return users
+}
+
+// retire marks e as no longer reachable, and destroys its ccache once it's
+// not in use.  The caller must hold mu.
+func (v *Vault) retire(e *entry) {
+	e.removed = true
+	if e.refs > 0 {
+		return
+	}
+	if e.ccache != "" {
+		destroyCCache(e.ccache)
+		e.ccache = ""
+	}
+	e.exported = nil
+}
+
+// evictIdle removes entries which haven't been used recently.
+func (v *Vault) evictIdle() {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	cutoff := time.Now().Add(-v.idle)
+	for user, e := range v.entries {
+		if e.refs == 0 && e.lastUsed.Before(cutoff) {
+			delete(v.entries, user)
+			v.retire(e)
+		}
+	}
+}
+
+func (v *Vault) evictLoop() {
+	defer v.done.Done()
+
+	ticker := time.NewTicker(v.idle / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-v.stop:
+			return
+		case <-ticker.C:
+			v.evictIdle()
+		}
+	}
+}
+
+// Close stops evicting entries and removes all of them.  Calling it more than
+// once has no further effect.
+func (v *Vault) Close() {
+	v.closeOnce.Do(func() {
+		close(v.stop)
+		v.done.Wait()
+
+		v.mu.Lock()
+		defer v.mu.Unlock()
+		for user, e := range v.entries {
+			delete(v.entries, user)
+			v.retire(e)
+		}
+	})
+}
+
+func destroyCCache(name string) error {
+	if major, minor := gss.Krb5DestroyCcache(name); major != gss.S_COMPLETE {
+		return fmt.Errorf("destroying ccache %s: error %d", name, minor)
+	}
+	return nil
+}
@@ -0,0 +1,164 @@
+package vault
+
+import (
+	"testing"
+	"time"
+
+	"github.com/twistlock/gss/pkg/gss"
+	"github.com/twistlock/gss/pkg/gss/credstore"
+	"github.com/twistlock/gss/pkg/gss/gsstest"
+)
+
+// aliceCred starts a KDC with alice's credentials in the default ccache and
+// returns a handle for them.
+func aliceCred(t *testing.T) (*gsstest.KDC, gss.CredHandle) {
+	t.Helper()
+	kdc := gsstest.Start(t, gsstest.Options{})
+	if err := kdc.AddUser("alice"); err != nil {
+		t.Fatal(err)
+	}
+	major, minor, cred, _, _ := gss.AcquireCred(nil, gss.C_INDEFINITE, nil, gss.C_INITIATE)
+	if major != gss.S_COMPLETE {
+		t.Fatal(gss.NewGSSError("acquiring credentials", major, minor, nil))
+	}
+	t.Cleanup(func() { gss.ReleaseCred(cred) })
+	return kdc, cred
+}
+
+// ccacheUsable reports whether credentials can be acquired from ccache.
+func ccacheUsable(ccache string) bool {
+	major, _, cred, _, _ := gss.AcquireCredFrom(nil, gss.C_INDEFINITE, nil, gss.C_INITIATE, credstore.New().SetCCache(ccache))
+	if major != gss.S_COMPLETE {
+		return false
+	}
+	gss.ReleaseCred(cred)
+	return true
+}
+
+// entryCCache returns the ccache which holds user's credentials.
+func entryCCache(t *testing.T, v *Vault, user string) string {
+	t.Helper()
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	e, ok := v.entries[user]
+	if !ok {
+		t.Fatalf("no entry for %s", user)
+	}
+	return e.ccache
+}
+
+func TestRefcount(t *testing.T) {
+	_, cred := aliceCred(t)
+	v := New(time.Hour)
+	defer v.Close()
+
+	if err := v.Store("alice", cred); err != nil {
+		t.Fatal(err)
+	}
+	ccache := entryCCache(t, v, "alice")
+	handle, done, err := v.Get("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, done2, err := v.Get("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Removing the entry leaves the ccache alone while handles are out.
+	v.Remove("alice")
+	if _, _, err = v.Get("alice"); err != ErrNotFound {
+		t.Errorf("Get after Remove returned %v", err)
+	}
+	if major, minor, _, _, _, _ := gss.InquireCred(handle); major != gss.S_COMPLETE {
+		t.Error(gss.NewGSSError("inquiring about a removed entry's handle", major, minor, nil))
+	}
+	done()
+	done() // Only the first call counts.
+	if !ccacheUsable(ccache) {
+		t.Fatal("ccache was destroyed while a handle was still out")
+	}
+	done2()
+	if ccacheUsable(ccache) {
+		t.Error("ccache outlived the last handle")
+	}
+
+	// Replacing an entry destroys the old ccache.
+	if err = v.Store("alice", cred); err != nil {
+		t.Fatal(err)
+	}
+	old := entryCCache(t, v, "alice")
+	if err = v.Store("alice", cred); err != nil {
+		t.Fatal(err)
+	}
+	if ccacheUsable(old) || !ccacheUsable(entryCCache(t, v, "alice")) {
+		t.Error("replacing an entry didn't swap its ccache")
+	}
+}
+
+func TestIdleEviction(t *testing.T) {
+	_, cred := aliceCred(t)
+	v := New(time.Hour)
+	defer v.Close()
+
+	for _, user := range []string{"idle", "busy", "recent"} {
+		if err := v.Store(user, cred); err != nil {
+			t.Fatal(err)
+		}
+	}
+	idle := entryCCache(t, v, "idle")
+	busy := entryCCache(t, v, "busy")
+	_, done, err := v.Get("busy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	v.mu.Lock()
+	v.entries["idle"].lastUsed = time.Now().Add(-2 * time.Hour)
+	v.entries["busy"].lastUsed = time.Now().Add(-2 * time.Hour)
+	v.mu.Unlock()
+
+	v.evictIdle()
+	users := make(map[string]bool)
+	for _, user := range v.Users() {
+		users[user] = true
+	}
+	if users["idle"] || !users["busy"] || !users["recent"] {
+		t.Errorf("users after eviction: %v", users)
+	}
+	if ccacheUsable(idle) {
+		t.Error("evicted entry's ccache wasn't destroyed")
+	}
+	done()
+	if !ccacheUsable(busy) {
+		t.Error("entry which was in use lost its ccache")
+	}
+
+	// Closing removes everything.
+	v.Close()
+	if len(v.Users()) != 0 || ccacheUsable(busy) {
+		t.Error("Close left entries behind")
+	}
+}
+
+func TestEvictLoop(t *testing.T) {
+	_, cred := aliceCred(t)
+	v := New(20 * time.Millisecond)
+	defer v.Close()
+	if err := v.Store("alice", cred); err != nil {
+		t.Fatal(err)
+	}
+	for deadline := time.Now().Add(10 * time.Second); len(v.Users()) != 0; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("idle entry wasn't evicted")
+		}
+	}
+}
+
+func TestCloseTwice(t *testing.T) {
+	v := New(0)
+	v.Close()
+	v.Close()
+	if _, _, err := v.Get("nobody"); err != ErrNotFound {
+		t.Errorf("Get from a closed vault returned %v", err)
+	}
+}